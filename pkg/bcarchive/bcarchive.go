@@ -0,0 +1,161 @@
+// Package bcarchive implements a streaming archive format for blobs.
+//
+// An archive is the magic number, followed by a length-prefixed JSON Header,
+// followed by any number of blobs.  Each blob is written as its ID, then its length as a uvarint, then its data.
+// Blobs are verified against their IDs when they are read.
+package bcarchive
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/blobcache/blobcache/pkg/blobs"
+	"github.com/blobcache/blobcache/pkg/tries"
+)
+
+// Magic is the start of every archive
+const Magic = "BCARCH00"
+
+const maxHeaderSize = 1 << 16
+
+var (
+	ErrBadMagic = errors.New("bcarchive: not a blobcache archive")
+	ErrBadBlob  = errors.New("bcarchive: blob does not match its ID")
+)
+
+// Header describes the contents of an archive.
+type Header struct {
+	// Name is the name of the pinset the archive was exported from
+	Name string `json:"name,omitempty"`
+	// Root is the root of the pinset's trie.  The nodes of the trie are in the archive.
+	Root *tries.Ref `json:"root,omitempty"`
+}
+
+type Writer struct {
+	w *bufio.Writer
+}
+
+// NewWriter writes the magic number and h to w, and returns a Writer for the blobs.
+// Flush must be called after the last blob.
+func NewWriter(w io.Writer, h Header) (*Writer, error) {
+	hData, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	aw := &Writer{w: bufio.NewWriter(w)}
+	if _, err := aw.w.WriteString(Magic); err != nil {
+		return nil, err
+	}
+	if err := aw.writeBytes(hData); err != nil {
+		return nil, err
+	}
+	return aw, nil
+}
+
+// WriteBlob appends a blob to the archive, and returns its ID
+func (w *Writer) WriteBlob(data []byte) (blobs.ID, error) {
+	if len(data) > blobs.MaxSize {
+		return blobs.ID{}, blobs.ErrTooLarge
+	}
+	id := blobs.Hash(data)
+	if _, err := w.w.Write(id[:]); err != nil {
+		return blobs.ID{}, err
+	}
+	return id, w.writeBytes(data)
+}
+
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+func (w *Writer) writeBytes(x []byte) error {
+	lenBuf := [binary.MaxVarintLen64]byte{}
+	n := binary.PutUvarint(lenBuf[:], uint64(len(x)))
+	if _, err := w.w.Write(lenBuf[:n]); err != nil {
+		return err
+	}
+	_, err := w.w.Write(x)
+	return err
+}
+
+type Reader struct {
+	r      *bufio.Reader
+	header Header
+	buf    []byte
+}
+
+// NewReader reads the magic number and header from r.
+func NewReader(r io.Reader) (*Reader, error) {
+	ar := &Reader{r: bufio.NewReader(r)}
+	magic := make([]byte, len(Magic))
+	if _, err := io.ReadFull(ar.r, magic); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrBadMagic
+		}
+		return nil, err
+	}
+	if !bytes.Equal(magic, []byte(Magic)) {
+		return nil, ErrBadMagic
+	}
+	hData, err := ar.readBytes(maxHeaderSize)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if err := json.Unmarshal(hData, &ar.header); err != nil {
+		return nil, fmt.Errorf("bcarchive: parsing header: %w", err)
+	}
+	return ar, nil
+}
+
+func (r *Reader) Header() Header {
+	return r.header
+}
+
+// Next returns the next blob in the archive, or io.EOF if there are no more.
+// The data is only valid until the next call to Next.
+func (r *Reader) Next() (blobs.ID, []byte, error) {
+	id := blobs.ID{}
+	// io.ReadFull only returns io.EOF if nothing was read, which is the clean end of the archive.
+	if _, err := io.ReadFull(r.r, id[:]); err != nil {
+		return blobs.ID{}, nil, err
+	}
+	data, err := r.readBytes(blobs.MaxSize)
+	if err != nil {
+		return blobs.ID{}, nil, unexpectedEOF(err)
+	}
+	if !blobs.Hash(data).Equals(id) {
+		return blobs.ID{}, nil, ErrBadBlob
+	}
+	return id, data, nil
+}
+
+func (r *Reader) readBytes(max int) ([]byte, error) {
+	l, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return nil, err
+	}
+	if l > uint64(max) {
+		return nil, fmt.Errorf("bcarchive: length %d exceeds max of %d", l, max)
+	}
+	if cap(r.buf) < int(l) {
+		r.buf = make([]byte, l)
+	}
+	r.buf = r.buf[:l]
+	if _, err := io.ReadFull(r.r, r.buf); err != nil {
+		return nil, err
+	}
+	return r.buf, nil
+}
+
+// unexpectedEOF converts io.EOF to io.ErrUnexpectedEOF, for reads which are part way through an item.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
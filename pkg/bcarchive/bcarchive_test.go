@@ -0,0 +1,71 @@
+package bcarchive
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blobcache/blobcache/pkg/blobs"
+)
+
+func TestWriteRead(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, err := NewWriter(buf, Header{Name: "pinset1"})
+	require.NoError(t, err)
+	const N = 10
+	expected := make([][]byte, N)
+	for i := range expected {
+		expected[i] = []byte(fmt.Sprintf("test-data-%d", i))
+		id, err := w.WriteBlob(expected[i])
+		require.NoError(t, err)
+		assert.Equal(t, blobs.Hash(expected[i]), id)
+	}
+	_, err = w.WriteBlob(make([]byte, blobs.MaxSize+1))
+	assert.Equal(t, blobs.ErrTooLarge, err)
+	require.NoError(t, w.Flush())
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, "pinset1", r.Header().Name)
+	for i := range expected {
+		id, data, err := r.Next()
+		require.NoError(t, err)
+		assert.Equal(t, blobs.Hash(expected[i]), id)
+		assert.Equal(t, expected[i], data)
+	}
+	_, _, err = r.Next()
+	assert.Equal(t, io.EOF, err)
+
+	// truncated
+	r, err = NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	require.NoError(t, err)
+	for i := 0; i < N-1; i++ {
+		_, _, err := r.Next()
+		require.NoError(t, err)
+	}
+	_, _, err = r.Next()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestBadArchive(t *testing.T) {
+	_, err := NewReader(bytes.NewReader([]byte("not an archive")))
+	assert.Equal(t, ErrBadMagic, err)
+
+	buf := &bytes.Buffer{}
+	w, err := NewWriter(buf, Header{})
+	require.NoError(t, err)
+	_, err = w.WriteBlob([]byte("test-data"))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	data := buf.Bytes()
+	data[len(data)-1] ^= 1
+
+	r, err := NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	_, _, err = r.Next()
+	assert.Equal(t, ErrBadBlob, err)
+}
@@ -0,0 +1,257 @@
+package bchttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/blobcache/blobcache/pkg/blobcache"
+	"github.com/blobcache/blobcache/pkg/blobs"
+	log "github.com/sirupsen/logrus"
+)
+
+var _ blobcache.API = &Client{}
+
+// Client is a blobcache.API which makes requests to a remote Server.
+type Client struct {
+	endpoint string
+	hc       *http.Client
+
+	mu          sync.Mutex
+	maxBlobSize int
+}
+
+// NewClient returns a Client for the Server at endpoint. e.g. http://127.0.0.1:6025
+func NewClient(endpoint string) *Client {
+	return &Client{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		hc:       &http.Client{},
+	}
+}
+
+func (c *Client) CreatePinSet(ctx context.Context, name string) (blobcache.PinSetID, error) {
+	reqData, err := json.Marshal(CreatePinSetReq{Name: name})
+	if err != nil {
+		return 0, err
+	}
+	res := CreatePinSetRes{}
+	if err := c.doJSON(ctx, http.MethodPost, "/v1/pinsets/", bytes.NewReader(reqData), &res); err != nil {
+		return 0, err
+	}
+	return res.ID, nil
+}
+
+func (c *Client) DeletePinSet(ctx context.Context, psID blobcache.PinSetID) error {
+	return c.doJSON(ctx, http.MethodDelete, pinSetPath(psID), nil, nil)
+}
+
+func (c *Client) GetPinSet(ctx context.Context, psID blobcache.PinSetID) (*blobcache.PinSet, error) {
+	ps := &blobcache.PinSet{}
+	if err := c.doJSON(ctx, http.MethodGet, pinSetPath(psID), nil, ps); err != nil {
+		return nil, err
+	}
+	return ps, nil
+}
+
+func (c *Client) GetPinSetByName(ctx context.Context, name string) (*blobcache.PinSet, error) {
+	// check here, since an invalid name could be mistaken for an ID, or a different route.
+	if err := blobcache.ValidatePinSetName(name); err != nil {
+		return nil, err
+	}
+	ps := &blobcache.PinSet{}
+	if err := c.doJSON(ctx, http.MethodGet, "/v1/pinsets/"+url.PathEscape(name), nil, ps); err != nil {
+		return nil, err
+	}
+	return ps, nil
+}
+
+func (c *Client) ListPinSets(ctx context.Context) ([]blobcache.PinSet, error) {
+	res := ListPinSetsRes{}
+	if err := c.doJSON(ctx, http.MethodGet, "/v1/pinsets/", nil, &res); err != nil {
+		return nil, err
+	}
+	return res.PinSets, nil
+}
+
+func (c *Client) RenamePinSet(ctx context.Context, psID blobcache.PinSetID, name string) error {
+	reqData, err := json.Marshal(RenamePinSetReq{Name: name})
+	if err != nil {
+		return err
+	}
+	return c.doJSON(ctx, http.MethodPatch, pinSetPath(psID), bytes.NewReader(reqData), nil)
+}
+
+func (c *Client) SetReplicas(ctx context.Context, psID blobcache.PinSetID, count int) error {
+	reqData, err := json.Marshal(SetReplicasReq{Replicas: count})
+	if err != nil {
+		return err
+	}
+	return c.doJSON(ctx, http.MethodPut, pinSetPath(psID)+"/replicas", bytes.NewReader(reqData), nil)
+}
+
+func (c *Client) Pin(ctx context.Context, psID blobcache.PinSetID, id blobs.ID) error {
+	return c.doJSON(ctx, http.MethodPut, pinSetBlobPath(psID, id), nil, nil)
+}
+
+func (c *Client) Unpin(ctx context.Context, psID blobcache.PinSetID, id blobs.ID) error {
+	return c.doJSON(ctx, http.MethodDelete, pinSetBlobPath(psID, id), nil, nil)
+}
+
+func (c *Client) Post(ctx context.Context, psID blobcache.PinSetID, data []byte) (blobs.ID, error) {
+	res := PostRes{}
+	if err := c.doJSON(ctx, http.MethodPost, pinSetPath(psID)+"/blobs", bytes.NewReader(data), &res); err != nil {
+		return blobs.ID{}, err
+	}
+	return res.ID, nil
+}
+
+func (c *Client) GetF(ctx context.Context, id blobs.ID, fn func([]byte) error) error {
+	res, err := c.do(ctx, http.MethodGet, "/v1/blobs/"+id.String(), nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(res.Body, blobs.MaxSize+1))
+	if err != nil {
+		return err
+	}
+	if !blobs.Hash(data).Equals(id) {
+		return fmt.Errorf("server returned bad data for blob %v", id)
+	}
+	return fn(data)
+}
+
+func (c *Client) Exists(ctx context.Context, psID blobcache.PinSetID, id blobs.ID) (bool, error) {
+	res, err := c.do(ctx, http.MethodHead, pinSetBlobPath(psID, id), nil)
+	if err == blobs.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	res.Body.Close()
+	return true, nil
+}
+
+// List lists the blobs in a pinset.
+// The server limits how many IDs it will return, so it may report blobs.ErrTooMany
+// for large buffers, even if there would have been space.
+func (c *Client) List(ctx context.Context, psID blobcache.PinSetID, prefix []byte, ids []blobs.ID) (int, error) {
+	limit := len(ids)
+	if limit > maxListLimit {
+		limit = maxListLimit
+	}
+	if limit < 1 {
+		return 0, blobs.ErrTooMany
+	}
+	q := url.Values{}
+	q.Set("prefix", hex.EncodeToString(prefix))
+	q.Set("limit", strconv.Itoa(limit))
+	res := ListRes{}
+	if err := c.doJSON(ctx, http.MethodGet, pinSetPath(psID)+"/blobs?"+q.Encode(), nil, &res); err != nil {
+		return 0, err
+	}
+	if len(res.IDs) > len(ids) {
+		return 0, blobs.ErrTooMany
+	}
+	return copy(ids, res.IDs), nil
+}
+
+// Export writes the pinset to w as an archive.
+// If the server fails part way through, the error from reading the response is returned.
+func (c *Client) Export(ctx context.Context, psID blobcache.PinSetID, w io.Writer) error {
+	res, err := c.do(ctx, http.MethodGet, pinSetPath(psID)+"/export", nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, err = io.Copy(w, res.Body)
+	return err
+}
+
+// Import streams the archive from r to the server, and returns the ID of the new pinset.
+func (c *Client) Import(ctx context.Context, name string, r io.Reader) (blobcache.PinSetID, error) {
+	q := url.Values{}
+	if name != "" {
+		q.Set("name", name)
+	}
+	res := CreatePinSetRes{}
+	if err := c.doJSON(ctx, http.MethodPost, "/v1/import?"+q.Encode(), r, &res); err != nil {
+		return 0, err
+	}
+	return res.ID, nil
+}
+
+// Balances returns the favors the node has exchanged with each of its peers.
+func (c *Client) Balances(ctx context.Context) ([]blobcache.PeerBalance, error) {
+	res := BalancesRes{}
+	if err := c.doJSON(ctx, http.MethodGet, "/v1/balances", nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Balances, nil
+}
+
+// MaxBlobSize returns the maximum blob size reported by the server.
+// It is only requested once.  If the request fails, blobs.MaxSize is returned.
+func (c *Client) MaxBlobSize() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.maxBlobSize > 0 {
+		return c.maxBlobSize
+	}
+	res := MaxBlobSizeRes{}
+	if err := c.doJSON(context.Background(), http.MethodGet, "/v1/max-blob-size", nil, &res); err != nil {
+		log.Error(err)
+		return blobs.MaxSize
+	}
+	c.maxBlobSize = res.MaxBlobSize
+	return c.maxBlobSize
+}
+
+// do makes a request, and returns an error for all non-2xx responses.
+// If err is nil, the caller must close the response body.
+func (c *Client) do(ctx context.Context, method, p string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.endpoint+p, body)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res, nil
+	}
+	defer res.Body.Close()
+	return nil, readError(res)
+}
+
+// doJSON makes a request, and decodes the JSON response into out, if it is not nil.
+func (c *Client) doJSON(ctx context.Context, method, p string, body io.Reader, out interface{}) error {
+	res, err := c.do(ctx, method, p, body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+func pinSetPath(psID blobcache.PinSetID) string {
+	return "/v1/pinsets/" + strconv.FormatInt(int64(psID), 10)
+}
+
+func pinSetBlobPath(psID blobcache.PinSetID, id blobs.ID) string {
+	return pinSetPath(psID) + "/blobs/" + id.String()
+}
@@ -0,0 +1,18 @@
+package bchttp
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/blobcache/blobcache/pkg/blobcache"
+	"github.com/blobcache/blobcache/pkg/blobcache/blobcachetest"
+)
+
+func TestClientAPI(t *testing.T) {
+	blobcachetest.TestAPI(t, func(t testing.TB) blobcache.API {
+		node := blobcachetest.NewTestNode(t)
+		hs := httptest.NewServer(NewServer(node, ""))
+		t.Cleanup(hs.Close)
+		return NewClient(hs.URL)
+	})
+}
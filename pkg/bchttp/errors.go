@@ -0,0 +1,107 @@
+package bchttp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/blobcache/blobcache/pkg/bcarchive"
+	"github.com/blobcache/blobcache/pkg/bcstate"
+	"github.com/blobcache/blobcache/pkg/blobcache"
+	"github.com/blobcache/blobcache/pkg/blobs"
+)
+
+const (
+	CodeBadRequest     = "bad_request"
+	CodeInternal       = "internal"
+	CodeNotFound       = "not_found"
+	CodePinSetNotFound = "pinset_not_found"
+	CodePinSetExists   = "pinset_exists"
+	CodeInvalidName    = "invalid_pinset_name"
+	CodeInvalidCount   = "invalid_replica_count"
+	CodeBlobNotFound   = "blob_not_found"
+	CodeTooMany        = "too_many"
+	CodeTooLarge       = "too_large"
+	CodeFull           = "full"
+	CodeBadArchive     = "bad_archive"
+
+	// errorCodeHeader holds the error code, for responses without a body, like HEAD.
+	errorCodeHeader = "X-Blobcache-Error"
+)
+
+// ErrorRes is the body of all non-2xx responses
+type ErrorRes struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e ErrorRes) Error() string {
+	return e.Message
+}
+
+var errorCodes = []struct {
+	err    error
+	code   string
+	status int
+}{
+	{blobcache.ErrPinSetNotFound, CodePinSetNotFound, http.StatusNotFound},
+	{blobcache.ErrPinSetExists, CodePinSetExists, http.StatusConflict},
+	{blobcache.ErrInvalidPinSetName, CodeInvalidName, http.StatusBadRequest},
+	{blobcache.ErrInvalidReplicaCount, CodeInvalidCount, http.StatusBadRequest},
+	{blobs.ErrNotFound, CodeBlobNotFound, http.StatusNotFound},
+	{blobs.ErrTooMany, CodeTooMany, http.StatusBadRequest},
+	{blobs.ErrTooLarge, CodeTooLarge, http.StatusRequestEntityTooLarge},
+	{bcstate.ErrFull, CodeFull, http.StatusInsufficientStorage},
+	{bcarchive.ErrBadMagic, CodeBadArchive, http.StatusBadRequest},
+	{bcarchive.ErrBadBlob, CodeBadArchive, http.StatusBadRequest},
+}
+
+// writeError writes err as an ErrorRes, with a status code depending on the error.
+func writeError(w http.ResponseWriter, err error) {
+	code, status := CodeInternal, http.StatusInternalServerError
+	for _, ec := range errorCodes {
+		if errors.Is(err, ec.err) {
+			code, status = ec.code, ec.status
+			break
+		}
+	}
+	if status == http.StatusInternalServerError {
+		log.Println(err)
+	}
+	writeErrorRes(w, status, ErrorRes{Code: code, Message: err.Error()})
+}
+
+func writeBadRequest(w http.ResponseWriter, err error) {
+	writeErrorRes(w, http.StatusBadRequest, ErrorRes{Code: CodeBadRequest, Message: err.Error()})
+}
+
+func writeErrorRes(w http.ResponseWriter, status int, res ErrorRes) {
+	w.Header().Set(errorCodeHeader, res.Code)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Println(err)
+	}
+}
+
+// readError returns the error for a non-2xx response.
+// Known codes are mapped back to the errors they came from, so callers can compare them.
+func readError(res *http.Response) error {
+	errRes := ErrorRes{Code: res.Header.Get(errorCodeHeader)}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<16)).Decode(&errRes); err != nil && errRes.Code == "" {
+		return fmt.Errorf("bchttp: %s", res.Status)
+	}
+	return errorFromCode(errRes)
+}
+
+func errorFromCode(res ErrorRes) error {
+	for _, ec := range errorCodes {
+		if ec.code == res.Code {
+			return ec.err
+		}
+	}
+	return res
+}
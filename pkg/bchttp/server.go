@@ -2,10 +2,12 @@ package bchttp
 
 import (
 	"context"
-	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"io"
-	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"strconv"
 	"time"
@@ -15,6 +17,51 @@ import (
 	"github.com/go-chi/chi"
 )
 
+const (
+	defaultListLimit = 1 << 10
+	maxListLimit     = 1 << 14
+)
+
+type CreatePinSetReq struct {
+	Name string `json:"name"`
+}
+
+type CreatePinSetRes struct {
+	ID blobcache.PinSetID `json:"id"`
+}
+
+type RenamePinSetReq struct {
+	Name string `json:"name"`
+}
+
+type SetReplicasReq struct {
+	Replicas int `json:"replicas"`
+}
+
+type ListPinSetsRes struct {
+	PinSets []blobcache.PinSet `json:"pinsets"`
+}
+
+type PostRes struct {
+	ID blobs.ID `json:"id"`
+}
+
+type ListRes struct {
+	IDs []blobs.ID `json:"ids"`
+}
+
+type MaxBlobSizeRes struct {
+	MaxBlobSize int `json:"max_blob_size"`
+}
+
+type BalancesRes struct {
+	Balances []blobcache.PeerBalance `json:"balances"`
+}
+
+type PostObjectRes struct {
+	Ref blobcache.ObjectRef `json:"ref"`
+}
+
 type Server struct {
 	n     blobcache.API
 	r     chi.Router
@@ -29,153 +76,422 @@ func NewServer(n blobcache.API, laddr string) *Server {
 			Addr:           laddr,
 			ReadTimeout:    10 * time.Second,
 			WriteTimeout:   10 * time.Second,
+			IdleTimeout:    time.Minute,
 			MaxHeaderBytes: 1 << 17,
+			ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+				return context.WithValue(ctx, connKey{}, c)
+			},
 		},
 		laddr: laddr,
 	}
 	r := chi.NewRouter()
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		writeErrorRes(w, http.StatusNotFound, ErrorRes{Code: CodeNotFound, Message: "no such route"})
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		writeErrorRes(w, http.StatusMethodNotAllowed, ErrorRes{Code: CodeBadRequest, Message: "method not allowed"})
+	})
 
-	r.Route("/s", func(r chi.Router) {
-		r.Post("/", s.createPinSet)
+	r.Route("/v1", func(r chi.Router) {
+		r.Get("/max-blob-size", s.maxBlobSize)
+		r.Get("/blobs/{blobID}", s.getBlob)
+		r.Get("/objects/{objectRef}", noTimeouts(s.getObject))
+		r.Post("/import", noTimeouts(s.importPinSet))
+		r.Get("/balances", s.balances)
 
-		r.Put("/{pinSetID:[0-9]+}", s.addPin)
-		r.Get("/{pinSetID:[0-9]+}/{blobID}", s.getBlob)
-		r.Delete("/{pinSetID:[0-9]}/{blobID}", s.deletePin)
-	})
+		r.Route("/pinsets", func(r chi.Router) {
+			r.Post("/", s.createPinSet)
+			r.Get("/", s.listPinSets)
 
-	r.Get("/{blobID}", s.getBlob)
+			// pinsets can be referred to by ID or by name
+			r.Route("/{pinSet}", func(r chi.Router) {
+				r.Get("/", s.getPinSet)
+				r.Patch("/", s.renamePinSet)
+				r.Delete("/", s.deletePinSet)
+				r.Put("/replicas", s.setReplicas)
+
+				r.Post("/blobs", s.post)
+				r.Get("/blobs", s.list)
+				r.Head("/blobs/{blobID}", s.exists)
+				r.Get("/blobs/{blobID}", s.getBlob)
+				r.Put("/blobs/{blobID}", s.pin)
+				r.Delete("/blobs/{blobID}", s.unpin)
+
+				r.Post("/objects", noTimeouts(s.postObject))
+				r.Get("/export", noTimeouts(s.exportPinSet))
+			})
+		})
+	})
 
 	s.r = r
 	s.hs.Handler = s.r
 	return s
 }
 
+type connKey struct{}
+
+// noTimeouts lifts the server's read and write timeouts for h.
+// Archives and objects are streamed in and out, and can take much longer than any other request.
+// The server only speaks HTTP/1, so the connection is not shared with other requests while h runs.
+func noTimeouts(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if c, ok := r.Context().Value(connKey{}).(net.Conn); ok {
+			c.SetReadDeadline(time.Time{})
+			c.SetWriteDeadline(time.Time{})
+		}
+		h(w, r)
+	}
+}
+
 func (s *Server) Run(ctx context.Context) error {
 	return s.hs.ListenAndServe()
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodPost:
-		s.post(w, r)
-	case http.MethodGet:
-		s.getBlob(w, r)
-	default:
-		w.WriteHeader(http.StatusBadRequest)
+	s.r.ServeHTTP(w, r)
+}
+
+func (s *Server) createPinSet(w http.ResponseWriter, r *http.Request) {
+	req := CreatePinSetReq{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBadRequest(w, err)
+		return
 	}
+	id, err := s.n.CreatePinSet(r.Context(), req.Name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, CreatePinSetRes{ID: id})
 }
 
-func (s *Server) post(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	maxSize := s.n.MaxBlobSize()
+func (s *Server) getPinSet(w http.ResponseWriter, r *http.Request) {
+	psID, err := s.pinSetParam(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	ps, err := s.n.GetPinSet(r.Context(), psID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ps)
+}
 
-	total := 0
-	buf := make([]byte, maxSize)
+func (s *Server) listPinSets(w http.ResponseWriter, r *http.Request) {
+	pinSets, err := s.n.ListPinSets(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if pinSets == nil {
+		pinSets = []blobcache.PinSet{}
+	}
+	writeJSON(w, http.StatusOK, ListPinSetsRes{PinSets: pinSets})
+}
 
-	for total < maxSize {
-		n, err := r.Body.Read(buf[total:])
-		total += int(n)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			log.Println(err)
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
+func (s *Server) renamePinSet(w http.ResponseWriter, r *http.Request) {
+	psID, err := s.pinSetParam(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	req := RenamePinSetReq{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBadRequest(w, err)
+		return
 	}
+	if err := s.n.RenamePinSet(r.Context(), psID, req.Name); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	mh, err := s.n.Post(ctx, 0, buf[:total])
+func (s *Server) setReplicas(w http.ResponseWriter, r *http.Request) {
+	psID, err := s.pinSetParam(r)
 	if err != nil {
-		log.Println(err)
+		writeError(w, err)
 		return
 	}
+	req := SetReplicasReq{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+	if err := s.n.SetReplicas(r.Context(), psID, req.Replicas); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	idb64 := make([]byte, base64.URLEncoding.EncodedLen(len(mh)))
-	base64.URLEncoding.Encode(idb64, mh[:])
+func (s *Server) deletePinSet(w http.ResponseWriter, r *http.Request) {
+	psID, err := s.pinSetParam(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := s.n.DeletePinSet(r.Context(), psID); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	_, err = w.Write(idb64)
+func (s *Server) post(w http.ResponseWriter, r *http.Request) {
+	psID, err := s.pinSetParam(r)
 	if err != nil {
-		log.Println(err)
+		writeError(w, err)
+		return
+	}
+	maxSize := s.n.MaxBlobSize()
+	buf := make([]byte, maxSize+1)
+	// read one byte past the max, so we can tell if the body was too large.
+	total, err := io.ReadFull(io.LimitReader(r.Body, int64(maxSize)+1), buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		writeBadRequest(w, err)
+		return
+	}
+	if total > maxSize {
+		writeError(w, blobs.ErrTooLarge)
+		return
+	}
+	id, err := s.n.Post(r.Context(), psID, buf[:total])
+	if err != nil {
+		writeError(w, err)
+		return
 	}
+	writeJSON(w, http.StatusCreated, PostRes{ID: id})
 }
 
-func (s *Server) addPin(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	idStr, ok := ctx.Value("pinSetID").(string)
-	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	psID, err := s.pinSetParam(r)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
-	pinSetID, err := strconv.Atoi(idStr)
+	q := r.URL.Query()
+	prefix, err := hex.DecodeString(q.Get("prefix"))
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeBadRequest(w, err)
 		return
 	}
+	limit := defaultListLimit
+	if x := q.Get("limit"); x != "" {
+		if limit, err = strconv.Atoi(x); err != nil {
+			writeBadRequest(w, err)
+			return
+		}
+		if limit < 1 || limit > maxListLimit {
+			writeBadRequest(w, errors.New("limit out of range"))
+			return
+		}
+	}
+	ids := make([]blobs.ID, limit)
+	n, err := s.n.List(r.Context(), psID, prefix, ids)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ListRes{IDs: ids[:n]})
+}
 
-	idb64, err := ioutil.ReadAll(r.Body)
+func (s *Server) exists(w http.ResponseWriter, r *http.Request) {
+	psID, err := s.pinSetParam(r)
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, err)
 		return
 	}
-	id := blobs.ID{}
-	if err := id.UnmarshalB64(idb64); err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusBadRequest)
+	id, err := blobIDParam(r)
+	if err != nil {
+		writeBadRequest(w, err)
 		return
 	}
-	if err := s.n.Pin(r.Context(), blobcache.PinSetID(pinSetID), id); err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+	exists, err := s.n.Exists(r.Context(), psID, id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !exists {
+		writeError(w, blobs.ErrNotFound)
+		return
 	}
 	w.WriteHeader(http.StatusOK)
 }
 
-func (s *Server) getBlob(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	idStr, ok := ctx.Value("blobID").(string)
-	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
+func (s *Server) pin(w http.ResponseWriter, r *http.Request) {
+	psID, err := s.pinSetParam(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	id, err := blobIDParam(r)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+	if err := s.n.Pin(r.Context(), psID, id); err != nil {
+		writeError(w, err)
 		return
 	}
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	id := blobs.ID{}
-	if err := id.UnmarshalB64([]byte(idStr)); err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusBadRequest)
+func (s *Server) unpin(w http.ResponseWriter, r *http.Request) {
+	psID, err := s.pinSetParam(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	id, err := blobIDParam(r)
+	if err != nil {
+		writeBadRequest(w, err)
 		return
 	}
-	err := s.n.GetF(ctx, id, func(data []byte) error {
+	if err := s.n.Unpin(r.Context(), psID, id); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) getBlob(w http.ResponseWriter, r *http.Request) {
+	id, err := blobIDParam(r)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+	err = s.n.GetF(r.Context(), id, func(data []byte) error {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
 		_, err := w.Write(data)
 		return err
 	})
 	if err != nil {
-		if err == blobs.ErrNotFound {
-			w.WriteHeader(http.StatusNotFound)
-			return
-		}
-		log.Println(err)
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, err)
 		return
 	}
 }
 
-func (s *Server) createPinSet(w http.ResponseWriter, r *http.Request) {
-	data, err := ioutil.ReadAll(r.Body)
+func (s *Server) maxBlobSize(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, MaxBlobSizeRes{MaxBlobSize: s.n.MaxBlobSize()})
+}
+
+func (s *Server) balances(w http.ResponseWriter, r *http.Request) {
+	balances, err := s.n.Balances(r.Context())
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, err)
+		return
 	}
+	writeJSON(w, http.StatusOK, BalancesRes{Balances: balances})
+}
 
-	ctx := r.Context()
-	id, err := s.n.CreatePinSet(ctx, string(data))
+func (s *Server) postObject(w http.ResponseWriter, r *http.Request) {
+	psID, err := s.pinSetParam(r)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, err)
+		return
+	}
+	store := blobcache.NewStore(s.n, psID)
+	ref, err := blobcache.PostObject(r.Context(), store, r.Body)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, PostObjectRes{Ref: *ref})
+}
+
+// getObject serves an object, with support for Range requests
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request) {
+	var ref blobcache.ObjectRef
+	if err := ref.UnmarshalText([]byte(chi.URLParam(r, "objectRef"))); err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+	or := blobcache.NewObjectReader(r.Context(), blobcache.NewGetter(s.n), ref)
+	// ServeContent sends the status before reading anything, so errors have to be found first.
+	if err := or.Resolve(); err != nil {
+		writeError(w, err)
 		return
 	}
-	w.Write([]byte(strconv.Itoa(int(id))))
+	content := &objectContent{ObjectReader: or}
+	http.ServeContent(w, r, "", time.Time{}, content)
+	if content.err != nil {
+		// the status has already been sent, so the only way to tell the client
+		// that the object is incomplete is to abort the response.
+		log.Println(content.err)
+		panic(http.ErrAbortHandler)
+	}
 }
 
-func (s *Server) deletePin(w http.ResponseWriter, r *http.Request) {
+// objectContent records the first error reading an object, which ServeContent would otherwise drop.
+type objectContent struct {
+	*blobcache.ObjectReader
+	err error
+}
 
+func (c *objectContent) Read(p []byte) (int, error) {
+	n, err := c.ObjectReader.Read(p)
+	if err != nil && err != io.EOF && c.err == nil {
+		c.err = err
+	}
+	return n, err
+}
+
+func (s *Server) exportPinSet(w http.ResponseWriter, r *http.Request) {
+	psID, err := s.pinSetParam(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	// check that the pinset exists, so the error can be sent before the archive is started.
+	if _, err := s.n.GetPinSet(r.Context(), psID); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if err := s.n.Export(r.Context(), psID, w); err != nil {
+		// the status has already been sent, so the only way to tell the client
+		// that the archive is incomplete is to abort the response.
+		log.Println(err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func (s *Server) importPinSet(w http.ResponseWriter, r *http.Request) {
+	id, err := s.n.Import(r.Context(), r.URL.Query().Get("name"), r.Body)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, CreatePinSetRes{ID: id})
+}
+
+// pinSetParam returns the ID of the pinset in the URL, looking it up if it is referred to by name.
+func (s *Server) pinSetParam(r *http.Request) (blobcache.PinSetID, error) {
+	x := chi.URLParam(r, "pinSet")
+	if id, err := strconv.ParseInt(x, 10, 64); err == nil {
+		return blobcache.PinSetID(id), nil
+	}
+	ps, err := s.n.GetPinSetByName(r.Context(), x)
+	if err != nil {
+		return 0, err
+	}
+	return ps.ID, nil
+}
+
+func blobIDParam(r *http.Request) (blobs.ID, error) {
+	id := blobs.ID{}
+	err := id.UnmarshalB64([]byte(chi.URLParam(r, "blobID")))
+	return id, err
+}
+
+func writeJSON(w http.ResponseWriter, status int, x interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(x); err != nil {
+		log.Println(err)
+	}
 }
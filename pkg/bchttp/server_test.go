@@ -0,0 +1,181 @@
+package bchttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blobcache/blobcache/pkg/blobcache"
+	"github.com/blobcache/blobcache/pkg/blobcache/blobcachetest"
+	"github.com/blobcache/blobcache/pkg/blobs"
+)
+
+func TestPinSetByName(t *testing.T) {
+	node := blobcachetest.NewTestNode(t)
+	hs := httptest.NewServer(NewServer(node, ""))
+	defer hs.Close()
+
+	res, err := http.Post(hs.URL+"/v1/pinsets/", "application/json", bytes.NewReader([]byte(`{"name": "my-pinset"}`)))
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+
+	data := []byte("test-data")
+	res, err = http.Post(hs.URL+"/v1/pinsets/my-pinset/blobs", "application/octet-stream", bytes.NewReader(data))
+	require.NoError(t, err)
+	postRes := PostRes{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&postRes))
+	res.Body.Close()
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	assert.Equal(t, blobs.Hash(data), postRes.ID)
+
+	res, err = http.Head(hs.URL + "/v1/pinsets/my-pinset/blobs/" + postRes.ID.String())
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	res, err = http.Get(hs.URL + "/v1/pinsets/other-pinset")
+	require.NoError(t, err)
+	errRes := ErrorRes{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&errRes))
+	res.Body.Close()
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	assert.Equal(t, CodePinSetNotFound, errRes.Code)
+}
+
+func TestGetObjectMissing(t *testing.T) {
+	ctx := context.TODO()
+	node := blobcachetest.NewTestNode(t)
+	hs := httptest.NewServer(NewServer(node, ""))
+	defer hs.Close()
+	psID, err := node.CreatePinSet(ctx, "pinset1")
+	require.NoError(t, err)
+
+	getObject := func(skip int) *http.Response {
+		// chunks are convergently encrypted, so the data has to be different each time.
+		data := make([]byte, 1<<18)
+		rand.New(rand.NewSource(int64(skip))).Read(data)
+		s := &skipPoster{Poster: blobcache.NewStore(node, psID), skip: skip}
+		ref, err := blobcache.PostObject(ctx, s, bytes.NewReader(data))
+		require.NoError(t, err)
+		require.Greater(t, s.n, 2, "object should have several chunks")
+		res, err := http.Get(hs.URL + "/v1/objects/" + ref.String())
+		require.NoError(t, err)
+		return res
+	}
+
+	// the first chunk is missing, which is found before the response starts
+	res := getObject(0)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	// the second chunk is missing, so the response is aborted
+	res = getObject(1)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	_, err = ioutil.ReadAll(res.Body)
+	assert.Error(t, err)
+}
+
+func TestArchiveTimeouts(t *testing.T) {
+	ctx := context.TODO()
+	node := blobcachetest.NewTestNode(t)
+	s := NewServer(node, "")
+	s.hs.ReadTimeout = 100 * time.Millisecond
+	s.hs.WriteTimeout = 100 * time.Millisecond
+	hs := httptest.NewUnstartedServer(nil)
+	hs.Config = &s.hs
+	hs.Start()
+	defer hs.Close()
+
+	psID, err := node.CreatePinSet(ctx, "pinset1")
+	require.NoError(t, err)
+	_, err = node.Post(ctx, psID, []byte("test-data"))
+	require.NoError(t, err)
+	archive := &bytes.Buffer{}
+	require.NoError(t, node.Export(ctx, psID, archive))
+
+	// the body is slower than the read timeout allows.
+	res, err := http.Post(hs.URL+"/v1/pinsets/pinset1/blobs", "application/octet-stream", slowly([]byte("other-data")))
+	if err == nil {
+		res.Body.Close()
+		assert.NotEqual(t, http.StatusCreated, res.StatusCode)
+	}
+
+	// but archives can take as long as they need.
+	res, err = http.Post(hs.URL+"/v1/import?name=pinset2", "application/octet-stream", slowly(archive.Bytes()))
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+}
+
+func TestObjectTimeouts(t *testing.T) {
+	ctx := context.TODO()
+	node := blobcachetest.NewTestNode(t)
+	s := NewServer(node, "")
+	s.hs.ReadTimeout = 100 * time.Millisecond
+	s.hs.WriteTimeout = 100 * time.Millisecond
+	hs := httptest.NewUnstartedServer(nil)
+	hs.Config = &s.hs
+	hs.Start()
+	defer hs.Close()
+	_, err := node.CreatePinSet(ctx, "pinset1")
+	require.NoError(t, err)
+
+	// objects can be uploaded slower than the read timeout allows
+	data := make([]byte, 1<<22)
+	rand.New(rand.NewSource(0)).Read(data)
+	res, err := http.Post(hs.URL+"/v1/pinsets/pinset1/objects", "application/octet-stream", slowly(data))
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	postRes := PostObjectRes{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&postRes))
+
+	// and downloaded slower than the write timeout allows
+	res, err = http.Get(hs.URL + "/v1/objects/" + postRes.Ref.String())
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	actual, err := ioutil.ReadAll(io.MultiReader(io.LimitReader(res.Body, 1), sleepReader(200*time.Millisecond), res.Body))
+	require.NoError(t, err)
+	assert.Equal(t, data, actual)
+}
+
+// slowly returns a reader for data which pauses after the first byte.
+func slowly(data []byte) io.Reader {
+	return io.MultiReader(bytes.NewReader(data[:1]), sleepReader(200*time.Millisecond), bytes.NewReader(data[1:]))
+}
+
+// sleepReader sleeps for its duration, then returns io.EOF.
+type sleepReader time.Duration
+
+func (r sleepReader) Read([]byte) (int, error) {
+	time.Sleep(time.Duration(r))
+	return 0, io.EOF
+}
+
+// skipPoster doesn't post the blob with index skip, in the order they are posted.
+type skipPoster struct {
+	blobs.Poster
+	skip int
+	n    int
+}
+
+func (s *skipPoster) Post(ctx context.Context, data []byte) (blobs.ID, error) {
+	defer func() { s.n++ }()
+	if s.n == s.skip {
+		return blobs.Hash(data), nil
+	}
+	return s.Poster.Post(ctx, data)
+}
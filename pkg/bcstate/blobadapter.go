@@ -15,7 +15,11 @@ func BlobAdapter(c KV) blobAdapter {
 }
 
 func (s blobAdapter) GetF(ctx context.Context, id blobs.ID, fn func(data []byte) error) error {
-	return s.c.GetF(id[:], fn)
+	err := s.c.GetF(id[:], fn)
+	if err == ErrNotExist {
+		return blobs.ErrNotFound
+	}
+	return err
 }
 
 func (s blobAdapter) Exists(ctx context.Context, id blobs.ID) (bool, error) {
@@ -24,6 +28,9 @@ func (s blobAdapter) Exists(ctx context.Context, id blobs.ID) (bool, error) {
 		exists = true
 		return nil
 	})
+	if err == blobs.ErrNotFound {
+		return false, nil
+	}
 	return exists, err
 }
 
@@ -40,9 +47,7 @@ func (s blobAdapter) Delete(ctx context.Context, id blobs.ID) error {
 }
 
 func (s blobAdapter) List(ctx context.Context, prefix []byte, ids []blobs.ID) (n int, err error) {
-	first := prefix
-	last := append(prefix, 0)
-	err = s.c.ForEach(first, last, func(k, v []byte) error {
+	err = s.c.ForEach(prefix, PrefixEnd(prefix), func(k, v []byte) error {
 		if n == len(ids) {
 			return blobs.ErrTooMany
 		}
@@ -3,20 +3,42 @@ package bcstate
 import (
 	"bytes"
 	"context"
-	"math"
+	"encoding/binary"
 
 	bolt "go.etcd.io/bbolt"
 )
 
 var _ DB = &BoltDB{}
 
+var (
+	// bucketSizes holds the number of bytes used by each bucket
+	bucketSizes = []byte("_bcstate_sizes")
+	// bucketMeta holds the number of bytes used by the whole DB
+	bucketMeta = []byte("_bcstate_meta")
+	keyTotal   = []byte("size_total")
+)
+
 type BoltDB struct {
 	db  *bolt.DB
 	cap uint64
 }
 
+// NewBoltDB is OpenBoltDB, but it panics if the sizes of the buckets can't be set up.
 func NewBoltDB(db *bolt.DB, capacity uint64) *BoltDB {
-	return &BoltDB{db: db, cap: capacity}
+	bdb, err := OpenBoltDB(db, capacity)
+	if err != nil {
+		panic(err)
+	}
+	return bdb
+}
+
+// OpenBoltDB creates a DB which will hold at most capacity bytes across all its buckets.
+// A capacity of 0 means there is no limit.
+func OpenBoltDB(db *bolt.DB, capacity uint64) (*BoltDB, error) {
+	if err := db.Update(initSizes); err != nil {
+		return nil, err
+	}
+	return &BoltDB{db: db, cap: capacity}, nil
 }
 
 func (db *BoltDB) Bucket(p string) KV {
@@ -28,23 +50,25 @@ func (db *BoltDB) Bucket(p string) KV {
 			return db.db.Update(f)
 		},
 		bucketName: []byte(p),
+		cap:        db.cap,
 	}
 }
 
 func (kv *BoltDB) WriteTx(ctx context.Context, f func(db DB) error) error {
 	return kv.db.Update(func(tx *bolt.Tx) error {
-		return f(boltTx{tx})
+		return f(boltTx{tx: tx, cap: kv.cap})
 	})
 }
 
 func (kv *BoltDB) ReadTx(ctx context.Context, f func(db DB) error) error {
 	return kv.db.Update(func(tx *bolt.Tx) error {
-		return f(boltTx{tx})
+		return f(boltTx{tx: tx, cap: kv.cap})
 	})
 }
 
 type boltTx struct {
-	tx *bolt.Tx
+	tx  *bolt.Tx
+	cap uint64
 }
 
 func (btx boltTx) Bucket(name string) KV {
@@ -56,6 +80,7 @@ func (btx boltTx) Bucket(name string) KV {
 			return f(btx.tx)
 		},
 		bucketName: []byte(name),
+		cap:        btx.cap,
 	}
 }
 
@@ -65,15 +90,19 @@ type boltKV struct {
 	update     func(func(tx *bolt.Tx) error) error
 	view       func(func(tx *bolt.Tx) error) error
 	bucketName []byte
+	cap        uint64
 }
 
 func (kv *boltKV) GetF(key []byte, f func([]byte) error) error {
 	return kv.view(func(tx *bolt.Tx) error {
 		b := kv.selectBucket(tx)
 		if b == nil {
-			return nil
+			return ErrNotExist
 		}
 		value := b.Get(key)
+		if value == nil {
+			return ErrNotExist
+		}
 		return f(value)
 	})
 }
@@ -81,6 +110,13 @@ func (kv *boltKV) GetF(key []byte, f func([]byte) error) error {
 func (kv *boltKV) Put(key, value []byte) error {
 	err := kv.update(func(tx *bolt.Tx) error {
 		b := kv.selectBucket(tx)
+		var prevSize uint64
+		if prev := b.Get(key); prev != nil {
+			prevSize = entrySize(key, prev)
+		}
+		if err := kv.resize(tx, prevSize, entrySize(key, value)); err != nil {
+			return err
+		}
 		return b.Put(key, value)
 	})
 	return err
@@ -89,6 +125,13 @@ func (kv *boltKV) Put(key, value []byte) error {
 func (kv *boltKV) Delete(key []byte) error {
 	err := kv.update(func(tx *bolt.Tx) error {
 		b := kv.selectBucket(tx)
+		prev := b.Get(key)
+		if prev == nil {
+			return nil
+		}
+		if err := kv.resize(tx, entrySize(key, prev), 0); err != nil {
+			return err
+		}
 		return b.Delete(key)
 	})
 	return err
@@ -100,7 +143,7 @@ func (kv *boltKV) ForEach(start, end []byte, fn func(k, v []byte) error) error {
 
 		c := b.Cursor()
 		for k, v := c.Seek(start); k != nil; k, v = c.Next() {
-			if bytes.Compare(k, end) >= 0 {
+			if end != nil && bytes.Compare(k, end) >= 0 {
 				break
 			}
 			if err := fn(k, v); err != nil {
@@ -125,17 +168,28 @@ func (kv *boltKV) NextSequence() (uint64, error) {
 	return seq, err
 }
 
-func (kv *boltKV) MaxCount() uint64 {
-	return uint64(math.MaxInt64)
+func (kv *boltKV) SizeTotal() uint64 {
+	if kv.cap == 0 {
+		return Unlimited
+	}
+	var total uint64
+	err := kv.view(func(tx *bolt.Tx) error {
+		othersUsed := getUint64(tx.Bucket(bucketMeta), keyTotal) - getUint64(tx.Bucket(bucketSizes), kv.bucketName)
+		if othersUsed < kv.cap {
+			total = kv.cap - othersUsed
+		}
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	return total
 }
 
-func (kv *boltKV) Count() uint64 {
+func (kv *boltKV) SizeUsed() uint64 {
 	var size uint64
 	err := kv.view(func(tx *bolt.Tx) error {
-		b := kv.selectBucket(tx)
-		if b != nil {
-			size = uint64(b.Stats().KeyN)
-		}
+		size = getUint64(tx.Bucket(bucketSizes), kv.bucketName)
 		return nil
 	})
 	if err != nil {
@@ -144,6 +198,22 @@ func (kv *boltKV) Count() uint64 {
 	return size
 }
 
+// resize updates the size of the bucket and the db, for an entry going from prev to next bytes.
+// It returns ErrFull if the DB would grow beyond its capacity.
+func (kv *boltKV) resize(tx *bolt.Tx, prev, next uint64) error {
+	sizes := tx.Bucket(bucketSizes)
+	meta := tx.Bucket(bucketMeta)
+	total := getUint64(meta, keyTotal) - prev + next
+	if kv.cap > 0 && next > prev && total > kv.cap {
+		return ErrFull
+	}
+	used := getUint64(sizes, kv.bucketName) - prev + next
+	if err := putUint64(sizes, kv.bucketName, used); err != nil {
+		return err
+	}
+	return putUint64(meta, keyTotal, total)
+}
+
 func (kv *boltKV) selectBucket(tx *bolt.Tx) *bolt.Bucket {
 	type hasBucket interface {
 		CreateBucketIfNotExists([]byte) (*bolt.Bucket, error)
@@ -166,3 +236,54 @@ func (kv *boltKV) selectBucket(tx *bolt.Tx) *bolt.Bucket {
 	}
 	return b.(*bolt.Bucket)
 }
+
+// initSizes creates the size accounting buckets,
+// counting the size of any existing buckets if they do not exist.
+func initSizes(tx *bolt.Tx) error {
+	if tx.Bucket(bucketMeta) != nil {
+		return nil
+	}
+	sizes, err := tx.CreateBucketIfNotExists(bucketSizes)
+	if err != nil {
+		return err
+	}
+	meta, err := tx.CreateBucket(bucketMeta)
+	if err != nil {
+		return err
+	}
+	var total uint64
+	if err := tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		if bytes.Equal(name, bucketSizes) || bytes.Equal(name, bucketMeta) {
+			return nil
+		}
+		var used uint64
+		if err := b.ForEach(func(k, v []byte) error {
+			used += entrySize(k, v)
+			return nil
+		}); err != nil {
+			return err
+		}
+		total += used
+		return putUint64(sizes, name, used)
+	}); err != nil {
+		return err
+	}
+	return putUint64(meta, keyTotal, total)
+}
+
+func getUint64(b *bolt.Bucket, key []byte) uint64 {
+	if b == nil {
+		return 0
+	}
+	v := b.Get(key)
+	if len(v) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(v)
+}
+
+func putUint64(b *bolt.Bucket, key []byte, x uint64) error {
+	buf := [8]byte{}
+	binary.BigEndian.PutUint64(buf[:], x)
+	return b.Put(key, buf[:])
+}
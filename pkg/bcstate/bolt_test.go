@@ -0,0 +1,41 @@
+package bcstate
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBoltCapacity(t *testing.T) {
+	bdb, err := bolt.Open(filepath.Join(t.TempDir(), "test.db"), 0666, nil)
+	require.NoError(t, err)
+	defer bdb.Close()
+	db, err := OpenBoltDB(bdb, 100)
+	require.NoError(t, err)
+	testSharedCapacity(t, db, 100)
+}
+
+func testSharedCapacity(t *testing.T, db DB, capacity uint64) {
+	a, b := db.Bucket("a"), db.Bucket("b")
+	value := make([]byte, 38)
+
+	// 2 entries of 40 bytes fit in 100 bytes
+	require.NoError(t, a.Put([]byte("k1"), value))
+	require.NoError(t, b.Put([]byte("k1"), value))
+	assert.Equal(t, uint64(40), a.SizeUsed())
+	assert.Equal(t, capacity-40, a.SizeTotal())
+
+	// a third doesn't
+	require.Equal(t, ErrFull, a.Put([]byte("k2"), value))
+	// overwriting with something the same size does
+	require.NoError(t, a.Put([]byte("k1"), value))
+
+	// deleting makes space
+	require.NoError(t, b.Delete([]byte("k1")))
+	assert.Equal(t, uint64(0), b.SizeUsed())
+	require.NoError(t, a.Put([]byte("k2"), value))
+	assert.Equal(t, uint64(80), a.SizeUsed())
+}
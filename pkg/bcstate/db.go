@@ -30,14 +30,18 @@ type PrefixedTxDB struct {
 	TxDB
 }
 
+func (tx PrefixedTxDB) Bucket(p string) KV {
+	return PrefixedDB{Prefix: tx.Prefix, DB: tx.TxDB}.Bucket(p)
+}
+
 func (tx PrefixedTxDB) WriteTx(ctx context.Context, f func(DB) error) error {
-	return tx.WriteTx(ctx, func(db DB) error {
+	return tx.TxDB.WriteTx(ctx, func(db DB) error {
 		return f(PrefixedDB{Prefix: tx.Prefix, DB: db})
 	})
 }
 
 func (tx PrefixedTxDB) ReadTx(ctx context.Context, f func(db DB) error) error {
-	return tx.ReadTx(ctx, func(db DB) error {
+	return tx.TxDB.ReadTx(ctx, func(db DB) error {
 		return f(PrefixedDB{Prefix: tx.Prefix, DB: db})
 	})
 }
@@ -1,6 +1,9 @@
 package bcstate
 
-import "errors"
+import (
+	"errors"
+	"math"
+)
 
 var (
 	ErrFull     = errors.New("store is full")
@@ -17,8 +20,19 @@ type KV interface {
 	// if last == nil ForEach will call fn with the last key
 	ForEach(first, last []byte, fn func(k, v []byte) error) error
 
-	Count() uint64
-	MaxCount() uint64
+	// SizeUsed is the number of bytes stored in the KV.
+	// Each entry takes up len(key) + len(value) bytes
+	SizeUsed() uint64
+	// SizeTotal is the number of bytes the KV could hold, including SizeUsed.
+	// Put returns ErrFull if it would cause SizeUsed to exceed SizeTotal
+	SizeTotal() uint64
+}
+
+// Unlimited is the SizeTotal of a KV with no capacity limit
+const Unlimited = math.MaxUint64
+
+func entrySize(key, value []byte) uint64 {
+	return uint64(len(key) + len(value))
 }
 
 func Exists(kv KV, key []byte) (bool, error) {
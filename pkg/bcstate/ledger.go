@@ -0,0 +1,225 @@
+package bcstate
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/brendoncarroll/go-p2p"
+)
+
+const (
+	// ForwardCost is the number of bytes a forwarded request is worth, when comparing favors.
+	ForwardCost = 1024
+	// DefaultAllowance is the debt in bytes every peer can run up, on top of the trust placed in it.
+	// It is enough for a few blobs, so a peer with no trust is not refused after its first request.
+	DefaultAllowance = 256 * 1024
+)
+
+// Favors counts the work done by one peer for another.
+type Favors struct {
+	BytesServed       uint64 `json:"bytes_served"`
+	BytesStored       uint64 `json:"bytes_stored"`
+	RequestsForwarded uint64 `json:"requests_forwarded"`
+}
+
+// Value is the total worth of the favors in bytes.
+func (f Favors) Value() int64 {
+	return int64(f.BytesServed + f.BytesStored + f.RequestsForwarded*ForwardCost)
+}
+
+func (f Favors) add(x Favors) Favors {
+	return Favors{
+		BytesServed:       f.BytesServed + x.BytesServed,
+		BytesStored:       f.BytesStored + x.BytesStored,
+		RequestsForwarded: f.RequestsForwarded + x.RequestsForwarded,
+	}
+}
+
+// Balance is the favors exchanged with a single peer.
+type Balance struct {
+	// Given is the favors done for the peer
+	Given Favors `json:"given"`
+	// Received is the favors done by the peer
+	Received Favors `json:"received"`
+}
+
+// Debt is how much the peer owes, in bytes. It is negative if we owe the peer.
+func (b Balance) Debt() int64 {
+	return b.Given.Value() - b.Received.Value()
+}
+
+// Ledger keeps the favors exchanged with each peer.
+// A peer whose debt exceeds the trust placed in it, plus the ledger's allowance, should not be given any more favors.
+//
+// Balances are kept in memory as they change, and only written to the KV by Flush.
+// All the methods can be called on a nil Ledger, which records nothing, and never refuses a peer.
+type Ledger struct {
+	kv        KV
+	trustFor  func(p2p.PeerID) (int64, error)
+	allowance int64
+
+	mu       sync.Mutex
+	balances map[p2p.PeerID]*Balance
+	dirty    map[p2p.PeerID]struct{}
+}
+
+// NewLedger returns a Ledger stored in kv, which allows every peer DefaultAllowance.
+// trustFor is used to get the trust for a peer. Peers it returns an error for have no trust.
+func NewLedger(kv KV, trustFor func(p2p.PeerID) (int64, error)) *Ledger {
+	return &Ledger{
+		kv:        kv,
+		trustFor:  trustFor,
+		allowance: DefaultAllowance,
+		balances:  make(map[p2p.PeerID]*Balance),
+		dirty:     make(map[p2p.PeerID]struct{}),
+	}
+}
+
+// Gave records favors done for peer.
+func (l *Ledger) Gave(peer p2p.PeerID, f Favors) error {
+	return l.update(peer, func(b *Balance) {
+		b.Given = b.Given.add(f)
+	})
+}
+
+// Received records favors done by peer.
+func (l *Ledger) Received(peer p2p.PeerID, f Favors) error {
+	return l.update(peer, func(b *Balance) {
+		b.Received = b.Received.add(f)
+	})
+}
+
+// Balance returns the favors exchanged with peer.
+func (l *Ledger) Balance(peer p2p.PeerID) (*Balance, error) {
+	if l == nil {
+		return &Balance{}, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, err := l.get(peer)
+	if err != nil {
+		return nil, err
+	}
+	b2 := *b
+	return &b2, nil
+}
+
+// Trust returns the trust placed in peer.
+func (l *Ledger) Trust(peer p2p.PeerID) int64 {
+	if l == nil || l.trustFor == nil {
+		return 0
+	}
+	trust, err := l.trustFor(peer)
+	if err != nil {
+		return 0
+	}
+	return trust
+}
+
+// InDebt returns true if peer owes more than the trust placed in it, plus the allowance.
+func (l *Ledger) InDebt(peer p2p.PeerID) (bool, error) {
+	if l == nil {
+		return false, nil
+	}
+	b, err := l.Balance(peer)
+	if err != nil {
+		return false, err
+	}
+	return b.Debt() > l.allowance+l.Trust(peer), nil
+}
+
+// ForEach calls fn with the balance of every peer which has exchanged favors, in order of peer ID.
+func (l *Ledger) ForEach(fn func(peer p2p.PeerID, b Balance) error) error {
+	if l == nil {
+		return nil
+	}
+	// collect everything first, so fn can use the ledger.
+	balances := make(map[p2p.PeerID]Balance)
+	l.mu.Lock()
+	err := l.kv.ForEach(nil, nil, func(k, v []byte) error {
+		var peer p2p.PeerID
+		copy(peer[:], k)
+		var b Balance
+		if err := json.Unmarshal(v, &b); err != nil {
+			return err
+		}
+		balances[peer] = b
+		return nil
+	})
+	for peer, b := range l.balances {
+		balances[peer] = *b
+	}
+	l.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	peers := make([]p2p.PeerID, 0, len(balances))
+	for peer := range balances {
+		peers = append(peers, peer)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return bytes.Compare(peers[i][:], peers[j][:]) < 0
+	})
+	for _, peer := range peers {
+		if err := fn(peer, balances[peer]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush writes the balances which have changed since the last Flush to the KV.
+func (l *Ledger) Flush() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for peer := range l.dirty {
+		data, err := json.Marshal(l.balances[peer])
+		if err != nil {
+			panic(err)
+		}
+		if err := l.kv.Put(peer[:], data); err != nil {
+			return err
+		}
+		delete(l.dirty, peer)
+	}
+	return nil
+}
+
+func (l *Ledger) update(peer p2p.PeerID, fn func(b *Balance)) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, err := l.get(peer)
+	if err != nil {
+		return err
+	}
+	fn(b)
+	l.dirty[peer] = struct{}{}
+	return nil
+}
+
+// get returns the balance for peer, loading it from the KV if it is not in memory.
+func (l *Ledger) get(peer p2p.PeerID) (*Balance, error) {
+	if b, exists := l.balances[peer]; exists {
+		return b, nil
+	}
+	b := &Balance{}
+	err := l.kv.GetF(peer[:], func(v []byte) error {
+		return json.Unmarshal(v, b)
+	})
+	if err == ErrNotExist {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	l.balances[peer] = b
+	return b, nil
+}
@@ -0,0 +1,97 @@
+package bcstate
+
+import (
+	"testing"
+
+	"github.com/brendoncarroll/go-p2p"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLedger(t *testing.T) {
+	peer := p2p.PeerID{1}
+	trust := map[p2p.PeerID]int64{peer: 2 * ForwardCost}
+	l := NewLedger(&MemKV{}, func(id p2p.PeerID) (int64, error) {
+		return trust[id], nil
+	})
+
+	require.NoError(t, l.Gave(peer, Favors{BytesServed: ForwardCost + DefaultAllowance, RequestsForwarded: 1}))
+	b, err := l.Balance(peer)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2*ForwardCost+DefaultAllowance), b.Debt())
+	inDebt, err := l.InDebt(peer)
+	require.NoError(t, err)
+	assert.False(t, inDebt)
+
+	require.NoError(t, l.Gave(peer, Favors{BytesStored: 1}))
+	inDebt, err = l.InDebt(peer)
+	require.NoError(t, err)
+	assert.True(t, inDebt)
+
+	// the peer returns the favor
+	require.NoError(t, l.Received(peer, Favors{BytesServed: DefaultAllowance + 100}))
+	inDebt, err = l.InDebt(peer)
+	require.NoError(t, err)
+	assert.False(t, inDebt)
+
+	var peers []p2p.PeerID
+	require.NoError(t, l.ForEach(func(id p2p.PeerID, b Balance) error {
+		peers = append(peers, id)
+		assert.Equal(t, int64(2*ForwardCost+1-100), b.Debt())
+		return nil
+	}))
+	assert.Equal(t, []p2p.PeerID{peer}, peers)
+
+	// a nil ledger records nothing, and refuses no one
+	var nilLedger *Ledger
+	require.NoError(t, nilLedger.Gave(peer, Favors{BytesServed: 1 << 30}))
+	inDebt, err = nilLedger.InDebt(peer)
+	require.NoError(t, err)
+	assert.False(t, inDebt)
+}
+
+func TestLedgerAllowance(t *testing.T) {
+	peer := p2p.PeerID{1}
+	l := NewLedger(&MemKV{}, func(id p2p.PeerID) (int64, error) {
+		return 0, nil
+	})
+
+	// a peer with no trust can run up a debt of the allowance, but no more.
+	require.NoError(t, l.Gave(peer, Favors{BytesServed: DefaultAllowance}))
+	inDebt, err := l.InDebt(peer)
+	require.NoError(t, err)
+	assert.False(t, inDebt)
+
+	require.NoError(t, l.Gave(peer, Favors{BytesServed: 1}))
+	inDebt, err = l.InDebt(peer)
+	require.NoError(t, err)
+	assert.True(t, inDebt)
+}
+
+func TestLedgerFlush(t *testing.T) {
+	peer := p2p.PeerID{1}
+	kv := &MemKV{}
+	trustFor := func(id p2p.PeerID) (int64, error) {
+		return 0, nil
+	}
+	l := NewLedger(kv, trustFor)
+
+	// favors are not written until the ledger is flushed.
+	for i := 0; i < 10; i++ {
+		require.NoError(t, l.Gave(peer, Favors{BytesServed: 10}))
+	}
+	assert.Equal(t, uint64(0), kv.SizeUsed())
+	var n int
+	require.NoError(t, l.ForEach(func(id p2p.PeerID, b Balance) error {
+		n++
+		assert.Equal(t, int64(100), b.Debt())
+		return nil
+	}))
+	assert.Equal(t, 1, n)
+
+	require.NoError(t, l.Flush())
+	l2 := NewLedger(kv, trustFor)
+	b, err := l2.Balance(peer)
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), b.Debt())
+}
@@ -7,39 +7,48 @@ import (
 )
 
 type MemKV struct {
+	// Capacity is the maximum number of bytes the KV will hold.
+	// 0 means there is no limit.
 	Capacity uint64
 
-	m     sync.Map
-	count int64
-	seq   uint64
+	mu   sync.Mutex
+	m    sync.Map
+	used uint64
+	seq  uint64
 }
 
 func (kv *MemKV) GetF(key []byte, f func([]byte) error) error {
 	value, ok := kv.m.Load(string(key))
 	if !ok {
-		return ErrFull
+		return ErrNotExist
 	}
 	bytes := value.([]byte)
 	return f(bytes)
 }
 
 func (kv *MemKV) Put(key, value []byte) error {
-	count := atomic.LoadInt64(&kv.count)
-	if uint64(count) >= kv.Capacity {
-		_, exists := kv.m.Load(string(key))
-		if !exists {
-			return ErrFull
-		}
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	used := kv.used + entrySize(key, value)
+	if prev, exists := kv.m.Load(string(key)); exists {
+		used -= entrySize(key, prev.([]byte))
+	}
+	if used > kv.SizeTotal() && used > kv.used {
+		return ErrFull
 	}
-	atomic.AddInt64(&kv.count, 1)
 	data := append([]byte{}, value...)
 	kv.m.Store(string(key), data)
+	kv.used = used
 	return nil
 }
 
 func (kv *MemKV) Delete(key []byte) error {
-	kv.m.Delete(string(key))
-	atomic.AddInt64(&kv.count, -1)
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if prev, exists := kv.m.Load(string(key)); exists {
+		kv.m.Delete(string(key))
+		kv.used -= entrySize(key, prev.([]byte))
+	}
 	return nil
 }
 
@@ -49,12 +58,17 @@ func (kv *MemKV) Bucket(p string) KV {
 	}
 }
 
-func (kv *MemKV) MaxCount() uint64 {
+func (kv *MemKV) SizeTotal() uint64 {
+	if kv.Capacity == 0 {
+		return Unlimited
+	}
 	return kv.Capacity
 }
 
-func (kv *MemKV) Count() uint64 {
-	return uint64(atomic.LoadInt64(&kv.count))
+func (kv *MemKV) SizeUsed() uint64 {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	return kv.used
 }
 
 func (kv *MemKV) ForEach(start, end []byte, fn func(k, v []byte) error) error {
@@ -62,13 +76,13 @@ func (kv *MemKV) ForEach(start, end []byte, fn func(k, v []byte) error) error {
 	kv.m.Range(func(k, v interface{}) bool {
 		key := []byte(k.(string))
 		value := v.([]byte)
-		if bytes.Compare(key, start) >= 0 && bytes.Compare(key, end) < 0 {
+		if bytes.Compare(key, start) >= 0 && (end == nil || bytes.Compare(key, end) < 0) {
 			err = fn(key, value)
 			return err == nil
 		}
 		return true
 	})
-	return nil
+	return err
 }
 
 func (kv *MemKV) NextSequence() (uint64, error) {
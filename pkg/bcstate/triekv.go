@@ -50,7 +50,7 @@ func (kv *TrieKV) Delete(k []byte) error {
 	})
 }
 
-func (TrieKV) NextSequence() (uint64, error) {
+func (*TrieKV) NextSequence() (uint64, error) {
 	panic("not implemented")
 }
 
@@ -62,11 +62,11 @@ func (kv *TrieKV) ForEach(first, last []byte, fn func(k, v []byte) error) error
 	})
 }
 
-func (TrieKV) Count() uint64 {
+func (*TrieKV) SizeUsed() uint64 {
 	panic("not implemented")
 }
 
-func (TrieKV) MaxCount() uint64 {
+func (*TrieKV) SizeTotal() uint64 {
 	panic("not implemented")
 }
 
@@ -2,7 +2,11 @@ package blobcache
 
 import (
 	"context"
+	"io"
 
+	"github.com/brendoncarroll/go-p2p"
+
+	"github.com/blobcache/blobcache/pkg/bcstate"
 	"github.com/blobcache/blobcache/pkg/blobnet/peers"
 	"github.com/blobcache/blobcache/pkg/blobs"
 )
@@ -12,6 +16,11 @@ type API interface {
 	CreatePinSet(ctx context.Context, name string) (PinSetID, error)
 	DeletePinSet(ctx context.Context, pinset PinSetID) error
 	GetPinSet(ctx context.Context, pinset PinSetID) (*PinSet, error)
+	GetPinSetByName(ctx context.Context, name string) (*PinSet, error)
+	ListPinSets(ctx context.Context) ([]PinSet, error)
+	RenamePinSet(ctx context.Context, pinset PinSetID, name string) error
+	// SetReplicas sets the number of trusted peers which should hold a copy of each blob in the pinset
+	SetReplicas(ctx context.Context, pinset PinSetID, count int) error
 
 	Pin(ctx context.Context, pinset PinSetID, id blobs.ID) error
 	Unpin(ctx context.Context, pinset PinSetID, id blobs.ID) error
@@ -22,9 +31,28 @@ type API interface {
 	Exists(ctx context.Context, pinset PinSetID, id blobs.ID) (bool, error)
 	List(ctx context.Context, pinSet PinSetID, prefix []byte, ids []blobs.ID) (n int, err error)
 
+	// Archives
+	Export(ctx context.Context, pinset PinSetID, w io.Writer) error
+	Import(ctx context.Context, name string, r io.Reader) (PinSetID, error)
+
+	// Peers
+	// Balances returns the favors exchanged with each peer
+	Balances(ctx context.Context) ([]PeerBalance, error)
+
 	MaxBlobSize() int
 }
 
+// PeerBalance is the favors exchanged with a peer, and how much the peer is trusted.
+// A peer whose Debt exceeds its Trust plus bcstate.DefaultAllowance is refused service.
+type PeerBalance struct {
+	PeerID p2p.PeerID `json:"peer_id"`
+	bcstate.Balance
+	// Debt is the value of the favors done for the peer, minus the value of those it has done.
+	Debt int64 `json:"debt"`
+	// Trust is the debt the peer is allowed on top of bcstate.DefaultAllowance.
+	Trust int64 `json:"trust"`
+}
+
 type Source interface {
 	blobs.Getter
 	blobs.Lister
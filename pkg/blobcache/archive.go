@@ -0,0 +1,241 @@
+package blobcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/blobcache/blobcache/pkg/bcarchive"
+	"github.com/blobcache/blobcache/pkg/bcstate"
+	"github.com/blobcache/blobcache/pkg/blobs"
+	"github.com/blobcache/blobcache/pkg/tries"
+	log "github.com/sirupsen/logrus"
+)
+
+const importBatchSize = 64
+
+// ErrArchiveIncomplete is returned by Import when a blob in the archive's pinset is neither in the archive, nor stored locally.
+var ErrArchiveIncomplete = errors.New("archive is missing blobs in its pinset")
+
+// Export writes a pinset to w as an archive.
+// The archive contains the nodes of the pinset's trie, followed by every blob in the pinset.
+// It is an error if any of the pinset's blobs can not be found.
+func (n *Node) Export(ctx context.Context, psID PinSetID, w io.Writer) error {
+	ps, err := n.pinSets.Get(ctx, psID)
+	if err != nil {
+		return err
+	}
+	aw, err := bcarchive.NewWriter(w, bcarchive.Header{Name: ps.Name, Root: &ps.Root})
+	if err != nil {
+		return err
+	}
+	copyBlob := func(id blobs.ID) error {
+		err := n.GetF(ctx, id, func(data []byte) error {
+			_, err := aw.WriteBlob(data)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("exporting blob %v: %w", id, err)
+		}
+		return nil
+	}
+	nodes := newTrieStore(n.persistent)
+	if err := tries.Walk(ctx, nodes, ps.Root, func(ref tries.Ref) error {
+		return copyBlob(ref.ID)
+	}); err != nil {
+		return err
+	}
+	if err := tries.ForEach(ctx, nodes, ps.Root, nil, nil, func(k, _ []byte) error {
+		return copyBlob(blobs.IDFromBytes(k))
+	}); err != nil {
+		return err
+	}
+	return aw.Flush()
+}
+
+// Import creates a pinset from an archive written by Export, and returns its ID.
+// If name is empty, the name from the archive is used.
+// The archive's trie nodes are stored with the other trie nodes, and the blobs in its pinset are persisted.
+// The pinset is only kept if its root matches the one in the archive, and every blob in it
+// is now stored persistently, or in an external source.
+func (n *Node) Import(ctx context.Context, name string, r io.Reader) (_ PinSetID, retErr error) {
+	ar, err := bcarchive.NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	h := ar.Header()
+	if h.Root == nil {
+		return 0, errors.New("archive does not contain a pinset")
+	}
+	if name == "" {
+		name = h.Name
+	}
+	psID, err := n.pinSets.Create(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if retErr != nil {
+			if err := n.pinSets.Delete(ctx, psID); err != nil {
+				log.Error(err)
+			}
+		}
+	}()
+
+	if err := n.importBlobs(ctx, ar, *h.Root); err != nil {
+		return 0, err
+	}
+	var ids []blobs.ID
+	if err := tries.ForEach(ctx, newTrieStore(n.persistent), *h.Root, nil, nil, func(k, _ []byte) error {
+		ids = append(ids, blobs.IDFromBytes(k))
+		return nil
+	}); err != nil {
+		return 0, fmt.Errorf("reading pinset from archive: %w", err)
+	}
+	// pins are trusted to refer to stored blobs, so check the archive had them all.
+	if err := n.persistAll(ctx, ids); err != nil {
+		return 0, err
+	}
+	if err := n.pinSets.pinMany(ctx, psID, ids); err != nil {
+		return 0, err
+	}
+	ps, err := n.pinSets.Get(ctx, psID)
+	if err != nil {
+		return 0, err
+	}
+	if !ps.Root.ID.Equals(h.Root.ID) {
+		return 0, errors.New("imported pinset does not match the root in the archive")
+	}
+	for _, id := range ids {
+		if err := n.bn.HaveLocally(ctx, id); err != nil {
+			log.Error(err)
+		}
+	}
+	return psID, nil
+}
+
+// importBlobs stores the blobs in the archive which make up the pinset with root.
+// Export writes the trie's nodes before the blobs in the pinset, so by the time a blob is read it is
+// known to be a trie node, a blob in the pinset, or neither.  Trie nodes are stored with the other
+// trie nodes, blobs in the pinset are persisted, and any other blobs are skipped.
+// Blobs are written in batches, since a transaction per blob is slow for large archives.
+func (n *Node) importBlobs(ctx context.Context, ar *bcarchive.Reader, root tries.Ref) error {
+	type item struct {
+		id     blobs.ID
+		data   []byte
+		isNode bool
+	}
+	nodes := map[blobs.ID]tries.Ref{root.ID: root}
+	pinned := make(map[blobs.ID]struct{})
+	batch := make([]item, 0, importBatchSize)
+	flush := func() error {
+		err := n.persistent.WriteTx(ctx, func(tx bcstate.DB) error {
+			trieNodes := newTrieStore(tx)
+			for _, x := range batch {
+				var err error
+				if x.isNode {
+					_, err = trieNodes.Post(ctx, x.data)
+				} else {
+					err = putPersistent(tx, n.persistentCap, x.id, x.data)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		batch = batch[:0]
+		return err
+	}
+	for {
+		id, data, err := ar.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		ref, isNode := nodes[id]
+		if isNode {
+			delete(nodes, id)
+			node, err := parseTrieNode(ctx, ref, data)
+			if err != nil {
+				return fmt.Errorf("reading pinset from archive: %w", err)
+			}
+			for _, ent := range node.Entries {
+				key := append(append([]byte{}, node.Prefix...), ent.Key...)
+				pinned[blobs.IDFromBytes(key)] = struct{}{}
+			}
+			for i := range node.Children {
+				if child := tries.Child(node, byte(i)); child != nil {
+					nodes[child.ID] = *child
+				}
+			}
+		} else if _, isPinned := pinned[id]; !isPinned {
+			continue
+		}
+		batch = append(batch, item{id: id, data: append([]byte{}, data...), isNode: isNode})
+		if len(batch) >= importBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// parseTrieNode decrypts and parses the trie node with ref, from its data.
+func parseTrieNode(ctx context.Context, ref tries.Ref, data []byte) (*tries.Node, error) {
+	s := blobs.NewMem()
+	if _, err := s.Post(ctx, data); err != nil {
+		return nil, err
+	}
+	return tries.GetNode(ctx, s, ref)
+}
+
+// persistAll ensures every blob in ids is stored persistently, or in an external source.
+// Blobs which are only in the ephemeral cache are persisted, since they could be evicted at any time.
+// ErrArchiveIncomplete is returned for the first blob which can't be found locally.
+func (n *Node) persistAll(ctx context.Context, ids []blobs.ID) error {
+	var missing []blobs.ID
+	if err := n.persistent.ReadTx(ctx, func(tx bcstate.DB) error {
+		b := tx.Bucket(bucketBlobs)
+		for _, id := range ids {
+			exists, err := bcstate.Exists(b, id[:])
+			if err != nil {
+				return err
+			}
+			if !exists {
+				missing = append(missing, id)
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, id := range missing {
+		exists, err := n.inExternalSource(ctx, id)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		var data []byte
+		if err := n.cache.GetF(ctx, id, func(x []byte) error {
+			data = append([]byte{}, x...)
+			return nil
+		}); err == blobs.ErrNotFound {
+			return fmt.Errorf("%w: %v", ErrArchiveIncomplete, id)
+		} else if err != nil {
+			return err
+		}
+		if err := n.persistent.WriteTx(ctx, func(tx bcstate.DB) error {
+			return putPersistent(tx, n.persistentCap, id, data)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package blobcache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/brendoncarroll/go-p2p/p/dynmux"
+	"github.com/brendoncarroll/go-p2p/p2ptest"
+	"github.com/brendoncarroll/go-p2p/s/memswarm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blobcache/blobcache/pkg/bcarchive"
+	"github.com/blobcache/blobcache/pkg/bcstate"
+	"github.com/blobcache/blobcache/pkg/blobnet/peers"
+	"github.com/blobcache/blobcache/pkg/blobs"
+	"github.com/blobcache/blobcache/pkg/tries"
+)
+
+func TestImportStorage(t *testing.T) {
+	ctx := context.TODO()
+	node1 := newTestNode(t)
+	psID, err := node1.CreatePinSet(ctx, "pinset1")
+	require.NoError(t, err)
+	var ids []blobs.ID
+	var cached []byte
+	for i := 0; i < 100; i++ {
+		data := []byte(fmt.Sprintf("test-data-%d", i))
+		id, err := node1.Post(ctx, psID, data)
+		require.NoError(t, err)
+		ids = append(ids, id)
+		cached = data
+	}
+	buf := &bytes.Buffer{}
+	require.NoError(t, node1.Export(ctx, psID, buf))
+
+	// the last blob is only in the cache, and not in the archive.
+	node2 := newTestNode(t)
+	_, err = node2.cache.Post(ctx, cached)
+	require.NoError(t, err)
+	archive := withoutBlob(t, buf.Bytes(), ids[len(ids)-1])
+	psID2, err := node2.Import(ctx, "", bytes.NewReader(archive))
+	require.NoError(t, err)
+	ps2, err := node2.GetPinSet(ctx, psID2)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(len(ids)), ps2.Count)
+
+	// only the pinned blobs are persistent blobs, including the one from the cache.
+	blobsKV := node2.persistent.Bucket(bucketBlobs)
+	for _, id := range ids {
+		exists, err := bcstate.Exists(blobsKV, id[:])
+		require.NoError(t, err)
+		assert.True(t, exists)
+	}
+	// and trie nodes are only stored once.
+	require.NoError(t, tries.Walk(ctx, newTrieStore(node2.persistent), ps2.Root, func(ref tries.Ref) error {
+		exists, err := bcstate.Exists(blobsKV, ref.ID[:])
+		require.NoError(t, err)
+		assert.False(t, exists)
+		return nil
+	}))
+	var count int
+	require.NoError(t, blobsKV.ForEach(nil, nil, func(k, v []byte) error {
+		count++
+		return nil
+	}))
+	assert.Equal(t, len(ids), count)
+}
+
+func newTestNode(t *testing.T) *Node {
+	realm := memswarm.NewRealm()
+	privKey := p2ptest.NewTestKey(t, 0)
+	node, err := NewNode(Params{
+		Ephemeral:  newTestBoltDB(t, "ephemeral.db", 0),
+		Persistent: newTestBoltDB(t, "persistent.db", 0),
+		Mux:        dynmux.MultiplexSwarm(realm.NewSwarmWithKey(privKey)),
+		PrivateKey: privKey,
+		PeerStore:  make(peers.MemPeerStore),
+
+		EphemeralCapacity: 1 << 20,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, node.Shutdown()) })
+	return node
+}
+
+// withoutBlob returns a copy of archive without the blob with id.
+func withoutBlob(t *testing.T, archive []byte, id blobs.ID) []byte {
+	ar, err := bcarchive.NewReader(bytes.NewReader(archive))
+	require.NoError(t, err)
+	buf := &bytes.Buffer{}
+	aw, err := bcarchive.NewWriter(buf, ar.Header())
+	require.NoError(t, err)
+	for {
+		id2, data, err := ar.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if id2.Equals(id) {
+			continue
+		}
+		_, err = aw.WriteBlob(data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, aw.Flush())
+	return buf.Bytes()
+}
@@ -0,0 +1,340 @@
+// Package blobcachetest contains a conformance suite for implementations of blobcache.API
+package blobcachetest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/brendoncarroll/go-p2p/p/dynmux"
+	"github.com/brendoncarroll/go-p2p/p2ptest"
+	"github.com/brendoncarroll/go-p2p/s/memswarm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/blobcache/blobcache/pkg/bcarchive"
+	"github.com/blobcache/blobcache/pkg/bcstate"
+	"github.com/blobcache/blobcache/pkg/blobcache"
+	"github.com/blobcache/blobcache/pkg/blobnet/peers"
+	"github.com/blobcache/blobcache/pkg/blobs"
+)
+
+// TestAPI runs the conformance suite against the API returned by newAPI.
+// newAPI is called once per subtest, and must return an API with no pinsets.
+func TestAPI(t *testing.T, newAPI func(t testing.TB) blobcache.API) {
+	t.Run("CreateGetPinSet", func(t *testing.T) {
+		ctx := context.TODO()
+		api := newAPI(t)
+		id1, err := api.CreatePinSet(ctx, "pinset1")
+		require.NoError(t, err)
+		id2, err := api.CreatePinSet(ctx, "pinset2")
+		require.NoError(t, err)
+		assert.NotEqual(t, id1, id2)
+
+		ps, err := api.GetPinSet(ctx, id1)
+		require.NoError(t, err)
+		assert.Equal(t, id1, ps.ID)
+		assert.Equal(t, uint64(0), ps.Count)
+	})
+
+	t.Run("PinSetNames", func(t *testing.T) {
+		ctx := context.TODO()
+		api := newAPI(t)
+		id1 := createPinSet(t, api, "pinset1")
+		_, err := api.CreatePinSet(ctx, "pinset1")
+		assert.Equal(t, blobcache.ErrPinSetExists, err)
+		for _, name := range []string{"", "1234", "has space", "has/slash"} {
+			_, err := api.CreatePinSet(ctx, name)
+			assert.Equal(t, blobcache.ErrInvalidPinSetName, err, "name=%q", name)
+		}
+
+		ps, err := api.GetPinSetByName(ctx, "pinset1")
+		require.NoError(t, err)
+		assert.Equal(t, id1, ps.ID)
+		assert.Equal(t, "pinset1", ps.Name)
+		_, err = api.GetPinSetByName(ctx, "pinset2")
+		assert.Equal(t, blobcache.ErrPinSetNotFound, err)
+
+		// rename
+		id2 := createPinSet(t, api, "pinset2")
+		assert.Equal(t, blobcache.ErrPinSetExists, api.RenamePinSet(ctx, id1, "pinset2"))
+		require.NoError(t, api.RenamePinSet(ctx, id1, "pinset1-renamed"))
+		_, err = api.GetPinSetByName(ctx, "pinset1")
+		assert.Equal(t, blobcache.ErrPinSetNotFound, err)
+		ps, err = api.GetPinSet(ctx, id1)
+		require.NoError(t, err)
+		assert.Equal(t, "pinset1-renamed", ps.Name)
+
+		pinSets, err := api.ListPinSets(ctx)
+		require.NoError(t, err)
+		require.Len(t, pinSets, 2)
+		assert.Equal(t, id1, pinSets[0].ID)
+		assert.Equal(t, id2, pinSets[1].ID)
+
+		// names can be reused after the pinset is deleted
+		require.NoError(t, api.DeletePinSet(ctx, id2))
+		createPinSet(t, api, "pinset2")
+	})
+
+	t.Run("PinSetNotFound", func(t *testing.T) {
+		ctx := context.TODO()
+		api := newAPI(t)
+		const psID = blobcache.PinSetID(1234)
+		id := blobs.Hash([]byte("test-data"))
+
+		_, err := api.GetPinSet(ctx, psID)
+		assert.Equal(t, blobcache.ErrPinSetNotFound, err)
+		_, err = api.Post(ctx, psID, []byte("test-data"))
+		assert.Equal(t, blobcache.ErrPinSetNotFound, err)
+		assert.Equal(t, blobcache.ErrPinSetNotFound, api.RenamePinSet(ctx, psID, "pinset1"))
+		assert.Equal(t, blobcache.ErrPinSetNotFound, api.SetReplicas(ctx, psID, 1))
+		assert.Equal(t, blobcache.ErrPinSetNotFound, api.Pin(ctx, psID, id))
+		assert.Equal(t, blobcache.ErrPinSetNotFound, api.Unpin(ctx, psID, id))
+		_, err = api.Exists(ctx, psID, id)
+		assert.Equal(t, blobcache.ErrPinSetNotFound, err)
+		_, err = api.List(ctx, psID, nil, make([]blobs.ID, 10))
+		assert.Equal(t, blobcache.ErrPinSetNotFound, err)
+	})
+
+	t.Run("SetReplicas", func(t *testing.T) {
+		ctx := context.TODO()
+		api := newAPI(t)
+		psID := createPinSet(t, api, "pinset1")
+		ps, err := api.GetPinSet(ctx, psID)
+		require.NoError(t, err)
+		assert.Equal(t, 0, ps.Replicas)
+
+		require.NoError(t, api.SetReplicas(ctx, psID, 3))
+		ps, err = api.GetPinSet(ctx, psID)
+		require.NoError(t, err)
+		assert.Equal(t, 3, ps.Replicas)
+		assert.Equal(t, blobcache.ErrInvalidReplicaCount, api.SetReplicas(ctx, psID, -1))
+	})
+
+	t.Run("Balances", func(t *testing.T) {
+		ctx := context.TODO()
+		api := newAPI(t)
+		// a new node has not exchanged favors with anyone
+		balances, err := api.Balances(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, balances)
+	})
+
+	t.Run("PostGet", func(t *testing.T) {
+		ctx := context.TODO()
+		api := newAPI(t)
+		psID := createPinSet(t, api, "pinset1")
+
+		data := []byte("test-data")
+		id, err := api.Post(ctx, psID, data)
+		require.NoError(t, err)
+		assert.Equal(t, blobs.Hash(data), id)
+
+		var actual []byte
+		require.NoError(t, api.GetF(ctx, id, func(x []byte) error {
+			actual = append([]byte{}, x...)
+			return nil
+		}))
+		assert.Equal(t, data, actual)
+
+		exists, err := api.Exists(ctx, psID, id)
+		require.NoError(t, err)
+		assert.True(t, exists)
+
+		ps, err := api.GetPinSet(ctx, psID)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), ps.Count)
+	})
+
+	t.Run("GetNotFound", func(t *testing.T) {
+		ctx := context.TODO()
+		api := newAPI(t)
+		err := api.GetF(ctx, blobs.Hash([]byte("never-posted")), func([]byte) error {
+			return nil
+		})
+		assert.Equal(t, blobs.ErrNotFound, err)
+	})
+
+	t.Run("PostTooLarge", func(t *testing.T) {
+		ctx := context.TODO()
+		api := newAPI(t)
+		psID := createPinSet(t, api, "pinset1")
+		_, err := api.Post(ctx, psID, make([]byte, api.MaxBlobSize()+1))
+		assert.Equal(t, blobs.ErrTooLarge, err)
+		_, err = api.Post(ctx, psID, make([]byte, api.MaxBlobSize()))
+		assert.NoError(t, err)
+	})
+
+	t.Run("PinUnpin", func(t *testing.T) {
+		ctx := context.TODO()
+		api := newAPI(t)
+		ps1 := createPinSet(t, api, "pinset1")
+		ps2 := createPinSet(t, api, "pinset2")
+
+		id, err := api.Post(ctx, ps1, []byte("test-data"))
+		require.NoError(t, err)
+		exists, err := api.Exists(ctx, ps2, id)
+		require.NoError(t, err)
+		assert.False(t, exists)
+
+		require.NoError(t, api.Pin(ctx, ps2, id))
+		require.NoError(t, api.Unpin(ctx, ps1, id))
+		exists, err = api.Exists(ctx, ps1, id)
+		require.NoError(t, err)
+		assert.False(t, exists)
+		exists, err = api.Exists(ctx, ps2, id)
+		require.NoError(t, err)
+		assert.True(t, exists)
+		assert.NoError(t, api.GetF(ctx, id, func([]byte) error { return nil }))
+	})
+
+	t.Run("List", func(t *testing.T) {
+		ctx := context.TODO()
+		api := newAPI(t)
+		ps1 := createPinSet(t, api, "pinset1")
+		ps2 := createPinSet(t, api, "pinset2")
+		const N = 20
+		expected := postN(t, api, ps1, N)
+		postN(t, api, ps2, N)
+
+		ids := make([]blobs.ID, 2*N)
+		n, err := api.List(ctx, ps1, nil, ids)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, expected, ids[:n])
+
+		_, err = api.List(ctx, ps1, nil, make([]blobs.ID, N-1))
+		assert.Equal(t, blobs.ErrTooMany, err)
+
+		prefix := expected[0][:1]
+		n, err = api.List(ctx, ps1, prefix, ids)
+		require.NoError(t, err)
+		require.True(t, n > 0)
+		for _, id := range ids[:n] {
+			assert.True(t, bytes.HasPrefix(id[:], prefix))
+		}
+	})
+
+	t.Run("DeletePinSet", func(t *testing.T) {
+		ctx := context.TODO()
+		api := newAPI(t)
+		psID := createPinSet(t, api, "pinset1")
+		postN(t, api, psID, 3)
+
+		require.NoError(t, api.DeletePinSet(ctx, psID))
+		_, err := api.GetPinSet(ctx, psID)
+		assert.Equal(t, blobcache.ErrPinSetNotFound, err)
+		// deleting is idempotent
+		require.NoError(t, api.DeletePinSet(ctx, psID))
+	})
+
+	t.Run("Store", func(t *testing.T) {
+		ctx := context.TODO()
+		api := newAPI(t)
+		s := blobcache.NewStore(api, createPinSet(t, api, "pinset1"))
+
+		const N = 10
+		expected := make([]blobs.ID, N)
+		for i := range expected {
+			id, err := s.Post(ctx, []byte(fmt.Sprintf("test-data-%d", i)))
+			require.NoError(t, err)
+			expected[i] = id
+		}
+		var actual []blobs.ID
+		require.NoError(t, blobs.ForEach(ctx, s, func(id blobs.ID) error {
+			actual = append(actual, id)
+			return nil
+		}))
+		assert.ElementsMatch(t, expected, actual)
+
+		require.NoError(t, s.Delete(ctx, expected[0]))
+		exists, err := s.Exists(ctx, expected[0])
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("ExportImport", func(t *testing.T) {
+		ctx := context.TODO()
+		api := newAPI(t)
+		ps1 := createPinSet(t, api, "pinset1")
+		expected := postN(t, api, ps1, 20)
+
+		buf := &bytes.Buffer{}
+		require.NoError(t, api.Export(ctx, ps1, buf))
+		ps2, err := api.Import(ctx, "pinset2", bytes.NewReader(buf.Bytes()))
+		require.NoError(t, err)
+		pinSet1, err := api.GetPinSet(ctx, ps1)
+		require.NoError(t, err)
+		pinSet2, err := api.GetPinSet(ctx, ps2)
+		require.NoError(t, err)
+		assert.Equal(t, "pinset2", pinSet2.Name)
+		assert.Equal(t, pinSet1.Root, pinSet2.Root)
+		for _, id := range expected {
+			exists, err := api.Exists(ctx, ps2, id)
+			require.NoError(t, err)
+			assert.True(t, exists)
+		}
+
+		// the name from the archive is used by default, which is taken.
+		_, err = api.Import(ctx, "", bytes.NewReader(buf.Bytes()))
+		assert.Equal(t, blobcache.ErrPinSetExists, err)
+		_, err = api.Import(ctx, "pinset3", bytes.NewReader([]byte("not an archive")))
+		assert.Equal(t, bcarchive.ErrBadMagic, err)
+		err = api.Export(ctx, 1234, ioutil.Discard)
+		assert.Equal(t, blobcache.ErrPinSetNotFound, err)
+	})
+
+	t.Run("MaxBlobSize", func(t *testing.T) {
+		api := newAPI(t)
+		assert.Equal(t, blobs.MaxSize, api.MaxBlobSize())
+	})
+}
+
+// NewTestNode returns a Node backed by temporary databases, with no peers.
+// It is shutdown when the test is cleaned up.
+func NewTestNode(t testing.TB) *blobcache.Node {
+	realm := memswarm.NewRealm()
+	privKey := p2ptest.NewTestKey(t, 0)
+	swarm := realm.NewSwarmWithKey(privKey)
+	node, err := blobcache.NewNode(blobcache.Params{
+		Ephemeral:  newTestBoltDB(t, "ephemeral.db"),
+		Persistent: newTestBoltDB(t, "persistent.db"),
+		Mux:        dynmux.MultiplexSwarm(swarm),
+		PrivateKey: privKey,
+		PeerStore:  make(peers.MemPeerStore),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, node.Shutdown())
+	})
+	return node
+}
+
+func newTestBoltDB(t testing.TB, name string) *bcstate.BoltDB {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), name), 0666, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	bdb, err := bcstate.OpenBoltDB(db, 0)
+	require.NoError(t, err)
+	return bdb
+}
+
+func createPinSet(t testing.TB, api blobcache.API, name string) blobcache.PinSetID {
+	id, err := api.CreatePinSet(context.TODO(), name)
+	require.NoError(t, err)
+	return id
+}
+
+func postN(t testing.TB, api blobcache.API, psID blobcache.PinSetID, n int) []blobs.ID {
+	ids := make([]blobs.ID, n)
+	for i := range ids {
+		data := []byte(fmt.Sprintf("test-data-%d-%d", psID, i))
+		id, err := api.Post(context.TODO(), psID, data)
+		require.NoError(t, err)
+		ids[i] = id
+	}
+	return ids
+}
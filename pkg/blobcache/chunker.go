@@ -0,0 +1,67 @@
+package blobcache
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/blobcache/blobcache/pkg/blobs"
+)
+
+const (
+	minChunkSize = 1 << 14
+	maxChunkSize = blobs.MaxSize
+	// chunkMask has 15 bits set, which makes the average chunk about 32KiB past the minimum.
+	chunkMask = (1 << 15) - 1
+)
+
+// gearTable is a fixed table of pseudo-random values for the rolling hash.
+// It must never change, or chunk boundaries (and so blob IDs) would change with it.
+var gearTable = func() (table [256]uint64) {
+	// splitmix64
+	x := uint64(0x626c6f6263616368) // "blobcach"
+	for i := range table {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// Chunker splits a stream into content defined chunks,
+// so that an insertion or deletion only changes the chunks near it.
+type Chunker struct {
+	r   *bufio.Reader
+	buf [maxChunkSize]byte
+}
+
+func NewChunker(r io.Reader) *Chunker {
+	return &Chunker{r: bufio.NewReaderSize(r, maxChunkSize)}
+}
+
+// Next returns the next chunk, or io.EOF when there are no more.
+// The returned slice is only valid until the next call to Next.
+func (c *Chunker) Next() ([]byte, error) {
+	var h uint64
+	n := 0
+	for n < maxChunkSize {
+		b, err := c.r.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		c.buf[n] = b
+		n++
+		h = (h << 1) + gearTable[b]
+		if n >= minChunkSize && h&chunkMask == 0 {
+			break
+		}
+	}
+	if n == 0 {
+		return nil, io.EOF
+	}
+	return c.buf[:n], nil
+}
@@ -0,0 +1,76 @@
+package blobcache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/blobcache/blobcache/pkg/bcstate"
+	"github.com/blobcache/blobcache/pkg/blobs"
+	"github.com/blobcache/blobcache/pkg/eviction"
+)
+
+// fetchTimeout limits how long a fetch shared by several callers can take.
+const fetchTimeout = time.Minute
+
+// remoteFetcher gets blobs which are not stored locally from remote.
+// Concurrent fetches of the same blob share a single request, and fetched blobs are added to the cache,
+// so later reads are local, and the node can serve them to peers.
+// The cache tells the network about blobs added to it.
+type remoteFetcher struct {
+	remote blobs.Getter
+	cache  *eviction.Cache
+
+	group singleflight.Group
+}
+
+func newRemoteFetcher(remote blobs.Getter, cache *eviction.Cache) *remoteFetcher {
+	return &remoteFetcher{
+		remote: remote,
+		cache:  cache,
+	}
+}
+
+// GetF calls fn with the blob. The data passed to fn may be shared with concurrent callers, and must not be modified.
+// The fetch is not tied to the context of any one caller, so callers which give up do not fail it for the others.
+func (f *remoteFetcher) GetF(ctx context.Context, id blobs.ID, fn func([]byte) error) error {
+	ch := f.group.DoChan(string(id[:]), func() (interface{}, error) {
+		ctx, cf := context.WithTimeout(context.Background(), fetchTimeout)
+		defer cf()
+		return f.fetch(ctx, id)
+	})
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			return res.Err
+		}
+		return fn(res.Val.([]byte))
+	}
+}
+
+func (f *remoteFetcher) fetch(ctx context.Context, id blobs.ID) ([]byte, error) {
+	var data []byte
+	if err := f.remote.GetF(ctx, id, func(x []byte) error {
+		data = append([]byte{}, x...)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if !blobs.Hash(data).Equals(id) {
+		return nil, errors.New("fetched blob does not match ID")
+	}
+	// if the cache is full, the policy prefers what is already cached.
+	if _, err := f.cache.Post(ctx, data); err != nil && err != bcstate.ErrFull {
+		log.WithField("blob_id", id).Error("caching fetched blob: ", err)
+	}
+	return data, nil
+}
+
+func (f *remoteFetcher) Exists(ctx context.Context, id blobs.ID) (bool, error) {
+	return f.remote.Exists(ctx, id)
+}
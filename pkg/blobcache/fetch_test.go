@@ -0,0 +1,124 @@
+package blobcache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blobcache/blobcache/pkg/bcstate"
+	"github.com/blobcache/blobcache/pkg/blobs"
+)
+
+func TestRemoteFetcher(t *testing.T) {
+	ctx := context.TODO()
+	const N = 10
+	remote := &slowGetter{
+		Store:   bcstate.BlobAdapter(&bcstate.MemKV{}),
+		release: make(chan struct{}),
+		started: make(chan struct{}),
+	}
+	data := []byte("test-data")
+	id, err := remote.Post(ctx, data)
+	require.NoError(t, err)
+	cache := newTestCache(t, 1024)
+	var cached []blobs.ID
+	cache.OnChange(func(id blobs.ID, added bool) {
+		if added {
+			cached = append(cached, id)
+		}
+	})
+	f := newRemoteFetcher(remote, cache)
+
+	wg := sync.WaitGroup{}
+	wg.Add(N)
+	ready := sync.WaitGroup{}
+	ready.Add(N)
+	for i := 0; i < N; i++ {
+		go func() {
+			defer wg.Done()
+			ready.Done()
+			assert.NoError(t, f.GetF(ctx, id, func(x []byte) error {
+				assert.Equal(t, data, x)
+				return nil
+			}))
+		}()
+	}
+	// wait for the fetch to start, and give everyone else time to wait on it.
+	ready.Wait()
+	<-remote.started
+	time.Sleep(10 * time.Millisecond)
+	close(remote.release)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&remote.calls))
+
+	exists, err := cache.Exists(ctx, id)
+	require.NoError(t, err)
+	assert.True(t, exists)
+	assert.Contains(t, cached, id)
+
+	// missing blobs are not cached
+	missing := blobs.Hash([]byte("missing"))
+	err = f.GetF(ctx, missing, func([]byte) error { return nil })
+	assert.Equal(t, blobs.ErrNotFound, err)
+	assert.NotContains(t, cached, missing)
+}
+
+func TestRemoteFetcherCancel(t *testing.T) {
+	remote := &slowGetter{
+		Store:   bcstate.BlobAdapter(&bcstate.MemKV{}),
+		release: make(chan struct{}),
+		started: make(chan struct{}),
+	}
+	data := []byte("test-data")
+	id, err := remote.Post(context.TODO(), data)
+	require.NoError(t, err)
+	f := newRemoteFetcher(remote, newTestCache(t, 1024))
+
+	// the first caller starts the fetch, and gives up while it is in progress.
+	ctx1, cf := context.WithCancel(context.TODO())
+	done1 := make(chan error)
+	go func() {
+		done1 <- f.GetF(ctx1, id, func([]byte) error { return nil })
+	}()
+	<-remote.started
+	done2 := make(chan error)
+	go func() {
+		done2 <- f.GetF(context.TODO(), id, func(x []byte) error {
+			assert.Equal(t, data, x)
+			return nil
+		})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cf()
+	assert.Equal(t, context.Canceled, <-done1)
+
+	// the second caller still gets the blob, from the same fetch.
+	close(remote.release)
+	require.NoError(t, <-done2)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&remote.calls))
+}
+
+// slowGetter blocks every GetF until release is closed, or ctx is done.
+type slowGetter struct {
+	blobs.Store
+	release chan struct{}
+	started chan struct{}
+	once    sync.Once
+	calls   int32
+}
+
+func (g *slowGetter) GetF(ctx context.Context, id blobs.ID, fn func([]byte) error) error {
+	atomic.AddInt32(&g.calls, 1)
+	g.once.Do(func() { close(g.started) })
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-g.release:
+	}
+	return g.Store.GetF(ctx, id, fn)
+}
@@ -0,0 +1,299 @@
+package blobcache
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/blobcache/blobcache/pkg/bcstate"
+	"github.com/blobcache/blobcache/pkg/blobs"
+	"github.com/blobcache/blobcache/pkg/eviction"
+	"github.com/blobcache/blobcache/pkg/tries"
+)
+
+const (
+	bucketBlobs      = "blobs"
+	bucketGCUnpinned = "gc-unpinned"
+
+	prefixPinSets = "pinsets"
+
+	DefaultGCPeriod      = 10 * time.Minute
+	DefaultGCGracePeriod = time.Hour
+)
+
+type GCParams struct {
+	Persistent bcstate.TxDB
+	Ephemeral  *eviction.Cache
+	Clock      clockwork.Clock
+
+	// Period is the time between collections when running in the background.
+	Period time.Duration
+	// GracePeriod is how long a blob must go unpinned before it is collected.
+	GracePeriod time.Duration
+	// OnDelete is called with the ID of each blob deleted from persistent storage. It can be nil.
+	OnDelete func(id blobs.ID)
+}
+
+// GCResult summarizes a single collection
+type GCResult struct {
+	Scanned        int    `json:"scanned"`
+	Deleted        int    `json:"deleted"`
+	Demoted        int    `json:"demoted"`
+	BytesReclaimed uint64 `json:"bytes_reclaimed"`
+}
+
+// GC removes blobs from persistent storage which are not in any pinset, and trie nodes which are not in any pinset's trie.
+// Blobs are only collected after they have been seen unpinned for longer than the grace period,
+// and they are moved to the ephemeral cache if there is space without evicting anything.
+type GC struct {
+	persistent  bcstate.TxDB
+	ephemeral   *eviction.Cache
+	clock       clockwork.Clock
+	period      time.Duration
+	gracePeriod time.Duration
+	onDelete    func(id blobs.ID)
+}
+
+func NewGC(params GCParams) *GC {
+	period := params.Period
+	if period == 0 {
+		period = DefaultGCPeriod
+	}
+	gracePeriod := params.GracePeriod
+	if gracePeriod == 0 {
+		gracePeriod = DefaultGCGracePeriod
+	}
+	clock := params.Clock
+	if clock == nil {
+		clock = clockwork.NewRealClock()
+	}
+	return &GC{
+		persistent:  params.Persistent,
+		ephemeral:   params.Ephemeral,
+		clock:       clock,
+		period:      period,
+		gracePeriod: gracePeriod,
+		onDelete:    params.OnDelete,
+	}
+}
+
+func (gc *GC) run(ctx context.Context) {
+	ticker := gc.clock.NewTicker(gc.period)
+	defer ticker.Stop()
+	log.Info("starting garbage collector")
+	defer func() { log.Info("stopped garbage collector") }()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.Chan():
+			if _, err := gc.Collect(ctx); err != nil {
+				log.Error(err)
+			}
+		}
+	}
+}
+
+// Collect performs a single pass over the persistent blobs.
+func (gc *GC) Collect(ctx context.Context) (*GCResult, error) {
+	res := &GCResult{}
+	now := gc.clock.Now()
+	var toCollect []blobs.ID
+	err := gc.persistent.WriteTx(ctx, func(tx bcstate.DB) error {
+		unpinnedKV := tx.Bucket(bucketGCUnpinned)
+		rc := refCounts(tx)
+		live, err := liveTrieNodes(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		var marks, unmarks []blobs.ID
+		scan := func(k, _ []byte) error {
+			res.Scanned++
+			id := blobs.IDFromBytes(k)
+			count, err := pinCount(rc, id)
+			if err != nil {
+				return err
+			}
+			if _, isLive := live[id]; count > 0 || isLive {
+				unmarks = append(unmarks, id)
+				return nil
+			}
+			var since time.Time
+			err = unpinnedKV.GetF(id[:], func(v []byte) error {
+				since = parseUnixTime(v)
+				return nil
+			})
+			switch {
+			case err == bcstate.ErrNotExist:
+				marks = append(marks, id)
+			case err != nil:
+				return err
+			case now.Sub(since) >= gc.gracePeriod:
+				toCollect = append(toCollect, id)
+			}
+			return nil
+		}
+		if err := tx.Bucket(bucketBlobs).ForEach(nil, nil, scan); err != nil {
+			return err
+		}
+		if err := tx.Bucket(bucketTrieNodes).ForEach(nil, nil, scan); err != nil {
+			return err
+		}
+
+		for _, id := range unmarks {
+			if err := unpinnedKV.Delete(id[:]); err != nil {
+				return err
+			}
+		}
+		for _, id := range marks {
+			if err := unpinnedKV.Put(id[:], unixTimeBytes(now)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range toCollect {
+		if err := gc.collectBlob(ctx, id, res); err != nil {
+			return nil, err
+		}
+	}
+	if res.Deleted > 0 {
+		log.WithFields(log.Fields{
+			"scanned":         res.Scanned,
+			"deleted":         res.Deleted,
+			"demoted":         res.Demoted,
+			"bytes_reclaimed": res.BytesReclaimed,
+		}).Info("garbage collected blobs")
+	}
+	return res, nil
+}
+
+// collectBlob removes a single blob or trie node, rechecking that it is still unpinned
+// in the same transaction as the delete.
+// Trie nodes which have been posted again since they were marked will no longer have a mark.
+func (gc *GC) collectBlob(ctx context.Context, id blobs.ID, res *GCResult) error {
+	deleted := false
+	if err := gc.persistent.WriteTx(ctx, func(tx bcstate.DB) error {
+		blobsKV := tx.Bucket(bucketBlobs)
+		nodesKV := tx.Bucket(bucketTrieNodes)
+		unpinnedKV := tx.Bucket(bucketGCUnpinned)
+		count, err := pinCount(refCounts(tx), id)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return unpinnedKV.Delete(id[:])
+		}
+		var since time.Time
+		if err := unpinnedKV.GetF(id[:], func(v []byte) error {
+			since = parseUnixTime(v)
+			return nil
+		}); err == bcstate.ErrNotExist {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if gc.clock.Now().Sub(since) < gc.gracePeriod {
+			return nil
+		}
+
+		var data []byte
+		getData := func(v []byte) error {
+			data = append([]byte{}, v...)
+			return nil
+		}
+		err = blobsKV.GetF(id[:], getData)
+		if err == bcstate.ErrNotExist {
+			err = nodesKV.GetF(id[:], getData)
+		}
+		if err == bcstate.ErrNotExist {
+			return unpinnedKV.Delete(id[:])
+		} else if err != nil {
+			return err
+		}
+
+		demoted := true
+		if _, err := gc.ephemeral.TryPost(ctx, data); err == bcstate.ErrFull {
+			demoted = false
+		} else if err != nil {
+			return err
+		}
+		if err := blobsKV.Delete(id[:]); err != nil {
+			return err
+		}
+		if err := nodesKV.Delete(id[:]); err != nil {
+			return err
+		}
+		if err := unpinnedKV.Delete(id[:]); err != nil {
+			return err
+		}
+
+		deleted = true
+		res.Deleted++
+		if demoted {
+			res.Demoted++
+		}
+		res.BytesReclaimed += uint64(len(data))
+		return nil
+	}); err != nil {
+		return err
+	}
+	if deleted && gc.onDelete != nil {
+		gc.onDelete(id)
+	}
+	return nil
+}
+
+// liveTrieNodes returns the IDs of every node in the tries of all the pinsets.
+func liveTrieNodes(ctx context.Context, persistentTx bcstate.DB) (map[blobs.ID]struct{}, error) {
+	pinSetsTx := bcstate.PrefixedDB{DB: persistentTx, Prefix: prefixPinSets}
+	var roots []tries.Ref
+	if err := pinSetsTx.Bucket(bucketPinSets).ForEach(nil, nil, func(k, v []byte) error {
+		var rec pinSetRecord
+		if err := json.Unmarshal(v, &rec); err != nil {
+			return err
+		}
+		roots = append(roots, rec.Root)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	nodes := newTrieStore(persistentTx)
+	live := make(map[blobs.ID]struct{})
+	for _, root := range roots {
+		if err := tries.Walk(ctx, nodes, root, func(ref tries.Ref) error {
+			live[ref.ID] = struct{}{}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return live, nil
+}
+
+func refCounts(persistentTx bcstate.DB) bcstate.KV {
+	return bcstate.PrefixedDB{DB: persistentTx, Prefix: prefixPinSets}.Bucket(bucketPinRefCounts)
+}
+
+func unixTimeBytes(t time.Time) []byte {
+	buf := [8]byte{}
+	binary.BigEndian.PutUint64(buf[:], uint64(t.Unix()))
+	return buf[:]
+}
+
+func parseUnixTime(x []byte) time.Time {
+	if len(x) != 8 {
+		return time.Time{}
+	}
+	return time.Unix(int64(binary.BigEndian.Uint64(x)), 0)
+}
@@ -0,0 +1,164 @@
+package blobcache
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/blobcache/blobcache/pkg/bcstate"
+	"github.com/blobcache/blobcache/pkg/blobs"
+	"github.com/blobcache/blobcache/pkg/eviction"
+)
+
+func TestGC(t *testing.T) {
+	ctx := context.TODO()
+	persistent := newTestBoltDB(t, "persistent.db", 0)
+	ephemeral := newTestCache(t, 1<<20)
+	clock := clockwork.NewFakeClock()
+	gc := NewGC(GCParams{
+		Persistent:  persistent,
+		Ephemeral:   ephemeral,
+		Clock:       clock,
+		GracePeriod: time.Minute,
+	})
+
+	const N = 10
+	ids := make([]blobs.ID, N)
+	for i := range ids {
+		data := []byte(fmt.Sprintf("test-data-%d", i))
+		ids[i] = blobs.Hash(data)
+		require.NoError(t, persistent.Bucket(bucketBlobs).Put(ids[i][:], data))
+	}
+	// pin the first half
+	require.NoError(t, persistent.WriteTx(ctx, func(tx bcstate.DB) error {
+		for _, id := range ids[:N/2] {
+			if err := pinIncr(refCounts(tx), id); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	// nothing should be collected during the grace period
+	res, err := gc.Collect(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, N, res.Scanned)
+	assert.Equal(t, 0, res.Deleted)
+
+	clock.Advance(2 * time.Minute)
+	res, err = gc.Collect(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, N/2, res.Deleted)
+	assert.Equal(t, N/2, res.Demoted)
+	assert.True(t, res.BytesReclaimed > 0)
+
+	for i, id := range ids {
+		persisted, err := bcstate.Exists(persistent.Bucket(bucketBlobs), id[:])
+		require.NoError(t, err)
+		demoted, err := ephemeral.Exists(ctx, id)
+		require.NoError(t, err)
+		if i < N/2 {
+			assert.True(t, persisted)
+			assert.False(t, demoted)
+		} else {
+			assert.False(t, persisted)
+			assert.True(t, demoted)
+		}
+	}
+}
+
+func TestGCPinDuringGrace(t *testing.T) {
+	ctx := context.TODO()
+	persistent := newTestBoltDB(t, "persistent.db", 0)
+	ephemeral := newTestCache(t, 1<<20)
+	clock := clockwork.NewFakeClock()
+	gc := NewGC(GCParams{
+		Persistent:  persistent,
+		Ephemeral:   ephemeral,
+		Clock:       clock,
+		GracePeriod: time.Minute,
+	})
+
+	data := []byte("test-data")
+	id := blobs.Hash(data)
+	require.NoError(t, persistent.Bucket(bucketBlobs).Put(id[:], data))
+	_, err := gc.Collect(ctx)
+	require.NoError(t, err)
+
+	// pinned after being marked, but before the grace period is over
+	require.NoError(t, persistent.WriteTx(ctx, func(tx bcstate.DB) error {
+		return pinIncr(refCounts(tx), id)
+	}))
+	clock.Advance(2 * time.Minute)
+	res, err := gc.Collect(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.Deleted)
+}
+
+func TestGCTrieNodes(t *testing.T) {
+	ctx := context.TODO()
+	persistent := newTestBoltDB(t, "persistent.db", 0)
+	ephemeral := newTestCache(t, 1<<20)
+	clock := clockwork.NewFakeClock()
+	gc := NewGC(GCParams{
+		Persistent:  persistent,
+		Ephemeral:   ephemeral,
+		Clock:       clock,
+		GracePeriod: time.Minute,
+	})
+	pinSets := NewPinSetStore(persistent)
+	psID, err := pinSets.Create(ctx, "pinset1")
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, pinSets.Pin(ctx, psID, blobs.Hash([]byte(fmt.Sprintf("test-data-%d", i)))))
+	}
+	ps, err := pinSets.Get(ctx, psID)
+	require.NoError(t, err)
+
+	_, err = gc.Collect(ctx)
+	require.NoError(t, err)
+	clock.Advance(2 * time.Minute)
+	res, err := gc.Collect(ctx)
+	require.NoError(t, err)
+	assert.True(t, res.Deleted > 0, "old versions of the root should be collected")
+	exists, err := bcstate.Exists(persistent.Bucket(bucketTrieNodes), ps.Root.ID[:])
+	require.NoError(t, err)
+	assert.True(t, exists, "the current root should not be collected")
+
+	// once the pinset is gone, so is its trie
+	require.NoError(t, pinSets.Delete(ctx, psID))
+	_, err = gc.Collect(ctx)
+	require.NoError(t, err)
+	clock.Advance(2 * time.Minute)
+	_, err = gc.Collect(ctx)
+	require.NoError(t, err)
+	exists, err = bcstate.Exists(persistent.Bucket(bucketTrieNodes), ps.Root.ID[:])
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func newTestBoltDB(t *testing.T, name string, capacity uint64) *bcstate.BoltDB {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), name), 0666, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	bdb, err := bcstate.OpenBoltDB(db, capacity)
+	require.NoError(t, err)
+	return bdb
+}
+
+func newTestCache(t *testing.T, capacity uint64) *eviction.Cache {
+	db := newTestBoltDB(t, "ephemeral.db", 0)
+	c, err := eviction.New(eviction.Params{
+		KV:       db.Bucket(bucketBlobs),
+		Capacity: capacity,
+	})
+	require.NoError(t, err)
+	return c
+}
@@ -2,53 +2,95 @@ package blobcache
 
 import (
 	"context"
+	"time"
 
 	"github.com/blobcache/blobcache/pkg/bcstate"
 	"github.com/blobcache/blobcache/pkg/blobnet"
 	"github.com/blobcache/blobcache/pkg/blobnet/peers"
 	"github.com/blobcache/blobcache/pkg/blobs"
+	"github.com/blobcache/blobcache/pkg/eviction"
+	"github.com/blobcache/blobcache/pkg/tries"
 	"github.com/brendoncarroll/go-p2p"
 	"github.com/brendoncarroll/go-p2p/p/dynmux"
 	"github.com/jonboulle/clockwork"
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	bucketLedger = "ledger"
+	// ledgerFlushPeriod is how often the balances in the ledger are persisted.
+	ledgerFlushPeriod = 10 * time.Second
+)
+
 type Params struct {
 	Ephemeral  bcstate.TxDB
 	Persistent bcstate.TxDB
 
+	// PersistentCapacity is the number of bytes of blobs to keep in Persistent, after which posts are spilled to peers.
+	// Pinsets and other metadata are not counted, so they can always be updated. 0 means no limit.
+	PersistentCapacity uint64
+	// EphemeralCapacity is the number of bytes of blobs to keep in Ephemeral
+	EphemeralCapacity uint64
+	// EphemeralPolicy decides which blobs to evict from Ephemeral. Defaults to LRU
+	EphemeralPolicy eviction.Policy
+
 	Mux        dynmux.Muxer
 	PrivateKey p2p.PrivateKey
 	PeerStore  peers.PeerStore
 
 	ExternalSources []Source
+
+	GCPeriod      time.Duration
+	GCGracePeriod time.Duration
+
+	ReplicationPeriod time.Duration
+
+	// PeerQuota is the number of bytes each trusted peer can persist locally. Defaults to DefaultPeerQuota
+	PeerQuota uint64
+	// SealMessages makes requests for blobs readable only by the peer they are for, not the peers forwarding them.
+	SealMessages bool
 }
 
 var _ API = &Node{}
 
 type Node struct {
-	ephemeral  bcstate.DB
-	persistent bcstate.DB
-	pinSets    *PinSetStore
+	ephemeral     bcstate.TxDB
+	persistent    bcstate.TxDB
+	persistentCap uint64
+	pinSets       *PinSetStore
+	cache         *eviction.Cache
 
 	readChain  blobs.ReadChain
 	extSources []Source
+	remote     *remoteFetcher
+
+	ledger *bcstate.Ledger
 
-	bn *blobnet.Blobnet
+	bn  *blobnet.Blobnet
+	gc  *GC
+	rep *Replicator
+	cf  context.CancelFunc
 }
 
-func NewNode(params Params) *Node {
-	pinSetStore := NewPinSetStore(bcstate.PrefixedTxDB{
-		TxDB:   params.Persistent,
-		Prefix: "pinsets",
-	})
+func NewNode(params Params) (*Node, error) {
+	pinSetStore := NewPinSetStore(params.Persistent)
 
-	ephemeralBlobs := params.Ephemeral.Bucket("blobs")
-	persistentBlobs := params.Persistent.Bucket("blobs")
+	clock := clockwork.NewRealClock()
+	cache, err := eviction.New(eviction.Params{
+		KV:       params.Ephemeral.Bucket(bucketBlobs),
+		Capacity: params.EphemeralCapacity,
+		Policy:   params.EphemeralPolicy,
+		Clock:    clock,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ledger := bcstate.NewLedger(params.Persistent.Bucket(bucketLedger), params.PeerStore.TrustFor)
 
 	readChain := blobs.ReadChain{
-		bcstate.BlobAdapter(ephemeralBlobs),
-		bcstate.BlobAdapter(persistentBlobs),
+		cache,
+		bcstate.BlobAdapter(params.Persistent.Bucket(bucketBlobs)),
+		bcstate.BlobAdapter(params.Persistent.Bucket(bucketTrieNodes)),
 	}
 	for _, extSource := range params.ExternalSources {
 		readChain = append(readChain, extSource)
@@ -57,28 +99,102 @@ func NewNode(params Params) *Node {
 	log.WithFields(log.Fields{
 		"local_id": p2p.NewPeerID(params.PrivateKey.Public()),
 	}).Info("starting node")
+	bn := blobnet.NewBlobNet(blobnet.Params{
+		Mux:       params.Mux,
+		Local:     readChain,
+		PeerStore: params.PeerStore,
+		DB:        bcstate.PrefixedDB{DB: params.Persistent, Prefix: "blobnet"},
+		Clock:     clock,
+
+		PeerStorage: newPeerStorage(params.Persistent, params.PersistentCapacity, cache, params.PeerQuota),
+		Ledger:      ledger,
+
+		PrivateKey:   params.PrivateKey,
+		SealMessages: params.SealMessages,
+	})
+	// tell the network about blobs entering and leaving the cache.
+	cache.OnChange(func(id blobs.ID, added bool) {
+		if added {
+			bn.HaveLocally(context.Background(), id)
+		} else {
+			bn.GoneLocally(context.Background(), id)
+		}
+	})
+
+	ctx, cf := context.WithCancel(context.Background())
 	n := &Node{
-		ephemeral:  params.Ephemeral,
-		persistent: params.Persistent,
+		ephemeral:     params.Ephemeral,
+		persistent:    params.Persistent,
+		persistentCap: params.PersistentCapacity,
 
 		pinSets:    pinSetStore,
+		cache:      cache,
 		readChain:  readChain,
 		extSources: params.ExternalSources,
+		ledger:     ledger,
 
-		bn: blobnet.NewBlobNet(blobnet.Params{
-			Mux:       params.Mux,
-			Local:     readChain,
-			PeerStore: params.PeerStore,
-			DB:        bcstate.PrefixedDB{DB: params.Ephemeral, Prefix: "blobnet"},
-			Clock:     clockwork.NewRealClock(),
+		bn: bn,
+		gc: NewGC(GCParams{
+			Persistent:  params.Persistent,
+			Ephemeral:   cache,
+			Clock:       clock,
+			Period:      params.GCPeriod,
+			GracePeriod: params.GCGracePeriod,
+			OnDelete: func(id blobs.ID) {
+				bn.GoneLocally(context.Background(), id)
+			},
 		}),
+		cf: cf,
 	}
+	n.rep = NewReplicator(ReplicatorParams{
+		Persistent: params.Persistent,
+		PinSets:    pinSetStore,
+		Local:      readChain,
+		Network:    n.bn,
+		PeerStore:  params.PeerStore,
+		Clock:      clock,
+		Period:     params.ReplicationPeriod,
+	})
+	n.remote = newRemoteFetcher(blobs.ReadChain{holderSource{rep: n.rep, bn: n.bn}, n.bn}, cache)
+	go n.gc.run(ctx)
+	go n.rep.run(ctx)
+	go n.flushLedger(ctx, clock)
 
-	return n
+	return n, nil
 }
 
 func (n *Node) Shutdown() error {
-	return n.bn.Close()
+	n.cf()
+	if err := n.bn.Close(); err != nil {
+		return err
+	}
+	return n.ledger.Flush()
+}
+
+// flushLedger persists the ledger every ledgerFlushPeriod, until ctx is done.
+func (n *Node) flushLedger(ctx context.Context, clock clockwork.Clock) {
+	ticker := clock.NewTicker(ledgerFlushPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.Chan():
+			if err := n.ledger.Flush(); err != nil {
+				log.Error(err)
+			}
+		}
+	}
+}
+
+// GC runs the garbage collector once, and returns a summary of what was collected.
+func (n *Node) GC(ctx context.Context) (*GCResult, error) {
+	return n.gc.Collect(ctx)
+}
+
+// Replicate runs the replicator once, and returns a summary of what was replicated.
+func (n *Node) Replicate(ctx context.Context) (*ReplicationResult, error) {
+	return n.rep.Replicate(ctx)
 }
 
 func (n *Node) CreatePinSet(ctx context.Context, name string) (PinSetID, error) {
@@ -98,39 +214,76 @@ func (n *Node) Unpin(ctx context.Context, pinset PinSetID, id blobs.ID) error {
 }
 
 func (n *Node) GetF(ctx context.Context, id blobs.ID, fn func([]byte) error) error {
-	readChain := append(n.readChain, n.bn)
+	readChain := append(n.readChain, n.remote)
 	return readChain.GetF(ctx, id, fn)
 }
 
 func (n *Node) Post(ctx context.Context, pinset PinSetID, data []byte) (blobs.ID, error) {
+	if len(data) > n.MaxBlobSize() {
+		return blobs.ID{}, blobs.ErrTooLarge
+	}
 	id := blobs.Hash(data)
 	if err := n.pinSets.Pin(ctx, pinset, id); err != nil {
 		return blobs.ID{}, err
 	}
 
 	// don't persist data if it is in an external source
-	for _, s := range n.extSources {
-		if exists, err := s.Exists(ctx, id); err != nil {
-			return blobs.ID{}, err
-		} else if exists {
-			return id, nil
-		}
+	if exists, err := n.inExternalSource(ctx, id); err != nil {
+		return blobs.ID{}, err
+	} else if exists {
+		return id, nil
 	}
 
 	// persist that data to local storage
-	err := n.persistent.Bucket("blobs").Put(id[:], data)
+	err := n.persistent.WriteTx(ctx, func(tx bcstate.DB) error {
+		return putPersistent(tx, n.persistentCap, id, data)
+	})
 	if err == bcstate.ErrFull {
-		// TODO: must be on the network
-		return blobs.ID{}, err
+		// there is no space locally, so it must be on the network
+		if err := n.rep.Spill(ctx, data); err != nil {
+			return blobs.ID{}, err
+		}
+		return id, nil
 	} else if err != nil {
 		return blobs.ID{}, err
 	}
 
-	// TODO: fire and forget to network
-	// TODO: depending on persistance config, ensure replication
+	if err := n.bn.HaveLocally(ctx, id); err != nil {
+		log.Error(err)
+	}
+	n.rep.Trigger()
 	return id, nil
 }
 
+// putPersistent stores a blob in the persistent blobs bucket.
+// It returns bcstate.ErrFull if the bucket would hold more than capacity bytes, unless capacity is 0.
+func putPersistent(tx bcstate.DB, capacity uint64, id blobs.ID, data []byte) error {
+	b := tx.Bucket(bucketBlobs)
+	if capacity > 0 {
+		exists, err := bcstate.Exists(b, id[:])
+		if err != nil {
+			return err
+		}
+		if exists {
+			return nil
+		}
+		if b.SizeUsed()+uint64(len(id)+len(data)) > capacity {
+			return bcstate.ErrFull
+		}
+	}
+	return b.Put(id[:], data)
+}
+
+// inExternalSource returns true if any of the external sources has the blob.
+func (n *Node) inExternalSource(ctx context.Context, id blobs.ID) (bool, error) {
+	for _, s := range n.extSources {
+		if exists, err := s.Exists(ctx, id); err != nil || exists {
+			return exists, err
+		}
+	}
+	return false, nil
+}
+
 func (node *Node) List(ctx context.Context, psID PinSetID, prefix []byte, ids []blobs.ID) (n int, err error) {
 	return node.pinSets.List(ctx, psID, prefix, ids)
 }
@@ -143,6 +296,80 @@ func (n *Node) GetPinSet(ctx context.Context, pinset PinSetID) (*PinSet, error)
 	return n.pinSets.Get(ctx, pinset)
 }
 
+func (n *Node) GetPinSetByName(ctx context.Context, name string) (*PinSet, error) {
+	return n.pinSets.GetByName(ctx, name)
+}
+
+func (n *Node) ListPinSets(ctx context.Context) ([]PinSet, error) {
+	return n.pinSets.ListPinSets(ctx)
+}
+
+func (n *Node) RenamePinSet(ctx context.Context, pinset PinSetID, name string) error {
+	return n.pinSets.Rename(ctx, pinset, name)
+}
+
+// Snapshot returns the current root of a pinset.
+func (n *Node) Snapshot(ctx context.Context, pinset PinSetID) (*tries.Ref, error) {
+	return n.pinSets.Snapshot(ctx, pinset)
+}
+
+// Diff calls fn for each blob added or removed between two pinset roots.
+func (n *Node) Diff(ctx context.Context, left, right tries.Ref, fn func(id blobs.ID, added bool) error) error {
+	return n.pinSets.Diff(ctx, left, right, fn)
+}
+
+func (n *Node) SetReplicas(ctx context.Context, pinset PinSetID, count int) error {
+	if err := n.pinSets.SetReplicas(ctx, pinset, count); err != nil {
+		return err
+	}
+	n.rep.Trigger()
+	return nil
+}
+
+func (n *Node) Balances(ctx context.Context) ([]PeerBalance, error) {
+	var balances []PeerBalance
+	err := n.ledger.ForEach(func(peer p2p.PeerID, b bcstate.Balance) error {
+		balances = append(balances, PeerBalance{
+			PeerID:  peer,
+			Balance: b,
+			Debt:    b.Debt(),
+			Trust:   n.ledger.Trust(peer),
+		})
+		return nil
+	})
+	return balances, err
+}
+
 func (n *Node) MaxBlobSize() int {
 	return blobs.MaxSize
 }
+
+// holderSource gets blobs from the peers which the Replicator knows are holding them.
+// This is the only way to get blobs which were spilled, if the network does not know where they are.
+type holderSource struct {
+	rep *Replicator
+	bn  *blobnet.Blobnet
+}
+
+func (s holderSource) GetF(ctx context.Context, id blobs.ID, fn func([]byte) error) error {
+	holders, err := s.rep.Holders(ctx, id)
+	if err != nil {
+		return err
+	}
+	for _, peer := range holders {
+		err := s.bn.GetFrom(ctx, peer, id, fn)
+		if err == nil {
+			return nil
+		}
+		log.WithFields(log.Fields{
+			"blob_id": id,
+			"peer_id": peer,
+		}).Warn("failed to get blob from holder: ", err)
+	}
+	return blobs.ErrNotFound
+}
+
+func (s holderSource) Exists(ctx context.Context, id blobs.ID) (bool, error) {
+	holders, err := s.rep.Holders(ctx, id)
+	return len(holders) > 0, err
+}
@@ -0,0 +1,138 @@
+package blobcache_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blobcache/blobcache/pkg/bcarchive"
+	"github.com/blobcache/blobcache/pkg/blobcache"
+	"github.com/blobcache/blobcache/pkg/blobcache/blobcachetest"
+	"github.com/blobcache/blobcache/pkg/blobs"
+)
+
+func TestNodeAPI(t *testing.T) {
+	blobcachetest.TestAPI(t, func(t testing.TB) blobcache.API {
+		return blobcachetest.NewTestNode(t)
+	})
+}
+
+func TestSnapshotDiff(t *testing.T) {
+	ctx := context.TODO()
+	node := blobcachetest.NewTestNode(t)
+	psID, err := node.CreatePinSet(ctx, "pinset1")
+	require.NoError(t, err)
+	ids := postN(t, node, psID, 10)
+	before, err := node.Snapshot(ctx, psID)
+	require.NoError(t, err)
+
+	var added []blobs.ID
+	for i := 0; i < 5; i++ {
+		id, err := node.Post(ctx, psID, []byte(fmt.Sprintf("test-data-added-%d", i)))
+		require.NoError(t, err)
+		added = append(added, id)
+	}
+	require.NoError(t, node.Unpin(ctx, psID, ids[0]))
+	after, err := node.Snapshot(ctx, psID)
+	require.NoError(t, err)
+
+	var actualAdded, actualRemoved []blobs.ID
+	require.NoError(t, node.Diff(ctx, *before, *after, func(id blobs.ID, isAdded bool) error {
+		if isAdded {
+			actualAdded = append(actualAdded, id)
+		} else {
+			actualRemoved = append(actualRemoved, id)
+		}
+		return nil
+	}))
+	assert.ElementsMatch(t, added, actualAdded)
+	assert.Equal(t, []blobs.ID{ids[0]}, actualRemoved)
+}
+
+func TestExportImport(t *testing.T) {
+	ctx := context.TODO()
+	node1 := blobcachetest.NewTestNode(t)
+	node2 := blobcachetest.NewTestNode(t)
+	psID, err := node1.CreatePinSet(ctx, "pinset1")
+	require.NoError(t, err)
+	ids := postN(t, node1, psID, 100)
+	ps1, err := node1.GetPinSet(ctx, psID)
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, node1.Export(ctx, psID, buf))
+	archive := buf.Bytes()
+
+	psID2, err := node2.Import(ctx, "", bytes.NewReader(archive))
+	require.NoError(t, err)
+	ps2, err := node2.GetPinSet(ctx, psID2)
+	require.NoError(t, err)
+	assert.Equal(t, ps1.Name, ps2.Name)
+	assert.Equal(t, ps1.Root, ps2.Root)
+	assert.Equal(t, ps1.Count, ps2.Count)
+	for _, id := range ids {
+		exists, err := node2.Exists(ctx, psID2, id)
+		require.NoError(t, err)
+		assert.True(t, exists)
+		require.NoError(t, node2.GetF(ctx, id, func([]byte) error { return nil }))
+	}
+
+	// the name is taken, so it must be renamed
+	_, err = node2.Import(ctx, "", bytes.NewReader(archive))
+	assert.Equal(t, blobcache.ErrPinSetExists, err)
+	_, err = node2.Import(ctx, "pinset2", bytes.NewReader(archive))
+	require.NoError(t, err)
+
+	// a truncated archive does not leave a pinset behind
+	_, err = node2.Import(ctx, "pinset3", bytes.NewReader(archive[:len(archive)/2]))
+	require.Error(t, err)
+	_, err = node2.GetPinSetByName(ctx, "pinset3")
+	assert.Equal(t, blobcache.ErrPinSetNotFound, err)
+
+	// an archive without one of its pinset's blobs is refused
+	node3 := blobcachetest.NewTestNode(t)
+	_, err = node3.Import(ctx, "", bytes.NewReader(dropLastBlob(t, archive)))
+	assert.True(t, errors.Is(err, blobcache.ErrArchiveIncomplete))
+	_, err = node3.GetPinSetByName(ctx, ps1.Name)
+	assert.Equal(t, blobcache.ErrPinSetNotFound, err)
+}
+
+// dropLastBlob returns archive without its last blob, which is one of the pinset's blobs for archives from Export.
+func dropLastBlob(t *testing.T, archive []byte) []byte {
+	ar, err := bcarchive.NewReader(bytes.NewReader(archive))
+	require.NoError(t, err)
+	var datas [][]byte
+	for {
+		_, data, err := ar.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		datas = append(datas, append([]byte{}, data...))
+	}
+	buf := &bytes.Buffer{}
+	aw, err := bcarchive.NewWriter(buf, ar.Header())
+	require.NoError(t, err)
+	for _, data := range datas[:len(datas)-1] {
+		_, err := aw.WriteBlob(data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, aw.Flush())
+	return buf.Bytes()
+}
+
+func postN(t testing.TB, api blobcache.API, psID blobcache.PinSetID, n int) []blobs.ID {
+	ids := make([]blobs.ID, n)
+	for i := range ids {
+		id, err := api.Post(context.TODO(), psID, []byte(fmt.Sprintf("test-data-%d-%d", psID, i)))
+		require.NoError(t, err)
+		ids[i] = id
+	}
+	return ids
+}
@@ -0,0 +1,337 @@
+package blobcache
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/blobcache/blobcache/pkg/bccrypto"
+	"github.com/blobcache/blobcache/pkg/blobs"
+)
+
+const (
+	objectRefSize = blobs.IDSize + len(bccrypto.DEK{}) + 8
+	// maxIndexEntries is how many child refs fit in an index node, after the 1 byte depth header
+	maxIndexEntries = (blobs.MaxSize - 1) / objectRefSize
+)
+
+// ObjectRef refers to an object of arbitrary size.
+// Depth 0 refers to a single data chunk, otherwise ID is an index node.
+type ObjectRef struct {
+	ID    blobs.ID
+	DEK   bccrypto.DEK
+	Size  uint64
+	Depth uint8
+}
+
+func (r ObjectRef) MarshalText() ([]byte, error) {
+	buf := make([]byte, 0, objectRefSize+1)
+	buf = appendRef(buf, r)
+	buf = append(buf, r.Depth)
+	out := make([]byte, base64.RawURLEncoding.EncodedLen(len(buf)))
+	base64.RawURLEncoding.Encode(out, buf)
+	return out, nil
+}
+
+func (r *ObjectRef) UnmarshalText(data []byte) error {
+	buf := make([]byte, base64.RawURLEncoding.DecodedLen(len(data)))
+	n, err := base64.RawURLEncoding.Decode(buf, data)
+	if err != nil {
+		return err
+	}
+	if n != objectRefSize+1 {
+		return errors.New("object ref is wrong length")
+	}
+	*r = parseRef(buf[:objectRefSize])
+	r.Depth = buf[objectRefSize]
+	return nil
+}
+
+func (r ObjectRef) String() string {
+	data, _ := r.MarshalText()
+	return string(data)
+}
+
+// PostObject splits the data from r into content defined chunks, and posts them to s.
+// Index nodes are built over the chunks, and a ref to the root is returned.
+// Pass a store from NewStore to pin every blob in the object into a pinset.
+func PostObject(ctx context.Context, s blobs.Poster, r io.Reader) (*ObjectRef, error) {
+	var levels [][]ObjectRef
+	// addRef adds a ref at the given depth, flushing full index nodes up the tree.
+	var addRef func(depth int, ref ObjectRef) error
+	addRef = func(depth int, ref ObjectRef) error {
+		if depth == len(levels) {
+			levels = append(levels, nil)
+		}
+		if len(levels[depth]) == maxIndexEntries {
+			parent, err := postIndex(ctx, s, uint8(depth+1), levels[depth])
+			if err != nil {
+				return err
+			}
+			levels[depth] = levels[depth][:0]
+			if err := addRef(depth+1, *parent); err != nil {
+				return err
+			}
+		}
+		levels[depth] = append(levels[depth], ref)
+		return nil
+	}
+
+	chunker := NewChunker(r)
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		ref, err := postChunk(ctx, s, chunk)
+		if err != nil {
+			return nil, err
+		}
+		if err := addRef(0, *ref); err != nil {
+			return nil, err
+		}
+	}
+	if len(levels) == 0 {
+		return postChunk(ctx, s, nil)
+	}
+
+	// flush the partial nodes
+	for depth := 0; ; depth++ {
+		refs := levels[depth]
+		if depth == len(levels)-1 && len(refs) == 1 {
+			return &refs[0], nil
+		}
+		parent, err := postIndex(ctx, s, uint8(depth+1), refs)
+		if err != nil {
+			return nil, err
+		}
+		if err := addRef(depth+1, *parent); err != nil {
+			return nil, err
+		}
+	}
+}
+
+var _ interface {
+	io.ReadSeeker
+	io.ReaderAt
+} = &ObjectReader{}
+
+// ObjectReader reads an object, fetching chunks lazily.
+// ReadAt is safe to call concurrently, Read and Seek are not.
+type ObjectReader struct {
+	ctx    context.Context
+	s      blobs.Getter
+	root   ObjectRef
+	offset int64
+
+	mu        sync.Mutex
+	nodes     map[blobs.ID][]ObjectRef
+	lastChunk blobs.ID
+	chunk     []byte
+}
+
+func NewObjectReader(ctx context.Context, s blobs.Getter, ref ObjectRef) *ObjectReader {
+	return &ObjectReader{
+		ctx:   ctx,
+		s:     s,
+		root:  ref,
+		nodes: make(map[blobs.ID][]ObjectRef),
+	}
+}
+
+func (or *ObjectReader) Size() int64 {
+	return int64(or.root.Size)
+}
+
+// Resolve fetches the start of the object, so that a missing or corrupt object
+// can be reported before anything is read from it.
+func (or *ObjectReader) Resolve() error {
+	if or.Size() == 0 {
+		_, err := or.getChunk(or.root)
+		return err
+	}
+	var b [1]byte
+	_, err := or.ReadAt(b[:], 0)
+	return err
+}
+
+func (or *ObjectReader) Read(p []byte) (int, error) {
+	n, err := or.ReadAt(p, or.offset)
+	or.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (or *ObjectReader) Seek(offset int64, whence int) (int64, error) {
+	var next int64
+	switch whence {
+	case io.SeekStart:
+		next = offset
+	case io.SeekCurrent:
+		next = or.offset + offset
+	case io.SeekEnd:
+		next = or.Size() + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if next < 0 {
+		return 0, errors.New("seek to negative offset")
+	}
+	or.offset = next
+	return next, nil
+}
+
+func (or *ObjectReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("read at negative offset")
+	}
+	n := 0
+	for n < len(p) {
+		if off+int64(n) >= or.Size() {
+			return n, io.EOF
+		}
+		n2, err := or.readAt(or.root, p[n:], uint64(off)+uint64(n))
+		if err != nil {
+			return n, err
+		}
+		n += n2
+	}
+	return n, nil
+}
+
+// readAt reads from the chunk which contains off, under ref.
+func (or *ObjectReader) readAt(ref ObjectRef, p []byte, off uint64) (int, error) {
+	if ref.Depth == 0 {
+		chunk, err := or.getChunk(ref)
+		if err != nil {
+			return 0, err
+		}
+		return copy(p, chunk[off:]), nil
+	}
+	children, err := or.getIndex(ref)
+	if err != nil {
+		return 0, err
+	}
+	for _, child := range children {
+		if off < child.Size {
+			return or.readAt(child, p, off)
+		}
+		off -= child.Size
+	}
+	return 0, errors.New("offset out of range of index node")
+}
+
+func (or *ObjectReader) getChunk(ref ObjectRef) ([]byte, error) {
+	or.mu.Lock()
+	if or.chunk != nil && or.lastChunk == ref.ID {
+		chunk := or.chunk
+		or.mu.Unlock()
+		return chunk, nil
+	}
+	or.mu.Unlock()
+
+	var chunk []byte
+	if err := bccrypto.GetF(or.ctx, or.s, ref.DEK, ref.ID, func(data []byte) error {
+		chunk = append([]byte{}, data...)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if uint64(len(chunk)) != ref.Size {
+		return nil, fmt.Errorf("chunk %v has size %d, expected %d", ref.ID, len(chunk), ref.Size)
+	}
+	or.mu.Lock()
+	or.lastChunk, or.chunk = ref.ID, chunk
+	or.mu.Unlock()
+	return chunk, nil
+}
+
+func (or *ObjectReader) getIndex(ref ObjectRef) ([]ObjectRef, error) {
+	or.mu.Lock()
+	children, exists := or.nodes[ref.ID]
+	or.mu.Unlock()
+	if exists {
+		return children, nil
+	}
+	if err := bccrypto.GetF(or.ctx, or.s, ref.DEK, ref.ID, func(data []byte) error {
+		var err error
+		children, err = parseIndex(ref.Depth, data)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	or.mu.Lock()
+	defer or.mu.Unlock()
+	// index nodes are small, but don't hold on to all of them for huge objects.
+	if len(or.nodes) > 64 {
+		or.nodes = make(map[blobs.ID][]ObjectRef)
+	}
+	or.nodes[ref.ID] = children
+	return children, nil
+}
+
+func postChunk(ctx context.Context, s blobs.Poster, data []byte) (*ObjectRef, error) {
+	id, dek, err := bccrypto.Post(ctx, s, bccrypto.Convergent, data)
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectRef{ID: id, DEK: *dek, Size: uint64(len(data))}, nil
+}
+
+func postIndex(ctx context.Context, s blobs.Poster, depth uint8, children []ObjectRef) (*ObjectRef, error) {
+	buf := make([]byte, 0, 1+len(children)*objectRefSize)
+	buf = append(buf, depth)
+	var size uint64
+	for _, child := range children {
+		buf = appendRef(buf, child)
+		size += child.Size
+	}
+	id, dek, err := bccrypto.Post(ctx, s, bccrypto.Convergent, buf)
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectRef{ID: id, DEK: *dek, Size: size, Depth: depth}, nil
+}
+
+func parseIndex(depth uint8, data []byte) ([]ObjectRef, error) {
+	if len(data) < 1 || data[0] != depth {
+		return nil, errors.New("index node has wrong depth")
+	}
+	data = data[1:]
+	if len(data)%objectRefSize != 0 {
+		return nil, errors.New("index node has invalid length")
+	}
+	children := make([]ObjectRef, 0, len(data)/objectRefSize)
+	for len(data) > 0 {
+		child := parseRef(data[:objectRefSize])
+		child.Depth = depth - 1
+		children = append(children, child)
+		data = data[objectRefSize:]
+	}
+	return children, nil
+}
+
+func appendRef(buf []byte, r ObjectRef) []byte {
+	buf = append(buf, r.ID[:]...)
+	buf = append(buf, r.DEK[:]...)
+	var size [8]byte
+	binary.BigEndian.PutUint64(size[:], r.Size)
+	return append(buf, size[:]...)
+}
+
+func parseRef(x []byte) ObjectRef {
+	r := ObjectRef{}
+	copy(r.ID[:], x[:blobs.IDSize])
+	copy(r.DEK[:], x[blobs.IDSize:blobs.IDSize+len(r.DEK)])
+	r.Size = binary.BigEndian.Uint64(x[blobs.IDSize+len(r.DEK):])
+	return r
+}
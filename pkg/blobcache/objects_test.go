@@ -0,0 +1,107 @@
+package blobcache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blobcache/blobcache/pkg/blobs"
+)
+
+func TestObjectRoundTrip(t *testing.T) {
+	ctx := context.TODO()
+	for _, size := range []int{0, 1, minChunkSize, 3 * blobs.MaxSize, 70 << 20} {
+		s := blobs.NewMem()
+		data := randomBytes(size)
+		ref, err := PostObject(ctx, s, bytes.NewReader(data))
+		require.NoError(t, err)
+		require.Equal(t, uint64(size), ref.Size)
+		t.Logf("size=%d depth=%d blobs=%d", size, ref.Depth, s.Len())
+
+		actual, err := ioutil.ReadAll(NewObjectReader(ctx, s, *ref))
+		require.NoError(t, err)
+		require.True(t, bytes.Equal(data, actual))
+	}
+}
+
+func TestObjectReadAt(t *testing.T) {
+	ctx := context.TODO()
+	s := blobs.NewMem()
+	data := randomBytes(1 << 20)
+	ref, err := PostObject(ctx, s, bytes.NewReader(data))
+	require.NoError(t, err)
+
+	r := NewObjectReader(ctx, s, *ref)
+	for _, off := range []int64{0, 12345, 1<<20 - 100} {
+		buf := make([]byte, 1000)
+		n, err := r.ReadAt(buf, off)
+		if off+int64(len(buf)) > int64(len(data)) {
+			require.Equal(t, io.EOF, err)
+		} else {
+			require.NoError(t, err)
+		}
+		assert.Equal(t, data[off:off+int64(n)], buf[:n])
+	}
+}
+
+func TestObjectReadAtConcurrent(t *testing.T) {
+	ctx := context.TODO()
+	s := blobs.NewMem()
+	data := randomBytes(1 << 20)
+	ref, err := PostObject(ctx, s, bytes.NewReader(data))
+	require.NoError(t, err)
+
+	r := NewObjectReader(ctx, s, *ref)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		off := int64(i) * (1 << 17)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, 1<<16)
+			n, err := r.ReadAt(buf, off)
+			assert.NoError(t, err)
+			assert.Equal(t, data[off:off+int64(n)], buf[:n])
+		}()
+	}
+	wg.Wait()
+}
+
+func TestChunkerStable(t *testing.T) {
+	ctx := context.TODO()
+	data := randomBytes(1 << 20)
+	s := blobs.NewMem()
+	_, err := PostObject(ctx, s, bytes.NewReader(data))
+	require.NoError(t, err)
+	before := s.Len()
+
+	// inserting a byte near the start should only add a few new chunks
+	data2 := append([]byte{0}, data...)
+	_, err = PostObject(ctx, s, bytes.NewReader(data2))
+	require.NoError(t, err)
+	assert.True(t, s.Len()-before < 5, "%d new blobs", s.Len()-before)
+}
+
+func TestObjectRefText(t *testing.T) {
+	ref := ObjectRef{Size: 1234, Depth: 2}
+	ref.ID[0] = 1
+	ref.DEK[0] = 2
+	data, err := ref.MarshalText()
+	require.NoError(t, err)
+	var actual ObjectRef
+	require.NoError(t, actual.UnmarshalText(data))
+	assert.Equal(t, ref, actual)
+}
+
+func randomBytes(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(data)
+	return data
+}
@@ -0,0 +1,174 @@
+package blobcache
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"path"
+
+	"github.com/brendoncarroll/go-p2p"
+
+	"github.com/blobcache/blobcache/pkg/bcstate"
+	"github.com/blobcache/blobcache/pkg/blobnet"
+	"github.com/blobcache/blobcache/pkg/blobs"
+	"github.com/blobcache/blobcache/pkg/eviction"
+)
+
+const (
+	prefixPeerStorage = "peer-storage"
+	bucketPeerUsage   = "peer-storage-usage"
+
+	// DefaultPeerQuota is the number of bytes each trusted peer can persist locally.
+	DefaultPeerQuota = 64 * 1024 * 1024
+)
+
+var _ blobnet.PeerStorage = &peerStorage{}
+
+// peerStorage stores blobs on behalf of peers.
+// Each blob persisted for a peer counts as a pin, so it is not garbage collected, and its size
+// counts towards the peer's quota.
+// Cached blobs go in the ephemeral cache, which is shared with everything else, so they are not counted.
+type peerStorage struct {
+	db       bcstate.TxDB
+	capacity uint64
+	cache    *eviction.Cache
+	quota    uint64
+}
+
+func newPeerStorage(persistent bcstate.TxDB, capacity uint64, cache *eviction.Cache, quota uint64) *peerStorage {
+	if quota == 0 {
+		quota = DefaultPeerQuota
+	}
+	return &peerStorage{db: persistent, capacity: capacity, cache: cache, quota: quota}
+}
+
+func (s *peerStorage) StoreFor(ctx context.Context, peer p2p.PeerID, data []byte, persist bool) error {
+	if !persist {
+		_, err := s.cache.Post(ctx, data)
+		return err
+	}
+	id := blobs.Hash(data)
+	return s.db.WriteTx(ctx, func(tx bcstate.DB) error {
+		b := tx.Bucket(peerStorageBucket(peer))
+		exists, err := bcstate.Exists(b, id[:])
+		if err != nil {
+			return err
+		}
+		if exists {
+			return nil
+		}
+		usage, err := peerUsage(tx, peer)
+		if err != nil {
+			return err
+		}
+		if usage+uint64(len(data)) > s.quota {
+			return bcstate.ErrFull
+		}
+		if err := putPersistent(tx, s.capacity, id, data); err != nil {
+			return err
+		}
+		if err := b.Put(id[:], uint64Bytes(uint64(len(data)))); err != nil {
+			return err
+		}
+		if err := putPeerUsage(tx, peer, usage+uint64(len(data))); err != nil {
+			return err
+		}
+		return pinIncr(refCounts(tx), id)
+	})
+}
+
+func (s *peerStorage) ReleaseFor(ctx context.Context, peer p2p.PeerID, ids []blobs.ID) error {
+	return s.db.WriteTx(ctx, func(tx bcstate.DB) error {
+		b := tx.Bucket(peerStorageBucket(peer))
+		usage, err := peerUsage(tx, peer)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			var size uint64
+			err := b.GetF(id[:], func(v []byte) error {
+				size = parseUint64(v)
+				return nil
+			})
+			if err == bcstate.ErrNotExist {
+				continue
+			} else if err != nil {
+				return err
+			}
+			if err := b.Delete(id[:]); err != nil {
+				return err
+			}
+			if err := pinDecr(refCounts(tx), id); err != nil {
+				return err
+			}
+			if size > usage {
+				size = usage
+			}
+			usage -= size
+		}
+		return putPeerUsage(tx, peer, usage)
+	})
+}
+
+func (s *peerStorage) HeldFor(ctx context.Context, peer p2p.PeerID, ids []blobs.ID) ([]bool, error) {
+	held := make([]bool, len(ids))
+	err := s.db.ReadTx(ctx, func(tx bcstate.DB) error {
+		b := tx.Bucket(peerStorageBucket(peer))
+		for i, id := range ids {
+			exists, err := bcstate.Exists(b, id[:])
+			if err != nil {
+				return err
+			}
+			held[i] = exists
+		}
+		return nil
+	})
+	return held, err
+}
+
+// Usage returns the number of bytes persisted on behalf of peer.
+func (s *peerStorage) Usage(ctx context.Context, peer p2p.PeerID) (uint64, error) {
+	var usage uint64
+	err := s.db.ReadTx(ctx, func(tx bcstate.DB) error {
+		var err error
+		usage, err = peerUsage(tx, peer)
+		return err
+	})
+	return usage, err
+}
+
+func peerUsage(tx bcstate.DB, peer p2p.PeerID) (uint64, error) {
+	var usage uint64
+	err := tx.Bucket(bucketPeerUsage).GetF(peer[:], func(v []byte) error {
+		usage = parseUint64(v)
+		return nil
+	})
+	if err == bcstate.ErrNotExist {
+		err = nil
+	}
+	return usage, err
+}
+
+func putPeerUsage(tx bcstate.DB, peer p2p.PeerID, usage uint64) error {
+	if usage == 0 {
+		return tx.Bucket(bucketPeerUsage).Delete(peer[:])
+	}
+	return tx.Bucket(bucketPeerUsage).Put(peer[:], uint64Bytes(usage))
+}
+
+func peerStorageBucket(peer p2p.PeerID) string {
+	return path.Join(prefixPeerStorage, hex.EncodeToString(peer[:]))
+}
+
+func uint64Bytes(x uint64) []byte {
+	buf := [8]byte{}
+	binary.BigEndian.PutUint64(buf[:], x)
+	return buf[:]
+}
+
+func parseUint64(x []byte) uint64 {
+	if len(x) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(x)
+}
@@ -3,6 +3,7 @@ package blobcache
 import (
 	"context"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"path"
@@ -16,246 +17,535 @@ const (
 	bucketPinSets      = "pinsets"
 	bucketPinSetNames  = "pinsets-names"
 	bucketPinRefCounts = "pinrefcount"
+	// bucketTrieNodes holds the nodes of every pinset's trie.
+	// It is separate from the blobs, so pinning does not compete with them for capacity.
+	bucketTrieNodes = "trie-nodes"
 
 	idBucketFmt = "%016x"
 )
 
 var (
-	ErrPinSetExists   = errors.New("pinset exists")
-	ErrPinSetNotFound = errors.New("pinset not found")
+	ErrPinSetExists      = errors.New("pinset exists")
+	ErrPinSetNotFound    = errors.New("pinset not found")
+	ErrInvalidPinSetName = errors.New("invalid pinset name")
+
+	ErrInvalidReplicaCount = errors.New("replica count must not be negative")
 )
 
+const maxPinSetNameLen = 255
+
 type PinSetID int64
 
 type PinSet struct {
-	ID    PinSetID `json:"id"`
-	Name  string   `json:"name"`
-	Root  blobs.ID `json:"root"`
-	Count uint64   `json:"count"`
+	ID    PinSetID  `json:"id"`
+	Name  string    `json:"name"`
+	Root  tries.Ref `json:"root"`
+	Count uint64    `json:"count"`
+	// Replicas is the number of trusted peers which should hold a copy of each blob
+	Replicas int `json:"replicas"`
+}
+
+// pinSetRecord is stored in the pinsets bucket for each pinset.
+// Root is the trie containing every blob ID in the pinset, and is updated on each Pin and Unpin.
+type pinSetRecord struct {
+	Name     string    `json:"name"`
+	Root     tries.Ref `json:"root"`
+	Count    uint64    `json:"count"`
+	Replicas int       `json:"replicas,omitempty"`
 }
 
+// PinSetStore manages pinsets in the persistent database.
+// The contents of each pinset are kept in a bucket for fast lookups, and in a trie,
+// whose nodes are stored in their own persistent bucket so they can be served to peers.
 type PinSetStore struct {
 	db bcstate.TxDB
 }
 
-func NewPinSetStore(db bcstate.TxDB) *PinSetStore {
+func NewPinSetStore(persistent bcstate.TxDB) *PinSetStore {
 	return &PinSetStore{
-		db: db,
+		db: persistent,
 	}
 }
 
-// Create creates a new PinSet
+// Create creates a new PinSet.  Names must be unique.
 func (s *PinSetStore) Create(ctx context.Context, name string) (PinSetID, error) {
+	if err := ValidatePinSetName(name); err != nil {
+		return 0, err
+	}
 	var id PinSetID
-	err := s.db.WriteTx(ctx, func(tx bcstate.DB) error {
-		b := tx.Bucket(path.Join(bucketPinSets))
+	err := s.writeTx(ctx, func(tx bcstate.DB, nodes blobs.Store) error {
+		names := tx.Bucket(bucketPinSetNames)
+		exists, err := bcstate.Exists(names, []byte(name))
+		if err != nil {
+			return err
+		}
+		if exists {
+			return ErrPinSetExists
+		}
+		b := tx.Bucket(bucketPinSets)
 		seq, err := b.NextSequence()
 		if err != nil {
 			return err
 		}
 		id = PinSetID(seq)
-		return err
+		root, err := tries.PostNode(ctx, nodes, tries.New())
+		if err != nil {
+			return err
+		}
+		if err := putRecord(tx, id, pinSetRecord{Name: name, Root: *root}); err != nil {
+			return err
+		}
+		return names.Put([]byte(name), idToKey(id))
 	})
 	return id, err
 }
 
 // Get returns a pinset by id
 func (s *PinSetStore) Get(ctx context.Context, id PinSetID) (*PinSet, error) {
-	//TODO: cache this in the pinsets bucket
-	// so we don't have to build the Trie every time
 	var ps *PinSet
-	err := s.db.ReadTx(ctx, func(tx bcstate.DB) error {
-		b := tx.Bucket(bucketPinSets)
-		exists, err := bcstate.Exists(b, idToKey(id))
+	err := s.readTx(ctx, func(tx bcstate.DB) error {
+		var err error
+		ps, err = getPinSet(tx, id)
+		return err
+	})
+	return ps, err
+}
+
+// GetByName returns a pinset by name
+func (s *PinSetStore) GetByName(ctx context.Context, name string) (*PinSet, error) {
+	if err := ValidatePinSetName(name); err != nil {
+		return nil, err
+	}
+	var ps *PinSet
+	err := s.readTx(ctx, func(tx bcstate.DB) error {
+		var id PinSetID
+		err := tx.Bucket(bucketPinSetNames).GetF([]byte(name), func(v []byte) error {
+			id = keyToID(v)
+			return nil
+		})
+		if err == bcstate.ErrNotExist {
+			return ErrPinSetNotFound
+		}
 		if err != nil {
 			return err
 		}
-		if !exists {
-			return ErrPinSetNotFound
-		}
+		ps, err = getPinSet(tx, id)
+		return err
+	})
+	return ps, err
+}
 
-		pinSetB := tx.Bucket(idToBucket(id))
-		t := tries.New()
-		count := uint64(0)
-		if err := pinSetB.ForEach(nil, nil, func(k, v []byte) error {
-			t.Entries = append(t.Entries, &tries.Entry{
-				Key: k,
-			})
-			count++
+// ListPinSets returns all the pinsets, ordered by ID
+func (s *PinSetStore) ListPinSets(ctx context.Context) ([]PinSet, error) {
+	var pinSets []PinSet
+	err := s.readTx(ctx, func(tx bcstate.DB) error {
+		return tx.Bucket(bucketPinSets).ForEach(nil, nil, func(k, v []byte) error {
+			var rec pinSetRecord
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return err
+			}
+			pinSets = append(pinSets, rec.toPinSet(keyToID(k)))
 			return nil
-		}); err != nil {
+		})
+	})
+	return pinSets, err
+}
+
+// Rename changes the name of a pinset.  The new name must not be in use by another pinset.
+func (s *PinSetStore) Rename(ctx context.Context, id PinSetID, name string) error {
+	if err := ValidatePinSetName(name); err != nil {
+		return err
+	}
+	return s.writeTx(ctx, func(tx bcstate.DB, _ blobs.Store) error {
+		rec, err := getRecord(tx, id)
+		if err != nil {
 			return err
 		}
-		root, err := tries.PostNode(ctx, blobs.NewMem(), t)
+		if rec.Name == name {
+			return nil
+		}
+		names := tx.Bucket(bucketPinSetNames)
+		exists, err := bcstate.Exists(names, []byte(name))
 		if err != nil {
 			return err
 		}
-		ps = &PinSet{
-			ID:    id,
-			Root:  root.ID,
-			Count: count,
+		if exists {
+			return ErrPinSetExists
 		}
-		return nil
+		if err := names.Delete([]byte(rec.Name)); err != nil {
+			return err
+		}
+		if err := names.Put([]byte(name), idToKey(id)); err != nil {
+			return err
+		}
+		rec.Name = name
+		return putRecord(tx, id, *rec)
 	})
-	return ps, err
 }
 
-// Delete ensures a pinset does not exist
-func (s *PinSetStore) Delete(ctx context.Context, id PinSetID) error {
-	return s.db.WriteTx(ctx, func(tx bcstate.DB) error {
-		b := tx.Bucket(bucketPinSets)
-		exists, err := bcstate.Exists(b, idToKey(id))
+// SetReplicas sets the number of trusted peers which should hold a copy of each blob in the pinset.
+func (s *PinSetStore) SetReplicas(ctx context.Context, id PinSetID, n int) error {
+	if n < 0 {
+		return ErrInvalidReplicaCount
+	}
+	return s.writeTx(ctx, func(tx bcstate.DB, _ blobs.Store) error {
+		rec, err := getRecord(tx, id)
 		if err != nil {
 			return err
 		}
-		if !exists {
+		rec.Replicas = n
+		return putRecord(tx, id, *rec)
+	})
+}
+
+// Delete ensures a pinset does not exist.
+// The nodes of its trie are left for the garbage collector.
+func (s *PinSetStore) Delete(ctx context.Context, id PinSetID) error {
+	return s.writeTx(ctx, func(tx bcstate.DB, _ blobs.Store) error {
+		rec, err := getRecord(tx, id)
+		if err == ErrPinSetNotFound {
 			return nil
 		}
+		if err != nil {
+			return err
+		}
 
 		// first decrement all the pins
 		rc := tx.Bucket(bucketPinRefCounts)
 		pinSetB := tx.Bucket(idToBucket(id))
+		var pinned []blobs.ID
 		err = pinSetB.ForEach(nil, nil, func(k, v []byte) error {
-			blobID := blobs.ID{}
-			copy(blobID[:], k)
-			if err := pinDecr(rc, blobID); err != nil {
-				return err
-			}
+			pinned = append(pinned, blobs.IDFromBytes(k))
 			return nil
 		})
 		if err != nil {
 			return err
 		}
-		return b.Delete(idToKey(id))
+		for _, blobID := range pinned {
+			if err := pinDecr(rc, blobID); err != nil {
+				return err
+			}
+			if err := pinSetB.Delete(blobID[:]); err != nil {
+				return err
+			}
+		}
+		if err := tx.Bucket(bucketPinSetNames).Delete([]byte(rec.Name)); err != nil {
+			return err
+		}
+		return tx.Bucket(bucketPinSets).Delete(idToKey(id))
 	})
 }
 
 // Pin ensures that a pinset contain a blob
 func (s *PinSetStore) Pin(ctx context.Context, psID PinSetID, id blobs.ID) error {
-	err := s.db.WriteTx(ctx, func(tx bcstate.DB) error {
-		b := tx.Bucket(bucketPinSets)
-		exists, err := bcstate.Exists(b, idToKey(psID))
+	return s.pinMany(ctx, psID, []blobs.ID{id})
+}
+
+// pinMany pins ids in a single transaction, writing the pinset's record once.
+func (s *PinSetStore) pinMany(ctx context.Context, psID PinSetID, ids []blobs.ID) error {
+	return s.writeTx(ctx, func(tx bcstate.DB, nodes blobs.Store) error {
+		rec, err := getRecord(tx, psID)
 		if err != nil {
 			return err
 		}
-		if !exists {
-			return ErrPinSetNotFound
-		}
-
 		pinSetB := tx.Bucket(idToBucket(psID))
-		if err := pinSetB.Put(id[:], []byte{}); err != nil {
-			return err
-		}
-
 		rc := tx.Bucket(bucketPinRefCounts)
-		return pinIncr(rc, id)
+		for _, id := range ids {
+			exists, err := bcstate.Exists(pinSetB, id[:])
+			if err != nil {
+				return err
+			}
+			if exists {
+				continue
+			}
+			if err := pinSetB.Put(id[:], []byte{}); err != nil {
+				return err
+			}
+			if err := pinIncr(rc, id); err != nil {
+				return err
+			}
+			root, err := tries.Put(ctx, nodes, rec.Root, id[:], nil)
+			if err != nil {
+				return err
+			}
+			rec.Root = *root
+			rec.Count++
+		}
+		return putRecord(tx, psID, *rec)
 	})
-	return err
 }
 
 // Unpin ensures that a pinset does not contain a blob
 func (s *PinSetStore) Unpin(ctx context.Context, psID PinSetID, id blobs.ID) error {
-	err := s.db.WriteTx(ctx, func(tx bcstate.DB) error {
-		b := tx.Bucket(bucketPinSets)
-		exists, err := bcstate.Exists(b, idToKey(psID))
+	return s.writeTx(ctx, func(tx bcstate.DB, nodes blobs.Store) error {
+		rec, err := getRecord(tx, psID)
+		if err != nil {
+			return err
+		}
+		pinSetB := tx.Bucket(idToBucket(psID))
+		exists, err := bcstate.Exists(pinSetB, id[:])
 		if err != nil {
 			return err
 		}
 		if !exists {
-			return ErrPinSetNotFound
+			return nil
 		}
-
-		pinSetB := tx.Bucket(idToBucket(psID))
 		if err := pinSetB.Delete(id[:]); err != nil {
 			return err
 		}
+		if err := pinDecr(tx.Bucket(bucketPinRefCounts), id); err != nil {
+			return err
+		}
 
-		rc := tx.Bucket(bucketPinRefCounts)
-		return pinDecr(rc, id)
+		root, err := tries.Delete(ctx, nodes, rec.Root, id[:])
+		if err != nil {
+			return err
+		}
+		rec.Root = *root
+		rec.Count--
+		return putRecord(tx, psID, *rec)
 	})
-	return err
 }
 
 // Exists returns true iff a pinset contains id
 func (s *PinSetStore) Exists(ctx context.Context, psID PinSetID, id blobs.ID) (bool, error) {
 	var exists bool
-	err := s.db.ReadTx(ctx, func(tx bcstate.DB) error {
+	err := s.readTx(ctx, func(tx bcstate.DB) error {
 		b := tx.Bucket(bucketPinSets)
-		exists, err := bcstate.Exists(b, idToKey(psID))
+		psExists, err := bcstate.Exists(b, idToKey(psID))
 		if err != nil {
 			return err
 		}
-		if !exists {
+		if !psExists {
 			return ErrPinSetNotFound
 		}
 		pinSetB := tx.Bucket(idToBucket(psID))
-		return pinSetB.GetF(id[:], func([]byte) error {
-			exists = true
-			return nil
-		})
+		exists, err = bcstate.Exists(pinSetB, id[:])
+		return err
 	})
 	return exists, err
 }
 
 // List lists all the items in the pinset
 func (s *PinSetStore) List(ctx context.Context, pinSetID PinSetID, prefix []byte, ids []blobs.ID) (n int, err error) {
-	err = s.db.ReadTx(ctx, func(tx bcstate.DB) error {
-		rc := tx.Bucket(bucketPinRefCounts)
-		return rc.ForEach(prefix, bcstate.PrefixEnd(prefix), func(k, v []byte) error {
-			b := tx.Bucket(bucketPinSets)
-			exists, err := bcstate.Exists(b, idToKey(pinSetID))
-			if err != nil {
-				return err
-			}
-			if !exists {
-				return ErrPinSetNotFound
+	err = s.readTx(ctx, func(tx bcstate.DB) error {
+		b := tx.Bucket(bucketPinSets)
+		exists, err := bcstate.Exists(b, idToKey(pinSetID))
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrPinSetNotFound
+		}
+		pinSetB := tx.Bucket(idToBucket(pinSetID))
+		return pinSetB.ForEach(prefix, bcstate.PrefixEnd(prefix), func(k, v []byte) error {
+			if n >= len(ids) {
+				return blobs.ErrTooMany
 			}
-			pinSetB := tx.Bucket(idToBucket(pinSetID))
-			return pinSetB.ForEach(prefix, bcstate.PrefixEnd(prefix), func(k, v []byte) error {
-				if n >= len(ids) {
-					return blobs.ErrTooMany
-				}
-				copy(ids[n][:], k)
-				n++
-				return nil
-			})
+			copy(ids[n][:], k)
+			n++
+			return nil
 		})
 	})
 	return n, err
 }
 
-func pinIncr(b bcstate.KV, id blobs.ID) error {
-	key := id[:]
-	return b.GetF(key, func(data []byte) error {
-		if data == nil {
-			data := make([]byte, binary.MaxVarintLen64)
-			n := binary.PutUvarint(key, 1)
-			data = data[:n]
-		} else {
-			x, _ := binary.Uvarint(data)
-			x++
-			n := binary.PutUvarint(data, x)
-			data = data[:n]
-		}
-		return b.Put(key, data)
+// Snapshot returns the current root of a pinset.
+// The nodes of old roots are kept until the garbage collector's grace period has passed,
+// so a snapshot can be diffed or exported for at least that long.
+func (s *PinSetStore) Snapshot(ctx context.Context, psID PinSetID) (*tries.Ref, error) {
+	ps, err := s.Get(ctx, psID)
+	if err != nil {
+		return nil, err
+	}
+	return &ps.Root, nil
+}
+
+// Diff calls fn for each blob which is in right but not left (added), or in left but not right (removed).
+// left and right are pinset roots, from Snapshot or GetPinSet.
+func (s *PinSetStore) Diff(ctx context.Context, left, right tries.Ref, fn func(id blobs.ID, added bool) error) error {
+	nodes := newTrieStore(s.db)
+	return tries.Diff(ctx, nodes, left, right, func(l, r *tries.Entry) error {
+		switch {
+		case l == nil:
+			return fn(blobs.IDFromBytes(r.Key), true)
+		case r == nil:
+			return fn(blobs.IDFromBytes(l.Key), false)
+		default:
+			// pinsets have no values, so there is nothing to change.
+			return nil
+		}
+	})
+}
+
+// pinned returns all the blobs in a pinset
+func (s *PinSetStore) pinned(ctx context.Context, psID PinSetID) ([]blobs.ID, error) {
+	var ids []blobs.ID
+	err := s.readTx(ctx, func(tx bcstate.DB) error {
+		ids = nil
+		if _, err := getRecord(tx, psID); err != nil {
+			return err
+		}
+		return tx.Bucket(idToBucket(psID)).ForEach(nil, nil, func(k, _ []byte) error {
+			ids = append(ids, blobs.IDFromBytes(k))
+			return nil
+		})
+	})
+	return ids, err
+}
+
+// isPinned returns true if any pinset contains id
+func (s *PinSetStore) isPinned(ctx context.Context, id blobs.ID) (bool, error) {
+	var count uint64
+	err := s.readTx(ctx, func(tx bcstate.DB) error {
+		var err error
+		count, err = pinCount(tx.Bucket(bucketPinRefCounts), id)
+		return err
+	})
+	return count > 0, err
+}
+
+// writeTx calls fn with the pinset buckets, and a store for trie nodes, in a single transaction.
+func (s *PinSetStore) writeTx(ctx context.Context, fn func(tx bcstate.DB, nodes blobs.Store) error) error {
+	return s.db.WriteTx(ctx, func(tx bcstate.DB) error {
+		return fn(bcstate.PrefixedDB{DB: tx, Prefix: prefixPinSets}, newTrieStore(tx))
 	})
 }
 
+func (s *PinSetStore) readTx(ctx context.Context, fn func(tx bcstate.DB) error) error {
+	return s.db.ReadTx(ctx, func(tx bcstate.DB) error {
+		return fn(bcstate.PrefixedDB{DB: tx, Prefix: prefixPinSets})
+	})
+}
+
+// ValidatePinSetName returns ErrInvalidPinSetName if name can not be used for a pinset.
+// Names are made of letters, digits, '_', '-' and '.', and can not be all digits,
+// so they are never confused with an ID.
+func ValidatePinSetName(name string) error {
+	if len(name) == 0 || len(name) > maxPinSetNameLen {
+		return ErrInvalidPinSetName
+	}
+	allDigits := true
+	for _, c := range name {
+		switch {
+		case c >= '0' && c <= '9':
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_', c == '-', c == '.':
+			allDigits = false
+		default:
+			return ErrInvalidPinSetName
+		}
+	}
+	if allDigits {
+		return ErrInvalidPinSetName
+	}
+	return nil
+}
+
+func getPinSet(tx bcstate.DB, id PinSetID) (*PinSet, error) {
+	rec, err := getRecord(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	ps := rec.toPinSet(id)
+	return &ps, nil
+}
+
+func getRecord(tx bcstate.DB, id PinSetID) (*pinSetRecord, error) {
+	rec := &pinSetRecord{}
+	err := tx.Bucket(bucketPinSets).GetF(idToKey(id), func(v []byte) error {
+		return json.Unmarshal(v, rec)
+	})
+	if err == bcstate.ErrNotExist {
+		return nil, ErrPinSetNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+func putRecord(tx bcstate.DB, id PinSetID, rec pinSetRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(bucketPinSets).Put(idToKey(id), data)
+}
+
+func (rec pinSetRecord) toPinSet(id PinSetID) PinSet {
+	return PinSet{
+		ID:       id,
+		Name:     rec.Name,
+		Root:     rec.Root,
+		Count:    rec.Count,
+		Replicas: rec.Replicas,
+	}
+}
+
+// trieStore stores trie nodes in the trie node bucket.
+// Posting a node clears any mark left by the garbage collector, since the node
+// may have been unreachable before, and is about to be referenced again.
+type trieStore struct {
+	blobs.Store
+	unpinned bcstate.KV
+}
+
+func newTrieStore(persistentTx bcstate.DB) trieStore {
+	return trieStore{
+		Store:    bcstate.BlobAdapter(persistentTx.Bucket(bucketTrieNodes)),
+		unpinned: persistentTx.Bucket(bucketGCUnpinned),
+	}
+}
+
+func (s trieStore) Post(ctx context.Context, data []byte) (blobs.ID, error) {
+	id, err := s.Store.Post(ctx, data)
+	if err != nil {
+		return blobs.ID{}, err
+	}
+	if err := s.unpinned.Delete(id[:]); err != nil {
+		return blobs.ID{}, err
+	}
+	return id, nil
+}
+
+func pinIncr(b bcstate.KV, id blobs.ID) error {
+	x, err := pinCount(b, id)
+	if err != nil {
+		return err
+	}
+	data := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(data, x+1)
+	return b.Put(id[:], data[:n])
+}
+
 func pinDecr(b bcstate.KV, id blobs.ID) error {
-	key := id[:]
-	return b.GetF(key, func(data []byte) error {
-		if data == nil {
-			return errors.New("can't decrement null")
-		}
-		x, _ := binary.Uvarint(data)
-		x--
-		if x == 0 {
-			return b.Delete(key)
-		}
-		data2 := make([]byte, 8)
-		n := binary.PutUvarint(data2, x)
-		return b.Put(key, data2[:n])
+	x, err := pinCount(b, id)
+	if err != nil {
+		return err
+	}
+	if x == 0 {
+		return errors.New("can't decrement null")
+	}
+	x--
+	if x == 0 {
+		return b.Delete(id[:])
+	}
+	data := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(data, x)
+	return b.Put(id[:], data[:n])
+}
+
+// pinCount returns the number of pinsets which contain id
+func pinCount(b bcstate.KV, id blobs.ID) (uint64, error) {
+	var x uint64
+	err := b.GetF(id[:], func(data []byte) error {
+		x, _ = binary.Uvarint(data)
+		return nil
 	})
+	if err == bcstate.ErrNotExist {
+		return 0, nil
+	}
+	return x, err
 }
 
 func idToBucket(id PinSetID) string {
@@ -0,0 +1,58 @@
+package blobcache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blobcache/blobcache/pkg/bcstate"
+	"github.com/blobcache/blobcache/pkg/blobs"
+	"github.com/blobcache/blobcache/pkg/tries"
+)
+
+func TestPinSetRoot(t *testing.T) {
+	ctx := context.TODO()
+	persistent := newTestBoltDB(t, "persistent.db", 0)
+	s := NewPinSetStore(persistent)
+	psID, err := s.Create(ctx, "pinset1")
+	require.NoError(t, err)
+	ps, err := s.Get(ctx, psID)
+	require.NoError(t, err)
+	emptyRoot := ps.Root
+
+	const N = 100
+	ids := make([]blobs.ID, N)
+	for i := range ids {
+		ids[i] = blobs.Hash([]byte(fmt.Sprintf("test-data-%d", i)))
+		require.NoError(t, s.Pin(ctx, psID, ids[i]))
+	}
+	// pinning twice has no effect
+	require.NoError(t, s.Pin(ctx, psID, ids[0]))
+
+	ps, err = s.Get(ctx, psID)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(N), ps.Count)
+	assert.NotEqual(t, emptyRoot.ID, ps.Root.ID)
+
+	// the trie is readable from the trie node bucket, and contains every pinned ID.
+	nodes := bcstate.BlobAdapter(persistent.Bucket(bucketTrieNodes))
+	var actual []blobs.ID
+	require.NoError(t, tries.ForEach(ctx, nodes, ps.Root, nil, nil, func(k, _ []byte) error {
+		actual = append(actual, blobs.IDFromBytes(k))
+		return nil
+	}))
+	assert.ElementsMatch(t, ids, actual)
+
+	// unpinning everything returns to the empty root
+	for _, id := range ids {
+		require.NoError(t, s.Unpin(ctx, psID, id))
+	}
+	require.NoError(t, s.Unpin(ctx, psID, ids[0]))
+	ps, err = s.Get(ctx, psID)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), ps.Count)
+	assert.Equal(t, emptyRoot.ID, ps.Root.ID)
+}
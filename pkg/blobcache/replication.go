@@ -0,0 +1,527 @@
+package blobcache
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/brendoncarroll/go-p2p"
+	"github.com/brendoncarroll/go-p2p/p/kademlia"
+	"github.com/jonboulle/clockwork"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/blobcache/blobcache/pkg/bcstate"
+	"github.com/blobcache/blobcache/pkg/blobnet"
+	"github.com/blobcache/blobcache/pkg/blobnet/peers"
+	"github.com/blobcache/blobcache/pkg/blobs"
+)
+
+const (
+	prefixReplication = "replication"
+	bucketHolders     = "holders"
+	bucketSpilled     = "spilled"
+
+	DefaultReplicationPeriod = 10 * time.Minute
+	// HolderGracePeriod is how long a trusted holder can be missing from the one hop peers before it is forgotten.
+	HolderGracePeriod = 30 * time.Minute
+)
+
+// ReplicationNetwork is how the Replicator reaches peers
+type ReplicationNetwork interface {
+	OneHop() []p2p.PeerID
+	StoreOn(ctx context.Context, peer p2p.PeerID, data []byte) error
+	ReleaseOn(ctx context.Context, peer p2p.PeerID, ids []blobs.ID) error
+	CheckOn(ctx context.Context, peer p2p.PeerID, ids []blobs.ID) ([]bool, error)
+}
+
+type ReplicatorParams struct {
+	Persistent bcstate.TxDB
+	PinSets    *PinSetStore
+	Local      blobs.Getter
+	Network    ReplicationNetwork
+	PeerStore  peers.PeerStore
+	Clock      clockwork.Clock
+
+	// Period is the time between passes when running in the background.
+	Period time.Duration
+}
+
+// ReplicationResult summarizes a single pass
+type ReplicationResult struct {
+	Checked    int `json:"checked"`
+	Replicated int `json:"replicated"`
+	Failed     int `json:"failed"`
+	Dropped    int `json:"dropped"`
+	Released   int `json:"released"`
+}
+
+// Replicator ensures that blobs in pinsets with a replica count are held by that many trusted peers.
+// Peers which hold a blob are recorded in the persistent database, in one transaction per pass.
+// If a holder is no longer trusted, or no longer has the blob, it is forgotten, and the blob is copied to
+// another peer. A holder which is no longer a one hop peer is forgotten once it has been missing for
+// HolderGracePeriod, so peers which briefly disconnect don't cause blobs to be copied.
+// Holders of blobs which no longer need as many replicas are asked to release them.
+type Replicator struct {
+	db        bcstate.TxDB
+	pinSets   *PinSetStore
+	local     blobs.Getter
+	network   ReplicationNetwork
+	peerStore peers.PeerStore
+	clock     clockwork.Clock
+	period    time.Duration
+
+	trigger chan struct{}
+
+	mu sync.Mutex
+	// missing is when each holder which is not a one hop peer was first noticed missing.
+	missing map[p2p.PeerID]time.Time
+}
+
+func NewReplicator(params ReplicatorParams) *Replicator {
+	period := params.Period
+	if period == 0 {
+		period = DefaultReplicationPeriod
+	}
+	clock := params.Clock
+	if clock == nil {
+		clock = clockwork.NewRealClock()
+	}
+	return &Replicator{
+		db:        bcstate.PrefixedTxDB{TxDB: params.Persistent, Prefix: prefixReplication},
+		pinSets:   params.PinSets,
+		local:     params.Local,
+		network:   params.Network,
+		peerStore: params.PeerStore,
+		clock:     clock,
+		period:    period,
+		trigger:   make(chan struct{}, 1),
+		missing:   make(map[p2p.PeerID]time.Time),
+	}
+}
+
+func (r *Replicator) run(ctx context.Context) {
+	ticker := r.clock.NewTicker(r.period)
+	defer ticker.Stop()
+	log.Info("starting replicator")
+	defer func() { log.Info("stopped replicator") }()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.Chan():
+		case <-r.trigger:
+		}
+		if _, err := r.Replicate(ctx); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// Trigger causes a pass to run soon, if the Replicator is running in the background.
+func (r *Replicator) Trigger() {
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Replicate performs a single pass over all the pinsets with a replica count.
+// Holders are checked first, so blobs they no longer have are replicated again, then blobs with
+// more holders than they need are released.
+func (r *Replicator) Replicate(ctx context.Context) (*ReplicationResult, error) {
+	var w writes
+	targets, err := r.targets(ctx, &w)
+	if err != nil {
+		return nil, err
+	}
+	candidates := r.candidates()
+	held, err := r.allHolders(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := &ReplicationResult{}
+	r.verify(ctx, held, candidates, &w, res)
+	r.release(ctx, held, targets, &w, res)
+	for id, target := range targets {
+		r.replicateBlob(ctx, id, target, held[id], candidates, &w, res)
+	}
+	if len(w) > 0 {
+		if err := r.db.WriteTx(ctx, w.write); err != nil {
+			return nil, err
+		}
+	}
+	if res.Replicated > 0 || res.Failed > 0 || res.Dropped > 0 || res.Released > 0 {
+		log.WithFields(log.Fields{
+			"checked":    res.Checked,
+			"replicated": res.Replicated,
+			"failed":     res.Failed,
+			"dropped":    res.Dropped,
+			"released":   res.Released,
+		}).Info("replicated blobs")
+	}
+	return res, nil
+}
+
+// Spill stores data on the closest trusted peer which will accept it, for when there is no space locally.
+// The blob is kept on at least one peer for as long as it is pinned.
+// It returns bcstate.ErrFull if no peer accepts it.
+func (r *Replicator) Spill(ctx context.Context, data []byte) error {
+	id := blobs.Hash(data)
+	var peerIDs []p2p.PeerID
+	for peer := range r.candidates() {
+		peerIDs = append(peerIDs, peer)
+	}
+	sortByDistance(id, peerIDs)
+	for _, peer := range peerIDs {
+		if err := r.network.StoreOn(ctx, peer, data); err != nil {
+			log.WithFields(log.Fields{
+				"blob_id": id,
+				"peer_id": peer,
+			}).Warn("failed to spill blob: ", err)
+			continue
+		}
+		now := unixTimeBytes(r.clock.Now())
+		return r.db.WriteTx(ctx, func(tx bcstate.DB) error {
+			if err := tx.Bucket(bucketHolders).Put(holderKey(id, peer), now); err != nil {
+				return err
+			}
+			return tx.Bucket(bucketSpilled).Put(id[:], now)
+		})
+	}
+	return bcstate.ErrFull
+}
+
+// Holders returns the peers which are known to hold a copy of id
+func (r *Replicator) Holders(ctx context.Context, id blobs.ID) ([]p2p.PeerID, error) {
+	var holders []p2p.PeerID
+	err := r.db.ReadTx(ctx, func(tx bcstate.DB) error {
+		var err error
+		holders, err = getHolders(tx, id)
+		return err
+	})
+	return holders, err
+}
+
+// targets returns the number of replicas required for each blob,
+// which is the largest replica count of any pinset containing it.
+// Pinned blobs which were spilled need at least one replica, since there is no local copy.
+// Spilled blobs which are no longer pinned are forgotten in w.
+func (r *Replicator) targets(ctx context.Context, w *writes) (map[blobs.ID]int, error) {
+	pinSets, err := r.pinSets.ListPinSets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	targets := make(map[blobs.ID]int)
+	for _, ps := range pinSets {
+		if ps.Replicas < 1 {
+			continue
+		}
+		ids, err := r.pinSets.pinned(ctx, ps.ID)
+		if err == ErrPinSetNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			if targets[id] < ps.Replicas {
+				targets[id] = ps.Replicas
+			}
+		}
+	}
+
+	var spilled []blobs.ID
+	if err := r.db.ReadTx(ctx, func(tx bcstate.DB) error {
+		spilled = nil
+		return tx.Bucket(bucketSpilled).ForEach(nil, nil, func(k, _ []byte) error {
+			spilled = append(spilled, blobs.IDFromBytes(k))
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+	for _, id := range spilled {
+		pinned, err := r.pinSets.isPinned(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if !pinned {
+			w.delete(bucketSpilled, id[:])
+			continue
+		}
+		if targets[id] < 1 {
+			targets[id] = 1
+		}
+	}
+	return targets, nil
+}
+
+// candidates returns the one hop peers which are trusted to hold blobs
+func (r *Replicator) candidates() map[p2p.PeerID]struct{} {
+	candidates := make(map[p2p.PeerID]struct{})
+	for _, peer := range r.network.OneHop() {
+		trust, err := r.peerStore.TrustFor(peer)
+		if err != nil || trust <= 0 {
+			continue
+		}
+		candidates[peer] = struct{}{}
+	}
+	return candidates
+}
+
+// allHolders returns every recorded holder, by blob
+func (r *Replicator) allHolders(ctx context.Context) (map[blobs.ID][]p2p.PeerID, error) {
+	var held map[blobs.ID][]p2p.PeerID
+	err := r.db.ReadTx(ctx, func(tx bcstate.DB) error {
+		held = make(map[blobs.ID][]p2p.PeerID)
+		return tx.Bucket(bucketHolders).ForEach(nil, nil, func(k, _ []byte) error {
+			id, peer := splitHolderKey(k)
+			held[id] = append(held[id], peer)
+			return nil
+		})
+	})
+	return held, err
+}
+
+// verify forgets holders which are no longer candidates, or which no longer have the blob.
+// Trusted holders which are missing from the one hop peers are kept until HolderGracePeriod has passed.
+// If a holder can't be asked, it is assumed to still have its blobs.
+func (r *Replicator) verify(ctx context.Context, held map[blobs.ID][]p2p.PeerID, candidates map[p2p.PeerID]struct{}, w *writes, res *ReplicationResult) {
+	missing := r.updateMissing(held, candidates)
+	byPeer := make(map[p2p.PeerID][]blobs.ID)
+	for id, holders := range held {
+		for _, peer := range holders {
+			if _, ok := candidates[peer]; !ok {
+				if since, ok := missing[peer]; ok && r.clock.Since(since) < HolderGracePeriod {
+					continue
+				}
+				r.dropHolder(held, id, peer, w)
+				res.Dropped++
+				continue
+			}
+			byPeer[peer] = append(byPeer[peer], id)
+		}
+	}
+	for peer, ids := range byPeer {
+		for len(ids) > 0 {
+			n := len(ids)
+			if n > blobnet.MaxStorageIDs {
+				n = blobnet.MaxStorageIDs
+			}
+			batch := ids[:n]
+			ids = ids[n:]
+			isHeld, err := r.network.CheckOn(ctx, peer, batch)
+			if err != nil {
+				log.WithField("peer_id", peer).Warn("failed to check blobs on peer: ", err)
+				break
+			}
+			for i, id := range batch {
+				if isHeld[i] {
+					continue
+				}
+				r.dropHolder(held, id, peer, w)
+				res.Dropped++
+			}
+		}
+	}
+}
+
+// updateMissing records when each trusted holder which is not a candidate was first missing, and returns the record.
+// Holders which are candidates again, or no longer hold anything, are removed from it.
+func (r *Replicator) updateMissing(held map[blobs.ID][]p2p.PeerID, candidates map[p2p.PeerID]struct{}) map[p2p.PeerID]time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.clock.Now()
+	missing := make(map[p2p.PeerID]time.Time)
+	for _, holders := range held {
+		for _, peer := range holders {
+			if _, ok := candidates[peer]; ok {
+				continue
+			}
+			if _, ok := missing[peer]; ok {
+				continue
+			}
+			if trust, err := r.peerStore.TrustFor(peer); err != nil || trust <= 0 {
+				continue
+			}
+			since, ok := r.missing[peer]
+			if !ok {
+				since = now
+			}
+			missing[peer] = since
+		}
+	}
+	r.missing = missing
+	return missing
+}
+
+// release tells the farthest holders of blobs which have more replicas than they need to release them.
+func (r *Replicator) release(ctx context.Context, held map[blobs.ID][]p2p.PeerID, targets map[blobs.ID]int, w *writes, res *ReplicationResult) {
+	byPeer := make(map[p2p.PeerID][]blobs.ID)
+	for id, holders := range held {
+		target := targets[id]
+		if len(holders) <= target {
+			continue
+		}
+		holders = append([]p2p.PeerID{}, holders...)
+		sortByDistance(id, holders)
+		for _, peer := range holders[target:] {
+			byPeer[peer] = append(byPeer[peer], id)
+		}
+	}
+	for peer, ids := range byPeer {
+		for len(ids) > 0 {
+			n := len(ids)
+			if n > blobnet.MaxStorageIDs {
+				n = blobnet.MaxStorageIDs
+			}
+			batch := ids[:n]
+			ids = ids[n:]
+			if err := r.network.ReleaseOn(ctx, peer, batch); err != nil {
+				log.WithField("peer_id", peer).Warn("failed to release blobs on peer: ", err)
+				break
+			}
+			for _, id := range batch {
+				r.dropHolder(held, id, peer, w)
+				res.Released++
+			}
+		}
+	}
+}
+
+// dropHolder forgets that peer holds id, in held and in w.
+// held[id] is replaced rather than modified, since callers may be ranging over it.
+func (r *Replicator) dropHolder(held map[blobs.ID][]p2p.PeerID, id blobs.ID, peer p2p.PeerID, w *writes) {
+	w.delete(bucketHolders, holderKey(id, peer))
+	var holders []p2p.PeerID
+	for _, p := range held[id] {
+		if p != peer {
+			holders = append(holders, p)
+		}
+	}
+	if len(holders) == 0 {
+		delete(held, id)
+	} else {
+		held[id] = holders
+	}
+}
+
+// replicateBlob stores id on candidates until it has target holders, and records the new holders in w.
+func (r *Replicator) replicateBlob(ctx context.Context, id blobs.ID, target int, holders []p2p.PeerID, candidates map[p2p.PeerID]struct{}, w *writes, res *ReplicationResult) {
+	res.Checked++
+	count := len(holders)
+	if count >= target {
+		return
+	}
+	isHolder := make(map[p2p.PeerID]struct{}, len(holders))
+	for _, peer := range holders {
+		isHolder[peer] = struct{}{}
+	}
+
+	// prefer the peers closest to the blob, so replicas are spread evenly.
+	var others []p2p.PeerID
+	for peer := range candidates {
+		if _, ok := isHolder[peer]; !ok {
+			others = append(others, peer)
+		}
+	}
+	sortByDistance(id, others)
+
+	var data []byte
+	if err := r.local.GetF(ctx, id, func(x []byte) error {
+		data = append([]byte{}, x...)
+		return nil
+	}); err != nil {
+		log.WithField("blob_id", id).Warn("can't replicate blob: ", err)
+		res.Failed++
+		return
+	}
+	for _, peer := range others {
+		if count >= target {
+			break
+		}
+		if err := r.network.StoreOn(ctx, peer, data); err != nil {
+			log.WithFields(log.Fields{
+				"blob_id": id,
+				"peer_id": peer,
+			}).Warn("failed to replicate blob: ", err)
+			continue
+		}
+		w.put(bucketHolders, holderKey(id, peer), unixTimeBytes(r.clock.Now()))
+		res.Replicated++
+		count++
+	}
+	if count < target {
+		res.Failed++
+	}
+}
+
+// writes are made to the replication state during a pass, and written in order, in one transaction, at the end of it.
+type writes []writeOp
+
+type writeOp struct {
+	bucket string
+	key    []byte
+	// value is nil for deletes
+	value []byte
+}
+
+func (w *writes) put(bucket string, key, value []byte) {
+	*w = append(*w, writeOp{bucket: bucket, key: key, value: value})
+}
+
+func (w *writes) delete(bucket string, key []byte) {
+	*w = append(*w, writeOp{bucket: bucket, key: key})
+}
+
+func (w writes) write(tx bcstate.DB) error {
+	for _, op := range w {
+		kv := tx.Bucket(op.bucket)
+		var err error
+		if op.value == nil {
+			err = kv.Delete(op.key)
+		} else {
+			err = kv.Put(op.key, op.value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getHolders(tx bcstate.DB, id blobs.ID) ([]p2p.PeerID, error) {
+	var holders []p2p.PeerID
+	err := tx.Bucket(bucketHolders).ForEach(id[:], bcstate.PrefixEnd(id[:]), func(k, _ []byte) error {
+		peer := p2p.PeerID{}
+		copy(peer[:], k[len(id):])
+		holders = append(holders, peer)
+		return nil
+	})
+	return holders, err
+}
+
+func holderKey(id blobs.ID, peer p2p.PeerID) []byte {
+	return append(append([]byte{}, id[:]...), peer[:]...)
+}
+
+func splitHolderKey(k []byte) (blobs.ID, p2p.PeerID) {
+	id := blobs.IDFromBytes(k[:len(blobs.ID{})])
+	peer := p2p.PeerID{}
+	copy(peer[:], k[len(id):])
+	return id, peer
+}
+
+func sortByDistance(id blobs.ID, peerIDs []p2p.PeerID) {
+	dist := func(peer p2p.PeerID) []byte {
+		d := make([]byte, len(peer))
+		kademlia.XORBytes(d, id[:], peer[:])
+		return d
+	}
+	sort.Slice(peerIDs, func(i, j int) bool {
+		return bytes.Compare(dist(peerIDs[i]), dist(peerIDs[j])) < 0
+	})
+}
@@ -0,0 +1,480 @@
+package blobcache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/brendoncarroll/go-p2p"
+	"github.com/brendoncarroll/go-p2p/p/dynmux"
+	"github.com/brendoncarroll/go-p2p/p2ptest"
+	"github.com/brendoncarroll/go-p2p/s/memswarm"
+	"github.com/jonboulle/clockwork"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blobcache/blobcache/pkg/bcstate"
+	"github.com/blobcache/blobcache/pkg/blobnet/peers"
+	"github.com/blobcache/blobcache/pkg/blobs"
+	"github.com/blobcache/blobcache/pkg/eviction"
+)
+
+func TestReplicate(t *testing.T) {
+	ctx := context.TODO()
+	persistent := &countingTxDB{TxDB: newTestBoltDB(t, "persistent.db", 0)}
+	pinSets := NewPinSetStore(persistent)
+	local := blobs.NewMem()
+
+	net := &fakeNetwork{stored: make(map[p2p.PeerID]map[blobs.ID]struct{})}
+	trust := fakeTrust{}
+	for i := 0; i < 4; i++ {
+		peer := p2p.NewPeerID(p2ptest.NewTestKey(t, i).Public())
+		net.peers = append(net.peers, peer)
+		// the last peer is not trusted
+		if i < 3 {
+			trust[peer] = 1
+		}
+	}
+	untrusted := net.peers[3]
+	clock := clockwork.NewFakeClock()
+	rep := NewReplicator(ReplicatorParams{
+		Persistent: persistent,
+		PinSets:    pinSets,
+		Local:      local,
+		Network:    net,
+		PeerStore:  trust,
+		Clock:      clock,
+	})
+
+	psID, err := pinSets.Create(ctx, "pinset1")
+	require.NoError(t, err)
+	const N = 10
+	ids := make([]blobs.ID, N)
+	for i := range ids {
+		ids[i], err = local.Post(ctx, []byte(fmt.Sprintf("test-data-%d", i)))
+		require.NoError(t, err)
+		require.NoError(t, pinSets.Pin(ctx, psID, ids[i]))
+	}
+
+	// no replicas requested
+	res, err := rep.Replicate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.Checked)
+
+	require.NoError(t, pinSets.SetReplicas(ctx, psID, 2))
+	persistent.count()
+	res, err = rep.Replicate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, N, res.Checked)
+	assert.Equal(t, 2*N, res.Replicated)
+	// the new holders are all written at once
+	assert.Equal(t, 1, persistent.count())
+	for _, id := range ids {
+		holders, err := rep.Holders(ctx, id)
+		require.NoError(t, err)
+		require.Len(t, holders, 2)
+		for _, peer := range holders {
+			assert.True(t, net.has(peer, id))
+		}
+		assert.False(t, net.has(untrusted, id))
+	}
+
+	// nothing to do
+	res, err = rep.Replicate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.Replicated)
+
+	// a holder disconnects briefly, which changes nothing.
+	gone := net.peers[0]
+	net.peers = net.peers[1:]
+	res, err = rep.Replicate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.Dropped)
+	assert.Equal(t, 0, res.Replicated)
+	clock.Advance(HolderGracePeriod - time.Minute)
+	net.peers = append([]p2p.PeerID{gone}, net.peers...)
+	res, err = rep.Replicate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.Dropped)
+
+	// the grace period starts again the next time it goes away.
+	net.peers = net.peers[1:]
+	res, err = rep.Replicate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.Dropped)
+	clock.Advance(HolderGracePeriod - time.Minute)
+	res, err = rep.Replicate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.Dropped)
+
+	// once the holder has been gone for the grace period, its blobs are copied to the remaining trusted peer.
+	clock.Advance(time.Minute)
+	res, err = rep.Replicate(ctx)
+	require.NoError(t, err)
+	assert.NotZero(t, res.Dropped)
+	assert.Equal(t, res.Dropped, res.Replicated)
+	for _, id := range ids {
+		holders, err := rep.Holders(ctx, id)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []p2p.PeerID{net.peers[0], net.peers[1]}, holders)
+		assert.NotContains(t, holders, gone)
+	}
+
+	// a holder loses a blob, so it is copied again
+	lost := net.peers[0]
+	net.forget(lost, ids[0])
+	res, err = rep.Replicate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Dropped)
+	assert.Equal(t, 1, res.Replicated)
+	assert.True(t, net.has(lost, ids[0]))
+
+	// only 2 trusted peers remain
+	require.NoError(t, pinSets.SetReplicas(ctx, psID, 3))
+	res, err = rep.Replicate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, N, res.Failed)
+
+	// fewer replicas are needed, so the farthest holders release them
+	require.NoError(t, pinSets.SetReplicas(ctx, psID, 1))
+	res, err = rep.Replicate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, N, res.Released)
+	for _, id := range ids {
+		holders, err := rep.Holders(ctx, id)
+		require.NoError(t, err)
+		require.Len(t, holders, 1)
+		count := 0
+		for _, peer := range net.peers {
+			if net.has(peer, id) {
+				count++
+			}
+		}
+		assert.Equal(t, 1, count)
+	}
+}
+
+func TestReplicateDropAdjacent(t *testing.T) {
+	ctx := context.TODO()
+	persistent := newTestBoltDB(t, "persistent.db", 0)
+	pinSets := NewPinSetStore(persistent)
+	local := blobs.NewMem()
+	net := &fakeNetwork{stored: make(map[p2p.PeerID]map[blobs.ID]struct{})}
+	trust := fakeTrust{}
+	for i := 0; i < 3; i++ {
+		peer := p2p.NewPeerID(p2ptest.NewTestKey(t, i).Public())
+		net.peers = append(net.peers, peer)
+		trust[peer] = 1
+	}
+	// holders are listed in order of their IDs
+	sort.Slice(net.peers, func(i, j int) bool {
+		return bytes.Compare(net.peers[i][:], net.peers[j][:]) < 0
+	})
+	clock := clockwork.NewFakeClock()
+	rep := NewReplicator(ReplicatorParams{
+		Persistent: persistent,
+		PinSets:    pinSets,
+		Local:      local,
+		Network:    net,
+		PeerStore:  trust,
+		Clock:      clock,
+	})
+	psID, err := pinSets.Create(ctx, "pinset1")
+	require.NoError(t, err)
+	id, err := local.Post(ctx, []byte("test-data"))
+	require.NoError(t, err)
+	require.NoError(t, pinSets.Pin(ctx, psID, id))
+	require.NoError(t, pinSets.SetReplicas(ctx, psID, 3))
+	res, err := rep.Replicate(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 3, res.Replicated)
+
+	// the first two holders go away, and are dropped together once the grace period is over.
+	remaining := net.peers[2]
+	net.peers = net.peers[2:]
+	res, err = rep.Replicate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.Dropped)
+	clock.Advance(HolderGracePeriod)
+	res, err = rep.Replicate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, res.Dropped)
+	holders, err := rep.Holders(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, []p2p.PeerID{remaining}, holders)
+
+	// the remaining holder is checked once
+	res, err = rep.Replicate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.Dropped)
+
+	// a holder which is no longer trusted is dropped right away
+	delete(trust, remaining)
+	res, err = rep.Replicate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Dropped)
+}
+
+func TestSpill(t *testing.T) {
+	ctx := context.TODO()
+	persistent := newTestBoltDB(t, "persistent.db", 0)
+	pinSets := NewPinSetStore(persistent)
+	net := &fakeNetwork{stored: make(map[p2p.PeerID]map[blobs.ID]struct{})}
+	trust := fakeTrust{}
+	rep := NewReplicator(ReplicatorParams{
+		Persistent: persistent,
+		PinSets:    pinSets,
+		Local:      blobs.NewMem(),
+		Network:    net,
+		PeerStore:  trust,
+		Clock:      clockwork.NewFakeClock(),
+	})
+	psID, err := pinSets.Create(ctx, "pinset1")
+	require.NoError(t, err)
+	data := []byte("test-data")
+	id := blobs.Hash(data)
+	require.NoError(t, pinSets.Pin(ctx, psID, id))
+
+	// no trusted peers
+	assert.Equal(t, bcstate.ErrFull, rep.Spill(ctx, data))
+
+	peer := p2p.NewPeerID(p2ptest.NewTestKey(t, 0).Public())
+	net.peers = append(net.peers, peer)
+	trust[peer] = 1
+	require.NoError(t, rep.Spill(ctx, data))
+	assert.True(t, net.has(peer, id))
+
+	// spilled blobs are kept while they are pinned, even without a replica count
+	res, err := rep.Replicate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.Released)
+	assert.True(t, net.has(peer, id))
+
+	require.NoError(t, pinSets.Unpin(ctx, psID, id))
+	res, err = rep.Replicate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Released)
+	assert.False(t, net.has(peer, id))
+}
+
+// countingTxDB counts write transactions.
+type countingTxDB struct {
+	bcstate.TxDB
+	writes int32
+}
+
+func (db *countingTxDB) WriteTx(ctx context.Context, fn func(bcstate.DB) error) error {
+	atomic.AddInt32(&db.writes, 1)
+	return db.TxDB.WriteTx(ctx, fn)
+}
+
+// count returns the number of write transactions since it was last called.
+func (db *countingTxDB) count() int {
+	return int(atomic.SwapInt32(&db.writes, 0))
+}
+
+type fakeNetwork struct {
+	mu     sync.Mutex
+	peers  []p2p.PeerID
+	stored map[p2p.PeerID]map[blobs.ID]struct{}
+}
+
+func (n *fakeNetwork) OneHop() []p2p.PeerID {
+	return n.peers
+}
+
+func (n *fakeNetwork) StoreOn(ctx context.Context, peer p2p.PeerID, data []byte) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, p := range n.peers {
+		if p == peer {
+			if n.stored[peer] == nil {
+				n.stored[peer] = make(map[blobs.ID]struct{})
+			}
+			n.stored[peer][blobs.Hash(data)] = struct{}{}
+			return nil
+		}
+	}
+	return errors.New("peer unreachable")
+}
+
+func (n *fakeNetwork) ReleaseOn(ctx context.Context, peer p2p.PeerID, ids []blobs.ID) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, id := range ids {
+		delete(n.stored[peer], id)
+	}
+	return nil
+}
+
+func (n *fakeNetwork) CheckOn(ctx context.Context, peer p2p.PeerID, ids []blobs.ID) ([]bool, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	held := make([]bool, len(ids))
+	for i, id := range ids {
+		_, held[i] = n.stored[peer][id]
+	}
+	return held, nil
+}
+
+func (n *fakeNetwork) forget(peer p2p.PeerID, id blobs.ID) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.stored[peer], id)
+}
+
+func (n *fakeNetwork) has(peer p2p.PeerID, id blobs.ID) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	_, ok := n.stored[peer][id]
+	return ok
+}
+
+type fakeTrust map[p2p.PeerID]int64
+
+func (ft fakeTrust) TrustFor(id p2p.PeerID) (int64, error) {
+	return ft[id], nil
+}
+
+func (ft fakeTrust) ListPeers() []p2p.PeerID {
+	return nil
+}
+
+func (ft fakeTrust) GetAddrs(id p2p.PeerID) []p2p.Addr {
+	return nil
+}
+
+func TestPeerStorage(t *testing.T) {
+	ctx := context.TODO()
+	persistent := newTestBoltDB(t, "persistent.db", 0)
+	cache, err := eviction.New(eviction.Params{
+		KV:       &bcstate.MemKV{Capacity: 1024},
+		Capacity: 1024,
+		Clock:    clockwork.NewFakeClock(),
+	})
+	require.NoError(t, err)
+	ps := newPeerStorage(persistent, 0, cache, 16)
+	peer := p2p.NewPeerID(p2ptest.NewTestKey(t, 0).Public())
+	data := []byte("test-data")
+	id := blobs.Hash(data)
+	require.NoError(t, ps.StoreFor(ctx, peer, data, true))
+	require.NoError(t, ps.StoreFor(ctx, peer, data, true))
+
+	// blobs stored for peers count as a single pin, so they aren't collected
+	require.NoError(t, persistent.ReadTx(ctx, func(tx bcstate.DB) error {
+		count, err := pinCount(refCounts(tx), id)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), count)
+		return nil
+	}))
+	usage, err := ps.Usage(ctx, peer)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(len(data)), usage)
+
+	// over quota
+	data2 := []byte("test-data-2")
+	assert.Equal(t, bcstate.ErrFull, ps.StoreFor(ctx, peer, data2, true))
+	// cached blobs don't count towards the quota
+	require.NoError(t, ps.StoreFor(ctx, peer, data2, false))
+	exists, err := cache.Exists(ctx, blobs.Hash(data2))
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	held, err := ps.HeldFor(ctx, peer, []blobs.ID{id, blobs.Hash(data2)})
+	require.NoError(t, err)
+	assert.Equal(t, []bool{true, false}, held)
+
+	require.NoError(t, ps.ReleaseFor(ctx, peer, []blobs.ID{id}))
+	held, err = ps.HeldFor(ctx, peer, []blobs.ID{id})
+	require.NoError(t, err)
+	assert.Equal(t, []bool{false}, held)
+	usage, err = ps.Usage(ctx, peer)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), usage)
+	require.NoError(t, persistent.ReadTx(ctx, func(tx bcstate.DB) error {
+		count, err := pinCount(refCounts(tx), id)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(0), count)
+		return nil
+	}))
+	require.NoError(t, ps.StoreFor(ctx, peer, data2, true))
+}
+
+func TestPostSpillWhenFull(t *testing.T) {
+	ctx := context.TODO()
+	realm := memswarm.NewRealm()
+	keys := []p2p.PrivateKey{p2ptest.NewTestKey(t, 0), p2ptest.NewTestKey(t, 1)}
+	swarms := make([]*memswarm.Swarm, len(keys))
+	for i := range keys {
+		swarms[i] = realm.NewSwarmWithKey(keys[i])
+	}
+	// the first node only has room for a single blob, and trusts the second to hold the rest.
+	const capacity = 100
+	nodes := make([]*Node, len(keys))
+	for i := range nodes {
+		other := swarms[1-i]
+		peerStore := trustedPeerStore{peers.MemPeerStore{}}
+		peerStore.AddAddr(p2p.NewPeerID(other.PublicKey()), other.LocalAddrs()[0])
+		params := Params{
+			Ephemeral:  newTestBoltDB(t, "ephemeral.db", 0),
+			Persistent: newTestBoltDB(t, "persistent.db", 0),
+			Mux:        dynmux.MultiplexSwarm(swarms[i]),
+			PrivateKey: keys[i],
+			PeerStore:  peerStore,
+		}
+		if i == 0 {
+			params.PersistentCapacity = capacity
+		}
+		node, err := NewNode(params)
+		require.NoError(t, err)
+		nodes[i] = node
+		t.Cleanup(func() { nodes[i].Shutdown() })
+	}
+	node := nodes[0]
+	require.Eventually(t, func() bool {
+		return len(node.bn.OneHop()) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	psID, err := node.CreatePinSet(ctx, "pinset1")
+	require.NoError(t, err)
+	var ids []blobs.ID
+	for i := 0; i < 4; i++ {
+		data := bytes.Repeat([]byte{byte(i)}, capacity/2)
+		id, err := node.Post(ctx, psID, data)
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	used := node.persistent.Bucket(bucketBlobs).SizeUsed()
+	assert.True(t, used <= capacity, "persistent blobs use %d bytes", used)
+
+	// every post is pinned, and the ones which did not fit are held by the other node.
+	ps, err := node.GetPinSet(ctx, psID)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(len(ids)), ps.Count)
+	spilled := 0
+	for _, id := range ids {
+		holders, err := node.rep.Holders(ctx, id)
+		require.NoError(t, err)
+		spilled += len(holders)
+		require.NoError(t, node.GetF(ctx, id, func([]byte) error { return nil }))
+	}
+	assert.Equal(t, len(ids)-1, spilled)
+}
+
+// trustedPeerStore trusts every peer it has addresses for, with up to a megabyte of debt.
+type trustedPeerStore struct {
+	peers.MemPeerStore
+}
+
+func (ps trustedPeerStore) TrustFor(id p2p.PeerID) (int64, error) {
+	if len(ps.GetAddrs(id)) == 0 {
+		return 0, nil
+	}
+	return 1 << 20, nil
+}
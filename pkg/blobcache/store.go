@@ -37,3 +37,27 @@ func (s *store) Exists(ctx context.Context, id blobs.ID) (bool, error) {
 func (s *store) List(ctx context.Context, prefix []byte, ids []blobs.ID) (n int, err error) {
 	return s.bc.List(ctx, s.pinSetID, prefix, ids)
 }
+
+type getter struct {
+	bc API
+}
+
+// NewGetter returns a blobs.Getter which can read any blob through bc, regardless of pinset.
+func NewGetter(bc API) blobs.Getter {
+	return getter{bc: bc}
+}
+
+func (g getter) GetF(ctx context.Context, id blobs.ID, fn func([]byte) error) error {
+	return g.bc.GetF(ctx, id, fn)
+}
+
+func (g getter) Exists(ctx context.Context, id blobs.ID) (bool, error) {
+	err := g.bc.GetF(ctx, id, func([]byte) error { return nil })
+	if err == blobs.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,97 @@
+package blobcachecmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+
+	"github.com/blobcache/blobcache/pkg/bchttp"
+	"github.com/blobcache/blobcache/pkg/blobcache"
+)
+
+var (
+	apiAddr    string
+	outputPath string
+	importName string
+)
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	exportCmd.Flags().StringVar(&apiAddr, "api", DefaultAPIAddr, "address of the blobcache API")
+	exportCmd.Flags().StringVarP(&outputPath, "output", "o", "", "file to write the archive to, defaults to stdout")
+
+	rootCmd.AddCommand(importCmd)
+	importCmd.Flags().StringVar(&apiAddr, "api", DefaultAPIAddr, "address of the blobcache API")
+	importCmd.Flags().StringVar(&importName, "name", "", "name for the new pinset, defaults to the name in the archive")
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export <pinset>",
+	Short: "writes a pinset, and all of its blobs, to an archive",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		client := newClient()
+		psID, err := resolvePinSet(ctx, client, args[0])
+		if err != nil {
+			return err
+		}
+		if outputPath == "" {
+			return client.Export(ctx, psID, cmd.OutOrStdout())
+		}
+		f, err := os.Create(outputPath)
+		if err != nil {
+			return err
+		}
+		if err := client.Export(ctx, psID, f); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	},
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import [archive]",
+	Short: "creates a pinset from an archive, read from stdin if no path is given",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		client := newClient()
+		var r io.Reader = cmd.InOrStdin()
+		if len(args) > 0 {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		psID, err := client.Import(ctx, importName, r)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(cmd.OutOrStdout(), psID)
+		return err
+	},
+}
+
+func newClient() *bchttp.Client {
+	return bchttp.NewClient("http://" + apiAddr)
+}
+
+// resolvePinSet returns the ID of a pinset referred to by ID or by name
+func resolvePinSet(ctx context.Context, api blobcache.API, x string) (blobcache.PinSetID, error) {
+	if id, err := strconv.ParseInt(x, 10, 64); err == nil {
+		return blobcache.PinSetID(id), nil
+	}
+	ps, err := api.GetPinSetByName(ctx, x)
+	if err != nil {
+		return 0, err
+	}
+	return ps.ID, nil
+}
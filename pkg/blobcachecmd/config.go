@@ -19,6 +19,7 @@ import (
 	"github.com/blobcache/blobcache/pkg/bcstate"
 	"github.com/blobcache/blobcache/pkg/blobcache"
 	"github.com/blobcache/blobcache/pkg/blobnet/peers"
+	"github.com/blobcache/blobcache/pkg/eviction"
 )
 
 const DefaultAPIAddr = "127.0.0.1:6025"
@@ -28,11 +29,22 @@ type Config struct {
 	PersistDir   string `yaml:"persist_dir"`
 	EphemeralDir string `yaml:"ephemeral_dir"`
 
-	INet256API    string           `yaml:"inet256_api"`
-	APIAddr       string           `yaml:"api_addr"`
-	EphemeralCap  string           `yaml:"ephemeral_capacity"`
+	INet256API   string `yaml:"inet256_api"`
+	APIAddr      string `yaml:"api_addr"`
+	EphemeralCap string `yaml:"ephemeral_capacity"`
+	// PersistentCap is how many bytes of blobs to persist locally.
+	// PinSets, trie nodes and routing state are not counted against it.
 	PersistentCap string           `yaml:"persistent_capacity"`
 	Peers         []peers.PeerSpec `yaml:"peers"`
+
+	// PeerQuota is how much each trusted peer can persist locally. Defaults to blobcache.DefaultPeerQuota
+	PeerQuota string `yaml:"peer_quota,omitempty"`
+
+	// EphemeralEviction is one of "lru", "lfu", or "kademlia"
+	EphemeralEviction string `yaml:"ephemeral_eviction"`
+
+	// SealMessages makes requests for blobs readable only by the peer they are for, not the peers forwarding them.
+	SealMessages bool `yaml:"seal_messages,omitempty"`
 }
 
 func (c *Config) Marshal() []byte {
@@ -72,6 +84,8 @@ func DefaultConfig() *Config {
 		EphemeralCap:  "10GB",
 		PersistentCap: "1GB",
 		Peers:         nil,
+
+		EphemeralEviction: "lru",
 	}
 }
 
@@ -86,6 +100,12 @@ func buildParams(configPath string, c Config) (*blobcache.Params, error) {
 	if err != nil {
 		return nil, errors.Errorf("invalid ephemeral_capacity (%s)", c.EphemeralCap)
 	}
+	var peerQuota int64
+	if c.PeerQuota != "" {
+		if peerQuota, err = units.FromHumanSize(c.PeerQuota); err != nil || peerQuota <= 0 {
+			return nil, errors.Errorf("invalid peer_quota (%s)", c.PeerQuota)
+		}
+	}
 
 	var persistDir, ephemeralDir string
 	if strings.HasPrefix(c.PersistDir, ".") {
@@ -98,11 +118,20 @@ func buildParams(configPath string, c Config) (*blobcache.Params, error) {
 	}
 	ephemeralPath := filepath.Join(ephemeralDir, "blobcache_ephemeral.db")
 
-	ephemeralDB, err := bolt.Open(ephemeralPath, 0666, nil)
+	ephemeralBolt, err := bolt.Open(ephemeralPath, 0666, nil)
+	if err != nil {
+		return nil, err
+	}
+	ephemeralDB, err := bcstate.OpenBoltDB(ephemeralBolt, uint64(ephemeralCap))
 	if err != nil {
 		return nil, err
 	}
-	persistDB, err := bolt.Open(persistPath, 0666, nil)
+	persistBolt, err := bolt.Open(persistPath, 0666, nil)
+	if err != nil {
+		return nil, err
+	}
+	// the node limits the persistent blobs itself, so pinsets can always be updated.
+	persistDB, err := bcstate.OpenBoltDB(persistBolt, 0)
 	if err != nil {
 		return nil, err
 	}
@@ -116,6 +145,13 @@ func buildParams(configPath string, c Config) (*blobcache.Params, error) {
 	if err != nil {
 		return nil, err
 	}
+	localID := p2p.NewPeerID(privKey.(p2p.PrivateKey).Public())
+
+	// Eviction
+	policy, err := eviction.ParsePolicy(c.EphemeralEviction, localID[:])
+	if err != nil {
+		return nil, err
+	}
 
 	// Peers
 	for i, peerSpec := range c.Peers {
@@ -127,8 +163,15 @@ func buildParams(configPath string, c Config) (*blobcache.Params, error) {
 	return &blobcache.Params{
 		PrivateKey: privKey.(p2p.PrivateKey),
 
-		Ephemeral:  bcstate.NewBoltDB(ephemeralDB, uint64(ephemeralCap)),
-		Persistent: bcstate.NewBoltDB(persistDB, uint64(persistCap)),
+		Ephemeral:  ephemeralDB,
+		Persistent: persistDB,
+
+		PersistentCapacity: uint64(persistCap),
+		EphemeralCapacity:  uint64(ephemeralCap),
+		EphemeralPolicy:    policy,
+
+		PeerQuota:    uint64(peerQuota),
+		SealMessages: c.SealMessages,
 	}, nil
 }
 
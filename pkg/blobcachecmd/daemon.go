@@ -25,15 +25,18 @@ type DaemonParams struct {
 	PeerStore       *peerStore
 }
 
-func NewDaemon(params DaemonParams) *Daemon {
-	node := blobcache.NewNode(params.BlobcacheParams)
+func NewDaemon(params DaemonParams) (*Daemon, error) {
+	node, err := blobcache.NewNode(params.BlobcacheParams)
+	if err != nil {
+		return nil, err
+	}
 	return &Daemon{
 		peerStore: params.PeerStore,
 
 		node:      node,
 		localID:   p2p.NewPeerID(params.BlobcacheParams.PrivateKey.Public()),
 		apiServer: bchttp.NewServer(node, params.APIAddr),
-	}
+	}, nil
 }
 
 func (d *Daemon) Run(ctx context.Context) error {
@@ -50,12 +50,15 @@ var runCmd = &cobra.Command{
 			return err
 		}
 		params.PeerStore = pstore
-		d := NewDaemon(DaemonParams{
+		d, err := NewDaemon(DaemonParams{
 			BlobcacheParams: *params,
 			APIAddr:         config.APIAddr,
 			PeerStore:       pstore,
 			Swarm:           swarm,
 		})
+		if err != nil {
+			return err
+		}
 		return d.Run(context.Background())
 	},
 }
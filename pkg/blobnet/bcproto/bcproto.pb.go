@@ -25,14 +25,122 @@ const (
 // of the legacy proto package is being used.
 const _ = proto.ProtoPackageIsVersion4
 
+// RouteError is returned in place of a response, when a message could not be forwarded.
+type RouteError int32
+
+const (
+	RouteError_ROUTE_OK RouteError = 0
+	// NO_ROUTE means a hop in the path was not a link of the node it was sent to.
+	RouteError_NO_ROUTE      RouteError = 1
+	RouteError_PATH_TOO_LONG RouteError = 2
+)
+
+// Enum value maps for RouteError.
+var (
+	RouteError_name = map[int32]string{
+		0: "ROUTE_OK",
+		1: "NO_ROUTE",
+		2: "PATH_TOO_LONG",
+	}
+	RouteError_value = map[string]int32{
+		"ROUTE_OK":      0,
+		"NO_ROUTE":      1,
+		"PATH_TOO_LONG": 2,
+	}
+)
+
+func (x RouteError) Enum() *RouteError {
+	p := new(RouteError)
+	*p = x
+	return p
+}
+
+func (x RouteError) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (RouteError) Descriptor() protoreflect.EnumDescriptor {
+	return file_bcproto_proto_enumTypes[0].Descriptor()
+}
+
+func (RouteError) Type() protoreflect.EnumType {
+	return &file_bcproto_proto_enumTypes[0]
+}
+
+func (x RouteError) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use RouteError.Descriptor instead.
+func (RouteError) EnumDescriptor() ([]byte, []int) {
+	return file_bcproto_proto_rawDescGZIP(), []int{0}
+}
+
+type StorageStatus int32
+
+const (
+	StorageStatus_STORAGE_UNKNOWN StorageStatus = 0
+	StorageStatus_STORAGE_OK      StorageStatus = 1
+	StorageStatus_STORAGE_REFUSED StorageStatus = 2
+	StorageStatus_STORAGE_FULL    StorageStatus = 3
+)
+
+// Enum value maps for StorageStatus.
+var (
+	StorageStatus_name = map[int32]string{
+		0: "STORAGE_UNKNOWN",
+		1: "STORAGE_OK",
+		2: "STORAGE_REFUSED",
+		3: "STORAGE_FULL",
+	}
+	StorageStatus_value = map[string]int32{
+		"STORAGE_UNKNOWN": 0,
+		"STORAGE_OK":      1,
+		"STORAGE_REFUSED": 2,
+		"STORAGE_FULL":    3,
+	}
+)
+
+func (x StorageStatus) Enum() *StorageStatus {
+	p := new(StorageStatus)
+	*p = x
+	return p
+}
+
+func (x StorageStatus) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (StorageStatus) Descriptor() protoreflect.EnumDescriptor {
+	return file_bcproto_proto_enumTypes[1].Descriptor()
+}
+
+func (StorageStatus) Type() protoreflect.EnumType {
+	return &file_bcproto_proto_enumTypes[1]
+}
+
+func (x StorageStatus) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use StorageStatus.Descriptor instead.
+func (StorageStatus) EnumDescriptor() ([]byte, []int) {
+	return file_bcproto_proto_rawDescGZIP(), []int{1}
+}
+
 // PeerRouting
 type RoutingTag struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	DstId []byte   `protobuf:"bytes,1,opt,name=dst_id,json=dstId,proto3" json:"dst_id,omitempty"`
-	Path  []uint64 `protobuf:"varint,2,rep,packed,name=path,proto3" json:"path,omitempty"`
+	DstId []byte `protobuf:"bytes,1,opt,name=dst_id,json=dstId,proto3" json:"dst_id,omitempty"`
+	// path is the link indices of the remaining hops, starting with the receiver's.
+	Path []uint64 `protobuf:"varint,2,rep,packed,name=path,proto3" json:"path,omitempty"`
+	// src_id is the peer which sent the message.
+	SrcId []byte `protobuf:"bytes,3,opt,name=src_id,json=srcId,proto3" json:"src_id,omitempty"`
+	// reverse_path is the path back to src_id from the sender, built up as the message is forwarded.
+	ReversePath []uint64 `protobuf:"varint,4,rep,packed,name=reverse_path,json=reversePath,proto3" json:"reverse_path,omitempty"`
 }
 
 func (x *RoutingTag) Reset() {
@@ -81,6 +189,20 @@ func (x *RoutingTag) GetPath() []uint64 {
 	return nil
 }
 
+func (x *RoutingTag) GetSrcId() []byte {
+	if x != nil {
+		return x.SrcId
+	}
+	return nil
+}
+
+func (x *RoutingTag) GetReversePath() []uint64 {
+	if x != nil {
+		return x.ReversePath
+	}
+	return nil
+}
+
 type ListPeersReq struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -133,8 +255,11 @@ type ListPeersRes struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	PeerId    []byte      `protobuf:"bytes,1,opt,name=peer_id,json=peerId,proto3" json:"peer_id,omitempty"`
-	PeerInfos []*PeerInfo `protobuf:"bytes,2,rep,name=peer_infos,json=peerInfos,proto3" json:"peer_infos,omitempty"`
+	PeerId     []byte      `protobuf:"bytes,1,opt,name=peer_id,json=peerId,proto3" json:"peer_id,omitempty"`
+	PeerInfos  []*PeerInfo `protobuf:"bytes,2,rep,name=peer_infos,json=peerInfos,proto3" json:"peer_infos,omitempty"`
+	RouteError RouteError  `protobuf:"varint,3,opt,name=route_error,json=routeError,proto3,enum=RouteError" json:"route_error,omitempty"`
+	// peer_key is the responder's key, so messages can be sealed to it.
+	PeerKey *PeerKey `protobuf:"bytes,4,opt,name=peer_key,json=peerKey,proto3" json:"peer_key,omitempty"`
 }
 
 func (x *ListPeersRes) Reset() {
@@ -183,6 +308,20 @@ func (x *ListPeersRes) GetPeerInfos() []*PeerInfo {
 	return nil
 }
 
+func (x *ListPeersRes) GetRouteError() RouteError {
+	if x != nil {
+		return x.RouteError
+	}
+	return RouteError_ROUTE_OK
+}
+
+func (x *ListPeersRes) GetPeerKey() *PeerKey {
+	if x != nil {
+		return x.PeerKey
+	}
+	return nil
+}
+
 type PeerInfo struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -190,6 +329,9 @@ type PeerInfo struct {
 
 	Id   []byte   `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
 	Path []uint64 `protobuf:"varint,2,rep,packed,name=path,proto3" json:"path,omitempty"`
+	// link_epoch changes when the sender's link indices are renumbered.
+	// Paths learned from the sender under another epoch are stale.
+	LinkEpoch uint64 `protobuf:"varint,3,opt,name=link_epoch,json=linkEpoch,proto3" json:"link_epoch,omitempty"`
 }
 
 func (x *PeerInfo) Reset() {
@@ -238,18 +380,27 @@ func (x *PeerInfo) GetPath() []uint64 {
 	return nil
 }
 
-// BlobRouting
-type ListBlobsReq struct {
+func (x *PeerInfo) GetLinkEpoch() uint64 {
+	if x != nil {
+		return x.LinkEpoch
+	}
+	return 0
+}
+
+// PeerKey is a peer's public key, and a key it can be sent sealed messages with.
+// The peer's ID is the hash of public_key, and sig is its signature of box_key.
+type PeerKey struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	RoutingTag *RoutingTag `protobuf:"bytes,1,opt,name=routing_tag,json=routingTag,proto3" json:"routing_tag,omitempty"`
-	Prefix     []byte      `protobuf:"bytes,2,opt,name=prefix,proto3" json:"prefix,omitempty"`
+	PublicKey []byte `protobuf:"bytes,1,opt,name=public_key,json=publicKey,proto3" json:"public_key,omitempty"`
+	BoxKey    []byte `protobuf:"bytes,2,opt,name=box_key,json=boxKey,proto3" json:"box_key,omitempty"`
+	Sig       []byte `protobuf:"bytes,3,opt,name=sig,proto3" json:"sig,omitempty"`
 }
 
-func (x *ListBlobsReq) Reset() {
-	*x = ListBlobsReq{}
+func (x *PeerKey) Reset() {
+	*x = PeerKey{}
 	if protoimpl.UnsafeEnabled {
 		mi := &file_bcproto_proto_msgTypes[4]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -257,13 +408,13 @@ func (x *ListBlobsReq) Reset() {
 	}
 }
 
-func (x *ListBlobsReq) String() string {
+func (x *PeerKey) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*ListBlobsReq) ProtoMessage() {}
+func (*PeerKey) ProtoMessage() {}
 
-func (x *ListBlobsReq) ProtoReflect() protoreflect.Message {
+func (x *PeerKey) ProtoReflect() protoreflect.Message {
 	mi := &file_bcproto_proto_msgTypes[4]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -275,36 +426,46 @@ func (x *ListBlobsReq) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use ListBlobsReq.ProtoReflect.Descriptor instead.
-func (*ListBlobsReq) Descriptor() ([]byte, []int) {
+// Deprecated: Use PeerKey.ProtoReflect.Descriptor instead.
+func (*PeerKey) Descriptor() ([]byte, []int) {
 	return file_bcproto_proto_rawDescGZIP(), []int{4}
 }
 
-func (x *ListBlobsReq) GetRoutingTag() *RoutingTag {
+func (x *PeerKey) GetPublicKey() []byte {
 	if x != nil {
-		return x.RoutingTag
+		return x.PublicKey
 	}
 	return nil
 }
 
-func (x *ListBlobsReq) GetPrefix() []byte {
+func (x *PeerKey) GetBoxKey() []byte {
 	if x != nil {
-		return x.Prefix
+		return x.BoxKey
 	}
 	return nil
 }
 
-type ListBlobsRes struct {
+func (x *PeerKey) GetSig() []byte {
+	if x != nil {
+		return x.Sig
+	}
+	return nil
+}
+
+// SignedMessage is what is inside a sealed message.
+type SignedMessage struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	BlobLocs []*BlobLoc `protobuf:"bytes,1,rep,name=blob_locs,json=blobLocs,proto3" json:"blob_locs,omitempty"`
-	TooMany  bool       `protobuf:"varint,2,opt,name=too_many,json=tooMany,proto3" json:"too_many,omitempty"`
+	SrcKey  *PeerKey `protobuf:"bytes,1,opt,name=src_key,json=srcKey,proto3" json:"src_key,omitempty"`
+	Payload []byte   `protobuf:"bytes,2,opt,name=payload,proto3" json:"payload,omitempty"`
+	// sig is the source's signature of the destination's ID followed by the payload.
+	Sig []byte `protobuf:"bytes,3,opt,name=sig,proto3" json:"sig,omitempty"`
 }
 
-func (x *ListBlobsRes) Reset() {
-	*x = ListBlobsRes{}
+func (x *SignedMessage) Reset() {
+	*x = SignedMessage{}
 	if protoimpl.UnsafeEnabled {
 		mi := &file_bcproto_proto_msgTypes[5]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -312,13 +473,13 @@ func (x *ListBlobsRes) Reset() {
 	}
 }
 
-func (x *ListBlobsRes) String() string {
+func (x *SignedMessage) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*ListBlobsRes) ProtoMessage() {}
+func (*SignedMessage) ProtoMessage() {}
 
-func (x *ListBlobsRes) ProtoReflect() protoreflect.Message {
+func (x *SignedMessage) ProtoReflect() protoreflect.Message {
 	mi := &file_bcproto_proto_msgTypes[5]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -330,37 +491,44 @@ func (x *ListBlobsRes) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use ListBlobsRes.ProtoReflect.Descriptor instead.
-func (*ListBlobsRes) Descriptor() ([]byte, []int) {
+// Deprecated: Use SignedMessage.ProtoReflect.Descriptor instead.
+func (*SignedMessage) Descriptor() ([]byte, []int) {
 	return file_bcproto_proto_rawDescGZIP(), []int{5}
 }
 
-func (x *ListBlobsRes) GetBlobLocs() []*BlobLoc {
+func (x *SignedMessage) GetSrcKey() *PeerKey {
 	if x != nil {
-		return x.BlobLocs
+		return x.SrcKey
 	}
 	return nil
 }
 
-func (x *ListBlobsRes) GetTooMany() bool {
+func (x *SignedMessage) GetPayload() []byte {
 	if x != nil {
-		return x.TooMany
+		return x.Payload
 	}
-	return false
+	return nil
 }
 
-type BlobLoc struct {
+func (x *SignedMessage) GetSig() []byte {
+	if x != nil {
+		return x.Sig
+	}
+	return nil
+}
+
+// BlobRouting
+type ListBlobsReq struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	BlobId    []byte `protobuf:"bytes,1,opt,name=blob_id,json=blobId,proto3" json:"blob_id,omitempty"`
-	PeerId    []byte `protobuf:"bytes,2,opt,name=peer_id,json=peerId,proto3" json:"peer_id,omitempty"`
-	SightedAt uint64 `protobuf:"varint,3,opt,name=sighted_at,json=sightedAt,proto3" json:"sighted_at,omitempty"`
+	RoutingTag *RoutingTag `protobuf:"bytes,1,opt,name=routing_tag,json=routingTag,proto3" json:"routing_tag,omitempty"`
+	Prefix     []byte      `protobuf:"bytes,2,opt,name=prefix,proto3" json:"prefix,omitempty"`
 }
 
-func (x *BlobLoc) Reset() {
-	*x = BlobLoc{}
+func (x *ListBlobsReq) Reset() {
+	*x = ListBlobsReq{}
 	if protoimpl.UnsafeEnabled {
 		mi := &file_bcproto_proto_msgTypes[6]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -368,13 +536,13 @@ func (x *BlobLoc) Reset() {
 	}
 }
 
-func (x *BlobLoc) String() string {
+func (x *ListBlobsReq) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*BlobLoc) ProtoMessage() {}
+func (*ListBlobsReq) ProtoMessage() {}
 
-func (x *BlobLoc) ProtoReflect() protoreflect.Message {
+func (x *ListBlobsReq) ProtoReflect() protoreflect.Message {
 	mi := &file_bcproto_proto_msgTypes[6]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -386,46 +554,43 @@ func (x *BlobLoc) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use BlobLoc.ProtoReflect.Descriptor instead.
-func (*BlobLoc) Descriptor() ([]byte, []int) {
+// Deprecated: Use ListBlobsReq.ProtoReflect.Descriptor instead.
+func (*ListBlobsReq) Descriptor() ([]byte, []int) {
 	return file_bcproto_proto_rawDescGZIP(), []int{6}
 }
 
-func (x *BlobLoc) GetBlobId() []byte {
+func (x *ListBlobsReq) GetRoutingTag() *RoutingTag {
 	if x != nil {
-		return x.BlobId
+		return x.RoutingTag
 	}
 	return nil
 }
 
-func (x *BlobLoc) GetPeerId() []byte {
+func (x *ListBlobsReq) GetPrefix() []byte {
 	if x != nil {
-		return x.PeerId
+		return x.Prefix
 	}
 	return nil
 }
 
-func (x *BlobLoc) GetSightedAt() uint64 {
-	if x != nil {
-		return x.SightedAt
-	}
-	return 0
-}
-
-// Fetching
-type GetReq struct {
+type ListBlobsRes struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	RoutingTag *RoutingTag `protobuf:"bytes,1,opt,name=routing_tag,json=routingTag,proto3" json:"routing_tag,omitempty"`
-	BlobId     []byte      `protobuf:"bytes,2,opt,name=blob_id,json=blobId,proto3" json:"blob_id,omitempty"`
-	HashAlgo   uint32      `protobuf:"varint,3,opt,name=hash_algo,json=hashAlgo,proto3" json:"hash_algo,omitempty"`
-	Found      bool        `protobuf:"varint,4,opt,name=found,proto3" json:"found,omitempty"`
+	// accept_bits is how many leading bits of its ID a peer requires blob IDs to share
+	// before it will add them to its route table.
+	AcceptBits uint32 `protobuf:"varint,3,opt,name=accept_bits,json=acceptBits,proto3" json:"accept_bits,omitempty"`
+	// Types that are assignable to Res:
+	//	*ListBlobsRes_Parent
+	//	*ListBlobsRes_Leaf
+	//	*ListBlobsRes_Sharded
+	Res        isListBlobsRes_Res `protobuf_oneof:"res"`
+	RouteError RouteError         `protobuf:"varint,7,opt,name=route_error,json=routeError,proto3,enum=RouteError" json:"route_error,omitempty"`
 }
 
-func (x *GetReq) Reset() {
-	*x = GetReq{}
+func (x *ListBlobsRes) Reset() {
+	*x = ListBlobsRes{}
 	if protoimpl.UnsafeEnabled {
 		mi := &file_bcproto_proto_msgTypes[7]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -433,13 +598,13 @@ func (x *GetReq) Reset() {
 	}
 }
 
-func (x *GetReq) String() string {
+func (x *ListBlobsRes) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*GetReq) ProtoMessage() {}
+func (*ListBlobsRes) ProtoMessage() {}
 
-func (x *GetReq) ProtoReflect() protoreflect.Message {
+func (x *ListBlobsRes) ProtoReflect() protoreflect.Message {
 	mi := &file_bcproto_proto_msgTypes[7]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -451,53 +616,91 @@ func (x *GetReq) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use GetReq.ProtoReflect.Descriptor instead.
-func (*GetReq) Descriptor() ([]byte, []int) {
+// Deprecated: Use ListBlobsRes.ProtoReflect.Descriptor instead.
+func (*ListBlobsRes) Descriptor() ([]byte, []int) {
 	return file_bcproto_proto_rawDescGZIP(), []int{7}
 }
 
-func (x *GetReq) GetRoutingTag() *RoutingTag {
+func (x *ListBlobsRes) GetAcceptBits() uint32 {
 	if x != nil {
-		return x.RoutingTag
+		return x.AcceptBits
+	}
+	return 0
+}
+
+func (m *ListBlobsRes) GetRes() isListBlobsRes_Res {
+	if m != nil {
+		return m.Res
 	}
 	return nil
 }
 
-func (x *GetReq) GetBlobId() []byte {
-	if x != nil {
-		return x.BlobId
+func (x *ListBlobsRes) GetParent() *RouteTableParent {
+	if x, ok := x.GetRes().(*ListBlobsRes_Parent); ok {
+		return x.Parent
 	}
 	return nil
 }
 
-func (x *GetReq) GetHashAlgo() uint32 {
-	if x != nil {
-		return x.HashAlgo
+func (x *ListBlobsRes) GetLeaf() *RouteTableLeaf {
+	if x, ok := x.GetRes().(*ListBlobsRes_Leaf); ok {
+		return x.Leaf
 	}
-	return 0
+	return nil
 }
 
-func (x *GetReq) GetFound() bool {
-	if x != nil {
-		return x.Found
+func (x *ListBlobsRes) GetSharded() bool {
+	if x, ok := x.GetRes().(*ListBlobsRes_Sharded); ok {
+		return x.Sharded
 	}
 	return false
 }
 
-type GetRes struct {
+func (x *ListBlobsRes) GetRouteError() RouteError {
+	if x != nil {
+		return x.RouteError
+	}
+	return RouteError_ROUTE_OK
+}
+
+type isListBlobsRes_Res interface {
+	isListBlobsRes_Res()
+}
+
+type ListBlobsRes_Parent struct {
+	Parent *RouteTableParent `protobuf:"bytes,4,opt,name=parent,proto3,oneof"`
+}
+
+type ListBlobsRes_Leaf struct {
+	Leaf *RouteTableLeaf `protobuf:"bytes,5,opt,name=leaf,proto3,oneof"`
+}
+
+type ListBlobsRes_Sharded struct {
+	// sharded is set when there is no single trie for the prefix.
+	// The requester should query each of the 256 longer prefixes instead.
+	Sharded bool `protobuf:"varint,6,opt,name=sharded,proto3,oneof"`
+}
+
+func (*ListBlobsRes_Parent) isListBlobsRes_Res() {}
+
+func (*ListBlobsRes_Leaf) isListBlobsRes_Res() {}
+
+func (*ListBlobsRes_Sharded) isListBlobsRes_Res() {}
+
+// RouteTableParent is a trie node which only points to smaller tries.
+type RouteTableParent struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	BlobId []byte `protobuf:"bytes,1,opt,name=blob_id,json=blobId,proto3" json:"blob_id,omitempty"`
-	// Types that are assignable to Res:
-	//	*GetRes_Data
-	//	*GetRes_Redirect
-	Res isGetRes_Res `protobuf_oneof:"res"`
+	// id is the ID of the trie node.
+	Id []byte `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	// children are the IDs of the 256 child nodes. They are empty where there is no child.
+	Children [][]byte `protobuf:"bytes,2,rep,name=children,proto3" json:"children,omitempty"`
 }
 
-func (x *GetRes) Reset() {
-	*x = GetRes{}
+func (x *RouteTableParent) Reset() {
+	*x = RouteTableParent{}
 	if protoimpl.UnsafeEnabled {
 		mi := &file_bcproto_proto_msgTypes[8]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -505,13 +708,13 @@ func (x *GetRes) Reset() {
 	}
 }
 
-func (x *GetRes) String() string {
+func (x *RouteTableParent) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*GetRes) ProtoMessage() {}
+func (*RouteTableParent) ProtoMessage() {}
 
-func (x *GetRes) ProtoReflect() protoreflect.Message {
+func (x *RouteTableParent) ProtoReflect() protoreflect.Message {
 	mi := &file_bcproto_proto_msgTypes[8]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -523,110 +726,1025 @@ func (x *GetRes) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use GetRes.ProtoReflect.Descriptor instead.
-func (*GetRes) Descriptor() ([]byte, []int) {
+// Deprecated: Use RouteTableParent.ProtoReflect.Descriptor instead.
+func (*RouteTableParent) Descriptor() ([]byte, []int) {
 	return file_bcproto_proto_rawDescGZIP(), []int{8}
 }
 
-func (x *GetRes) GetBlobId() []byte {
+func (x *RouteTableParent) GetId() []byte {
 	if x != nil {
-		return x.BlobId
+		return x.Id
 	}
 	return nil
 }
 
-func (m *GetRes) GetRes() isGetRes_Res {
-	if m != nil {
-		return m.Res
+func (x *RouteTableParent) GetChildren() [][]byte {
+	if x != nil {
+		return x.Children
 	}
 	return nil
 }
 
-func (x *GetRes) GetData() []byte {
-	if x, ok := x.GetRes().(*GetRes_Data); ok {
-		return x.Data
-	}
-	return nil
+// RouteTableLeaf holds all the BlobLocs with a prefix.
+type RouteTableLeaf struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// id is the ID of the trie node containing the BlobLocs. It is empty if there are none.
+	Id       []byte     `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	BlobLocs []*BlobLoc `protobuf:"bytes,2,rep,name=blob_locs,json=blobLocs,proto3" json:"blob_locs,omitempty"`
 }
 
-func (x *GetRes) GetRedirect() *GetReq {
-	if x, ok := x.GetRes().(*GetRes_Redirect); ok {
-		return x.Redirect
+func (x *RouteTableLeaf) Reset() {
+	*x = RouteTableLeaf{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_bcproto_proto_msgTypes[9]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
 	}
-	return nil
 }
 
-type isGetRes_Res interface {
-	isGetRes_Res()
+func (x *RouteTableLeaf) String() string {
+	return protoimpl.X.MessageStringOf(x)
 }
 
-type GetRes_Data struct {
-	Data []byte `protobuf:"bytes,2,opt,name=data,proto3,oneof"`
+func (*RouteTableLeaf) ProtoMessage() {}
+
+func (x *RouteTableLeaf) ProtoReflect() protoreflect.Message {
+	mi := &file_bcproto_proto_msgTypes[9]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
 }
 
-type GetRes_Redirect struct {
-	Redirect *GetReq `protobuf:"bytes,3,opt,name=redirect,proto3,oneof"`
+// Deprecated: Use RouteTableLeaf.ProtoReflect.Descriptor instead.
+func (*RouteTableLeaf) Descriptor() ([]byte, []int) {
+	return file_bcproto_proto_rawDescGZIP(), []int{9}
 }
 
-func (*GetRes_Data) isGetRes_Res() {}
+func (x *RouteTableLeaf) GetId() []byte {
+	if x != nil {
+		return x.Id
+	}
+	return nil
+}
 
-func (*GetRes_Redirect) isGetRes_Res() {}
+func (x *RouteTableLeaf) GetBlobLocs() []*BlobLoc {
+	if x != nil {
+		return x.BlobLocs
+	}
+	return nil
+}
+
+type BlobLoc struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	BlobId    []byte `protobuf:"bytes,1,opt,name=blob_id,json=blobId,proto3" json:"blob_id,omitempty"`
+	PeerId    []byte `protobuf:"bytes,2,opt,name=peer_id,json=peerId,proto3" json:"peer_id,omitempty"`
+	SightedAt uint64 `protobuf:"varint,3,opt,name=sighted_at,json=sightedAt,proto3" json:"sighted_at,omitempty"`
+}
+
+func (x *BlobLoc) Reset() {
+	*x = BlobLoc{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_bcproto_proto_msgTypes[10]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *BlobLoc) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BlobLoc) ProtoMessage() {}
+
+func (x *BlobLoc) ProtoReflect() protoreflect.Message {
+	mi := &file_bcproto_proto_msgTypes[10]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BlobLoc.ProtoReflect.Descriptor instead.
+func (*BlobLoc) Descriptor() ([]byte, []int) {
+	return file_bcproto_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *BlobLoc) GetBlobId() []byte {
+	if x != nil {
+		return x.BlobId
+	}
+	return nil
+}
+
+func (x *BlobLoc) GetPeerId() []byte {
+	if x != nil {
+		return x.PeerId
+	}
+	return nil
+}
+
+func (x *BlobLoc) GetSightedAt() uint64 {
+	if x != nil {
+		return x.SightedAt
+	}
+	return 0
+}
+
+// BlobAnnounce is told to peers when blobs enter or leave the sender's local storage.
+type BlobAnnounce struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Have [][]byte `protobuf:"bytes,1,rep,name=have,proto3" json:"have,omitempty"`
+	Gone [][]byte `protobuf:"bytes,2,rep,name=gone,proto3" json:"gone,omitempty"`
+}
+
+func (x *BlobAnnounce) Reset() {
+	*x = BlobAnnounce{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_bcproto_proto_msgTypes[11]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *BlobAnnounce) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BlobAnnounce) ProtoMessage() {}
+
+func (x *BlobAnnounce) ProtoReflect() protoreflect.Message {
+	mi := &file_bcproto_proto_msgTypes[11]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BlobAnnounce.ProtoReflect.Descriptor instead.
+func (*BlobAnnounce) Descriptor() ([]byte, []int) {
+	return file_bcproto_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *BlobAnnounce) GetHave() [][]byte {
+	if x != nil {
+		return x.Have
+	}
+	return nil
+}
+
+func (x *BlobAnnounce) GetGone() [][]byte {
+	if x != nil {
+		return x.Gone
+	}
+	return nil
+}
+
+// Fetching
+type GetReq struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	RoutingTag *RoutingTag `protobuf:"bytes,1,opt,name=routing_tag,json=routingTag,proto3" json:"routing_tag,omitempty"`
+	BlobId     []byte      `protobuf:"bytes,2,opt,name=blob_id,json=blobId,proto3" json:"blob_id,omitempty"`
+	HashAlgo   uint32      `protobuf:"varint,3,opt,name=hash_algo,json=hashAlgo,proto3" json:"hash_algo,omitempty"`
+	Found      bool        `protobuf:"varint,4,opt,name=found,proto3" json:"found,omitempty"`
+	// sealed is set in place of the other fields, except routing_tag, when the request is sealed to the destination.
+	// It is a SignedMessage holding the GetReq.
+	Sealed []byte `protobuf:"bytes,5,opt,name=sealed,proto3" json:"sealed,omitempty"`
+}
+
+func (x *GetReq) Reset() {
+	*x = GetReq{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_bcproto_proto_msgTypes[12]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *GetReq) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetReq) ProtoMessage() {}
+
+func (x *GetReq) ProtoReflect() protoreflect.Message {
+	mi := &file_bcproto_proto_msgTypes[12]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetReq.ProtoReflect.Descriptor instead.
+func (*GetReq) Descriptor() ([]byte, []int) {
+	return file_bcproto_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *GetReq) GetRoutingTag() *RoutingTag {
+	if x != nil {
+		return x.RoutingTag
+	}
+	return nil
+}
+
+func (x *GetReq) GetBlobId() []byte {
+	if x != nil {
+		return x.BlobId
+	}
+	return nil
+}
+
+func (x *GetReq) GetHashAlgo() uint32 {
+	if x != nil {
+		return x.HashAlgo
+	}
+	return 0
+}
+
+func (x *GetReq) GetFound() bool {
+	if x != nil {
+		return x.Found
+	}
+	return false
+}
+
+func (x *GetReq) GetSealed() []byte {
+	if x != nil {
+		return x.Sealed
+	}
+	return nil
+}
+
+type GetRes struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	BlobId []byte `protobuf:"bytes,1,opt,name=blob_id,json=blobId,proto3" json:"blob_id,omitempty"`
+	// Types that are assignable to Res:
+	//	*GetRes_Data
+	//	*GetRes_Redirect
+	Res        isGetRes_Res `protobuf_oneof:"res"`
+	RouteError RouteError   `protobuf:"varint,4,opt,name=route_error,json=routeError,proto3,enum=RouteError" json:"route_error,omitempty"`
+	// sealed is set in place of the other fields when the request was sealed.
+	// It is a SignedMessage holding the GetRes, sealed to the requester.
+	Sealed []byte `protobuf:"bytes,5,opt,name=sealed,proto3" json:"sealed,omitempty"`
+}
+
+func (x *GetRes) Reset() {
+	*x = GetRes{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_bcproto_proto_msgTypes[13]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *GetRes) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetRes) ProtoMessage() {}
+
+func (x *GetRes) ProtoReflect() protoreflect.Message {
+	mi := &file_bcproto_proto_msgTypes[13]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetRes.ProtoReflect.Descriptor instead.
+func (*GetRes) Descriptor() ([]byte, []int) {
+	return file_bcproto_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *GetRes) GetBlobId() []byte {
+	if x != nil {
+		return x.BlobId
+	}
+	return nil
+}
+
+func (m *GetRes) GetRes() isGetRes_Res {
+	if m != nil {
+		return m.Res
+	}
+	return nil
+}
+
+func (x *GetRes) GetData() []byte {
+	if x, ok := x.GetRes().(*GetRes_Data); ok {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *GetRes) GetRedirect() *GetReq {
+	if x, ok := x.GetRes().(*GetRes_Redirect); ok {
+		return x.Redirect
+	}
+	return nil
+}
+
+func (x *GetRes) GetRouteError() RouteError {
+	if x != nil {
+		return x.RouteError
+	}
+	return RouteError_ROUTE_OK
+}
+
+func (x *GetRes) GetSealed() []byte {
+	if x != nil {
+		return x.Sealed
+	}
+	return nil
+}
+
+type isGetRes_Res interface {
+	isGetRes_Res()
+}
+
+type GetRes_Data struct {
+	Data []byte `protobuf:"bytes,2,opt,name=data,proto3,oneof"`
+}
+
+type GetRes_Redirect struct {
+	Redirect *GetReq `protobuf:"bytes,3,opt,name=redirect,proto3,oneof"`
+}
+
+func (*GetRes_Data) isGetRes_Res() {}
+
+func (*GetRes_Redirect) isGetRes_Res() {}
+
+// Storage
+type StorageReq struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Types that are assignable to Req:
+	//	*StorageReq_Store
+	//	*StorageReq_Release
+	//	*StorageReq_Check
+	Req isStorageReq_Req `protobuf_oneof:"req"`
+}
+
+func (x *StorageReq) Reset() {
+	*x = StorageReq{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_bcproto_proto_msgTypes[14]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StorageReq) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StorageReq) ProtoMessage() {}
+
+func (x *StorageReq) ProtoReflect() protoreflect.Message {
+	mi := &file_bcproto_proto_msgTypes[14]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StorageReq.ProtoReflect.Descriptor instead.
+func (*StorageReq) Descriptor() ([]byte, []int) {
+	return file_bcproto_proto_rawDescGZIP(), []int{14}
+}
+
+func (m *StorageReq) GetReq() isStorageReq_Req {
+	if m != nil {
+		return m.Req
+	}
+	return nil
+}
+
+func (x *StorageReq) GetStore() *StoreReq {
+	if x, ok := x.GetReq().(*StorageReq_Store); ok {
+		return x.Store
+	}
+	return nil
+}
+
+func (x *StorageReq) GetRelease() *ReleaseReq {
+	if x, ok := x.GetReq().(*StorageReq_Release); ok {
+		return x.Release
+	}
+	return nil
+}
+
+func (x *StorageReq) GetCheck() *CheckReq {
+	if x, ok := x.GetReq().(*StorageReq_Check); ok {
+		return x.Check
+	}
+	return nil
+}
+
+type isStorageReq_Req interface {
+	isStorageReq_Req()
+}
+
+type StorageReq_Store struct {
+	Store *StoreReq `protobuf:"bytes,1,opt,name=store,proto3,oneof"`
+}
+
+type StorageReq_Release struct {
+	Release *ReleaseReq `protobuf:"bytes,2,opt,name=release,proto3,oneof"`
+}
+
+type StorageReq_Check struct {
+	Check *CheckReq `protobuf:"bytes,3,opt,name=check,proto3,oneof"`
+}
+
+func (*StorageReq_Store) isStorageReq_Req() {}
+
+func (*StorageReq_Release) isStorageReq_Req() {}
+
+func (*StorageReq_Check) isStorageReq_Req() {}
+
+type StorageRes struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Types that are assignable to Res:
+	//	*StorageRes_Store
+	//	*StorageRes_Release
+	//	*StorageRes_Check
+	Res isStorageRes_Res `protobuf_oneof:"res"`
+}
+
+func (x *StorageRes) Reset() {
+	*x = StorageRes{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_bcproto_proto_msgTypes[15]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StorageRes) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StorageRes) ProtoMessage() {}
+
+func (x *StorageRes) ProtoReflect() protoreflect.Message {
+	mi := &file_bcproto_proto_msgTypes[15]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StorageRes.ProtoReflect.Descriptor instead.
+func (*StorageRes) Descriptor() ([]byte, []int) {
+	return file_bcproto_proto_rawDescGZIP(), []int{15}
+}
+
+func (m *StorageRes) GetRes() isStorageRes_Res {
+	if m != nil {
+		return m.Res
+	}
+	return nil
+}
+
+func (x *StorageRes) GetStore() *StoreRes {
+	if x, ok := x.GetRes().(*StorageRes_Store); ok {
+		return x.Store
+	}
+	return nil
+}
+
+func (x *StorageRes) GetRelease() *ReleaseRes {
+	if x, ok := x.GetRes().(*StorageRes_Release); ok {
+		return x.Release
+	}
+	return nil
+}
+
+func (x *StorageRes) GetCheck() *CheckRes {
+	if x, ok := x.GetRes().(*StorageRes_Check); ok {
+		return x.Check
+	}
+	return nil
+}
+
+type isStorageRes_Res interface {
+	isStorageRes_Res()
+}
+
+type StorageRes_Store struct {
+	Store *StoreRes `protobuf:"bytes,1,opt,name=store,proto3,oneof"`
+}
+
+type StorageRes_Release struct {
+	Release *ReleaseRes `protobuf:"bytes,2,opt,name=release,proto3,oneof"`
+}
+
+type StorageRes_Check struct {
+	Check *CheckRes `protobuf:"bytes,3,opt,name=check,proto3,oneof"`
+}
+
+func (*StorageRes_Store) isStorageRes_Res() {}
+
+func (*StorageRes_Release) isStorageRes_Res() {}
+
+func (*StorageRes_Check) isStorageRes_Res() {}
+
+type StoreReq struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Data    []byte `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	Persist bool   `protobuf:"varint,2,opt,name=persist,proto3" json:"persist,omitempty"`
+}
+
+func (x *StoreReq) Reset() {
+	*x = StoreReq{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_bcproto_proto_msgTypes[16]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StoreReq) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StoreReq) ProtoMessage() {}
+
+func (x *StoreReq) ProtoReflect() protoreflect.Message {
+	mi := &file_bcproto_proto_msgTypes[16]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StoreReq.ProtoReflect.Descriptor instead.
+func (*StoreReq) Descriptor() ([]byte, []int) {
+	return file_bcproto_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *StoreReq) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *StoreReq) GetPersist() bool {
+	if x != nil {
+		return x.Persist
+	}
+	return false
+}
+
+type StoreRes struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	BlobId []byte        `protobuf:"bytes,1,opt,name=blob_id,json=blobId,proto3" json:"blob_id,omitempty"`
+	Status StorageStatus `protobuf:"varint,2,opt,name=status,proto3,enum=StorageStatus" json:"status,omitempty"`
+}
+
+func (x *StoreRes) Reset() {
+	*x = StoreRes{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_bcproto_proto_msgTypes[17]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StoreRes) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StoreRes) ProtoMessage() {}
+
+func (x *StoreRes) ProtoReflect() protoreflect.Message {
+	mi := &file_bcproto_proto_msgTypes[17]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StoreRes.ProtoReflect.Descriptor instead.
+func (*StoreRes) Descriptor() ([]byte, []int) {
+	return file_bcproto_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *StoreRes) GetBlobId() []byte {
+	if x != nil {
+		return x.BlobId
+	}
+	return nil
+}
+
+func (x *StoreRes) GetStatus() StorageStatus {
+	if x != nil {
+		return x.Status
+	}
+	return StorageStatus_STORAGE_UNKNOWN
+}
+
+type ReleaseReq struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	BlobIds [][]byte `protobuf:"bytes,1,rep,name=blob_ids,json=blobIds,proto3" json:"blob_ids,omitempty"`
+}
+
+func (x *ReleaseReq) Reset() {
+	*x = ReleaseReq{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_bcproto_proto_msgTypes[18]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ReleaseReq) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReleaseReq) ProtoMessage() {}
+
+func (x *ReleaseReq) ProtoReflect() protoreflect.Message {
+	mi := &file_bcproto_proto_msgTypes[18]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReleaseReq.ProtoReflect.Descriptor instead.
+func (*ReleaseReq) Descriptor() ([]byte, []int) {
+	return file_bcproto_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *ReleaseReq) GetBlobIds() [][]byte {
+	if x != nil {
+		return x.BlobIds
+	}
+	return nil
+}
+
+type ReleaseRes struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Status StorageStatus `protobuf:"varint,1,opt,name=status,proto3,enum=StorageStatus" json:"status,omitempty"`
+}
+
+func (x *ReleaseRes) Reset() {
+	*x = ReleaseRes{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_bcproto_proto_msgTypes[19]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ReleaseRes) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReleaseRes) ProtoMessage() {}
+
+func (x *ReleaseRes) ProtoReflect() protoreflect.Message {
+	mi := &file_bcproto_proto_msgTypes[19]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReleaseRes.ProtoReflect.Descriptor instead.
+func (*ReleaseRes) Descriptor() ([]byte, []int) {
+	return file_bcproto_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *ReleaseRes) GetStatus() StorageStatus {
+	if x != nil {
+		return x.Status
+	}
+	return StorageStatus_STORAGE_UNKNOWN
+}
+
+type CheckReq struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	BlobIds [][]byte `protobuf:"bytes,1,rep,name=blob_ids,json=blobIds,proto3" json:"blob_ids,omitempty"`
+}
+
+func (x *CheckReq) Reset() {
+	*x = CheckReq{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_bcproto_proto_msgTypes[20]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CheckReq) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckReq) ProtoMessage() {}
+
+func (x *CheckReq) ProtoReflect() protoreflect.Message {
+	mi := &file_bcproto_proto_msgTypes[20]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckReq.ProtoReflect.Descriptor instead.
+func (*CheckReq) Descriptor() ([]byte, []int) {
+	return file_bcproto_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *CheckReq) GetBlobIds() [][]byte {
+	if x != nil {
+		return x.BlobIds
+	}
+	return nil
+}
+
+type CheckRes struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Held []bool `protobuf:"varint,1,rep,packed,name=held,proto3" json:"held,omitempty"`
+}
+
+func (x *CheckRes) Reset() {
+	*x = CheckRes{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_bcproto_proto_msgTypes[21]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CheckRes) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckRes) ProtoMessage() {}
+
+func (x *CheckRes) ProtoReflect() protoreflect.Message {
+	mi := &file_bcproto_proto_msgTypes[21]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckRes.ProtoReflect.Descriptor instead.
+func (*CheckRes) Descriptor() ([]byte, []int) {
+	return file_bcproto_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *CheckRes) GetHeld() []bool {
+	if x != nil {
+		return x.Held
+	}
+	return nil
+}
 
 var File_bcproto_proto protoreflect.FileDescriptor
 
 var file_bcproto_proto_rawDesc = []byte{
 	0x0a, 0x0d, 0x62, 0x63, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22,
-	0x37, 0x0a, 0x0a, 0x52, 0x6f, 0x75, 0x74, 0x69, 0x6e, 0x67, 0x54, 0x61, 0x67, 0x12, 0x15, 0x0a,
+	0x71, 0x0a, 0x0a, 0x52, 0x6f, 0x75, 0x74, 0x69, 0x6e, 0x67, 0x54, 0x61, 0x67, 0x12, 0x15, 0x0a,
 	0x06, 0x64, 0x73, 0x74, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x64,
 	0x73, 0x74, 0x49, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x70, 0x61, 0x74, 0x68, 0x18, 0x02, 0x20, 0x03,
-	0x28, 0x04, 0x52, 0x04, 0x70, 0x61, 0x74, 0x68, 0x22, 0x3c, 0x0a, 0x0c, 0x4c, 0x69, 0x73, 0x74,
-	0x50, 0x65, 0x65, 0x72, 0x73, 0x52, 0x65, 0x71, 0x12, 0x2c, 0x0a, 0x0b, 0x72, 0x6f, 0x75, 0x74,
-	0x69, 0x6e, 0x67, 0x5f, 0x74, 0x61, 0x67, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0b, 0x2e,
-	0x52, 0x6f, 0x75, 0x74, 0x69, 0x6e, 0x67, 0x54, 0x61, 0x67, 0x52, 0x0a, 0x72, 0x6f, 0x75, 0x74,
-	0x69, 0x6e, 0x67, 0x54, 0x61, 0x67, 0x22, 0x51, 0x0a, 0x0c, 0x4c, 0x69, 0x73, 0x74, 0x50, 0x65,
-	0x65, 0x72, 0x73, 0x52, 0x65, 0x73, 0x12, 0x17, 0x0a, 0x07, 0x70, 0x65, 0x65, 0x72, 0x5f, 0x69,
-	0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x70, 0x65, 0x65, 0x72, 0x49, 0x64, 0x12,
-	0x28, 0x0a, 0x0a, 0x70, 0x65, 0x65, 0x72, 0x5f, 0x69, 0x6e, 0x66, 0x6f, 0x73, 0x18, 0x02, 0x20,
-	0x03, 0x28, 0x0b, 0x32, 0x09, 0x2e, 0x50, 0x65, 0x65, 0x72, 0x49, 0x6e, 0x66, 0x6f, 0x52, 0x09,
-	0x70, 0x65, 0x65, 0x72, 0x49, 0x6e, 0x66, 0x6f, 0x73, 0x22, 0x2e, 0x0a, 0x08, 0x50, 0x65, 0x65,
-	0x72, 0x49, 0x6e, 0x66, 0x6f, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28,
-	0x0c, 0x52, 0x02, 0x69, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x70, 0x61, 0x74, 0x68, 0x18, 0x02, 0x20,
-	0x03, 0x28, 0x04, 0x52, 0x04, 0x70, 0x61, 0x74, 0x68, 0x22, 0x54, 0x0a, 0x0c, 0x4c, 0x69, 0x73,
-	0x74, 0x42, 0x6c, 0x6f, 0x62, 0x73, 0x52, 0x65, 0x71, 0x12, 0x2c, 0x0a, 0x0b, 0x72, 0x6f, 0x75,
-	0x74, 0x69, 0x6e, 0x67, 0x5f, 0x74, 0x61, 0x67, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0b,
-	0x2e, 0x52, 0x6f, 0x75, 0x74, 0x69, 0x6e, 0x67, 0x54, 0x61, 0x67, 0x52, 0x0a, 0x72, 0x6f, 0x75,
-	0x74, 0x69, 0x6e, 0x67, 0x54, 0x61, 0x67, 0x12, 0x16, 0x0a, 0x06, 0x70, 0x72, 0x65, 0x66, 0x69,
-	0x78, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x70, 0x72, 0x65, 0x66, 0x69, 0x78, 0x22,
-	0x50, 0x0a, 0x0c, 0x4c, 0x69, 0x73, 0x74, 0x42, 0x6c, 0x6f, 0x62, 0x73, 0x52, 0x65, 0x73, 0x12,
-	0x25, 0x0a, 0x09, 0x62, 0x6c, 0x6f, 0x62, 0x5f, 0x6c, 0x6f, 0x63, 0x73, 0x18, 0x01, 0x20, 0x03,
-	0x28, 0x0b, 0x32, 0x08, 0x2e, 0x42, 0x6c, 0x6f, 0x62, 0x4c, 0x6f, 0x63, 0x52, 0x08, 0x62, 0x6c,
-	0x6f, 0x62, 0x4c, 0x6f, 0x63, 0x73, 0x12, 0x19, 0x0a, 0x08, 0x74, 0x6f, 0x6f, 0x5f, 0x6d, 0x61,
-	0x6e, 0x79, 0x18, 0x02, 0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x74, 0x6f, 0x6f, 0x4d, 0x61, 0x6e,
-	0x79, 0x22, 0x5a, 0x0a, 0x07, 0x42, 0x6c, 0x6f, 0x62, 0x4c, 0x6f, 0x63, 0x12, 0x17, 0x0a, 0x07,
+	0x28, 0x04, 0x52, 0x04, 0x70, 0x61, 0x74, 0x68, 0x12, 0x15, 0x0a, 0x06, 0x73, 0x72, 0x63, 0x5f,
+	0x69, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x73, 0x72, 0x63, 0x49, 0x64, 0x12,
+	0x21, 0x0a, 0x0c, 0x72, 0x65, 0x76, 0x65, 0x72, 0x73, 0x65, 0x5f, 0x70, 0x61, 0x74, 0x68, 0x18,
+	0x04, 0x20, 0x03, 0x28, 0x04, 0x52, 0x0b, 0x72, 0x65, 0x76, 0x65, 0x72, 0x73, 0x65, 0x50, 0x61,
+	0x74, 0x68, 0x22, 0x3c, 0x0a, 0x0c, 0x4c, 0x69, 0x73, 0x74, 0x50, 0x65, 0x65, 0x72, 0x73, 0x52,
+	0x65, 0x71, 0x12, 0x2c, 0x0a, 0x0b, 0x72, 0x6f, 0x75, 0x74, 0x69, 0x6e, 0x67, 0x5f, 0x74, 0x61,
+	0x67, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x52, 0x6f, 0x75, 0x74, 0x69, 0x6e,
+	0x67, 0x54, 0x61, 0x67, 0x52, 0x0a, 0x72, 0x6f, 0x75, 0x74, 0x69, 0x6e, 0x67, 0x54, 0x61, 0x67,
+	0x22, 0xa4, 0x01, 0x0a, 0x0c, 0x4c, 0x69, 0x73, 0x74, 0x50, 0x65, 0x65, 0x72, 0x73, 0x52, 0x65,
+	0x73, 0x12, 0x17, 0x0a, 0x07, 0x70, 0x65, 0x65, 0x72, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x0c, 0x52, 0x06, 0x70, 0x65, 0x65, 0x72, 0x49, 0x64, 0x12, 0x28, 0x0a, 0x0a, 0x70, 0x65,
+	0x65, 0x72, 0x5f, 0x69, 0x6e, 0x66, 0x6f, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x09,
+	0x2e, 0x50, 0x65, 0x65, 0x72, 0x49, 0x6e, 0x66, 0x6f, 0x52, 0x09, 0x70, 0x65, 0x65, 0x72, 0x49,
+	0x6e, 0x66, 0x6f, 0x73, 0x12, 0x2c, 0x0a, 0x0b, 0x72, 0x6f, 0x75, 0x74, 0x65, 0x5f, 0x65, 0x72,
+	0x72, 0x6f, 0x72, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x0b, 0x2e, 0x52, 0x6f, 0x75, 0x74,
+	0x65, 0x45, 0x72, 0x72, 0x6f, 0x72, 0x52, 0x0a, 0x72, 0x6f, 0x75, 0x74, 0x65, 0x45, 0x72, 0x72,
+	0x6f, 0x72, 0x12, 0x23, 0x0a, 0x08, 0x70, 0x65, 0x65, 0x72, 0x5f, 0x6b, 0x65, 0x79, 0x18, 0x04,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x08, 0x2e, 0x50, 0x65, 0x65, 0x72, 0x4b, 0x65, 0x79, 0x52, 0x07,
+	0x70, 0x65, 0x65, 0x72, 0x4b, 0x65, 0x79, 0x22, 0x4d, 0x0a, 0x08, 0x50, 0x65, 0x65, 0x72, 0x49,
+	0x6e, 0x66, 0x6f, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52,
+	0x02, 0x69, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x70, 0x61, 0x74, 0x68, 0x18, 0x02, 0x20, 0x03, 0x28,
+	0x04, 0x52, 0x04, 0x70, 0x61, 0x74, 0x68, 0x12, 0x1d, 0x0a, 0x0a, 0x6c, 0x69, 0x6e, 0x6b, 0x5f,
+	0x65, 0x70, 0x6f, 0x63, 0x68, 0x18, 0x03, 0x20, 0x01, 0x28, 0x04, 0x52, 0x09, 0x6c, 0x69, 0x6e,
+	0x6b, 0x45, 0x70, 0x6f, 0x63, 0x68, 0x22, 0x53, 0x0a, 0x07, 0x50, 0x65, 0x65, 0x72, 0x4b, 0x65,
+	0x79, 0x12, 0x1d, 0x0a, 0x0a, 0x70, 0x75, 0x62, 0x6c, 0x69, 0x63, 0x5f, 0x6b, 0x65, 0x79, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x09, 0x70, 0x75, 0x62, 0x6c, 0x69, 0x63, 0x4b, 0x65, 0x79,
+	0x12, 0x17, 0x0a, 0x07, 0x62, 0x6f, 0x78, 0x5f, 0x6b, 0x65, 0x79, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x0c, 0x52, 0x06, 0x62, 0x6f, 0x78, 0x4b, 0x65, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x73, 0x69, 0x67,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x03, 0x73, 0x69, 0x67, 0x22, 0x5e, 0x0a, 0x0d, 0x53,
+	0x69, 0x67, 0x6e, 0x65, 0x64, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x21, 0x0a, 0x07,
+	0x73, 0x72, 0x63, 0x5f, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x08, 0x2e,
+	0x50, 0x65, 0x65, 0x72, 0x4b, 0x65, 0x79, 0x52, 0x06, 0x73, 0x72, 0x63, 0x4b, 0x65, 0x79, 0x12,
+	0x18, 0x0a, 0x07, 0x70, 0x61, 0x79, 0x6c, 0x6f, 0x61, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c,
+	0x52, 0x07, 0x70, 0x61, 0x79, 0x6c, 0x6f, 0x61, 0x64, 0x12, 0x10, 0x0a, 0x03, 0x73, 0x69, 0x67,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x03, 0x73, 0x69, 0x67, 0x22, 0x54, 0x0a, 0x0c, 0x4c,
+	0x69, 0x73, 0x74, 0x42, 0x6c, 0x6f, 0x62, 0x73, 0x52, 0x65, 0x71, 0x12, 0x2c, 0x0a, 0x0b, 0x72,
+	0x6f, 0x75, 0x74, 0x69, 0x6e, 0x67, 0x5f, 0x74, 0x61, 0x67, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x0b, 0x2e, 0x52, 0x6f, 0x75, 0x74, 0x69, 0x6e, 0x67, 0x54, 0x61, 0x67, 0x52, 0x0a, 0x72,
+	0x6f, 0x75, 0x74, 0x69, 0x6e, 0x67, 0x54, 0x61, 0x67, 0x12, 0x16, 0x0a, 0x06, 0x70, 0x72, 0x65,
+	0x66, 0x69, 0x78, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x70, 0x72, 0x65, 0x66, 0x69,
+	0x78, 0x22, 0xe0, 0x01, 0x0a, 0x0c, 0x4c, 0x69, 0x73, 0x74, 0x42, 0x6c, 0x6f, 0x62, 0x73, 0x52,
+	0x65, 0x73, 0x12, 0x1f, 0x0a, 0x0b, 0x61, 0x63, 0x63, 0x65, 0x70, 0x74, 0x5f, 0x62, 0x69, 0x74,
+	0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0a, 0x61, 0x63, 0x63, 0x65, 0x70, 0x74, 0x42,
+	0x69, 0x74, 0x73, 0x12, 0x2b, 0x0a, 0x06, 0x70, 0x61, 0x72, 0x65, 0x6e, 0x74, 0x18, 0x04, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x11, 0x2e, 0x52, 0x6f, 0x75, 0x74, 0x65, 0x54, 0x61, 0x62, 0x6c, 0x65,
+	0x50, 0x61, 0x72, 0x65, 0x6e, 0x74, 0x48, 0x00, 0x52, 0x06, 0x70, 0x61, 0x72, 0x65, 0x6e, 0x74,
+	0x12, 0x25, 0x0a, 0x04, 0x6c, 0x65, 0x61, 0x66, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0f,
+	0x2e, 0x52, 0x6f, 0x75, 0x74, 0x65, 0x54, 0x61, 0x62, 0x6c, 0x65, 0x4c, 0x65, 0x61, 0x66, 0x48,
+	0x00, 0x52, 0x04, 0x6c, 0x65, 0x61, 0x66, 0x12, 0x1a, 0x0a, 0x07, 0x73, 0x68, 0x61, 0x72, 0x64,
+	0x65, 0x64, 0x18, 0x06, 0x20, 0x01, 0x28, 0x08, 0x48, 0x00, 0x52, 0x07, 0x73, 0x68, 0x61, 0x72,
+	0x64, 0x65, 0x64, 0x12, 0x2c, 0x0a, 0x0b, 0x72, 0x6f, 0x75, 0x74, 0x65, 0x5f, 0x65, 0x72, 0x72,
+	0x6f, 0x72, 0x18, 0x07, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x0b, 0x2e, 0x52, 0x6f, 0x75, 0x74, 0x65,
+	0x45, 0x72, 0x72, 0x6f, 0x72, 0x52, 0x0a, 0x72, 0x6f, 0x75, 0x74, 0x65, 0x45, 0x72, 0x72, 0x6f,
+	0x72, 0x42, 0x05, 0x0a, 0x03, 0x72, 0x65, 0x73, 0x4a, 0x04, 0x08, 0x01, 0x10, 0x02, 0x4a, 0x04,
+	0x08, 0x02, 0x10, 0x03, 0x22, 0x3e, 0x0a, 0x10, 0x52, 0x6f, 0x75, 0x74, 0x65, 0x54, 0x61, 0x62,
+	0x6c, 0x65, 0x50, 0x61, 0x72, 0x65, 0x6e, 0x74, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x0c, 0x52, 0x02, 0x69, 0x64, 0x12, 0x1a, 0x0a, 0x08, 0x63, 0x68, 0x69, 0x6c,
+	0x64, 0x72, 0x65, 0x6e, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0c, 0x52, 0x08, 0x63, 0x68, 0x69, 0x6c,
+	0x64, 0x72, 0x65, 0x6e, 0x22, 0x47, 0x0a, 0x0e, 0x52, 0x6f, 0x75, 0x74, 0x65, 0x54, 0x61, 0x62,
+	0x6c, 0x65, 0x4c, 0x65, 0x61, 0x66, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x0c, 0x52, 0x02, 0x69, 0x64, 0x12, 0x25, 0x0a, 0x09, 0x62, 0x6c, 0x6f, 0x62, 0x5f, 0x6c,
+	0x6f, 0x63, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x08, 0x2e, 0x42, 0x6c, 0x6f, 0x62,
+	0x4c, 0x6f, 0x63, 0x52, 0x08, 0x62, 0x6c, 0x6f, 0x62, 0x4c, 0x6f, 0x63, 0x73, 0x22, 0x5a, 0x0a,
+	0x07, 0x42, 0x6c, 0x6f, 0x62, 0x4c, 0x6f, 0x63, 0x12, 0x17, 0x0a, 0x07, 0x62, 0x6c, 0x6f, 0x62,
+	0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x62, 0x6c, 0x6f, 0x62, 0x49,
+	0x64, 0x12, 0x17, 0x0a, 0x07, 0x70, 0x65, 0x65, 0x72, 0x5f, 0x69, 0x64, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x0c, 0x52, 0x06, 0x70, 0x65, 0x65, 0x72, 0x49, 0x64, 0x12, 0x1d, 0x0a, 0x0a, 0x73, 0x69,
+	0x67, 0x68, 0x74, 0x65, 0x64, 0x5f, 0x61, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x04, 0x52, 0x09,
+	0x73, 0x69, 0x67, 0x68, 0x74, 0x65, 0x64, 0x41, 0x74, 0x22, 0x36, 0x0a, 0x0c, 0x42, 0x6c, 0x6f,
+	0x62, 0x41, 0x6e, 0x6e, 0x6f, 0x75, 0x6e, 0x63, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x68, 0x61, 0x76,
+	0x65, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0c, 0x52, 0x04, 0x68, 0x61, 0x76, 0x65, 0x12, 0x12, 0x0a,
+	0x04, 0x67, 0x6f, 0x6e, 0x65, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0c, 0x52, 0x04, 0x67, 0x6f, 0x6e,
+	0x65, 0x22, 0x9a, 0x01, 0x0a, 0x06, 0x47, 0x65, 0x74, 0x52, 0x65, 0x71, 0x12, 0x2c, 0x0a, 0x0b,
+	0x72, 0x6f, 0x75, 0x74, 0x69, 0x6e, 0x67, 0x5f, 0x74, 0x61, 0x67, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x0b, 0x32, 0x0b, 0x2e, 0x52, 0x6f, 0x75, 0x74, 0x69, 0x6e, 0x67, 0x54, 0x61, 0x67, 0x52, 0x0a,
+	0x72, 0x6f, 0x75, 0x74, 0x69, 0x6e, 0x67, 0x54, 0x61, 0x67, 0x12, 0x17, 0x0a, 0x07, 0x62, 0x6c,
+	0x6f, 0x62, 0x5f, 0x69, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x62, 0x6c, 0x6f,
+	0x62, 0x49, 0x64, 0x12, 0x1b, 0x0a, 0x09, 0x68, 0x61, 0x73, 0x68, 0x5f, 0x61, 0x6c, 0x67, 0x6f,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x08, 0x68, 0x61, 0x73, 0x68, 0x41, 0x6c, 0x67, 0x6f,
+	0x12, 0x14, 0x0a, 0x05, 0x66, 0x6f, 0x75, 0x6e, 0x64, 0x18, 0x04, 0x20, 0x01, 0x28, 0x08, 0x52,
+	0x05, 0x66, 0x6f, 0x75, 0x6e, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x65, 0x61, 0x6c, 0x65, 0x64,
+	0x18, 0x05, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x73, 0x65, 0x61, 0x6c, 0x65, 0x64, 0x22, 0xab,
+	0x01, 0x0a, 0x06, 0x47, 0x65, 0x74, 0x52, 0x65, 0x73, 0x12, 0x17, 0x0a, 0x07, 0x62, 0x6c, 0x6f,
+	0x62, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x62, 0x6c, 0x6f, 0x62,
+	0x49, 0x64, 0x12, 0x14, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c,
+	0x48, 0x00, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x12, 0x25, 0x0a, 0x08, 0x72, 0x65, 0x64, 0x69,
+	0x72, 0x65, 0x63, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x07, 0x2e, 0x47, 0x65, 0x74,
+	0x52, 0x65, 0x71, 0x48, 0x00, 0x52, 0x08, 0x72, 0x65, 0x64, 0x69, 0x72, 0x65, 0x63, 0x74, 0x12,
+	0x2c, 0x0a, 0x0b, 0x72, 0x6f, 0x75, 0x74, 0x65, 0x5f, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x18, 0x04,
+	0x20, 0x01, 0x28, 0x0e, 0x32, 0x0b, 0x2e, 0x52, 0x6f, 0x75, 0x74, 0x65, 0x45, 0x72, 0x72, 0x6f,
+	0x72, 0x52, 0x0a, 0x72, 0x6f, 0x75, 0x74, 0x65, 0x45, 0x72, 0x72, 0x6f, 0x72, 0x12, 0x16, 0x0a,
+	0x06, 0x73, 0x65, 0x61, 0x6c, 0x65, 0x64, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x73,
+	0x65, 0x61, 0x6c, 0x65, 0x64, 0x42, 0x05, 0x0a, 0x03, 0x72, 0x65, 0x73, 0x22, 0x82, 0x01, 0x0a,
+	0x0a, 0x53, 0x74, 0x6f, 0x72, 0x61, 0x67, 0x65, 0x52, 0x65, 0x71, 0x12, 0x21, 0x0a, 0x05, 0x73,
+	0x74, 0x6f, 0x72, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x09, 0x2e, 0x53, 0x74, 0x6f,
+	0x72, 0x65, 0x52, 0x65, 0x71, 0x48, 0x00, 0x52, 0x05, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x12, 0x27,
+	0x0a, 0x07, 0x72, 0x65, 0x6c, 0x65, 0x61, 0x73, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32,
+	0x0b, 0x2e, 0x52, 0x65, 0x6c, 0x65, 0x61, 0x73, 0x65, 0x52, 0x65, 0x71, 0x48, 0x00, 0x52, 0x07,
+	0x72, 0x65, 0x6c, 0x65, 0x61, 0x73, 0x65, 0x12, 0x21, 0x0a, 0x05, 0x63, 0x68, 0x65, 0x63, 0x6b,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x09, 0x2e, 0x43, 0x68, 0x65, 0x63, 0x6b, 0x52, 0x65,
+	0x71, 0x48, 0x00, 0x52, 0x05, 0x63, 0x68, 0x65, 0x63, 0x6b, 0x42, 0x05, 0x0a, 0x03, 0x72, 0x65,
+	0x71, 0x22, 0x82, 0x01, 0x0a, 0x0a, 0x53, 0x74, 0x6f, 0x72, 0x61, 0x67, 0x65, 0x52, 0x65, 0x73,
+	0x12, 0x21, 0x0a, 0x05, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32,
+	0x09, 0x2e, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x52, 0x65, 0x73, 0x48, 0x00, 0x52, 0x05, 0x73, 0x74,
+	0x6f, 0x72, 0x65, 0x12, 0x27, 0x0a, 0x07, 0x72, 0x65, 0x6c, 0x65, 0x61, 0x73, 0x65, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x52, 0x65, 0x6c, 0x65, 0x61, 0x73, 0x65, 0x52, 0x65,
+	0x73, 0x48, 0x00, 0x52, 0x07, 0x72, 0x65, 0x6c, 0x65, 0x61, 0x73, 0x65, 0x12, 0x21, 0x0a, 0x05,
+	0x63, 0x68, 0x65, 0x63, 0x6b, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x09, 0x2e, 0x43, 0x68,
+	0x65, 0x63, 0x6b, 0x52, 0x65, 0x73, 0x48, 0x00, 0x52, 0x05, 0x63, 0x68, 0x65, 0x63, 0x6b, 0x42,
+	0x05, 0x0a, 0x03, 0x72, 0x65, 0x73, 0x22, 0x38, 0x0a, 0x08, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x52,
+	0x65, 0x71, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c,
+	0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x12, 0x18, 0x0a, 0x07, 0x70, 0x65, 0x72, 0x73, 0x69, 0x73,
+	0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x70, 0x65, 0x72, 0x73, 0x69, 0x73, 0x74,
+	0x22, 0x4b, 0x0a, 0x08, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x52, 0x65, 0x73, 0x12, 0x17, 0x0a, 0x07,
 	0x62, 0x6c, 0x6f, 0x62, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x62,
-	0x6c, 0x6f, 0x62, 0x49, 0x64, 0x12, 0x17, 0x0a, 0x07, 0x70, 0x65, 0x65, 0x72, 0x5f, 0x69, 0x64,
-	0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x70, 0x65, 0x65, 0x72, 0x49, 0x64, 0x12, 0x1d,
-	0x0a, 0x0a, 0x73, 0x69, 0x67, 0x68, 0x74, 0x65, 0x64, 0x5f, 0x61, 0x74, 0x18, 0x03, 0x20, 0x01,
-	0x28, 0x04, 0x52, 0x09, 0x73, 0x69, 0x67, 0x68, 0x74, 0x65, 0x64, 0x41, 0x74, 0x22, 0x82, 0x01,
-	0x0a, 0x06, 0x47, 0x65, 0x74, 0x52, 0x65, 0x71, 0x12, 0x2c, 0x0a, 0x0b, 0x72, 0x6f, 0x75, 0x74,
-	0x69, 0x6e, 0x67, 0x5f, 0x74, 0x61, 0x67, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0b, 0x2e,
-	0x52, 0x6f, 0x75, 0x74, 0x69, 0x6e, 0x67, 0x54, 0x61, 0x67, 0x52, 0x0a, 0x72, 0x6f, 0x75, 0x74,
-	0x69, 0x6e, 0x67, 0x54, 0x61, 0x67, 0x12, 0x17, 0x0a, 0x07, 0x62, 0x6c, 0x6f, 0x62, 0x5f, 0x69,
-	0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x62, 0x6c, 0x6f, 0x62, 0x49, 0x64, 0x12,
-	0x1b, 0x0a, 0x09, 0x68, 0x61, 0x73, 0x68, 0x5f, 0x61, 0x6c, 0x67, 0x6f, 0x18, 0x03, 0x20, 0x01,
-	0x28, 0x0d, 0x52, 0x08, 0x68, 0x61, 0x73, 0x68, 0x41, 0x6c, 0x67, 0x6f, 0x12, 0x14, 0x0a, 0x05,
-	0x66, 0x6f, 0x75, 0x6e, 0x64, 0x18, 0x04, 0x20, 0x01, 0x28, 0x08, 0x52, 0x05, 0x66, 0x6f, 0x75,
-	0x6e, 0x64, 0x22, 0x65, 0x0a, 0x06, 0x47, 0x65, 0x74, 0x52, 0x65, 0x73, 0x12, 0x17, 0x0a, 0x07,
-	0x62, 0x6c, 0x6f, 0x62, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x62,
-	0x6c, 0x6f, 0x62, 0x49, 0x64, 0x12, 0x14, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x02, 0x20,
-	0x01, 0x28, 0x0c, 0x48, 0x00, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x12, 0x25, 0x0a, 0x08, 0x72,
-	0x65, 0x64, 0x69, 0x72, 0x65, 0x63, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x07, 0x2e,
-	0x47, 0x65, 0x74, 0x52, 0x65, 0x71, 0x48, 0x00, 0x52, 0x08, 0x72, 0x65, 0x64, 0x69, 0x72, 0x65,
-	0x63, 0x74, 0x42, 0x05, 0x0a, 0x03, 0x72, 0x65, 0x73, 0x42, 0x34, 0x5a, 0x32, 0x67, 0x69, 0x74,
-	0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x62, 0x6c, 0x6f, 0x62, 0x63, 0x61, 0x63, 0x68,
-	0x65, 0x2f, 0x62, 0x6c, 0x6f, 0x62, 0x63, 0x61, 0x63, 0x68, 0x65, 0x2f, 0x70, 0x6b, 0x67, 0x2f,
-	0x62, 0x6c, 0x6f, 0x62, 0x6e, 0x65, 0x74, 0x2f, 0x62, 0x63, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62,
-	0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x6c, 0x6f, 0x62, 0x49, 0x64, 0x12, 0x26, 0x0a, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x0e, 0x2e, 0x53, 0x74, 0x6f, 0x72, 0x61, 0x67, 0x65, 0x53,
+	0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x22, 0x27, 0x0a,
+	0x0a, 0x52, 0x65, 0x6c, 0x65, 0x61, 0x73, 0x65, 0x52, 0x65, 0x71, 0x12, 0x19, 0x0a, 0x08, 0x62,
+	0x6c, 0x6f, 0x62, 0x5f, 0x69, 0x64, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0c, 0x52, 0x07, 0x62,
+	0x6c, 0x6f, 0x62, 0x49, 0x64, 0x73, 0x22, 0x34, 0x0a, 0x0a, 0x52, 0x65, 0x6c, 0x65, 0x61, 0x73,
+	0x65, 0x52, 0x65, 0x73, 0x12, 0x26, 0x0a, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x0e, 0x32, 0x0e, 0x2e, 0x53, 0x74, 0x6f, 0x72, 0x61, 0x67, 0x65, 0x53, 0x74,
+	0x61, 0x74, 0x75, 0x73, 0x52, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x22, 0x25, 0x0a, 0x08,
+	0x43, 0x68, 0x65, 0x63, 0x6b, 0x52, 0x65, 0x71, 0x12, 0x19, 0x0a, 0x08, 0x62, 0x6c, 0x6f, 0x62,
+	0x5f, 0x69, 0x64, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0c, 0x52, 0x07, 0x62, 0x6c, 0x6f, 0x62,
+	0x49, 0x64, 0x73, 0x22, 0x1e, 0x0a, 0x08, 0x43, 0x68, 0x65, 0x63, 0x6b, 0x52, 0x65, 0x73, 0x12,
+	0x12, 0x0a, 0x04, 0x68, 0x65, 0x6c, 0x64, 0x18, 0x01, 0x20, 0x03, 0x28, 0x08, 0x52, 0x04, 0x68,
+	0x65, 0x6c, 0x64, 0x2a, 0x3b, 0x0a, 0x0a, 0x52, 0x6f, 0x75, 0x74, 0x65, 0x45, 0x72, 0x72, 0x6f,
+	0x72, 0x12, 0x0c, 0x0a, 0x08, 0x52, 0x4f, 0x55, 0x54, 0x45, 0x5f, 0x4f, 0x4b, 0x10, 0x00, 0x12,
+	0x0c, 0x0a, 0x08, 0x4e, 0x4f, 0x5f, 0x52, 0x4f, 0x55, 0x54, 0x45, 0x10, 0x01, 0x12, 0x11, 0x0a,
+	0x0d, 0x50, 0x41, 0x54, 0x48, 0x5f, 0x54, 0x4f, 0x4f, 0x5f, 0x4c, 0x4f, 0x4e, 0x47, 0x10, 0x02,
+	0x2a, 0x5b, 0x0a, 0x0d, 0x53, 0x74, 0x6f, 0x72, 0x61, 0x67, 0x65, 0x53, 0x74, 0x61, 0x74, 0x75,
+	0x73, 0x12, 0x13, 0x0a, 0x0f, 0x53, 0x54, 0x4f, 0x52, 0x41, 0x47, 0x45, 0x5f, 0x55, 0x4e, 0x4b,
+	0x4e, 0x4f, 0x57, 0x4e, 0x10, 0x00, 0x12, 0x0e, 0x0a, 0x0a, 0x53, 0x54, 0x4f, 0x52, 0x41, 0x47,
+	0x45, 0x5f, 0x4f, 0x4b, 0x10, 0x01, 0x12, 0x13, 0x0a, 0x0f, 0x53, 0x54, 0x4f, 0x52, 0x41, 0x47,
+	0x45, 0x5f, 0x52, 0x45, 0x46, 0x55, 0x53, 0x45, 0x44, 0x10, 0x02, 0x12, 0x10, 0x0a, 0x0c, 0x53,
+	0x54, 0x4f, 0x52, 0x41, 0x47, 0x45, 0x5f, 0x46, 0x55, 0x4c, 0x4c, 0x10, 0x03, 0x42, 0x34, 0x5a,
+	0x32, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x62, 0x6c, 0x6f, 0x62,
+	0x63, 0x61, 0x63, 0x68, 0x65, 0x2f, 0x62, 0x6c, 0x6f, 0x62, 0x63, 0x61, 0x63, 0x68, 0x65, 0x2f,
+	0x70, 0x6b, 0x67, 0x2f, 0x62, 0x6c, 0x6f, 0x62, 0x6e, 0x65, 0x74, 0x2f, 0x62, 0x63, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -641,30 +1759,61 @@ func file_bcproto_proto_rawDescGZIP() []byte {
 	return file_bcproto_proto_rawDescData
 }
 
-var file_bcproto_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_bcproto_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_bcproto_proto_msgTypes = make([]protoimpl.MessageInfo, 22)
 var file_bcproto_proto_goTypes = []interface{}{
-	(*RoutingTag)(nil),   // 0: RoutingTag
-	(*ListPeersReq)(nil), // 1: ListPeersReq
-	(*ListPeersRes)(nil), // 2: ListPeersRes
-	(*PeerInfo)(nil),     // 3: PeerInfo
-	(*ListBlobsReq)(nil), // 4: ListBlobsReq
-	(*ListBlobsRes)(nil), // 5: ListBlobsRes
-	(*BlobLoc)(nil),      // 6: BlobLoc
-	(*GetReq)(nil),       // 7: GetReq
-	(*GetRes)(nil),       // 8: GetRes
+	(RouteError)(0),          // 0: RouteError
+	(StorageStatus)(0),       // 1: StorageStatus
+	(*RoutingTag)(nil),       // 2: RoutingTag
+	(*ListPeersReq)(nil),     // 3: ListPeersReq
+	(*ListPeersRes)(nil),     // 4: ListPeersRes
+	(*PeerInfo)(nil),         // 5: PeerInfo
+	(*PeerKey)(nil),          // 6: PeerKey
+	(*SignedMessage)(nil),    // 7: SignedMessage
+	(*ListBlobsReq)(nil),     // 8: ListBlobsReq
+	(*ListBlobsRes)(nil),     // 9: ListBlobsRes
+	(*RouteTableParent)(nil), // 10: RouteTableParent
+	(*RouteTableLeaf)(nil),   // 11: RouteTableLeaf
+	(*BlobLoc)(nil),          // 12: BlobLoc
+	(*BlobAnnounce)(nil),     // 13: BlobAnnounce
+	(*GetReq)(nil),           // 14: GetReq
+	(*GetRes)(nil),           // 15: GetRes
+	(*StorageReq)(nil),       // 16: StorageReq
+	(*StorageRes)(nil),       // 17: StorageRes
+	(*StoreReq)(nil),         // 18: StoreReq
+	(*StoreRes)(nil),         // 19: StoreRes
+	(*ReleaseReq)(nil),       // 20: ReleaseReq
+	(*ReleaseRes)(nil),       // 21: ReleaseRes
+	(*CheckReq)(nil),         // 22: CheckReq
+	(*CheckRes)(nil),         // 23: CheckRes
 }
 var file_bcproto_proto_depIdxs = []int32{
-	0, // 0: ListPeersReq.routing_tag:type_name -> RoutingTag
-	3, // 1: ListPeersRes.peer_infos:type_name -> PeerInfo
-	0, // 2: ListBlobsReq.routing_tag:type_name -> RoutingTag
-	6, // 3: ListBlobsRes.blob_locs:type_name -> BlobLoc
-	0, // 4: GetReq.routing_tag:type_name -> RoutingTag
-	7, // 5: GetRes.redirect:type_name -> GetReq
-	6, // [6:6] is the sub-list for method output_type
-	6, // [6:6] is the sub-list for method input_type
-	6, // [6:6] is the sub-list for extension type_name
-	6, // [6:6] is the sub-list for extension extendee
-	0, // [0:6] is the sub-list for field type_name
+	2,  // 0: ListPeersReq.routing_tag:type_name -> RoutingTag
+	5,  // 1: ListPeersRes.peer_infos:type_name -> PeerInfo
+	0,  // 2: ListPeersRes.route_error:type_name -> RouteError
+	6,  // 3: ListPeersRes.peer_key:type_name -> PeerKey
+	6,  // 4: SignedMessage.src_key:type_name -> PeerKey
+	2,  // 5: ListBlobsReq.routing_tag:type_name -> RoutingTag
+	10, // 6: ListBlobsRes.parent:type_name -> RouteTableParent
+	11, // 7: ListBlobsRes.leaf:type_name -> RouteTableLeaf
+	0,  // 8: ListBlobsRes.route_error:type_name -> RouteError
+	12, // 9: RouteTableLeaf.blob_locs:type_name -> BlobLoc
+	2,  // 10: GetReq.routing_tag:type_name -> RoutingTag
+	14, // 11: GetRes.redirect:type_name -> GetReq
+	0,  // 12: GetRes.route_error:type_name -> RouteError
+	18, // 13: StorageReq.store:type_name -> StoreReq
+	20, // 14: StorageReq.release:type_name -> ReleaseReq
+	22, // 15: StorageReq.check:type_name -> CheckReq
+	19, // 16: StorageRes.store:type_name -> StoreRes
+	21, // 17: StorageRes.release:type_name -> ReleaseRes
+	23, // 18: StorageRes.check:type_name -> CheckRes
+	1,  // 19: StoreRes.status:type_name -> StorageStatus
+	1,  // 20: ReleaseRes.status:type_name -> StorageStatus
+	21, // [21:21] is the sub-list for method output_type
+	21, // [21:21] is the sub-list for method input_type
+	21, // [21:21] is the sub-list for extension type_name
+	21, // [21:21] is the sub-list for extension extendee
+	0,  // [0:21] is the sub-list for field type_name
 }
 
 func init() { file_bcproto_proto_init() }
@@ -722,7 +1871,7 @@ func file_bcproto_proto_init() {
 			}
 		}
 		file_bcproto_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*ListBlobsReq); i {
+			switch v := v.(*PeerKey); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -734,7 +1883,7 @@ func file_bcproto_proto_init() {
 			}
 		}
 		file_bcproto_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*ListBlobsRes); i {
+			switch v := v.(*SignedMessage); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -746,7 +1895,7 @@ func file_bcproto_proto_init() {
 			}
 		}
 		file_bcproto_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*BlobLoc); i {
+			switch v := v.(*ListBlobsReq); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -758,7 +1907,7 @@ func file_bcproto_proto_init() {
 			}
 		}
 		file_bcproto_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*GetReq); i {
+			switch v := v.(*ListBlobsRes); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -770,6 +1919,66 @@ func file_bcproto_proto_init() {
 			}
 		}
 		file_bcproto_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RouteTableParent); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_bcproto_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RouteTableLeaf); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_bcproto_proto_msgTypes[10].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*BlobLoc); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_bcproto_proto_msgTypes[11].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*BlobAnnounce); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_bcproto_proto_msgTypes[12].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*GetReq); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_bcproto_proto_msgTypes[13].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*GetRes); i {
 			case 0:
 				return &v.state
@@ -781,23 +1990,135 @@ func file_bcproto_proto_init() {
 				return nil
 			}
 		}
+		file_bcproto_proto_msgTypes[14].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StorageReq); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_bcproto_proto_msgTypes[15].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StorageRes); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_bcproto_proto_msgTypes[16].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StoreReq); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_bcproto_proto_msgTypes[17].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StoreRes); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_bcproto_proto_msgTypes[18].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ReleaseReq); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_bcproto_proto_msgTypes[19].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ReleaseRes); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_bcproto_proto_msgTypes[20].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CheckReq); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_bcproto_proto_msgTypes[21].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CheckRes); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	file_bcproto_proto_msgTypes[7].OneofWrappers = []interface{}{
+		(*ListBlobsRes_Parent)(nil),
+		(*ListBlobsRes_Leaf)(nil),
+		(*ListBlobsRes_Sharded)(nil),
 	}
-	file_bcproto_proto_msgTypes[8].OneofWrappers = []interface{}{
+	file_bcproto_proto_msgTypes[13].OneofWrappers = []interface{}{
 		(*GetRes_Data)(nil),
 		(*GetRes_Redirect)(nil),
 	}
+	file_bcproto_proto_msgTypes[14].OneofWrappers = []interface{}{
+		(*StorageReq_Store)(nil),
+		(*StorageReq_Release)(nil),
+		(*StorageReq_Check)(nil),
+	}
+	file_bcproto_proto_msgTypes[15].OneofWrappers = []interface{}{
+		(*StorageRes_Store)(nil),
+		(*StorageRes_Release)(nil),
+		(*StorageRes_Check)(nil),
+	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_bcproto_proto_rawDesc,
-			NumEnums:      0,
-			NumMessages:   9,
+			NumEnums:      2,
+			NumMessages:   22,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
 		GoTypes:           file_bcproto_proto_goTypes,
 		DependencyIndexes: file_bcproto_proto_depIdxs,
+		EnumInfos:         file_bcproto_proto_enumTypes,
 		MessageInfos:      file_bcproto_proto_msgTypes,
 	}.Build()
 	File_bcproto_proto = out.File
@@ -19,14 +19,25 @@ const (
 	ChannelPeerRoutingV0 = "blobcache/peer-routing-v0"
 	ChannelBlobRoutingV0 = "blobcache/blob-routing-v0"
 	ChannelFetchingV0    = "blobcache/fetching-v0"
+	ChannelStorageV0     = "blobcache/storage-v0"
 )
 
 type Params struct {
 	PeerStore peers.PeerStore
 	Mux       dynmux.Muxer
 	DB        bcstate.DB
-	Local     blobs.Getter
+	Local     blobrouting.Indexable
 	Clock     clockwork.Clock
+	// PeerStorage is where blobs are stored on behalf of peers.  If nil, peers can't store blobs here.
+	PeerStorage PeerStorage
+	// Ledger records the favors exchanged with peers.  If nil, nothing is recorded and no peer is refused.
+	Ledger *bcstate.Ledger
+	// PrivateKey is the node's key, which requests sealed to it are opened with.
+	// If it is nil, requests can't be sealed to or by this node.
+	PrivateKey p2p.PrivateKey
+	// SealMessages makes every get request sealed to, and only readable by, its destination.
+	// Blobs can only be fetched from peers whose keys are known.
+	SealMessages bool
 }
 
 type Blobnet struct {
@@ -34,6 +45,10 @@ type Blobnet struct {
 	peerRouter *peerrouting.Router
 	blobRouter *blobrouting.Router
 	fetcher    *Fetcher
+	storage    *Storage
+	cf         context.CancelFunc
+	// bootstrapped is closed when the bootstrap started by NewBlobNet is done.
+	bootstrapped chan struct{}
 }
 
 func NewBlobNet(params Params) *Blobnet {
@@ -50,6 +65,10 @@ func NewBlobNet(params Params) *Blobnet {
 	bn.peerRouter = peerrouting.NewRouter(peerrouting.RouterParams{
 		PeerSwarm: peers.NewPeerSwarm(rSwarm.(p2p.SecureAskSwarm), params.PeerStore),
 		Clock:     params.Clock,
+		Ledger:    params.Ledger,
+		DB:        bcstate.PrefixedDB{Prefix: "peer_router", DB: params.DB},
+
+		PrivateKey: params.PrivateKey,
 	})
 
 	// blob router
@@ -61,7 +80,15 @@ func NewBlobNet(params Params) *Blobnet {
 		PeerSwarm:  peers.NewPeerSwarm(brSwarm.(p2p.SecureAskSwarm), params.PeerStore),
 		PeerRouter: bn.peerRouter,
 		DB:         bcstate.PrefixedDB{Prefix: "blob_router", DB: params.DB},
+		LocalBlobs: params.Local,
 		Clock:      params.Clock,
+		Ledger:     params.Ledger,
+	})
+	// blobs can't be fetched from peers we can't route to.
+	bn.peerRouter.OnDrop(func(peer p2p.PeerID) {
+		if err := bn.blobRouter.DeletePeer(context.Background(), peer); err != nil {
+			log.Error(err)
+		}
 	})
 
 	// fetcher
@@ -70,18 +97,50 @@ func NewBlobNet(params Params) *Blobnet {
 		panic(err)
 	}
 	bn.fetcher = NewFetcher(FetcherParams{
-		PeerSwarm: peers.NewPeerSwarm(fSwarm.(p2p.SecureAskSwarm), params.PeerStore),
-		Local:     params.Local,
+		PeerRouter: bn.peerRouter,
+		BlobRouter: bn.blobRouter,
+		PeerSwarm:  peers.NewPeerSwarm(fSwarm.(p2p.SecureAskSwarm), params.PeerStore),
+		Local:      params.Local,
+		Ledger:     params.Ledger,
+		Clock:      params.Clock,
+		Seal:       params.SealMessages,
 	})
 
+	// storage
+	sSwarm, err := bn.mux.OpenSecureAsk(ChannelStorageV0)
+	if err != nil {
+		panic(err)
+	}
+	bn.storage = NewStorage(StorageParams{
+		PeerSwarm: peers.NewPeerSwarm(sSwarm.(p2p.SecureAskSwarm), params.PeerStore),
+		PeerStore: params.PeerStore,
+		Local:     params.PeerStorage,
+		Ledger:    params.Ledger,
+		OnStored: func(id blobs.ID) {
+			bn.blobRouter.Announce(id, true)
+		},
+	})
+
+	// paths from before a restart are usable right away, so this doesn't need to block.
+	ctx, cf := context.WithCancel(context.Background())
+	bn.cf = cf
+	bn.bootstrapped = make(chan struct{})
+	go func() {
+		defer close(bn.bootstrapped)
+		bn.bootstrap(ctx)
+	}()
+
 	return bn
 }
 
 func (bn *Blobnet) bootstrap(ctx context.Context) {
-	bn.peerRouter.Bootstrap(ctx)
+	if err := bn.peerRouter.Bootstrap(ctx); err != nil {
+		log.Error(err)
+	}
 }
 
 func (bn *Blobnet) Close() error {
+	bn.cf()
 	closers := []interface {
 		Close() error
 	}{
@@ -97,14 +156,46 @@ func (bn *Blobnet) Close() error {
 	return nil
 }
 
+// HaveLocally is called when a blob is added to local storage.
+// Peers which would route to the blob are told about it shortly after.
 func (bn *Blobnet) HaveLocally(ctx context.Context, id blobs.ID) error {
+	bn.blobRouter.Announce(id, true)
 	return nil
 }
 
+// GoneLocally is called when a blob is removed from local storage.
+// Peers which would route to the blob are told to forget us shortly after.
+// It does not block, so it can be called while local storage is locked.
 func (bn *Blobnet) GoneLocally(ctx context.Context, id blobs.ID) error {
+	bn.blobRouter.Announce(id, false)
 	return nil
 }
 
+// OneHop returns the peers which are directly connected
+func (bn *Blobnet) OneHop() []p2p.PeerID {
+	return bn.peerRouter.OneHop()
+}
+
+// StoreOn asks a peer to persist data
+func (bn *Blobnet) StoreOn(ctx context.Context, peer p2p.PeerID, data []byte) error {
+	return bn.storage.Store(ctx, peer, data, true)
+}
+
+// ReleaseOn tells a peer it no longer needs to persist ids
+func (bn *Blobnet) ReleaseOn(ctx context.Context, peer p2p.PeerID, ids []blobs.ID) error {
+	return bn.storage.Release(ctx, peer, ids)
+}
+
+// CheckOn asks a peer whether it still persists each of ids
+func (bn *Blobnet) CheckOn(ctx context.Context, peer p2p.PeerID, ids []blobs.ID) ([]bool, error) {
+	return bn.storage.Check(ctx, peer, ids)
+}
+
+// GetFrom fetches a blob from a specific peer
+func (bn *Blobnet) GetFrom(ctx context.Context, peer p2p.PeerID, id blobs.ID, fn func([]byte) error) error {
+	return bn.fetcher.GetFrom(ctx, peer, id, fn)
+}
+
 func (bn *Blobnet) GetF(ctx context.Context, id blobs.ID, f func([]byte) error) error {
 	return bn.fetcher.GetF(ctx, id, f)
 }
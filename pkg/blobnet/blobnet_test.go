@@ -3,14 +3,17 @@ package blobnet
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/blobcache/blobcache/pkg/bcstate"
+	"github.com/blobcache/blobcache/pkg/blobnet/blobrouting"
 	"github.com/blobcache/blobcache/pkg/blobnet/peers"
 	"github.com/brendoncarroll/go-p2p"
 	"github.com/brendoncarroll/go-p2p/p/dynmux"
 	"github.com/brendoncarroll/go-p2p/p2ptest"
 	"github.com/brendoncarroll/go-p2p/s/memswarm"
 	"github.com/jonboulle/clockwork"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -36,19 +39,96 @@ func TestBlobnet(t *testing.T) {
 		bns[i] = makeBlobnet(swarms[i], peerStore)
 	}
 
+	for i := range bns {
+		<-bns[i].bootstrapped
+	}
 	for i := range bns {
 		bns[i].bootstrap(context.TODO())
 	}
 }
 
 func makeBlobnet(s p2p.SecureAskSwarm, ps peers.PeerStore) *Blobnet {
-	mux := dynmux.MultiplexSwarm(s)
-	bn := NewBlobNet(Params{
+	return newTestBlobnet(s, Params{
 		PeerStore: ps,
-		Mux:       mux,
 		DB:        &bcstate.MemDB{},
 		Local:     bcstate.BlobAdapter(&bcstate.MemKV{Capacity: 100}),
 		Clock:     clockwork.NewRealClock(),
 	})
+}
+
+// newTestBlobnet is NewBlobNet with a Mux on s, which only answers asks once the Blobnet is built.
+// dynmux crashes on asks for a channel which is open, but doesn't have a handler yet,
+// and the peers of the Blobnet are already bootstrapping.
+func newTestBlobnet(s p2p.SecureAskSwarm, params Params) *Blobnet {
+	gs := &gatedSwarm{SecureAskSwarm: s}
+	params.Mux = dynmux.MultiplexSwarm(gs)
+	bn := NewBlobNet(params)
+	s.OnAsk(gs.handleAsk)
 	return bn
 }
+
+// gatedSwarm holds on to the ask handler set on it, instead of passing it to the swarm.
+type gatedSwarm struct {
+	p2p.SecureAskSwarm
+	handleAsk p2p.AskHandler
+}
+
+func (s *gatedSwarm) OnAsk(fn p2p.AskHandler) {
+	s.handleAsk = fn
+}
+
+func TestAnnounce(t *testing.T) {
+	ctx := context.TODO()
+	ids, locals, bns := newChain(t, 2)
+	a, b := bns[0], bns[1]
+
+	id, err := locals[0].Post(ctx, []byte("test-data"))
+	require.NoError(t, err)
+	require.NoError(t, a.HaveLocally(ctx, id))
+	a.blobRouter.FlushAnnouncements(ctx)
+	require.Eventually(t, func() bool {
+		ents := b.blobRouter.Lookup(ctx, id)
+		return len(ents) == 1 && ents[0].PeerID.Equals(ids[0])
+	}, time.Second, 10*time.Millisecond)
+
+	// announcements which have been undone are not sent
+	require.NoError(t, a.GoneLocally(ctx, id))
+	a.blobRouter.FlushAnnouncements(ctx)
+	time.Sleep(10 * time.Millisecond)
+	assert.Len(t, b.blobRouter.Lookup(ctx, id), 1)
+
+	require.NoError(t, locals[0].Delete(ctx, id))
+	require.NoError(t, a.GoneLocally(ctx, id))
+	a.blobRouter.FlushAnnouncements(ctx)
+	require.Eventually(t, func() bool {
+		return len(b.blobRouter.Lookup(ctx, id)) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestCrawl(t *testing.T) {
+	ctx := context.TODO()
+	ids, locals, bns := newChain(t, 3)
+	a, c := bns[0], bns[2]
+
+	// c is not a one-hop peer of a, so it only hears about a's blobs by crawling.
+	id, err := locals[0].Post(ctx, []byte("test-data"))
+	require.NoError(t, err)
+	require.NoError(t, a.HaveLocally(ctx, id))
+	a.blobRouter.FlushAnnouncements(ctx)
+	c.blobRouter.Crawl(ctx)
+	ents := c.blobRouter.Lookup(ctx, id)
+	require.Len(t, ents, 1)
+	assert.Equal(t, ids[0], ents[0].PeerID)
+
+	// the entry is sighted again by crawls which find a unchanged, so it doesn't expire.
+	require.NoError(t, c.blobRouter.Put(ctx, id, ids[0], time.Now().Add(-2*blobrouting.DefaultEntryTTL)))
+	c.blobRouter.Crawl(ctx)
+	require.NoError(t, c.blobRouter.PruneExpired(ctx))
+	assert.Len(t, c.blobRouter.Lookup(ctx, id), 1)
+
+	require.NoError(t, locals[0].Delete(ctx, id))
+	require.NoError(t, a.GoneLocally(ctx, id))
+	a.blobRouter.FlushAnnouncements(ctx)
+	c.blobRouter.Crawl(ctx)
+	assert.Len(t, c.blobRouter.Lookup(ctx, id), 0)
+}
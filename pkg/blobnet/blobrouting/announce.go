@@ -0,0 +1,204 @@
+package blobrouting
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/blobcache/blobcache/pkg/bitstrings"
+	"github.com/blobcache/blobcache/pkg/blobnet/bcproto"
+	"github.com/blobcache/blobcache/pkg/blobs"
+	"github.com/brendoncarroll/go-p2p"
+	proto "github.com/golang/protobuf/proto"
+	log "github.com/sirupsen/logrus"
+)
+
+type BlobAnnounce = bcproto.BlobAnnounce
+
+const (
+	// AnnouncePeriod is how often queued announcements are sent to peers.
+	AnnouncePeriod = time.Second
+	// MaxAnnounceIDs is the most blob IDs sent in a single announcement.
+	MaxAnnounceIDs = 256
+)
+
+// announcer queues changes to local storage, until they are told to peers.
+// Only one-hop peers are told, and only about blobs in the region of their route table.
+type announcer struct {
+	mu      sync.Mutex
+	have    map[blobs.ID]struct{}
+	gone    map[blobs.ID]struct{}
+	regions map[p2p.PeerID]int
+}
+
+func newAnnouncer() *announcer {
+	return &announcer{
+		have:    make(map[blobs.ID]struct{}),
+		gone:    make(map[blobs.ID]struct{}),
+		regions: make(map[p2p.PeerID]int),
+	}
+}
+
+func (a *announcer) queue(id blobs.ID, have bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if have {
+		delete(a.gone, id)
+		a.have[id] = struct{}{}
+	} else {
+		delete(a.have, id)
+		a.gone[id] = struct{}{}
+	}
+}
+
+// take returns the queued IDs, and clears the queue.
+func (a *announcer) take() (have, gone []blobs.ID) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for id := range a.have {
+		have = append(have, id)
+	}
+	for id := range a.gone {
+		gone = append(gone, id)
+	}
+	a.have = make(map[blobs.ID]struct{})
+	a.gone = make(map[blobs.ID]struct{})
+	return have, gone
+}
+
+// setRegion records the number of bits a peer requires blob IDs to share with its ID.
+func (a *announcer) setRegion(peer p2p.PeerID, bits int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.regions[peer] = bits
+}
+
+// wouldAccept returns true if the peer's route table would accept id.
+// Peers which have not told us their region are assumed to accept everything.
+func (a *announcer) wouldAccept(peer p2p.PeerID, id blobs.ID) bool {
+	a.mu.Lock()
+	bits := a.regions[peer]
+	a.mu.Unlock()
+	if bits > len(peer)*8 {
+		return false
+	}
+	region := bitstrings.FromBytes(bits, peer[:])
+	return bitstrings.HasPrefix(bitstrings.FromBytes(len(id)*8, id[:]), region)
+}
+
+// Announce queues a change to local storage to be told to peers.
+// It does not block, so it is safe to call while holding locks on local storage.
+func (r *Router) Announce(id blobs.ID, have bool) {
+	r.announcer.queue(id, have)
+}
+
+func (r *Router) runAnnouncer(ctx context.Context) {
+	// everything already in local storage needs to be indexed, and peers may as well hear about it.
+	if err := r.localRT.ForEach(ctx, func(id blobs.ID) error {
+		r.announcer.queue(id, true)
+		return nil
+	}); err != nil {
+		log.Error("listing local blobs: ", err)
+	}
+	ticker := r.clock.NewTicker(AnnouncePeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.Chan():
+			r.FlushAnnouncements(ctx)
+		}
+	}
+}
+
+// FlushAnnouncements applies the queued changes to the local index, and tells peers about them,
+// without waiting for the next period.
+// Changes which have been undone since they were queued are dropped.
+func (r *Router) FlushAnnouncements(ctx context.Context) {
+	have, gone := r.announcer.take()
+	if len(have) == 0 && len(gone) == 0 {
+		return
+	}
+	have = r.filterLocal(ctx, have, true)
+	gone = r.filterLocal(ctx, gone, false)
+	if err := r.localRT.Update(ctx, have, gone); err != nil {
+		log.Error("updating local index: ", err)
+	}
+	for _, peer := range r.peerRouter.OneHop() {
+		peerHave := r.acceptedBy(peer, have)
+		peerGone := r.acceptedBy(peer, gone)
+		for len(peerHave) > 0 || len(peerGone) > 0 {
+			msg := &BlobAnnounce{}
+			for len(msg.Have)+len(msg.Gone) < MaxAnnounceIDs && len(peerHave) > 0 {
+				msg.Have = append(msg.Have, peerHave[0][:])
+				peerHave = peerHave[1:]
+			}
+			for len(msg.Have)+len(msg.Gone) < MaxAnnounceIDs && len(peerGone) > 0 {
+				msg.Gone = append(msg.Gone, peerGone[0][:])
+				peerGone = peerGone[1:]
+			}
+			data, err := proto.Marshal(msg)
+			if err != nil {
+				panic(err)
+			}
+			if err := r.peerSwarm.TellPeer(ctx, peer, data); err != nil {
+				log.WithField("peer_id", peer).Warn("announcing blobs: ", err)
+				break
+			}
+		}
+	}
+}
+
+// filterLocal returns the ids which are (have=true) or are not (have=false) in local storage.
+func (r *Router) filterLocal(ctx context.Context, ids []blobs.ID, have bool) []blobs.ID {
+	var ret []blobs.ID
+	for _, id := range ids {
+		ents, err := r.localRT.Lookup(ctx, id)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		if (len(ents) > 0) == have {
+			ret = append(ret, id)
+		}
+	}
+	return ret
+}
+
+func (r *Router) acceptedBy(peer p2p.PeerID, ids []blobs.ID) []blobs.ID {
+	var ret []blobs.ID
+	for _, id := range ids {
+		if r.announcer.wouldAccept(peer, id) {
+			ret = append(ret, id)
+		}
+	}
+	return ret
+}
+
+func (r *Router) handleTell(msg *p2p.Message) {
+	ann := &BlobAnnounce{}
+	if err := proto.Unmarshal(msg.Payload, ann); err != nil {
+		log.Error(err)
+		return
+	}
+	ctx := context.Background()
+	peer := msg.Src.(p2p.PeerID)
+	now := r.clock.Now().UTC()
+	for _, x := range ann.Have {
+		if len(x) != len(blobs.ID{}) {
+			continue
+		}
+		if err := r.Put(ctx, blobs.IDFromBytes(x), peer, now); err != nil {
+			log.Error(err)
+		}
+	}
+	for _, x := range ann.Gone {
+		if len(x) != len(blobs.ID{}) {
+			continue
+		}
+		if err := r.kadRT.Delete(ctx, blobs.IDFromBytes(x), peer); err != nil {
+			log.Error(err)
+		}
+	}
+}
@@ -1,9 +1,13 @@
 package blobrouting
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"time"
 
+	"github.com/blobcache/blobcache/pkg/bcstate"
+	"github.com/blobcache/blobcache/pkg/blobnet/bcproto"
 	"github.com/blobcache/blobcache/pkg/blobnet/peerrouting"
 	"github.com/blobcache/blobcache/pkg/blobs"
 	"github.com/brendoncarroll/go-p2p"
@@ -21,13 +25,22 @@ type CrawlerParams struct {
 	BlobRouter *Router
 	PeerSwarm  PeerSwarm
 	Clock      clockwork.Clock
+	// State is where the IDs of the trie nodes last seen at each peer and prefix are kept.
+	State bcstate.KV
 }
 
+// Crawler syncs the route tables of peers into our own.
+//
+// Peers respond to queries with nodes from a trie of their BlobLocs.
+// The crawler remembers the ID of the node it last saw for each peer and prefix,
+// and skips subtrees which have not changed, so once a peer has been synced, only changes are transferred.
+// The entries in a skipped subtree are sighted again, since the peer still has them.
 type Crawler struct {
 	peerRouter *peerrouting.Router
 	blobRouter *Router
 	peerSwarm  PeerSwarm
 	clock      clockwork.Clock
+	state      bcstate.KV
 }
 
 func newCrawler(params CrawlerParams) *Crawler {
@@ -36,6 +49,7 @@ func newCrawler(params CrawlerParams) *Crawler {
 		blobRouter: params.BlobRouter,
 		peerSwarm:  params.PeerSwarm,
 		clock:      params.Clock,
+		state:      params.State,
 	}
 }
 
@@ -50,14 +64,14 @@ func (c *Crawler) run(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.Chan():
-			if err := c.crawl(ctx); err != nil {
-				log.Error(err)
-			}
+			c.crawl(ctx)
 		}
 	}
 }
 
-func (c *Crawler) crawl(ctx context.Context) error {
+// crawl syncs the part of every peer's route table which we would accept.
+// Peers which fail are logged and skipped.
+func (c *Crawler) crawl(ctx context.Context) {
 	log.Info("begin crawling")
 	defer func() { log.Info("done crawling") }()
 
@@ -66,15 +80,17 @@ func (c *Crawler) crawl(ctx context.Context) error {
 	peerIDs = append(peerIDs, c.peerRouter.MultiHop()...)
 
 	for _, peerID := range peerIDs {
-		bitstr := c.blobRouter.WouldAccept()
-		for _, prefix := range bitstr.EnumBytePrefixes() {
-			err := c.indexPeer(ctx, peerID, prefix)
-			if err == ErrShouldEvictThis {
-				continue
-			}
-			if err != nil {
-				return err
-			}
+		if err := c.crawlPeer(ctx, peerID); err != nil {
+			log.WithField("peer_id", peerID).Warn("crawling peer: ", err)
+		}
+	}
+}
+
+func (c *Crawler) crawlPeer(ctx context.Context, peerID p2p.PeerID) error {
+	bitstr := c.blobRouter.WouldAccept()
+	for _, prefix := range bitstr.EnumBytePrefixes() {
+		if err := c.indexPeer(ctx, peerID, prefix); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -85,53 +101,189 @@ func (c *Crawler) indexPeer(ctx context.Context, peerID p2p.PeerID, prefix []byt
 		"peer_id": peerID,
 		"prefix":  prefix,
 	}).Debug("indexing peer")
-	rt, nextHop := c.peerRouter.Lookup(peerID)
-	if rt == nil {
-		return peerrouting.ErrNoRouteToPeer
-	}
-	req := &ListBlobsReq{
-		RoutingTag: rt,
-		Prefix:     prefix,
-	}
-	res, err := c.blobRouter.request(ctx, nextHop, req)
+	var res *ListBlobsRes
+	err := c.peerRouter.TryPaths(ctx, peerID, func(rt *peerrouting.RoutingTag) error {
+		rt2, nextHop := c.peerRouter.ForwardWhere(rt)
+		if rt2 == nil {
+			return peerrouting.ErrNoRouteToPeer
+		}
+		var err error
+		res, err = c.blobRouter.request(ctx, nextHop, &ListBlobsReq{
+			RoutingTag: rt2,
+			Prefix:     prefix,
+		})
+		return err
+	})
 	if err != nil {
 		return err
 	}
-	// too many, request sub prefixes
-	if res.TooMany {
+	c.blobRouter.announcer.setRegion(peerID, int(res.AcceptBits))
+
+	switch x := res.Res.(type) {
+	case *bcproto.ListBlobsRes_Sharded:
+		if len(prefix) >= len(blobs.ID{}) {
+			return errors.New("peer sharded a full blob ID")
+		}
 		for i := 0; i < 256; i++ {
-			prefix2 := append(prefix, byte(i))
-			if err := c.indexPeer(ctx, peerID, prefix2); err != nil {
+			if err := c.indexPeer(ctx, peerID, appendPrefix(prefix, byte(i))); err != nil {
 				return err
 			}
 		}
 		return nil
+
+	case *bcproto.ListBlobsRes_Parent:
+		parent := x.Parent
+		if len(parent.Children) != 256 {
+			return errors.New("parent does not have 256 children")
+		}
+		if c.seen(peerID, prefix, parent.Id) {
+			return c.confirm(ctx, peerID, prefix)
+		}
+		for i, childID := range parent.Children {
+			prefix2 := appendPrefix(prefix, byte(i))
+			if len(childID) == 0 {
+				if err := c.syncLeaf(ctx, peerID, prefix2, nil, nil); err != nil {
+					return err
+				}
+				continue
+			}
+			if c.seen(peerID, prefix2, childID) {
+				if err := c.confirm(ctx, peerID, prefix2); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := c.indexPeer(ctx, peerID, prefix2); err != nil {
+				return err
+			}
+		}
+		return c.setSeen(peerID, prefix, parent.Id)
+
+	case *bcproto.ListBlobsRes_Leaf:
+		if len(x.Leaf.Id) > 0 && c.seen(peerID, prefix, x.Leaf.Id) {
+			return c.confirm(ctx, peerID, prefix)
+		}
+		return c.syncLeaf(ctx, peerID, prefix, x.Leaf.Id, x.Leaf.BlobLocs)
+
+	default:
+		return errors.New("empty response listing blobs")
 	}
-	// child
+}
+
+// syncLeaf makes the peer's entries under prefix match blobLocs.
+// Only BlobLocs for the peer itself are accepted.
+func (c *Crawler) syncLeaf(ctx context.Context, peerID p2p.PeerID, prefix, id []byte, blobLocs []*bcproto.BlobLoc) error {
 	now := c.clock.Now()
-	for _, blobLoc := range res.BlobLocs {
-		blobID := blobs.ID{}
-		copy(blobID[:], blobLoc.BlobId)
-		peerID := p2p.PeerID{}
-		copy(peerID[:], blobLoc.PeerId)
+	keep := map[blobs.ID]struct{}{}
+	for _, blobLoc := range blobLocs {
+		if len(blobLoc.BlobId) != len(blobs.ID{}) ||
+			!bytes.Equal(blobLoc.PeerId, peerID[:]) ||
+			!bytes.HasPrefix(blobLoc.BlobId, prefix) {
+			continue
+		}
+		blobID := blobs.IDFromBytes(blobLoc.BlobId)
 		sightedAt := time.Unix(int64(blobLoc.SightedAt), 0)
 		if sightedAt.After(now) {
-			log.Error("got route entry with time in future")
-			continue
+			sightedAt = now
 		}
 		if err := c.blobRouter.Put(ctx, blobID, peerID, sightedAt); err != nil {
 			return err
 		}
+		keep[blobID] = struct{}{}
+	}
+
+	var stale []blobs.ID
+	if err := c.blobRouter.kadRT.ForEach(ctx, prefix, func(ent RTEntry) error {
+		if _, ok := keep[ent.BlobID]; !ok && ent.PeerID.Equals(peerID) {
+			stale = append(stale, ent.BlobID)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, blobID := range stale {
+		if err := c.blobRouter.kadRT.Delete(ctx, blobID, peerID); err != nil {
+			return err
+		}
+	}
+
+	// anything remembered about longer prefixes no longer describes our entries.
+	if err := c.forgetUnder(peerID, prefix); err != nil {
+		return err
+	}
+	if len(id) == 0 {
+		return nil
+	}
+	return c.setSeen(peerID, prefix, id)
+}
+
+// confirm sights the peer's entries under prefix again, after finding the peer's subtree unchanged.
+// Only entries which are halfway to expiring are written, so most crawls do not write anything.
+func (c *Crawler) confirm(ctx context.Context, peerID p2p.PeerID, prefix []byte) error {
+	now := c.clock.Now()
+	return c.blobRouter.kadRT.Refresh(ctx, prefix, peerID, now, now.Add(-c.blobRouter.entryTTL/2))
+}
+
+// seen returns true if id is the node last seen at prefix on the peer.
+func (c *Crawler) seen(peerID p2p.PeerID, prefix, id []byte) bool {
+	var seen bool
+	err := c.state.GetF(stateKey(peerID, prefix), func(v []byte) error {
+		seen = bytes.Equal(v, id)
+		return nil
+	})
+	if err != nil && err != bcstate.ErrNotExist {
+		log.Error(err)
+	}
+	return seen
+}
+
+func (c *Crawler) setSeen(peerID p2p.PeerID, prefix, id []byte) error {
+	return c.state.Put(stateKey(peerID, prefix), id)
+}
+
+// forgetUnder forgets the nodes seen at prefix, and every longer prefix, on the peer.
+func (c *Crawler) forgetUnder(peerID p2p.PeerID, prefix []byte) error {
+	start := stateKey(peerID, prefix)
+	var keys [][]byte
+	if err := c.state.ForEach(start, bcstate.PrefixEnd(start), func(k, _ []byte) error {
+		keys = append(keys, append([]byte{}, k...))
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := c.state.Delete(k); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+// forgetPath forgets the nodes seen at every prefix of blobID on the peer,
+// so the next crawl fetches the path to the blob again, without fetching anything else.
+func (c *Crawler) forgetPath(peerID p2p.PeerID, blobID blobs.ID) error {
+	for i := 0; i <= len(blobID); i++ {
+		if err := c.state.Delete(stateKey(peerID, blobID[:i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func stateKey(peerID p2p.PeerID, prefix []byte) []byte {
+	return append(append([]byte{}, peerID[:]...), prefix...)
+}
+
+func appendPrefix(prefix []byte, c byte) []byte {
+	return append(append([]byte{}, prefix...), c)
+}
+
 func splitKey(x []byte) (blobs.ID, p2p.PeerID) {
 	l := len(x)
 	blobID := blobs.ID{}
 	peerID := p2p.PeerID{}
-	copy(blobID[:], x[l/2:])
-	copy(peerID[:], x[:l/2])
+	copy(blobID[:], x[:l/2])
+	copy(peerID[:], x[l/2:])
 	return blobID, peerID
 }
 
@@ -0,0 +1,137 @@
+package blobrouting
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/brendoncarroll/go-p2p"
+	"github.com/brendoncarroll/go-p2p/p/dynmux"
+	"github.com/brendoncarroll/go-p2p/p2ptest"
+	"github.com/brendoncarroll/go-p2p/s/memswarm"
+	"github.com/jonboulle/clockwork"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blobcache/blobcache/pkg/bcstate"
+	"github.com/blobcache/blobcache/pkg/blobnet/peerrouting"
+	"github.com/blobcache/blobcache/pkg/blobnet/peers"
+	"github.com/blobcache/blobcache/pkg/blobs"
+)
+
+func TestCrawlUnchanged(t *testing.T) {
+	ctx := context.TODO()
+	routers, swarms, stores := newTestRouters(t, 2)
+	a, b := routers[0], routers[1]
+
+	// a's index is too big for one node, so the root is a parent, and a lets b fetch it.
+	a.minQueryLength = 0
+	post := func(data []byte) blobs.ID {
+		id, err := stores[0].Post(ctx, data)
+		require.NoError(t, err)
+		a.Announce(id, true)
+		return id
+	}
+	for i := 0; i < 2000; i++ {
+		post([]byte(strconv.Itoa(i)))
+	}
+	a.FlushAnnouncements(ctx)
+	b.Crawl(ctx)
+	require.Greater(t, swarms[1].count(), 2)
+	require.Equal(t, 2000, countEntries(t, b))
+
+	// nothing changed, so only the root is fetched.
+	b.Crawl(ctx)
+	assert.Equal(t, 1, swarms[1].count())
+
+	// only the subtree with the new blob is fetched.
+	id := post([]byte("test-data"))
+	a.FlushAnnouncements(ctx)
+	b.Crawl(ctx)
+	assert.Equal(t, 2, swarms[1].count())
+	assert.Len(t, b.Lookup(ctx, id), 1)
+
+	// an evicted entry is fetched again, even though a has not changed.
+	b.kadRT.mu.Lock()
+	require.NoError(t, b.kadRT.evict(ctx, 256))
+	b.kadRT.mu.Unlock()
+	require.Equal(t, 2000, countEntries(t, b))
+	b.Crawl(ctx)
+	assert.Equal(t, 2, swarms[1].count())
+	assert.Equal(t, 2001, countEntries(t, b))
+}
+
+// countingSwarm counts the asks sent through it.
+type countingSwarm struct {
+	PeerSwarm
+	asks int32
+}
+
+func (s *countingSwarm) AskPeer(ctx context.Context, id p2p.PeerID, data []byte) ([]byte, error) {
+	atomic.AddInt32(&s.asks, 1)
+	return s.PeerSwarm.AskPeer(ctx, id, data)
+}
+
+// count returns the number of asks since it was last called.
+func (s *countingSwarm) count() int {
+	return int(atomic.SwapInt32(&s.asks, 0))
+}
+
+func newTestRouters(t *testing.T, n int) ([]*Router, []*countingSwarm, []blobs.Store) {
+	ctx := context.TODO()
+	realm := memswarm.NewRealm()
+	swarms := make([]p2p.SecureAskSwarm, n)
+	for i := range swarms {
+		swarms[i] = realm.NewSwarmWithKey(p2ptest.NewTestKey(t, i))
+	}
+	adjList := p2ptest.Chain(p2ptest.CastSlice(swarms))
+
+	routers := make([]*Router, n)
+	counters := make([]*countingSwarm, n)
+	stores := make([]blobs.Store, n)
+	for i := range swarms {
+		peerStore := make(peers.MemPeerStore)
+		for _, addr := range adjList[i] {
+			pubKey, err := swarms[i].LookupPublicKey(ctx, addr)
+			require.NoError(t, err)
+			peerStore.AddAddr(p2p.NewPeerID(pubKey), addr)
+		}
+		mux := dynmux.MultiplexSwarm(swarms[i])
+		prSwarm, err := mux.OpenSecureAsk("peer-routing")
+		require.NoError(t, err)
+		brSwarm, err := mux.OpenSecureAsk("blob-routing")
+		require.NoError(t, err)
+
+		clock := clockwork.NewRealClock()
+		peerRouter := peerrouting.NewRouter(peerrouting.RouterParams{
+			PeerSwarm: peers.NewPeerSwarm(prSwarm.(p2p.SecureAskSwarm), peerStore),
+			Clock:     clock,
+		})
+		counters[i] = &countingSwarm{PeerSwarm: peers.NewPeerSwarm(brSwarm.(p2p.SecureAskSwarm), peerStore)}
+		stores[i] = bcstate.BlobAdapter(&bcstate.MemKV{})
+		routers[i] = NewRouter(RouterParams{
+			PeerSwarm:  counters[i],
+			PeerRouter: peerRouter,
+			DB:         &bcstate.MemDB{},
+			LocalBlobs: stores[i],
+			Clock:      clock,
+		})
+		r := routers[i]
+		t.Cleanup(func() {
+			r.Close()
+			peerRouter.Close()
+		})
+	}
+	return routers, counters, stores
+}
+
+func countEntries(t *testing.T, r *Router) int {
+	n := 0
+	err := r.kadRT.ForEach(context.TODO(), nil, func(RTEntry) error {
+		n++
+		return nil
+	})
+	require.NoError(t, err)
+	return n
+}
@@ -24,6 +24,7 @@ type KadRT struct {
 	mu          sync.RWMutex
 	kv          bcstate.KV
 	lastEvicted int
+	onEvict     func(blobs.ID, p2p.PeerID)
 }
 
 func NewKadRT(kv bcstate.KV, locus []byte) *KadRT {
@@ -34,6 +35,14 @@ func NewKadRT(kv bcstate.KV, locus []byte) *KadRT {
 	return rt
 }
 
+// OnEvict sets fn to be called with every entry evicted to make room for another.
+// fn is called with the route table locked, so it must not call the route table.
+func (rt *KadRT) OnEvict(fn func(blobID blobs.ID, peerID p2p.PeerID)) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.onEvict = fn
+}
+
 func (rt *KadRT) Put(ctx context.Context, blobID blobs.ID, peerID p2p.PeerID, createdAt time.Time) error {
 	d := make([]byte, len(rt.locus))
 	kademlia.XORBytes(d, rt.locus, blobID[:])
@@ -116,21 +125,106 @@ func (rt *KadRT) List(ctx context.Context, prefix []byte, ents []RTEntry) (int,
 	return n, nil
 }
 
+func (rt *KadRT) Delete(ctx context.Context, blobID blobs.ID, peerID p2p.PeerID) error {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return rt.kv.Delete(makeKey(blobID, peerID))
+}
+
+// ForEach calls fn with every entry for a blob with prefix.
+func (rt *KadRT) ForEach(ctx context.Context, prefix []byte, fn func(RTEntry) error) error {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	return rt.kv.ForEach(prefix, bcstate.PrefixEnd(prefix), func(k, v []byte) error {
+		sightedAt, err := parseTime(v)
+		if err != nil {
+			return err
+		}
+		blobID, peerID := splitKey(k)
+		return fn(RTEntry{BlobID: blobID, PeerID: peerID, SightedAt: *sightedAt})
+	})
+}
+
 func (rt *KadRT) WouldAccept() bitstrings.BitString {
 	x := bitstrings.FromBytes(rt.lastEvicted, rt.locus)
 	return x
 }
 
-func (rt *KadRT) PruneExpired(ctx context.Context, prefix []byte, createdBefore time.Time) error {
+// PruneExpired deletes the entries for blobs with prefix which were sighted before createdBefore,
+// and returns them.
+func (rt *KadRT) PruneExpired(ctx context.Context, prefix []byte, createdBefore time.Time) ([]RTEntry, error) {
 	rt.mu.Lock()
 	defer rt.mu.Unlock()
-	return rt.kv.ForEach(prefix, bcstate.PrefixEnd(prefix), func(k, v []byte) error {
-		createdAt := time.Unix(int64(binary.BigEndian.Uint64(v)), 0)
-		if createdAt.Before(createdBefore) {
-			return rt.kv.Delete(k)
+	return rt.deleteWhere(prefix, func(ent RTEntry) bool {
+		return ent.SightedAt.Before(createdBefore)
+	})
+}
+
+// Refresh sets the sighting time of peerID's entries for blobs with prefix to sightedAt,
+// if they were sighted before olderThan.
+func (rt *KadRT) Refresh(ctx context.Context, prefix []byte, peerID p2p.PeerID, sightedAt, olderThan time.Time) error {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	var keys [][]byte
+	if err := rt.kv.ForEach(prefix, bcstate.PrefixEnd(prefix), func(k, v []byte) error {
+		last, err := parseTime(v)
+		if err != nil {
+			return nil
+		}
+		if _, p := splitKey(k); p.Equals(peerID) && last.Before(olderThan) {
+			keys = append(keys, append([]byte{}, k...))
 		}
 		return nil
+	}); err != nil {
+		return err
+	}
+	var timeBytes [8]byte
+	binary.BigEndian.PutUint64(timeBytes[:], uint64(sightedAt.Unix()))
+	for _, k := range keys {
+		if err := rt.kv.Put(k, timeBytes[:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeletePeer deletes every entry for peerID.
+func (rt *KadRT) DeletePeer(ctx context.Context, peerID p2p.PeerID) error {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	_, err := rt.deleteWhere(nil, func(ent RTEntry) bool {
+		return ent.PeerID.Equals(peerID)
 	})
+	return err
+}
+
+// deleteWhere deletes the entries for blobs with prefix which match pred, and returns them.
+// Entries which can't be parsed are deleted as well.
+func (rt *KadRT) deleteWhere(prefix []byte, pred func(RTEntry) bool) ([]RTEntry, error) {
+	var keys [][]byte
+	var deleted []RTEntry
+	if err := rt.kv.ForEach(prefix, bcstate.PrefixEnd(prefix), func(k, v []byte) error {
+		sightedAt, err := parseTime(v)
+		if err != nil {
+			keys = append(keys, append([]byte{}, k...))
+			return nil
+		}
+		blobID, peerID := splitKey(k)
+		ent := RTEntry{BlobID: blobID, PeerID: peerID, SightedAt: *sightedAt}
+		if pred(ent) {
+			keys = append(keys, append([]byte{}, k...))
+			deleted = append(deleted, ent)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		if err := rt.kv.Delete(k); err != nil {
+			return nil, err
+		}
+	}
+	return deleted, nil
 }
 
 func (rt *KadRT) evict(ctx context.Context, lz int) error {
@@ -141,9 +235,13 @@ func (rt *KadRT) evict(ctx context.Context, lz int) error {
 		if err := rt.kv.ForEach(prefix, bcstate.PrefixEnd(prefix), func(k, v []byte) error {
 			keybs := bitstrings.FromBytes(len(k)*8, k)
 			if !bitstrings.HasPrefix(keybs, locus) {
+				blobID, peerID := splitKey(k)
 				if err := rt.kv.Delete(k); err != nil {
 					return err
 				}
+				if rt.onEvict != nil {
+					rt.onEvict(blobID, peerID)
+				}
 				return stopIter
 			}
 			return nil
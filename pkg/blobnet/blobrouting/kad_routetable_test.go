@@ -13,7 +13,8 @@ import (
 )
 
 func TestPut(t *testing.T) {
-	kv := &bcstate.MemKV{Capacity: 15}
+	// room for 15 entries
+	kv := &bcstate.MemKV{Capacity: 15 * (blobs.IDSize + 32 + 8)}
 	locus := make([]byte, 32)
 	rt := NewKadRT(kv, locus)
 	ctx := context.TODO()
@@ -52,3 +53,28 @@ func TestPut(t *testing.T) {
 		require.Len(t, peerIDs, 0, "%v should not have an entry", blobID)
 	}
 }
+
+func TestPruneExpired(t *testing.T) {
+	ctx := context.TODO()
+	rt := NewKadRT(&bcstate.MemKV{}, make([]byte, 32))
+	now := time.Now()
+	peer1, peer2 := p2p.PeerID{1}, p2p.PeerID{2}
+	old, fresh := blobs.ID{1}, blobs.ID{2}
+	require.NoError(t, rt.Put(ctx, old, peer1, now.Add(-2*time.Hour)))
+	require.NoError(t, rt.Put(ctx, fresh, peer1, now))
+	require.NoError(t, rt.Put(ctx, fresh, peer2, now))
+
+	expired, err := rt.PruneExpired(ctx, nil, now.Add(-time.Hour))
+	require.NoError(t, err)
+	require.Len(t, expired, 1)
+	require.Equal(t, old, expired[0].BlobID)
+	ents, err := rt.Lookup(ctx, old)
+	require.NoError(t, err)
+	require.Len(t, ents, 0)
+
+	require.NoError(t, rt.DeletePeer(ctx, peer1))
+	ents, err = rt.Lookup(ctx, fresh)
+	require.NoError(t, err)
+	require.Len(t, ents, 1)
+	require.Equal(t, peer2, ents[0].PeerID)
+}
@@ -2,9 +2,12 @@ package blobrouting
 
 import (
 	"context"
+	"sync"
 	"time"
 
+	"github.com/blobcache/blobcache/pkg/bcstate"
 	"github.com/blobcache/blobcache/pkg/blobs"
+	"github.com/blobcache/blobcache/pkg/tries"
 	"github.com/brendoncarroll/go-p2p"
 	"github.com/jonboulle/clockwork"
 )
@@ -16,17 +19,35 @@ type Indexable interface {
 	blobs.Getter
 }
 
+// LocalRT is the route table for blobs in local storage.
+//
+// Lookups go straight to local storage.
+// The BlobLocs are also indexed in a trie, keyed by BlobID + PeerID, which is what peers sync from.
+// The index is only changed by Update, so it lags behind local storage.
 type LocalRT struct {
 	localID p2p.PeerID
 	store   Indexable
 	clock   clockwork.Clock
+
+	mu       sync.RWMutex
+	index    *bcstate.MemKV
+	root     tries.Ref
+	liveSize uint64
 }
 
 func NewLocalRT(store Indexable, localID p2p.PeerID, clock clockwork.Clock) *LocalRT {
+	index := &bcstate.MemKV{}
+	root, err := tries.PostNode(context.Background(), bcstate.BlobAdapter(index), tries.New())
+	if err != nil {
+		panic(err)
+	}
 	return &LocalRT{
-		localID: localID,
-		store:   store,
-		clock:   clock,
+		localID:  localID,
+		store:    store,
+		clock:    clock,
+		index:    index,
+		root:     *root,
+		liveSize: index.SizeUsed(),
 	}
 }
 
@@ -69,3 +90,97 @@ func (rt *LocalRT) List(ctx context.Context, prefix []byte, entries []RTEntry) (
 	}
 	return n, nil
 }
+
+// ForEach calls fn with the ID of every blob in local storage.
+func (rt *LocalRT) ForEach(ctx context.Context, fn func(blobs.ID) error) error {
+	return rt.forEach(ctx, nil, fn)
+}
+
+func (rt *LocalRT) forEach(ctx context.Context, prefix []byte, fn func(blobs.ID) error) error {
+	ids := make([]blobs.ID, 1024)
+	n, err := rt.store.List(ctx, prefix, ids)
+	if err == blobs.ErrTooMany && len(prefix) < len(blobs.ID{}) {
+		for i := 0; i < 256; i++ {
+			prefix2 := append(append([]byte{}, prefix...), byte(i))
+			if err := rt.forEach(ctx, prefix2, fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, id := range ids[:n] {
+		if err := fn(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Update adds have to the index, and removes gone from it.
+func (rt *LocalRT) Update(ctx context.Context, have, gone []blobs.ID) error {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	s := bcstate.BlobAdapter(rt.index)
+	root := rt.root
+	for _, id := range have {
+		r, err := tries.Put(ctx, s, root, makeKey(id, rt.localID), nil)
+		if err != nil {
+			return err
+		}
+		root = *r
+	}
+	for _, id := range gone {
+		r, err := tries.Delete(ctx, s, root, makeKey(id, rt.localID))
+		if err != nil {
+			return err
+		}
+		root = *r
+	}
+	rt.root = root
+	// every update leaves old nodes behind, so copy the live ones to a new index when it has doubled in size.
+	if rt.index.SizeUsed() > 2*rt.liveSize+blobs.MaxSize {
+		return rt.compact(ctx)
+	}
+	return nil
+}
+
+// Query returns the ID of the trie node which holds the BlobLocs with prefix, and the node.
+// If the node is a leaf, it may hold BlobLocs without the prefix as well.
+// If there are no BlobLocs with the prefix, the node is nil.
+func (rt *LocalRT) Query(ctx context.Context, prefix []byte) (blobs.ID, *tries.Node, error) {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	s := bcstate.BlobAdapter(rt.index)
+	ref := rt.root
+	for {
+		n, err := tries.GetNode(ctx, s, ref)
+		if err != nil {
+			return blobs.ID{}, nil, err
+		}
+		if !tries.IsParent(n) || len(n.Prefix) >= len(prefix) {
+			return ref.ID, n, nil
+		}
+		child := tries.Child(n, prefix[len(n.Prefix)])
+		if child == nil {
+			return blobs.ID{}, nil, nil
+		}
+		ref = *child
+	}
+}
+
+// compact moves the nodes reachable from the root to a new index, dropping the rest.
+func (rt *LocalRT) compact(ctx context.Context) error {
+	index := &bcstate.MemKV{}
+	if err := tries.Walk(ctx, bcstate.BlobAdapter(rt.index), rt.root, func(ref tries.Ref) error {
+		return rt.index.GetF(ref.ID[:], func(data []byte) error {
+			return index.Put(ref.ID[:], data)
+		})
+	}); err != nil {
+		return err
+	}
+	rt.index = index
+	rt.liveSize = index.SizeUsed()
+	return nil
+}
@@ -3,7 +3,6 @@ package blobrouting
 import (
 	"bytes"
 	"context"
-	"errors"
 	"io"
 	"time"
 
@@ -12,6 +11,7 @@ import (
 	"github.com/blobcache/blobcache/pkg/blobnet/bcproto"
 	"github.com/blobcache/blobcache/pkg/blobnet/peerrouting"
 	"github.com/blobcache/blobcache/pkg/blobs"
+	"github.com/blobcache/blobcache/pkg/tries"
 	"github.com/brendoncarroll/go-p2p"
 	proto "github.com/golang/protobuf/proto"
 	"github.com/jonboulle/clockwork"
@@ -25,16 +25,25 @@ type (
 
 type PeerSwarm interface {
 	AskPeer(ctx context.Context, id p2p.PeerID, data []byte) ([]byte, error)
+	TellPeer(ctx context.Context, id p2p.PeerID, data []byte) error
 	OnAsk(p2p.AskHandler)
+	OnTell(p2p.TellHandler)
 	LocalID() p2p.PeerID
 }
 
+// DefaultEntryTTL is how long an entry is kept, after it was last sighted, if RouterParams.EntryTTL is not set.
+const DefaultEntryTTL = time.Hour
+
 type RouterParams struct {
 	PeerSwarm
 	PeerRouter *peerrouting.Router
 	DB         bcstate.DB
 	LocalBlobs Indexable
 	Clock      clockwork.Clock
+	// EntryTTL is how long an entry is kept after it was last sighted.
+	EntryTTL time.Duration
+	// Ledger records requests forwarded for and by peers. If it is nil, nothing is recorded.
+	Ledger *bcstate.Ledger
 }
 
 type Router struct {
@@ -42,17 +51,25 @@ type Router struct {
 	peerRouter     *peerrouting.Router
 	minQueryLength int
 	clock          clockwork.Clock
+	ledger         *bcstate.Ledger
+	entryTTL       time.Duration
 
-	localRT *LocalRT
-	kadRT   *KadRT
-	crawler *Crawler
-	cf      context.CancelFunc
+	localRT   *LocalRT
+	kadRT     *KadRT
+	crawler   *Crawler
+	announcer *announcer
+	cf        context.CancelFunc
 }
 
 func NewRouter(params RouterParams) *Router {
 	peerSwarm := params.PeerSwarm
 	localID := peerSwarm.LocalID()
 
+	entryTTL := params.EntryTTL
+	if entryTTL == 0 {
+		entryTTL = DefaultEntryTTL
+	}
+
 	ctx, cf := context.WithCancel(context.Background())
 
 	rtStorage := params.DB.Bucket("route_table")
@@ -61,25 +78,38 @@ func NewRouter(params RouterParams) *Router {
 		peerSwarm:      peerSwarm,
 		minQueryLength: 1,
 		clock:          params.Clock,
+		ledger:         params.Ledger,
+		entryTTL:       entryTTL,
 
-		localRT: NewLocalRT(params.LocalBlobs, localID, params.Clock),
-		kadRT:   NewKadRT(rtStorage, localID[:]),
-		cf:      cf,
+		localRT:   NewLocalRT(params.LocalBlobs, localID, params.Clock),
+		kadRT:     NewKadRT(rtStorage, localID[:]),
+		announcer: newAnnouncer(),
+		cf:        cf,
 	}
+	br.crawler = newCrawler(CrawlerParams{
+		PeerRouter: br.peerRouter,
+		BlobRouter: br,
+		PeerSwarm:  peerSwarm,
+		Clock:      params.Clock,
+		State:      params.DB.Bucket("crawl_state"),
+	})
+	// the crawl state vouches for the entries under each node it has seen, so evicted entries must be fetched again.
+	br.kadRT.OnEvict(func(blobID blobs.ID, peerID p2p.PeerID) {
+		if err := br.crawler.forgetPath(peerID, blobID); err != nil {
+			log.Error(err)
+		}
+	})
 	peerSwarm.OnAsk(br.handleAsk)
+	peerSwarm.OnTell(br.handleTell)
 	go br.run(ctx)
+	go br.runAnnouncer(ctx)
+	go br.runExpiry(ctx)
 
 	return br
 }
 
 func (r *Router) run(ctx context.Context) {
-	crawler := newCrawler(CrawlerParams{
-		PeerRouter: r.peerRouter,
-		BlobRouter: r,
-		PeerSwarm:  r.peerSwarm,
-		Clock:      r.clock,
-	})
-	crawler.run(ctx)
+	r.crawler.run(ctx)
 }
 
 func (br *Router) Close() error {
@@ -87,6 +117,11 @@ func (br *Router) Close() error {
 	return nil
 }
 
+// Crawl syncs the route tables of peers into ours, without waiting for the crawler.
+func (r *Router) Crawl(ctx context.Context) {
+	r.crawler.crawl(ctx)
+}
+
 func (r *Router) Put(ctx context.Context, blobID blobs.ID, peerID p2p.PeerID, sightedAt time.Time) error {
 	if peerID.Equals(r.peerSwarm.LocalID()) {
 		return nil
@@ -94,6 +129,54 @@ func (r *Router) Put(ctx context.Context, blobID blobs.ID, peerID p2p.PeerID, si
 	return r.kadRT.Put(ctx, blobID, peerID, sightedAt)
 }
 
+// Delete removes the entry saying peerID has blobID.
+func (r *Router) Delete(ctx context.Context, blobID blobs.ID, peerID p2p.PeerID) error {
+	if peerID.Equals(r.peerSwarm.LocalID()) {
+		return nil
+	}
+	return r.kadRT.Delete(ctx, blobID, peerID)
+}
+
+// DeletePeer removes every entry for peerID, and forgets what has been crawled from it.
+func (r *Router) DeletePeer(ctx context.Context, peerID p2p.PeerID) error {
+	if err := r.kadRT.DeletePeer(ctx, peerID); err != nil {
+		return err
+	}
+	return r.crawler.forgetUnder(peerID, nil)
+}
+
+func (r *Router) runExpiry(ctx context.Context) {
+	ticker := r.clock.NewTicker(r.entryTTL / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.Chan():
+			if err := r.PruneExpired(ctx); err != nil {
+				log.Error("pruning route table: ", err)
+			}
+		}
+	}
+}
+
+// PruneExpired removes entries which have not been sighted within the entry TTL.
+// Entries are sighted again whenever a crawl finds them unchanged, so only entries for peers
+// which have not been crawled in that time expire.
+// The path to each expired entry is crawled again, in case the peer comes back.
+func (r *Router) PruneExpired(ctx context.Context) error {
+	expired, err := r.kadRT.PruneExpired(ctx, nil, r.clock.Now().Add(-r.entryTTL))
+	if err != nil {
+		return err
+	}
+	for _, ent := range expired {
+		if err := r.crawler.forgetPath(ent.PeerID, ent.BlobID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (r *Router) List(ctx context.Context, prefix []byte, entries []RTEntry) (int, error) {
 	total := 0
 	n, err := r.localRT.List(ctx, prefix, entries)
@@ -138,6 +221,14 @@ func (r *Router) request(ctx context.Context, nextHop p2p.PeerID, req *ListBlobs
 	if err := proto.Unmarshal(resData, res); err != nil {
 		return nil, err
 	}
+	if err := peerrouting.CheckRouteError(res.RouteError); err != nil {
+		return nil, err
+	}
+	if rt := req.GetRoutingTag(); rt != nil && !bytes.HasPrefix(nextHop[:], rt.DstId) {
+		if err := r.ledger.Received(nextHop, bcstate.Favors{RequestsForwarded: 1}); err != nil {
+			log.Error(err)
+		}
+	}
 	return res, nil
 }
 
@@ -147,7 +238,11 @@ func (r *Router) handleAsk(ctx context.Context, msg *p2p.Message, w io.Writer) {
 		log.Error(err)
 		return
 	}
-	res, err := r.handleRequest(ctx, req)
+	res, err := r.handleRequest(ctx, msg.Src.(p2p.PeerID), req)
+	if code, ok := peerrouting.RouteErrorFor(err); ok {
+		log.WithField("peer_id", msg.Src).Warn("forwarding ListBlobsReq: ", err)
+		res, err = &ListBlobsRes{RouteError: code}, nil
+	}
 	if err != nil {
 		log.Error(err)
 		return
@@ -159,48 +254,90 @@ func (r *Router) handleAsk(ctx context.Context, msg *p2p.Message, w io.Writer) {
 	w.Write(resData)
 }
 
-func (r *Router) handleRequest(ctx context.Context, req *ListBlobsReq) (*ListBlobsRes, error) {
+func (r *Router) handleRequest(ctx context.Context, peer p2p.PeerID, req *ListBlobsReq) (*ListBlobsRes, error) {
 	rt := req.GetRoutingTag()
 	localID := r.peerSwarm.LocalID()
 	if rt == nil || bytes.HasPrefix(localID[:], rt.DstId) {
 		return r.localRequest(ctx, req)
 	}
-	return r.forwardRequest(ctx, req)
+	if inDebt, err := r.ledger.InDebt(peer); err != nil {
+		return nil, err
+	} else if inDebt {
+		return nil, peerrouting.ErrPeerInDebt
+	}
+	res, err := r.forwardRequest(ctx, peer, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.ledger.Gave(peer, bcstate.Favors{RequestsForwarded: 1}); err != nil {
+		return nil, err
+	}
+	return res, nil
 }
 
-func (br *Router) forwardRequest(ctx context.Context, req *ListBlobsReq) (*ListBlobsRes, error) {
-	rt2, nextHop := br.peerRouter.ForwardWhere(req.GetRoutingTag())
-	if rt2 == nil {
-		return nil, errors.New("error forwarding")
+// forwardRequest sends req, which was received from src, on to the next hop, and returns the response.
+func (br *Router) forwardRequest(ctx context.Context, src p2p.PeerID, req *ListBlobsReq) (*ListBlobsRes, error) {
+	rt2, nextHop, err := br.peerRouter.Forward(req.GetRoutingTag(), src)
+	if err != nil {
+		return nil, err
 	}
 	req2 := &ListBlobsReq{
-		Prefix: req.Prefix,
+		RoutingTag: rt2,
+		Prefix:     req.Prefix,
+	}
+	res, err := br.request(ctx, nextHop, req2)
+	if err != nil && err != peerrouting.ErrPathTooLong {
+		log.WithField("next_hop", nextHop).Warn(err)
+		return nil, peerrouting.ErrNoRouteToPeer
 	}
-	return br.request(ctx, nextHop, req2)
+	return res, err
 }
 
+// localRequest responds with the node of the local index for the prefix.
+// Prefixes shorter than minQueryLength are too big to return, so the requester is told to shard them.
 func (br *Router) localRequest(ctx context.Context, req *ListBlobsReq) (*ListBlobsRes, error) {
+	res := &ListBlobsRes{AcceptBits: uint32(br.WouldAccept().Len())}
 	if len(req.Prefix) < br.minQueryLength {
-		return &ListBlobsRes{}, nil
+		res.Res = &bcproto.ListBlobsRes_Sharded{Sharded: true}
+		return res, nil
 	}
-	entries := make([]RTEntry, 1024)
-	n, err := br.List(ctx, req.Prefix, entries)
+	id, node, err := br.localRT.Query(ctx, req.Prefix)
 	if err != nil {
-		if err == blobs.ErrTooMany {
-			return &bcproto.ListBlobsRes{TooMany: true}, nil
-		}
 		return nil, err
 	}
-	entries = entries[:n]
-	blobLocs := make([]*bcproto.BlobLoc, len(entries))
-	for i, ent := range entries {
-		blobLocs[i] = &bcproto.BlobLoc{
-			BlobId:    ent.BlobID[:],
-			PeerId:    ent.PeerID[:],
-			SightedAt: uint64(ent.SightedAt.Unix()),
+	switch {
+	case node == nil:
+		res.Res = &bcproto.ListBlobsRes_Leaf{Leaf: &bcproto.RouteTableLeaf{}}
+	case tries.IsParent(node):
+		children := make([][]byte, len(node.Children))
+		for i := range node.Children {
+			if child := tries.Child(node, byte(i)); child != nil {
+				children[i] = child.ID[:]
+			}
 		}
+		res.Res = &bcproto.ListBlobsRes_Parent{Parent: &bcproto.RouteTableParent{
+			Id:       id[:],
+			Children: children,
+		}}
+	default:
+		now := uint64(br.clock.Now().Unix())
+		var blobLocs []*bcproto.BlobLoc
+		for _, ent := range node.Entries {
+			key := append(append([]byte{}, node.Prefix...), ent.Key...)
+			if !bytes.HasPrefix(key, req.Prefix) || len(key) != 2*len(blobs.ID{}) {
+				continue
+			}
+			blobID, peerID := splitKey(key)
+			blobLocs = append(blobLocs, &bcproto.BlobLoc{
+				BlobId:    blobID[:],
+				PeerId:    peerID[:],
+				SightedAt: now,
+			})
+		}
+		res.Res = &bcproto.ListBlobsRes_Leaf{Leaf: &bcproto.RouteTableLeaf{
+			Id:       id[:],
+			BlobLocs: blobLocs,
+		}}
 	}
-	return &bcproto.ListBlobsRes{
-		BlobLocs: blobLocs,
-	}, nil
+	return res, nil
 }
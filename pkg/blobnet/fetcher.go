@@ -1,17 +1,23 @@
 package blobnet
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"io"
+	"sort"
+	"time"
 
+	"github.com/blobcache/blobcache/pkg/bcstate"
 	"github.com/blobcache/blobcache/pkg/blobnet/bcproto"
 	"github.com/blobcache/blobcache/pkg/blobnet/blobrouting"
 	"github.com/blobcache/blobcache/pkg/blobnet/peerrouting"
 	"github.com/blobcache/blobcache/pkg/blobnet/peers"
 	"github.com/blobcache/blobcache/pkg/blobs"
 	"github.com/brendoncarroll/go-p2p"
+	"github.com/brendoncarroll/go-p2p/p/kademlia"
 	proto "github.com/golang/protobuf/proto"
+	"github.com/jonboulle/clockwork"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -20,6 +26,25 @@ type (
 	GetRes          = bcproto.GetRes
 	GetRes_Data     = bcproto.GetRes_Data
 	GetRes_Redirect = bcproto.GetRes_Redirect
+	RoutingTag      = bcproto.RoutingTag
+)
+
+const (
+	// MaxRedirects is the most redirects a fetch will follow.
+	MaxRedirects = 3
+	// MaxPathLen is the most hops a get request can travel.
+	MaxPathLen = peerrouting.MaxPathLen
+	// MaxSources is the most peers a fetch will ask for the same blob.
+	MaxSources = 3
+)
+
+var (
+	ErrTooManyRedirects = errors.New("too many redirects")
+	ErrRedirectLoop     = errors.New("redirected to a peer which was already asked")
+	ErrPathTooLong      = peerrouting.ErrPathTooLong
+	ErrBadRedirect      = errors.New("invalid redirect")
+	ErrBadBlob          = errors.New("got bad blob from peer")
+	ErrNotSealed        = errors.New("response to a sealed request was not sealed")
 )
 
 type FetcherParams struct {
@@ -27,21 +52,51 @@ type FetcherParams struct {
 	BlobRouter *blobrouting.Router
 	PeerSwarm  *peers.PeerSwarm
 	Local      blobs.Getter
+	// Ledger records blobs served to and by peers. If it is nil, nothing is recorded.
+	Ledger *bcstate.Ledger
+	// Clock times requests, and decides when to ask the next source. Defaults to the real clock.
+	Clock clockwork.Clock
+	// Seal makes every request sealed to its destination, and signed, so peers which forward it only see the routing tag.
+	// Requests to peers whose key isn't known fail.
+	Seal bool
 }
 
+// Fetcher gets blobs from the network, and serves them to peers.
+//
+// Requests carry a RoutingTag, with a path relative to the receiver.
+// A node which is not the destination forwards the request along the path.
+// The destination replies with the blob if it has it, otherwise it redirects the requester
+// to a peer which has the blob, or is closer to it.
+//
+// When several peers are known to have a blob, the fetcher asks the best of them first, and asks the
+// next if the first fails, or takes longer than expected. The first valid response wins.
+//
+// Sealed requests are opened by the destination, which seals its response to the requester.
 type Fetcher struct {
 	peerRouter *peerrouting.Router
 	blobRouter *blobrouting.Router
 	peerSwarm  *peers.PeerSwarm
 	local      blobs.Getter
+	ledger     *bcstate.Ledger
+	stats      *peerStats
+	clock      clockwork.Clock
+	seal       bool
 }
 
 func NewFetcher(params FetcherParams) *Fetcher {
+	clock := params.Clock
+	if clock == nil {
+		clock = clockwork.NewRealClock()
+	}
 	f := &Fetcher{
 		peerRouter: params.PeerRouter,
 		blobRouter: params.BlobRouter,
 		peerSwarm:  params.PeerSwarm,
 		local:      params.Local,
+		ledger:     params.Ledger,
+		stats:      newPeerStats(),
+		clock:      clock,
+		seal:       params.Seal,
 	}
 	params.PeerSwarm.OnAsk(f.handleAsk)
 
@@ -49,70 +104,279 @@ func NewFetcher(params FetcherParams) *Fetcher {
 }
 
 func (f *Fetcher) GetF(ctx context.Context, id blobs.ID, fn func([]byte) error) error {
-	data, err := f.get(ctx, id, nil, 3)
+	data, err := f.get(ctx, id)
 	if err != nil {
 		return err
 	}
 	return fn(data)
 }
 
-func (f *Fetcher) get(ctx context.Context, id blobs.ID, redirect *GetReq, n int) ([]byte, error) {
-	var (
-		req = &GetReq{
-			BlobId: id[:],
+// PeerStats returns the results of fetching blobs from peer.
+func (f *Fetcher) PeerStats(peer p2p.PeerID) PeerStats {
+	return f.stats.get(peer)
+}
+
+// GetFrom asks peer for a blob directly, without looking up where the blob is.
+func (f *Fetcher) GetFrom(ctx context.Context, peer p2p.PeerID, id blobs.ID, fn func([]byte) error) error {
+	res, _, err := f.getRouted(ctx, &RoutingTag{DstId: peer[:]}, &GetReq{
+		BlobId: id[:],
+		Found:  true,
+	})
+	if err != nil {
+		return err
+	}
+	x, ok := res.Res.(*GetRes_Data)
+	if !ok {
+		return blobs.ErrNotFound
+	}
+	return fn(x.Data)
+}
+
+func (f *Fetcher) get(ctx context.Context, id blobs.ID) ([]byte, error) {
+	srcs := f.sources(ctx, id)
+	if len(srcs) == 0 {
+		return nil, blobs.ErrNotFound
+	}
+	if len(srcs) > MaxSources {
+		srcs = srcs[:MaxSources]
+	}
+	ctx, cf := context.WithCancel(ctx)
+	defer cf()
+
+	results := make(chan getResult, len(srcs))
+	launch := func(src source) {
+		go func() {
+			data, err := f.getFrom(ctx, id, src.peer, src.found)
+			results <- getResult{data: data, err: err}
+		}()
+	}
+	launch(srcs[0])
+	next, pending := 1, 1
+	var firstErr error
+	for pending > 0 {
+		// ask the next source if the last one takes longer than expected.
+		var delay time.Duration
+		if next < len(srcs) {
+			delay = f.stats.get(srcs[next-1].peer).cost()
 		}
-		nextHop p2p.PeerID
-	)
-
-	if redirect != nil {
-		rt2, nh := f.peerRouter.ForwardWhere(req.RoutingTag)
-		req = redirect
-		req.RoutingTag = rt2
-		nextHop = nh
-	} else {
-		entries := f.blobRouter.Lookup(ctx, id)
-		if len(entries) > 0 {
-			req.RoutingTag, nextHop = f.peerRouter.Lookup(entries[0].PeerID)
-			req.Found = true
-		} else {
-			id := f.peerRouter.Closest(id[:])
-			req.RoutingTag, nextHop = f.peerRouter.Lookup(id)
-			req.Found = false
+		res, ok, err := f.await(ctx, results, delay)
+		if err != nil {
+			return nil, err
 		}
-
-		if nextHop.Equals(f.peerSwarm.LocalID()) {
-			return nil, errors.New("no peer closer to target")
+		if ok {
+			pending--
+			if res.err == nil {
+				return res.data, nil
+			}
+			if firstErr == nil {
+				firstErr = res.err
+			}
+		}
+		if next < len(srcs) {
+			launch(srcs[next])
+			next++
+			pending++
 		}
 	}
+	return nil, firstErr
+}
 
-	res, err := f.getReq(ctx, nextHop, req)
-	if err != nil {
-		return nil, err
+type getResult struct {
+	data []byte
+	err  error
+}
+
+// await returns the next result, or false if delay passes first.
+// If delay is 0, it waits until there is a result.
+func (f *Fetcher) await(ctx context.Context, results <-chan getResult, delay time.Duration) (getResult, bool, error) {
+	var hedge <-chan time.Time
+	if delay > 0 {
+		// clockwork has no timer, so this is a ticker which is stopped after it is used once.
+		ticker := f.clock.NewTicker(delay)
+		defer ticker.Stop()
+		hedge = ticker.Chan()
+	}
+	select {
+	case <-ctx.Done():
+		return getResult{}, false, ctx.Err()
+	case res := <-results:
+		return res, true, nil
+	case <-hedge:
+		return getResult{}, false, nil
+	}
+}
+
+type source struct {
+	peer  p2p.PeerID
+	found bool
+}
+
+// sources returns the peers to ask for a blob, best first.
+// Peers which are known to have the blob are ordered by their stats, then by when they were last seen with it.
+// If no peers are known to have the blob, the peer closest to it is returned.
+func (f *Fetcher) sources(ctx context.Context, id blobs.ID) []source {
+	localID := f.peerSwarm.LocalID()
+	entries := f.blobRouter.Lookup(ctx, id)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].SightedAt.After(entries[j].SightedAt)
+	})
+	var peers []p2p.PeerID
+	seen := map[p2p.PeerID]struct{}{}
+	for _, ent := range entries {
+		if _, ok := seen[ent.PeerID]; ok || ent.PeerID.Equals(localID) {
+			continue
+		}
+		seen[ent.PeerID] = struct{}{}
+		if f.peerRouter.PathTo(ent.PeerID) == nil {
+			continue
+		}
+		peers = append(peers, ent.PeerID)
+	}
+	f.stats.sort(peers)
+	var srcs []source
+	for _, peer := range peers {
+		srcs = append(srcs, source{peer: peer, found: true})
 	}
+	if len(srcs) > 0 {
+		return srcs
+	}
+	// no peer is closer to the blob than we are, and we don't have it.
+	closest := f.peerRouter.Closest(id[:])
+	if closest.Equals(localID) || closest.Equals(p2p.ZeroPeerID()) {
+		return nil
+	}
+	return []source{{peer: closest}}
+}
 
-	switch x := res.Res.(type) {
-	case *GetRes_Data:
-		actualID := blobs.Hash(x.Data)
-		if !id.Equals(actualID) {
-			return nil, errors.New("got bad blob from peer")
+// getFrom asks dst for a blob, following any redirects.
+func (f *Fetcher) getFrom(ctx context.Context, id blobs.ID, dst p2p.PeerID, found bool) ([]byte, error) {
+	rt := &RoutingTag{DstId: dst[:]}
+	visited := map[p2p.PeerID]struct{}{}
+	for i := 0; i <= MaxRedirects; i++ {
+		visited[dst] = struct{}{}
+		res, used, err := f.getRouted(ctx, rt, &GetReq{
+			BlobId: id[:],
+			Found:  found,
+		})
+		if err != nil {
+			return nil, err
 		}
-		return x.Data, nil
+		switch x := res.Res.(type) {
+		case *GetRes_Data:
+			return x.Data, nil
 
-	case *GetRes_Redirect:
-		r := x.Redirect
-		req2 := &GetReq{
-			RoutingTag: r.RoutingTag,
-			Found:      r.Found,
-			BlobId:     id[:],
+		case *GetRes_Redirect:
+			if rt, err = f.followRedirect(used, x.Redirect, visited); err != nil {
+				return nil, err
+			}
+			copy(dst[:], rt.DstId)
+			found = x.Redirect.Found
+
+		default:
+			// the route table, ours or a peer's, said dst had the blob, and it doesn't.
+			if found {
+				if err := f.blobRouter.Delete(ctx, id, dst); err != nil {
+					log.Error(err)
+				}
+			}
+			return nil, blobs.ErrNotFound
 		}
-		if n <= 0 {
-			return nil, errors.New("out of redirects")
+	}
+	return nil, ErrTooManyRedirects
+}
+
+// getRouted is getVia, except that if rt has no path, each of our paths to its destination is tried in turn,
+// until one of them gets a response.
+// It returns the routing tag which got the response.
+func (f *Fetcher) getRouted(ctx context.Context, rt *RoutingTag, req *GetReq) (*GetRes, *RoutingTag, error) {
+	if len(rt.Path) > 0 {
+		res, err := f.getVia(ctx, rt, req)
+		return res, rt, err
+	}
+	dst := p2p.PeerID{}
+	copy(dst[:], rt.DstId)
+	var res *GetRes
+	var used *RoutingTag
+	err := f.peerRouter.TryPaths(ctx, dst, func(rt *RoutingTag) error {
+		var err error
+		res, err = f.getVia(ctx, rt, req)
+		used = rt
+		return err
+	})
+	if used == nil {
+		// we don't know a path to dst.
+		return nil, nil, blobs.ErrNotFound
+	}
+	return res, used, err
+}
+
+// followRedirect returns a routing tag, relative to us, for the target of a redirect.
+// If we have paths of our own to the target, the tag has no path, so getRouted uses them.
+// Otherwise the redirect's path is used, which is relative to the peer at the end of prev.
+func (f *Fetcher) followRedirect(prev *RoutingTag, redirect *GetReq, visited map[p2p.PeerID]struct{}) (*RoutingTag, error) {
+	rt := redirect.GetRoutingTag()
+	if rt == nil || len(rt.DstId) != len(p2p.PeerID{}) {
+		return nil, ErrBadRedirect
+	}
+	dst := p2p.PeerID{}
+	copy(dst[:], rt.DstId)
+	if _, ok := visited[dst]; ok || dst.Equals(f.peerSwarm.LocalID()) {
+		return nil, ErrRedirectLoop
+	}
+	// prefer our own routes to the peer, if we have any.
+	if f.peerRouter.PathTo(dst) != nil {
+		return &RoutingTag{DstId: dst[:]}, nil
+	}
+	path := append(append(peerrouting.Path{}, prev.Path...), rt.Path...)
+	if len(path) > MaxPathLen {
+		return nil, ErrPathTooLong
+	}
+	return &RoutingTag{DstId: dst[:], Path: path}, nil
+}
+
+// getVia sends req along rt, which is relative to us, sealing it if the fetcher seals requests.
+// Data in the response is checked against the requested ID, and the result is recorded in the stats for the destination.
+// Redirects are not recorded.
+func (f *Fetcher) getVia(ctx context.Context, rt *RoutingTag, req *GetReq) (*GetRes, error) {
+	rt2, nextHop := f.peerRouter.ForwardWhere(rt)
+	if rt2 == nil || nextHop.Equals(p2p.ZeroPeerID()) {
+		return nil, blobs.ErrNotFound
+	}
+	req.RoutingTag = rt2
+	dst := p2p.PeerID{}
+	copy(dst[:], rt.DstId)
+	sent := req
+	if f.seal {
+		var err error
+		if sent, err = f.sealReq(dst, req); err != nil {
+			return nil, err
 		}
-		return f.get(ctx, id, req2, n-1)
+	}
 
+	start := f.clock.Now()
+	res, err := f.getReq(ctx, nextHop, sent)
+	if err == nil && f.seal {
+		res, err = f.openRes(dst, res)
+	}
+	if err == nil {
+		switch x := res.Res.(type) {
+		case *GetRes_Data:
+			if !blobs.IDFromBytes(req.BlobId).Equals(blobs.Hash(x.Data)) {
+				err = ErrBadBlob
+			}
+		case *GetRes_Redirect:
+			return res, nil
+		}
+	}
+	switch {
+	case err == nil && res.GetData() != nil:
+		f.stats.record(dst, f.clock.Since(start), true)
+	case ctx.Err() != nil:
+		// the request was cancelled, which says nothing about the peer.
 	default:
-		return nil, blobs.ErrNotFound
+		f.stats.record(dst, 0, false)
 	}
+	return res, err
 }
 
 func (f *Fetcher) getReq(ctx context.Context, nextHop p2p.PeerID, req *GetReq) (*GetRes, error) {
@@ -128,6 +392,18 @@ func (f *Fetcher) getReq(ctx context.Context, nextHop p2p.PeerID, req *GetReq) (
 	if err := proto.Unmarshal(resData, res); err != nil {
 		return nil, err
 	}
+	if err := peerrouting.CheckRouteError(res.RouteError); err != nil {
+		return nil, err
+	}
+	favors := bcstate.Favors{BytesServed: servedBytes(res)}
+	if rt := req.GetRoutingTag(); rt != nil && !bytes.Equal(rt.DstId, nextHop[:]) {
+		favors.RequestsForwarded = 1
+	}
+	if favors != (bcstate.Favors{}) {
+		if err := f.ledger.Received(nextHop, favors); err != nil {
+			log.Error(err)
+		}
+	}
 	return res, nil
 }
 
@@ -137,7 +413,15 @@ func (f *Fetcher) handleAsk(ctx context.Context, m *p2p.Message, w io.Writer) {
 		log.Error(err)
 		return
 	}
-	res, err := f.handleGetReq(ctx, req)
+	peer := m.Src.(p2p.PeerID)
+	if inDebt, err := f.ledger.InDebt(peer); err != nil {
+		log.Error(err)
+		return
+	} else if inDebt {
+		log.WithField("peer_id", peer).Warn("refusing get request from peer in debt")
+		return
+	}
+	res, err := f.handleGetReq(ctx, peer, req)
 	if err != nil {
 		log.Error(err)
 		return
@@ -149,10 +433,35 @@ func (f *Fetcher) handleAsk(ctx context.Context, m *p2p.Message, w io.Writer) {
 	w.Write(data)
 }
 
-func (f *Fetcher) handleGetReq(ctx context.Context, req *GetReq) (*GetRes, error) {
+func (f *Fetcher) handleGetReq(ctx context.Context, src p2p.PeerID, req *GetReq) (*GetRes, error) {
+	localID := f.peerSwarm.LocalID()
+	if rt := req.GetRoutingTag(); rt != nil && len(rt.DstId) > 0 && !bytes.Equal(rt.DstId, localID[:]) {
+		return f.forward(ctx, src, req)
+	}
+
+	var res *GetRes
+	var err error
+	if len(req.Sealed) > 0 {
+		res, err = f.handleSealed(ctx, req)
+	} else {
+		res, err = f.serve(ctx, src, req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if n := servedBytes(res); n > 0 {
+		if err := f.ledger.Gave(src, bcstate.Favors{BytesServed: n}); err != nil {
+			log.Error(err)
+		}
+	}
+	return res, nil
+}
+
+// serve responds to a request for a blob with the blob, or a redirect, on behalf of src.
+func (f *Fetcher) serve(ctx context.Context, src p2p.PeerID, req *GetReq) (*GetRes, error) {
+	localID := f.peerSwarm.LocalID()
 	// try local
-	id := blobs.ID{}
-	copy(id[:], req.BlobId)
+	id := blobs.IDFromBytes(req.BlobId)
 	res, err := f.tryLocal(ctx, id)
 	if err != nil {
 		return nil, err
@@ -161,12 +470,155 @@ func (f *Fetcher) handleGetReq(ctx context.Context, req *GetReq) (*GetRes, error
 		return res, nil
 	}
 
-	// TODO: use the routers, and support redirects
+	// redirect to a peer which has the blob
+	for _, ent := range f.blobRouter.Lookup(ctx, id) {
+		if ent.PeerID.Equals(localID) || ent.PeerID.Equals(src) {
+			continue
+		}
+		if res := f.redirect(id, ent.PeerID, true); res != nil {
+			return res, nil
+		}
+	}
+	// redirect to a peer which is closer to the blob, unless the requester thought we had it.
+	if !req.Found {
+		closest := f.peerRouter.Closest(id[:])
+		if !closest.Equals(localID) && !closest.Equals(src) && closer(id[:], closest, localID) {
+			if res := f.redirect(id, closest, false); res != nil {
+				return res, nil
+			}
+		}
+	}
 
 	// not found
 	return &GetRes{BlobId: req.BlobId}, nil
 }
 
+// forward sends req on to the next hop along its path, on behalf of src, and returns the response.
+// If the request can't be forwarded, the response says why.
+func (f *Fetcher) forward(ctx context.Context, src p2p.PeerID, req *GetReq) (*GetRes, error) {
+	rt2, nextHop, err := f.peerRouter.Forward(req.GetRoutingTag(), src)
+	if err != nil {
+		return routeError(req, err), nil
+	}
+	res, err := f.getReq(ctx, nextHop, &GetReq{
+		RoutingTag: rt2,
+		BlobId:     req.BlobId,
+		HashAlgo:   req.HashAlgo,
+		Found:      req.Found,
+		Sealed:     req.Sealed,
+	})
+	if err == peerrouting.ErrPathTooLong {
+		return routeError(req, err), nil
+	} else if err != nil {
+		log.WithField("next_hop", nextHop).Warn(err)
+		return routeError(req, peerrouting.ErrNoRouteToPeer), nil
+	}
+	favors := bcstate.Favors{RequestsForwarded: 1, BytesServed: servedBytes(res)}
+	if err := f.ledger.Gave(src, favors); err != nil {
+		log.Error(err)
+	}
+	return res, nil
+}
+
+// sealReq returns req sealed to dst, with only the routing tag left outside.
+func (f *Fetcher) sealReq(dst p2p.PeerID, req *GetReq) (*GetReq, error) {
+	key := f.peerRouter.KeyOf(dst)
+	if key == nil {
+		return nil, peerrouting.ErrNoPeerKey
+	}
+	data, err := proto.Marshal(&GetReq{
+		BlobId:   req.BlobId,
+		HashAlgo: req.HashAlgo,
+		Found:    req.Found,
+	})
+	if err != nil {
+		panic(err)
+	}
+	sealed, err := f.peerRouter.Seal(dst, key, data)
+	if err != nil {
+		return nil, err
+	}
+	return &GetReq{RoutingTag: req.RoutingTag, Sealed: sealed}, nil
+}
+
+// openRes returns the response sealed in res, which must have been signed by dst.
+func (f *Fetcher) openRes(dst p2p.PeerID, res *GetRes) (*GetRes, error) {
+	if len(res.Sealed) == 0 {
+		return nil, ErrNotSealed
+	}
+	signer, _, data, err := f.peerRouter.Open(res.Sealed)
+	if err != nil {
+		return nil, err
+	}
+	if !signer.Equals(dst) {
+		return nil, peerrouting.ErrBadSeal
+	}
+	res2 := &GetRes{}
+	if err := proto.Unmarshal(data, res2); err != nil {
+		return nil, err
+	}
+	return res2, nil
+}
+
+// handleSealed opens a request sealed to us, and seals the response to the peer which signed it.
+func (f *Fetcher) handleSealed(ctx context.Context, req *GetReq) (*GetRes, error) {
+	signer, signerKey, data, err := f.peerRouter.Open(req.Sealed)
+	if err != nil {
+		return nil, err
+	}
+	// the routing tag isn't signed, but it shouldn't claim the request is from someone else.
+	if srcID := req.GetRoutingTag().GetSrcId(); len(srcID) > 0 && !bytes.Equal(srcID, signer[:]) {
+		return nil, peerrouting.ErrBadSeal
+	}
+	req2 := &GetReq{}
+	if err := proto.Unmarshal(data, req2); err != nil {
+		return nil, err
+	}
+	res, err := f.serve(ctx, signer, req2)
+	if err != nil {
+		return nil, err
+	}
+	resData, err := proto.Marshal(res)
+	if err != nil {
+		panic(err)
+	}
+	sealed, err := f.peerRouter.Seal(signer, signerKey, resData)
+	if err != nil {
+		return nil, err
+	}
+	return &GetRes{Sealed: sealed}, nil
+}
+
+// servedBytes is the size of the blob in res, or of res's sealed contents, which peers forwarding it can't see into.
+func servedBytes(res *GetRes) uint64 {
+	if len(res.Sealed) > 0 {
+		return uint64(len(res.Sealed))
+	}
+	return uint64(len(res.GetData()))
+}
+
+func routeError(req *GetReq, err error) *GetRes {
+	code, _ := peerrouting.RouteErrorFor(err)
+	return &GetRes{BlobId: req.BlobId, RouteError: code}
+}
+
+// redirect returns a response redirecting the requester to peer, with a path relative to us.
+// It returns nil if there is no route to peer.
+func (f *Fetcher) redirect(id blobs.ID, peer p2p.PeerID, found bool) *GetRes {
+	path := f.peerRouter.PathTo(peer)
+	if path == nil {
+		return nil
+	}
+	return &GetRes{
+		BlobId: id[:],
+		Res: &GetRes_Redirect{Redirect: &GetReq{
+			RoutingTag: &RoutingTag{DstId: peer[:], Path: path},
+			BlobId:     id[:],
+			Found:      found,
+		}},
+	}
+}
+
 func (f *Fetcher) tryLocal(ctx context.Context, id blobs.ID) (*GetRes, error) {
 	var data []byte
 	err := f.local.GetF(ctx, id, func(data2 []byte) error {
@@ -176,7 +628,7 @@ func (f *Fetcher) tryLocal(ctx context.Context, id blobs.ID) (*GetRes, error) {
 	if err == nil {
 		return &GetRes{
 			BlobId: id[:],
-			Res:    &GetRes_Data{data},
+			Res:    &GetRes_Data{Data: data},
 		}, nil
 	}
 	if err == blobs.ErrNotFound {
@@ -184,3 +636,12 @@ func (f *Fetcher) tryLocal(ctx context.Context, id blobs.ID) (*GetRes, error) {
 	}
 	return nil, err
 }
+
+// closer returns true if a is closer to key than b
+func closer(key []byte, a, b p2p.PeerID) bool {
+	da := make([]byte, len(a))
+	db := make([]byte, len(b))
+	kademlia.XORBytes(da, key, a[:])
+	kademlia.XORBytes(db, key, b[:])
+	return bytes.Compare(da, db) < 0
+}
@@ -0,0 +1,339 @@
+package blobnet
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brendoncarroll/go-p2p"
+	"github.com/brendoncarroll/go-p2p/p2ptest"
+	"github.com/brendoncarroll/go-p2p/s/memswarm"
+	"github.com/jonboulle/clockwork"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blobcache/blobcache/pkg/bcstate"
+	"github.com/blobcache/blobcache/pkg/blobnet/peerrouting"
+	"github.com/blobcache/blobcache/pkg/blobnet/peers"
+	"github.com/blobcache/blobcache/pkg/blobs"
+)
+
+func TestFetchMultiHop(t *testing.T) {
+	ctx := context.TODO()
+	ids, locals, bns := newChain(t, 3)
+	a, b, c := bns[0], bns[1], bns[2]
+	require.NotNil(t, a.peerRouter.PathTo(ids[2]), "a should have learned a path to c")
+
+	data := []byte("test-data")
+	id, err := locals[2].Post(ctx, data)
+	require.NoError(t, err)
+
+	// a's request is forwarded through b
+	var got []byte
+	require.NoError(t, a.GetFrom(ctx, ids[2], id, func(x []byte) error {
+		got = append([]byte{}, x...)
+		return nil
+	}))
+	assert.Equal(t, data, got)
+
+	// b doesn't have the blob, but knows c does, so it redirects
+	require.NoError(t, b.blobRouter.Put(ctx, id, ids[2], time.Now()))
+	res, err := b.fetcher.handleGetReq(ctx, ids[0], &GetReq{
+		RoutingTag: &RoutingTag{DstId: ids[1][:]},
+		BlobId:     id[:],
+	})
+	require.NoError(t, err)
+	redirect := res.GetRedirect()
+	require.NotNil(t, redirect)
+	assert.Equal(t, ids[2][:], redirect.RoutingTag.DstId)
+	assert.True(t, redirect.Found)
+
+	// following the redirect from a reaches c, through b
+	visited := map[p2p.PeerID]struct{}{ids[1]: {}}
+	prev := &RoutingTag{DstId: ids[1][:], Path: a.peerRouter.PathTo(ids[1])}
+	rt, err := a.fetcher.followRedirect(prev, redirect, visited)
+	require.NoError(t, err)
+	res, err = a.fetcher.getVia(ctx, rt, &GetReq{BlobId: id[:], Found: true})
+	require.NoError(t, err)
+	assert.Equal(t, data, res.GetData())
+
+	// redirects back to a peer which was already asked are refused
+	visited[ids[2]] = struct{}{}
+	_, err = a.fetcher.followRedirect(prev, redirect, visited)
+	assert.Equal(t, ErrRedirectLoop, err)
+
+	// either a asks c through b, or b redirects a to c
+	got = nil
+	require.NoError(t, a.GetF(ctx, id, func(x []byte) error {
+		got = append([]byte{}, x...)
+		return nil
+	}))
+	assert.Equal(t, data, got)
+
+	// c can't find a blob nobody has
+	err = c.GetF(ctx, blobs.Hash([]byte("missing")), func([]byte) error { return nil })
+	assert.Equal(t, blobs.ErrNotFound, err)
+}
+
+func TestFetchHedged(t *testing.T) {
+	ctx := context.TODO()
+	clock := clockwork.NewFakeClock()
+	data := []byte("test-data")
+	// a is in the middle, and the first node hangs when asked for the blob.
+	var slow *slowStore
+	ids, locals, bns := newChainWith(t, 3, func(params *Params) {
+		params.Clock = clock
+		if slow == nil {
+			slow = &slowStore{
+				Store:     bcstate.BlobAdapter(&bcstate.MemKV{}),
+				id:        blobs.Hash(data),
+				asked:     make(chan struct{}),
+				cancelled: make(chan struct{}),
+			}
+			params.Local = slow
+		}
+	})
+	a := bns[1]
+
+	id, err := locals[2].Post(ctx, data)
+	require.NoError(t, err)
+
+	// a thinks both peers have the blob, and saw it on the slow one more recently.
+	now := clock.Now()
+	require.NoError(t, a.blobRouter.Put(ctx, id, ids[0], now))
+	require.NoError(t, a.blobRouter.Put(ctx, id, ids[2], now.Add(-time.Hour)))
+	srcs := a.fetcher.sources(ctx, id)
+	require.Len(t, srcs, 2)
+	assert.Equal(t, ids[0], srcs[0].peer)
+
+	var got []byte
+	done := make(chan error, 1)
+	go func() {
+		done <- a.GetF(ctx, id, func(x []byte) error {
+			got = append([]byte{}, x...)
+			return nil
+		})
+	}()
+	select {
+	case <-slow.asked:
+	case err := <-done:
+		t.Fatal("slow peer was not asked first", err)
+	}
+	select {
+	case <-done:
+		t.Fatal("asked the next source before the clock moved")
+	case <-time.After(2 * DefaultLatency):
+	}
+	// advance the clock until the hedge fires, and the other peer is asked.
+	for waiting := true; waiting; {
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+			waiting = false
+		case <-time.After(time.Millisecond):
+			clock.Advance(DefaultLatency)
+		}
+	}
+	assert.Equal(t, data, got)
+	assert.Equal(t, uint64(1), a.fetcher.PeerStats(ids[2]).Successes)
+
+	// the slow request is cancelled, and isn't counted against the peer.
+	select {
+	case <-slow.cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("slow request was not cancelled")
+	}
+	assert.Equal(t, PeerStats{}, a.fetcher.PeerStats(ids[0]))
+}
+
+// slowStore is a blobs.Store which blocks the first get for id, until it is cancelled.
+type slowStore struct {
+	blobs.Store
+	id               blobs.ID
+	asked, cancelled chan struct{}
+	once             sync.Once
+}
+
+func (s *slowStore) GetF(ctx context.Context, id blobs.ID, fn func([]byte) error) error {
+	first := false
+	if id == s.id {
+		s.once.Do(func() { first = true })
+	}
+	if !first {
+		return s.Store.GetF(ctx, id, fn)
+	}
+	close(s.asked)
+	<-ctx.Done()
+	close(s.cancelled)
+	return ctx.Err()
+}
+
+func TestPeerStatsSort(t *testing.T) {
+	ps := newPeerStats()
+	peers := []p2p.PeerID{{1}, {2}, {3}, {4}}
+	ps.record(peers[0], time.Second, true)
+	ps.record(peers[1], time.Millisecond, true)
+	ps.record(peers[2], time.Millisecond, false)
+	// peers[3] is unknown
+	ps.sort(peers)
+	assert.Equal(t, []p2p.PeerID{{2}, {4}, {3}, {1}}, peers)
+}
+
+// newChain returns n bootstrapped Blobnets, each connected to the next.
+func newChain(t *testing.T, n int) ([]p2p.PeerID, []blobs.Store, []*Blobnet) {
+	return newChainWith(t, n, func(*Params) {})
+}
+
+// newChainWith is newChain, with the params of each Blobnet changed by fn.
+func newChainWith(t *testing.T, n int, fn func(*Params)) ([]p2p.PeerID, []blobs.Store, []*Blobnet) {
+	links := make([][]int, n)
+	for i := range links {
+		if i > 0 {
+			links[i] = append(links[i], i-1)
+		}
+		if i < n-1 {
+			links[i] = append(links[i], i+1)
+		}
+	}
+	return newNetwork(t, links, fn)
+}
+
+// newNetwork returns a bootstrapped Blobnet for each entry in links, connected to the Blobnets it lists.
+// The params of each Blobnet are changed by fn.
+func newNetwork(t *testing.T, links [][]int, fn func(*Params)) ([]p2p.PeerID, []blobs.Store, []*Blobnet) {
+	ctx := context.TODO()
+	n := len(links)
+	realm := memswarm.NewRealm()
+	swarms := make([]p2p.SecureAskSwarm, n)
+	keys := make([]p2p.PrivateKey, n)
+	ids := make([]p2p.PeerID, n)
+	for i := range swarms {
+		keys[i] = p2ptest.NewTestKey(t, i)
+		swarms[i] = realm.NewSwarmWithKey(keys[i])
+		ids[i] = p2p.NewPeerID(swarms[i].PublicKey())
+	}
+
+	locals := make([]blobs.Store, n)
+	bns := make([]*Blobnet, n)
+	for i := range swarms {
+		peerStore := make(peers.MemPeerStore)
+		for _, j := range links[i] {
+			peerStore.AddAddr(ids[j], swarms[j].LocalAddrs()[0])
+		}
+		locals[i] = bcstate.BlobAdapter(&bcstate.MemKV{})
+		params := Params{
+			PeerStore:  peerStore,
+			DB:         &bcstate.MemDB{},
+			Local:      locals[i],
+			Clock:      clockwork.NewRealClock(),
+			PrivateKey: keys[i],
+		}
+		fn(&params)
+		bns[i] = newTestBlobnet(swarms[i], params)
+		bn := bns[i]
+		t.Cleanup(func() { bn.Close() })
+	}
+	// the peers of the first nodes didn't exist when they bootstrapped, so do it again.
+	for i := range bns {
+		<-bns[i].bootstrapped
+	}
+	for i := range bns {
+		bns[i].bootstrap(ctx)
+	}
+	return ids, locals, bns
+}
+
+func TestFetchFailover(t *testing.T) {
+	ctx := context.TODO()
+	// a diamond: 0 can reach 3 through 1 or 2
+	var peerStores []peers.MemPeerStore
+	ids, locals, bns := newNetwork(t, [][]int{{1, 2}, {0, 3}, {0, 3}, {1, 2}}, func(params *Params) {
+		peerStores = append(peerStores, params.PeerStore.(peers.MemPeerStore))
+	})
+	a := bns[0]
+	paths := a.peerRouter.PathsTo(ids[3])
+	require.Len(t, paths, 2)
+	_, primary := a.peerRouter.ForwardWhere(&RoutingTag{DstId: ids[3][:], Path: paths[0]})
+
+	data := []byte("test-data")
+	id, err := locals[3].Post(ctx, data)
+	require.NoError(t, err)
+
+	// the primary next hop loses its link to 3, so only the other path works.
+	for i := range ids {
+		if ids[i].Equals(primary) {
+			delete(peerStores[i], ids[3])
+		}
+	}
+	var got []byte
+	require.NoError(t, a.GetFrom(ctx, ids[3], id, func(x []byte) error {
+		got = append([]byte{}, x...)
+		return nil
+	}))
+	assert.Equal(t, data, got)
+}
+
+func TestFetchStaleEntry(t *testing.T) {
+	ctx := context.TODO()
+	ids, _, bns := newChain(t, 2)
+	b := bns[1]
+
+	// b thinks a has a blob, which it doesn't.
+	id := blobs.Hash([]byte("test-data"))
+	require.NoError(t, b.blobRouter.Put(ctx, id, ids[0], time.Now()))
+	err := b.GetF(ctx, id, func([]byte) error { return nil })
+	require.Equal(t, blobs.ErrNotFound, err)
+	require.Len(t, b.blobRouter.Lookup(ctx, id), 0)
+}
+
+func TestFetchSealed(t *testing.T) {
+	ctx := context.TODO()
+	ids, locals, bns := newChainWith(t, 3, func(params *Params) {
+		params.SealMessages = true
+	})
+	a, b, c := bns[0], bns[1], bns[2]
+	require.NotNil(t, a.peerRouter.KeyOf(ids[2]), "a should have learned c's key")
+
+	data := []byte("test-data")
+	id, err := locals[2].Post(ctx, data)
+	require.NoError(t, err)
+
+	// a's request is forwarded through b, sealed
+	var got []byte
+	require.NoError(t, a.GetFrom(ctx, ids[2], id, func(x []byte) error {
+		got = append([]byte{}, x...)
+		return nil
+	}))
+	assert.Equal(t, data, got)
+	got = nil
+	require.NoError(t, a.GetF(ctx, id, func(x []byte) error {
+		got = append([]byte{}, x...)
+		return nil
+	}))
+	assert.Equal(t, data, got)
+
+	// b only sees the routing tag, and can't open the rest
+	req, err := a.fetcher.sealReq(ids[2], &GetReq{
+		RoutingTag: &RoutingTag{DstId: ids[2][:], SrcId: ids[0][:]},
+		BlobId:     id[:],
+	})
+	require.NoError(t, err)
+	assert.Nil(t, req.BlobId)
+	_, _, _, err = b.peerRouter.Open(req.Sealed)
+	assert.Equal(t, peerrouting.ErrBadSeal, err)
+
+	// c can, and knows it is from a
+	signer, _, _, err := c.peerRouter.Open(req.Sealed)
+	require.NoError(t, err)
+	assert.Equal(t, ids[0], signer)
+
+	// tampering is detected
+	req.Sealed[len(req.Sealed)-1] ^= 1
+	_, _, _, err = c.peerRouter.Open(req.Sealed)
+	assert.Equal(t, peerrouting.ErrBadSeal, err)
+
+	// requests can't be sealed to peers whose key isn't known
+	_, err = a.fetcher.sealReq(p2p.PeerID{1}, &GetReq{BlobId: id[:]})
+	assert.Equal(t, peerrouting.ErrNoPeerKey, err)
+}
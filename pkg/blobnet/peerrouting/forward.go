@@ -0,0 +1,123 @@
+package peerrouting
+
+import (
+	"github.com/blobcache/blobcache/pkg/blobnet/bcproto"
+	"github.com/brendoncarroll/go-p2p"
+)
+
+// Forward returns the routing tag to send to the next hop of rt, and the next hop.
+// rt.Path is relative to us, so its first index is the link to the next hop, unless we know a shorter path to the destination.
+// If rt.Path is empty, our own path to the destination is used.
+// src is the peer rt was received from, or the zero PeerID if the message starts here.
+//
+// Every hop is checked: the next hop must be one of our links, and must not be src.
+// The reverse path is extended with the link to src, so the destination can reply without a path of its own.
+func (r *Router) Forward(rt *RoutingTag, src p2p.PeerID) (*RoutingTag, p2p.PeerID, error) {
+	zero := p2p.ZeroPeerID()
+	if rt == nil {
+		return nil, zero, ErrNoRouteToPeer
+	}
+	if len(rt.Path) > MaxPathLen || len(rt.ReversePath) >= MaxPathLen {
+		return nil, zero, ErrPathTooLong
+	}
+	rt2 := &RoutingTag{
+		DstId:       rt.DstId,
+		SrcId:       rt.SrcId,
+		ReversePath: rt.ReversePath,
+	}
+	nextHop := zero
+	if len(rt.Path) > 0 {
+		rt2.Path = rt.Path[1:]
+		nextHop = r.lm.Peer(int(rt.Path[0]))
+	}
+
+	// see if we know a shorter route, or any route if rt has run out.
+	dstID := p2p.PeerID{}
+	copy(dstID[:], rt.DstId)
+	if path := r.PathTo(dstID); path != nil && (len(rt.Path) == 0 || len(path) < len(rt.Path)) {
+		rt2.Path = path[1:]
+		nextHop = r.lm.Peer(int(path[0]))
+	}
+
+	if !r.isLink(nextHop) || nextHop.Equals(src) {
+		return nil, zero, ErrNoRouteToPeer
+	}
+	if src.Equals(zero) {
+		localID := r.peerSwarm.LocalID()
+		rt2.SrcId = localID[:]
+		rt2.ReversePath = nil
+	} else {
+		rt2.ReversePath = append(Path{uint64(r.lm.Int(src))}, rt.ReversePath...)
+	}
+	return rt2, nextHop, nil
+}
+
+// ReplyTag returns a routing tag, relative to us, back to the source of rt, which was received from src.
+// It can be sent with Forward like any other.
+// The router learns the path in it when it is the destination of a forwarded query.
+func (r *Router) ReplyTag(rt *RoutingTag, src p2p.PeerID) *RoutingTag {
+	dstID := rt.GetSrcId()
+	if len(dstID) == 0 {
+		dstID = src[:]
+	}
+	return &RoutingTag{
+		DstId: dstID,
+		Path:  append(Path{uint64(r.lm.Int(src))}, rt.GetReversePath()...),
+	}
+}
+
+// learnReply adds the path back to the source of rt, which was received from src, if we have no path to the source.
+// Like a stored path, all that is known about it is its length, and it is dropped if it fails when the source is queried.
+func (r *Router) learnReply(rt *RoutingTag, src p2p.PeerID) {
+	if len(rt.GetSrcId()) != len(p2p.PeerID{}) || len(rt.GetReversePath()) == 0 {
+		return
+	}
+	// the reply path is one hop longer than the reverse path.
+	if len(rt.GetReversePath()) >= MaxPathLen {
+		return
+	}
+	srcID := p2p.PeerID{}
+	copy(srcID[:], rt.SrcId)
+	if r.PathTo(srcID) != nil {
+		return
+	}
+	p := r.ReplyTag(rt, src).Path
+	r.putPeer(srcID, route{path: p, extraHops: len(p) - 1})
+}
+
+// isLink returns true if peer is one of our one-hop peers.
+func (r *Router) isLink(peer p2p.PeerID) bool {
+	if peer.Equals(p2p.ZeroPeerID()) || peer.Equals(r.peerSwarm.LocalID()) {
+		return false
+	}
+	for _, id := range r.peerSwarm.ListPeers() {
+		if id.Equals(peer) {
+			return true
+		}
+	}
+	return false
+}
+
+// RouteErrorFor returns the RouteError to respond with in place of a response, if err is a routing error.
+func RouteErrorFor(err error) (RouteError, bool) {
+	switch err {
+	case ErrNoRouteToPeer:
+		return bcproto.RouteError_NO_ROUTE, true
+	case ErrPathTooLong:
+		return bcproto.RouteError_PATH_TOO_LONG, true
+	default:
+		return bcproto.RouteError_ROUTE_OK, false
+	}
+}
+
+// CheckRouteError returns the error for a RouteError in a response, or nil if there was none.
+func CheckRouteError(x RouteError) error {
+	switch x {
+	case bcproto.RouteError_ROUTE_OK:
+		return nil
+	case bcproto.RouteError_PATH_TOO_LONG:
+		return ErrPathTooLong
+	default:
+		return ErrNoRouteToPeer
+	}
+}
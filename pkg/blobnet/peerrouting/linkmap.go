@@ -1,44 +1,233 @@
 package peerrouting
 
 import (
+	"crypto/rand"
+	"encoding/binary"
 	"sync"
+	"time"
 
+	"github.com/blobcache/blobcache/pkg/bcstate"
 	"github.com/brendoncarroll/go-p2p"
+	"github.com/jonboulle/clockwork"
+	log "github.com/sirupsen/logrus"
 )
 
+// LinkQuarantine is how long the index of a removed link is kept before it is given to another peer.
+// Paths through the removed link will have failed, and been dropped, long before then.
+const LinkQuarantine = 24 * time.Hour
+
+var epochKey = []byte("epoch")
+
+// LinkMap assigns an index to every link, which is what the first hop of a path refers to.
+//
+// Indices are persisted, so paths which peers have learned through us stay valid across restarts.
+// The index of a removed link is quarantined, and only given to another peer after LinkQuarantine.
+// Whenever an index is given to a different peer, or the assignments are lost, the epoch changes,
+// so peers can tell that paths they learned from us may be stale.
+// Changes are only written by Flush.
 type LinkMap struct {
-	mu   sync.RWMutex
-	n    int
-	atoi map[p2p.PeerID]int
-	itoa map[int]p2p.PeerID
+	kv    bcstate.KV
+	clock clockwork.Clock
+
+	mu      sync.RWMutex
+	n       int
+	epoch   uint64
+	atoi    map[p2p.PeerID]int
+	itoa    map[int]p2p.PeerID
+	removed map[int]time.Time
+
+	// dirty holds the links which changed since the last Flush.
+	dirty      map[p2p.PeerID]struct{}
+	dirtyEpoch bool
 }
 
-func NewLinkMap() *LinkMap {
-	return &LinkMap{
-		n:    0,
-		atoi: make(map[p2p.PeerID]int),
-		itoa: make(map[int]p2p.PeerID),
+func NewLinkMap(kv bcstate.KV, clock clockwork.Clock) *LinkMap {
+	lm := &LinkMap{
+		kv:      kv,
+		clock:   clock,
+		n:       0,
+		atoi:    make(map[p2p.PeerID]int),
+		itoa:    make(map[int]p2p.PeerID),
+		removed: make(map[int]time.Time),
+		dirty:   make(map[p2p.PeerID]struct{}),
+	}
+	if err := lm.load(); err != nil {
+		log.Error("loading link map: ", err)
 	}
+	return lm
 }
 
+// Int returns the index of the link to id, assigning one if necessary.
+// If id was removed, it gets its old index back.
 func (lm *LinkMap) Int(id p2p.PeerID) int {
 	lm.mu.Lock()
 	defer lm.mu.Unlock()
 
 	i, ok := lm.atoi[id]
 	if ok {
+		if _, ok := lm.removed[i]; ok {
+			delete(lm.removed, i)
+			lm.dirty[id] = struct{}{}
+		}
 		return i
 	}
 
-	i = lm.n
-	lm.n++
+	if i, ok = lm.recyclable(); ok {
+		prev := lm.itoa[i]
+		delete(lm.atoi, prev)
+		delete(lm.removed, i)
+		lm.dirty[prev] = struct{}{}
+		lm.setEpoch(lm.epoch + 1)
+	} else {
+		i = lm.n
+		lm.n++
+	}
 	lm.atoi[id] = i
 	lm.itoa[i] = id
+	lm.dirty[id] = struct{}{}
 	return i
 }
 
+// Lookup returns the index of the link to id, without assigning one.
+// Links which have been removed have no index, even while it is quarantined.
+func (lm *LinkMap) Lookup(id p2p.PeerID) (int, bool) {
+	lm.mu.RLock()
+	defer lm.mu.RUnlock()
+	i, ok := lm.atoi[id]
+	if !ok {
+		return 0, false
+	}
+	if _, removed := lm.removed[i]; removed {
+		return 0, false
+	}
+	return i, true
+}
+
 func (lm *LinkMap) Peer(i int) p2p.PeerID {
 	lm.mu.RLock()
 	defer lm.mu.RUnlock()
 	return lm.itoa[i]
 }
+
+// Epoch returns the current epoch.
+func (lm *LinkMap) Epoch() uint64 {
+	lm.mu.RLock()
+	defer lm.mu.RUnlock()
+	return lm.epoch
+}
+
+// Retain removes the links to every peer not in ids, starting their quarantine.
+func (lm *LinkMap) Retain(ids []p2p.PeerID) {
+	keep := make(map[p2p.PeerID]struct{}, len(ids))
+	for _, id := range ids {
+		keep[id] = struct{}{}
+	}
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	now := lm.clock.Now()
+	for id, i := range lm.atoi {
+		if _, ok := keep[id]; ok {
+			continue
+		}
+		if _, ok := lm.removed[i]; ok {
+			continue
+		}
+		lm.removed[i] = now
+		lm.dirty[id] = struct{}{}
+	}
+}
+
+// recyclable returns the index which has been quarantined the longest, if its quarantine is over.
+func (lm *LinkMap) recyclable() (int, bool) {
+	var oldest int
+	var oldestAt time.Time
+	found := false
+	for i, at := range lm.removed {
+		if !found || at.Before(oldestAt) {
+			oldest, oldestAt, found = i, at, true
+		}
+	}
+	if !found || lm.clock.Since(oldestAt) < LinkQuarantine {
+		return 0, false
+	}
+	return oldest, true
+}
+
+// Flush writes the links and the epoch which changed since the last Flush.
+// If a write fails, what is left is written by the next Flush.
+func (lm *LinkMap) Flush() error {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	if lm.dirtyEpoch {
+		var v [8]byte
+		binary.BigEndian.PutUint64(v[:], lm.epoch)
+		if err := lm.kv.Put(epochKey, v[:]); err != nil {
+			return err
+		}
+		lm.dirtyEpoch = false
+	}
+	for id := range lm.dirty {
+		if err := lm.save(id); err != nil {
+			return err
+		}
+		delete(lm.dirty, id)
+	}
+	return nil
+}
+
+// save writes the link to id, or deletes it if id no longer has an index.
+// The value is the index, followed by when it was removed, or 0.
+func (lm *LinkMap) save(id p2p.PeerID) error {
+	i, ok := lm.atoi[id]
+	if !ok {
+		return lm.kv.Delete(id[:])
+	}
+	var v [16]byte
+	binary.BigEndian.PutUint64(v[:8], uint64(i))
+	if at, ok := lm.removed[i]; ok {
+		binary.BigEndian.PutUint64(v[8:], uint64(at.Unix()))
+	}
+	return lm.kv.Put(id[:], v[:])
+}
+
+func (lm *LinkMap) setEpoch(epoch uint64) {
+	lm.epoch = epoch
+	lm.dirtyEpoch = true
+}
+
+func (lm *LinkMap) load() error {
+	err := lm.kv.GetF(epochKey, func(v []byte) error {
+		if len(v) == 8 {
+			lm.epoch = binary.BigEndian.Uint64(v)
+		}
+		return nil
+	})
+	if err == bcstate.ErrNotExist {
+		// we have no record of earlier assignments, so start an epoch no one could have seen.
+		var v [8]byte
+		if _, err := rand.Read(v[:]); err != nil {
+			return err
+		}
+		lm.setEpoch(binary.BigEndian.Uint64(v[:]))
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return lm.kv.ForEach(nil, nil, func(k, v []byte) error {
+		if len(k) != len(p2p.PeerID{}) || len(v) != 16 {
+			return nil
+		}
+		id := p2p.PeerID{}
+		copy(id[:], k)
+		i := int(binary.BigEndian.Uint64(v[:8]))
+		lm.atoi[id] = i
+		lm.itoa[i] = id
+		if at := binary.BigEndian.Uint64(v[8:]); at != 0 {
+			lm.removed[i] = time.Unix(int64(at), 0)
+		}
+		if i >= lm.n {
+			lm.n = i + 1
+		}
+		return nil
+	})
+}
@@ -0,0 +1,137 @@
+package peerrouting
+
+import (
+	"sync"
+	"time"
+
+	"github.com/brendoncarroll/go-p2p"
+)
+
+const (
+	// DefaultRTT is the round trip time assumed for peers which have not responded yet.
+	DefaultRTT = 100 * time.Millisecond
+	// hopReliability is the chance assumed for each extra hop in a path to work.
+	hopReliability = 0.9
+	// reliabilityRetention is how long the reliability of a peer is kept after it is dropped from the cache,
+	// so a peer which keeps failing is not treated as new each time it is relearned.
+	reliabilityRetention = time.Hour
+)
+
+// Reliability is what the router knows about how well a peer responds to queries.
+type Reliability struct {
+	Successes uint64
+	Failures  uint64
+	// RTT is a moving average of the round trip time of successful queries.
+	RTT time.Duration
+	// UpSince is when the peer started responding without failures. It is zero if the last query failed.
+	UpSince time.Time
+	// Overlap is the number of peers in the peer's last response which are also in our peer list.
+	Overlap int
+}
+
+// SuccessRate is the estimated chance of a query to the peer succeeding.
+// Peers with no history are given even odds.
+func (rel Reliability) SuccessRate() float64 {
+	return float64(rel.Successes+1) / float64(rel.Successes+rel.Failures+2)
+}
+
+// score is higher for peers which are more useful to keep.
+// It is the rate of successful queries, weighted up to double for peers which have been up for an hour or more.
+func (rel Reliability) score(now time.Time) float64 {
+	rtt := rel.RTT
+	if rel.Successes == 0 {
+		rtt = DefaultRTT
+	}
+	stability := 0.0
+	if !rel.UpSince.IsZero() {
+		uptime := now.Sub(rel.UpSince)
+		stability = float64(uptime) / float64(uptime+time.Hour)
+	}
+	return rel.SuccessRate() * (1 + stability) / rtt.Seconds()
+}
+
+// worse returns true if a peer with a is less useful to keep than a peer with b.
+// Peers which share fewer routes with us are worse, then peers which are less reliable.
+func (rel Reliability) worse(b Reliability, now time.Time) bool {
+	if rel.Overlap != b.Overlap {
+		return rel.Overlap < b.Overlap
+	}
+	return rel.score(now) < b.score(now)
+}
+
+// reliabilities keeps the Reliability of every peer the router queries.
+// Peers which have been dropped are kept for reliabilityRetention.
+type reliabilities struct {
+	mu   sync.Mutex
+	m    map[p2p.PeerID]Reliability
+	gone map[p2p.PeerID]time.Time
+}
+
+func newReliabilities() *reliabilities {
+	return &reliabilities{
+		m:    make(map[p2p.PeerID]Reliability),
+		gone: make(map[p2p.PeerID]time.Time),
+	}
+}
+
+func (rs *reliabilities) record(peer p2p.PeerID, rtt time.Duration, success bool, now time.Time) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	delete(rs.gone, peer)
+	rel := rs.m[peer]
+	if !success {
+		rel.Failures++
+		rel.UpSince = time.Time{}
+		rs.m[peer] = rel
+		return
+	}
+	if rel.Successes == 0 {
+		rel.RTT = rtt
+	} else {
+		rel.RTT = (rel.RTT*7 + rtt) / 8
+	}
+	if rel.UpSince.IsZero() {
+		rel.UpSince = now
+	}
+	rel.Successes++
+	rs.m[peer] = rel
+}
+
+func (rs *reliabilities) setOverlap(peer p2p.PeerID, overlap int) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rel := rs.m[peer]
+	rel.Overlap = overlap
+	rs.m[peer] = rel
+}
+
+func (rs *reliabilities) get(peer p2p.PeerID) Reliability {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.m[peer]
+}
+
+// forget marks peer as dropped, and deletes peers which were dropped more than reliabilityRetention ago.
+func (rs *reliabilities) forget(peer p2p.PeerID, now time.Time) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if _, exists := rs.m[peer]; exists {
+		rs.gone[peer] = now
+	}
+	for id, since := range rs.gone {
+		if now.Sub(since) > reliabilityRetention {
+			delete(rs.m, id)
+			delete(rs.gone, id)
+		}
+	}
+}
+
+// pathReliability is the estimated chance of a path learned from via working.
+// The path to via has been tested by querying via, but each hop after it is assumed to fail sometimes.
+func pathReliability(via Reliability, extraHops int) float64 {
+	x := via.SuccessRate()
+	for i := 0; i < extraHops; i++ {
+		x *= hopReliability
+	}
+	return x
+}
@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/blobcache/blobcache/pkg/bcstate"
 	"github.com/blobcache/blobcache/pkg/blobnet/bcproto"
 	"github.com/brendoncarroll/go-p2p"
 	"github.com/brendoncarroll/go-p2p/p/kademlia"
@@ -21,12 +24,22 @@ type (
 	PeerInfo     = bcproto.PeerInfo
 	ListPeersReq = bcproto.ListPeersReq
 	ListPeersRes = bcproto.ListPeersRes
+	RouteError   = bcproto.RouteError
 
 	Path = []uint64
 )
 
+const (
+	// MaxPaths is the most paths kept to each peer.
+	MaxPaths = 3
+	// MaxPathLen is the most hops a message can travel.
+	MaxPathLen = 16
+)
+
 var (
 	ErrNoRouteToPeer = errors.New("no route to peer")
+	ErrPathTooLong   = errors.New("path is too long")
+	ErrPeerInDebt    = errors.New("peer owes more than it is trusted with")
 )
 
 type PeerSwarm interface {
@@ -44,18 +57,36 @@ type RouterParams struct {
 	QueryPeriod time.Duration
 	CacheSize   int
 	Clock       clockwork.Clock
+	// Ledger records requests forwarded for and by peers. If it is nil, nothing is recorded.
+	Ledger *bcstate.Ledger
+	// DB is where learned paths are kept across restarts. If it is nil, they are only kept in memory.
+	DB bcstate.DB
+	// PrivateKey signs our peer key, and the messages we seal.
+	// If it is nil, messages can't be sealed to or by us.
+	PrivateKey p2p.PrivateKey
 }
 
 type Router struct {
 	peerSwarm   PeerSwarm
 	clock       clockwork.Clock
 	queryPeriod time.Duration
+	ledger      *bcstate.Ledger
+
+	lm    *LinkMap
+	paths pathStore
+	cf    context.CancelFunc
+
+	// dirty holds the peers whose paths changed since they were last written.
+	dirtyMu sync.Mutex
+	dirty   map[p2p.PeerID]struct{}
 
-	lm *LinkMap
-	cf context.CancelFunc
+	rel  *reliabilities
+	box  *boxKeys
+	keys *peerKeys
 
-	mu    sync.RWMutex
-	cache *kademlia.Cache
+	mu     sync.RWMutex
+	cache  *kademlia.Cache
+	onDrop func(p2p.PeerID)
 }
 
 func NewRouter(params RouterParams) *Router {
@@ -69,7 +100,12 @@ func NewRouter(params RouterParams) *Router {
 		cacheSize = 128
 	}
 
-	lm := NewLinkMap()
+	db := params.DB
+	if db == nil {
+		db = &bcstate.MemDB{}
+	}
+
+	lm := NewLinkMap(db.Bucket("links"), params.Clock)
 	lm.Int(peerSwarm.LocalID())
 
 	ctx, cf := context.WithCancel(context.Background())
@@ -79,12 +115,25 @@ func NewRouter(params RouterParams) *Router {
 		peerSwarm:   peerSwarm,
 		queryPeriod: queryPeriod,
 		clock:       params.Clock,
+		ledger:      params.Ledger,
 
-		cf: cf,
-		lm: lm,
+		cf:    cf,
+		lm:    lm,
+		paths: pathStore{kv: db.Bucket("paths"), lm: lm},
+		dirty: make(map[p2p.PeerID]struct{}),
+		rel:   newReliabilities(),
+		keys:  newPeerKeys(),
 
 		cache: kademlia.NewCache(localID[:], cacheSize, 1),
 	}
+	if params.PrivateKey != nil {
+		box, err := newBoxKeys(params.PrivateKey)
+		if err != nil {
+			panic(err)
+		}
+		r.box = box
+	}
+	r.loadPaths()
 
 	peerSwarm.OnAsk(r.handleAsk)
 
@@ -92,9 +141,22 @@ func NewRouter(params RouterParams) *Router {
 	return r
 }
 
+// Close stops the router, and writes any paths which have not been written yet.
 func (r *Router) Close() error {
 	r.cf()
-	return r.peerSwarm.Close()
+	err := r.flush()
+	if err2 := r.peerSwarm.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// OnDrop sets fn to be called with every peer which is removed from the cache,
+// either because it failed to respond, or to make room for a closer peer.
+func (r *Router) OnDrop(fn func(p2p.PeerID)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onDrop = fn
 }
 
 // Lookup returns a routing tag, and an address for the next hop peer
@@ -127,38 +189,52 @@ func (r *Router) PathTo(id p2p.PeerID) Path {
 		}
 		return nil
 	}
-	return append(Path{}, x.(Path)...)
+	return append(Path{}, x.([]route)[0].path...)
 }
 
-// ForwardWhere looks up which peer rt.Path[0] corresponds to and returns
-// their id, and a modified routing tag for the next peer.
-// if routing tag is nil, the caller should abandon any attempt to forward the message.
-func (r *Router) ForwardWhere(rt *RoutingTag) (*RoutingTag, p2p.PeerID) {
-	if rt == nil {
-		return nil, p2p.ZeroPeerID()
+// PathsTo returns every path to a peer, best first.
+func (r *Router) PathsTo(id p2p.PeerID) []Path {
+	r.mu.RLock()
+	x := r.cache.Get(id[:])
+	r.mu.RUnlock()
+	if x == nil {
+		if path := r.PathTo(id); path != nil {
+			return []Path{path}
+		}
+		return nil
 	}
+	var paths []Path
+	for _, rte := range x.([]route) {
+		paths = append(paths, append(Path{}, rte.path...))
+	}
+	return paths
+}
 
-	// see if we know a shorter route
-	dstID := p2p.PeerID{}
-	copy(dstID[:], rt.DstId)
-	rt2, nextHop := r.Lookup(dstID)
-	if rt2 != nil && len(rt2.Path) < len(rt.Path) {
-		return rt2, nextHop
+// TryPaths calls fn with a routing tag, relative to us, for each of our paths to dst in turn, best first,
+// until fn returns nil or ctx is done.
+// It returns the last error from fn, or ErrNoRouteToPeer if there are no paths to dst.
+func (r *Router) TryPaths(ctx context.Context, dst p2p.PeerID, fn func(rt *RoutingTag) error) error {
+	err := ErrNoRouteToPeer
+	for _, path := range r.PathsTo(dst) {
+		if err = fn(&RoutingTag{DstId: dst[:], Path: path}); err == nil || ctx.Err() != nil {
+			return err
+		}
+		log.WithFields(log.Fields{
+			"peer_id": dst,
+			"path":    path,
+		}).Debug("trying next path: ", err)
 	}
+	return err
+}
 
-	// lookup the peer at index
-	if len(rt.Path) == 0 {
+// ForwardWhere is Forward for a message which starts here.
+// If the returned routing tag is nil, the caller should abandon any attempt to send the message.
+func (r *Router) ForwardWhere(rt *RoutingTag) (*RoutingTag, p2p.PeerID) {
+	rt2, nextHop, err := r.Forward(rt, p2p.ZeroPeerID())
+	if err != nil {
 		return nil, p2p.ZeroPeerID()
 	}
-	id := r.lm.Peer(int(rt.Path[0]))
-	if id == p2p.ZeroPeerID() {
-		return nil, id
-	}
-	rt2 = &RoutingTag{
-		DstId: rt.DstId,
-		Path:  rt.Path[1:],
-	}
-	return rt2, id
+	return rt2, nextHop
 }
 
 func (r *Router) Closest(key []byte) p2p.PeerID {
@@ -208,11 +284,14 @@ func (r *Router) MultiHop() []p2p.PeerID {
 }
 
 func (r *Router) GetPeerInfos() []*PeerInfo {
+	epoch := r.lm.Epoch()
 	peerInfos := []*PeerInfo{}
 	for _, peerID := range r.OneHop() {
+		peerID := peerID
 		pinfo := &PeerInfo{
-			Id:   peerID[:],
-			Path: Path{uint64(r.lm.Int(peerID))},
+			Id:        peerID[:],
+			Path:      Path{uint64(r.lm.Int(peerID))},
+			LinkEpoch: epoch,
 		}
 		peerInfos = append(peerInfos, pinfo)
 	}
@@ -220,12 +299,13 @@ func (r *Router) GetPeerInfos() []*PeerInfo {
 	r.mu.RLock()
 	r.cache.ForEach(func(e kademlia.Entry) bool {
 		path := []uint64{}
-		for _, index := range e.Value.(Path) {
+		for _, index := range e.Value.([]route)[0].path {
 			path = append(path, uint64(index))
 		}
 		pinfo := &PeerInfo{
-			Id:   e.Key,
-			Path: path,
+			Id:        e.Key,
+			Path:      path,
+			LinkEpoch: epoch,
 		}
 		peerInfos = append(peerInfos, pinfo)
 		return true
@@ -234,17 +314,29 @@ func (r *Router) GetPeerInfos() []*PeerInfo {
 	return peerInfos
 }
 
-func (r *Router) Bootstrap(ctx context.Context) {
+// Bootstrap queries peers until no more are found.
+// It returns an error if the paths which were found could not be written.
+func (r *Router) Bootstrap(ctx context.Context) error {
 	const max = 10
-	lastCount := r.cache.Count()
+	lastCount, _ := r.cacheCount()
 	for i := 0; i < max; i++ {
-		r.queryPeers(ctx)
-		count := r.cache.Count()
-		if r.cache.IsFull() || count <= lastCount {
+		if err := r.queryPeers(ctx); err != nil {
+			return err
+		}
+		count, full := r.cacheCount()
+		if full || count <= lastCount {
 			break
 		}
 		lastCount = count
 	}
+	return nil
+}
+
+// cacheCount returns the number of peers in the cache, and whether it is full.
+func (r *Router) cacheCount() (int, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cache.Count(), r.cache.IsFull()
 }
 
 func (r *Router) run(ctx context.Context) {
@@ -257,7 +349,9 @@ func (r *Router) run(ctx context.Context) {
 		select {
 		case <-ticker.Chan():
 			ctx, cf := context.WithTimeout(ctx, r.queryPeriod/2)
-			r.queryPeers(ctx)
+			if err := r.queryPeers(ctx); err != nil {
+				log.Error(err)
+			}
 			cf()
 		case <-ctx.Done():
 			return
@@ -265,8 +359,12 @@ func (r *Router) run(ctx context.Context) {
 	}
 }
 
-func (r *Router) queryPeers(ctx context.Context) {
+// queryPeers queries every peer we know of, then writes the paths which changed.
+func (r *Router) queryPeers(ctx context.Context) error {
 	log.Debug("begin querying peers")
+	// links to peers which are no longer listed are removed.
+	r.lm.Retain(append(r.peerSwarm.ListPeers(), r.peerSwarm.LocalID()))
+
 	peerIDs := []p2p.PeerID{}
 	peerIDs = append(peerIDs, r.OneHop()...)
 	peerIDs = append(peerIDs, r.MultiHop()...)
@@ -285,52 +383,112 @@ func (r *Router) queryPeers(ctx context.Context) {
 
 	wg.Wait()
 	log.Debug("done querying peers")
+	return r.flush()
 }
 
+// queryPeer asks a peer for its peer list, and adds the peers in it to the cache.
+// Each path to the peer is tried in turn, and paths which fail are dropped.
+// The peer is only deleted if every path fails.
 func (r *Router) queryPeer(ctx context.Context, peerID p2p.PeerID) error {
-	rt, nextHopPeer := r.Lookup(peerID)
-	if rt == nil {
+	paths := r.PathsTo(peerID)
+	if len(paths) == 0 {
 		return ErrNoRouteToPeer
 	}
 
-	// errors in this section mean the peer should be deleted
-	res := &ListPeersRes{}
-	err := func() error {
-		req := &ListPeersReq{
-			RoutingTag: rt,
-		}
-		reqData, err := proto.Marshal(req)
-		if err != nil {
-			panic(err)
-		}
-		resData, err := r.peerSwarm.AskPeer(ctx, nextHopPeer, reqData)
-		if err != nil {
-			return err
-		}
-		if err := proto.Unmarshal(resData, res); err != nil {
-			return err
+	var res *ListPeersRes
+	var path Path
+	var err error
+	start := r.clock.Now()
+	for _, path = range paths {
+		if res, err = r.askVia(ctx, peerID, path); err == nil {
+			break
 		}
-		return nil
-	}()
+		log.WithFields(log.Fields{
+			"peer_id": peerID,
+			"path":    path,
+		}).Warn("querying peer: ", err)
+		r.dropPath(peerID, path)
+	}
 	if err != nil {
-		log.Error(err)
+		r.rel.record(peerID, 0, false, r.clock.Now())
 		r.deletePeer(peerID)
 		return err
 	}
+	r.rel.record(peerID, r.clock.Since(start), true, r.clock.Now())
+	if res.PeerKey != nil {
+		if err := VerifyPeerKey(peerID, res.PeerKey); err != nil {
+			log.WithField("peer_id", peerID).Warn(err)
+		} else {
+			r.keys.put(peerID, res.PeerKey)
+		}
+	}
 
+	known := map[p2p.PeerID]struct{}{}
+	for _, id := range append(r.OneHop(), r.MultiHop()...) {
+		known[id] = struct{}{}
+	}
+	if len(res.PeerInfos) > 0 {
+		r.dropStale(peerID, res.PeerInfos[0].LinkEpoch)
+	}
+	overlap := 0
 	for _, peerInfo := range res.PeerInfos {
-		path := r.PathTo(peerID)
-		path = append(path, peerInfo.Path...)
+		p := append(append(Path{}, path...), peerInfo.Path...)
 
 		id := p2p.PeerID{}
 		copy(id[:], peerInfo.Id)
+		if _, ok := known[id]; ok {
+			overlap++
+		}
 
-		r.putPeer(id, path)
+		r.putPeer(id, route{
+			path:      p,
+			via:       peerID,
+			viaEpoch:  peerInfo.LinkEpoch,
+			extraHops: len(peerInfo.Path),
+		})
 	}
+	r.rel.setOverlap(peerID, overlap)
 
 	return nil
 }
 
+// askVia sends a ListPeersReq to a peer along path.
+func (r *Router) askVia(ctx context.Context, peerID p2p.PeerID, path Path) (*ListPeersRes, error) {
+	nextHopPeer := r.lm.Peer(int(path[0]))
+	if !r.isLink(nextHopPeer) {
+		return nil, ErrNoRouteToPeer
+	}
+	localID := r.peerSwarm.LocalID()
+	req := &ListPeersReq{
+		RoutingTag: &RoutingTag{
+			DstId: peerID[:],
+			Path:  path[1:],
+			SrcId: localID[:],
+		},
+	}
+	reqData, err := proto.Marshal(req)
+	if err != nil {
+		panic(err)
+	}
+	resData, err := r.peerSwarm.AskPeer(ctx, nextHopPeer, reqData)
+	if err != nil {
+		return nil, err
+	}
+	res := &ListPeersRes{}
+	if err := proto.Unmarshal(resData, res); err != nil {
+		return nil, err
+	}
+	if err := CheckRouteError(res.RouteError); err != nil {
+		return nil, err
+	}
+	if !nextHopPeer.Equals(peerID) {
+		if err := r.ledger.Received(nextHopPeer, bcstate.Favors{RequestsForwarded: 1}); err != nil {
+			log.Error(err)
+		}
+	}
+	return res, nil
+}
+
 func (r *Router) handleAsk(ctx context.Context, msg *p2p.Message, w io.Writer) {
 	req := &ListPeersReq{}
 	if err := proto.Unmarshal(msg.Payload, req); err != nil {
@@ -348,18 +506,23 @@ func (r *Router) handleAsk(ctx context.Context, msg *p2p.Message, w io.Writer) {
 	case rt == nil:
 		fallthrough
 	case bytes.Compare(rt.DstId, localID[:]) == 0:
-		fallthrough
-	case len(rt.Path) < 1:
 		log.WithFields(log.Fields{
 			"peer_id": msg.Src,
 		}).Debug("giving local peer info")
+		if rt != nil {
+			// a peer which queries us can be queried back.
+			r.learnReply(rt, msg.Src.(p2p.PeerID))
+		}
 		res = r.localAsk(ctx, req)
 
-	// a valid forwarding case
 	default:
-		res, err = r.forwardAsk(ctx, req)
+		res, err = r.forwardFor(ctx, msg.Src.(p2p.PeerID), req)
 	}
 
+	if code, ok := RouteErrorFor(err); ok {
+		log.WithField("peer_id", msg.Src).Warn("forwarding ListPeersReq: ", err)
+		res, err = &ListPeersRes{RouteError: code}, nil
+	}
 	if err != nil {
 		log.Error(err)
 		return
@@ -373,31 +536,44 @@ func (r *Router) handleAsk(ctx context.Context, msg *p2p.Message, w io.Writer) {
 	}
 }
 
-func (r *Router) forwardAsk(ctx context.Context, req *ListPeersReq) (*ListPeersRes, error) {
-	rt := req.RoutingTag
-	peerID := r.lm.Peer(int(rt.Path[0]))
-	dstID := p2p.PeerID{}
-	copy(dstID[:], rt.DstId)
+// forwardFor forwards req on behalf of peer, unless peer owes more than it is trusted with.
+func (r *Router) forwardFor(ctx context.Context, peer p2p.PeerID, req *ListPeersReq) (*ListPeersRes, error) {
+	if inDebt, err := r.ledger.InDebt(peer); err != nil {
+		return nil, err
+	} else if inDebt {
+		return nil, ErrPeerInDebt
+	}
+	res, err := r.forwardAsk(ctx, peer, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.ledger.Gave(peer, bcstate.Favors{RequestsForwarded: 1}); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// forwardAsk sends req, which was received from src, on to the next hop, and returns the response.
+// If the next hop can't be reached, it returns ErrNoRouteToPeer.
+func (r *Router) forwardAsk(ctx context.Context, src p2p.PeerID, req *ListPeersReq) (*ListPeersRes, error) {
+	rt2, nextHop, err := r.Forward(req.RoutingTag, src)
+	if err != nil {
+		return nil, err
+	}
 	log.WithFields(log.Fields{
-		"path":     rt.Path,
-		"next_hop": peerID,
-		"dst_id":   dstID,
+		"path":     req.RoutingTag.Path,
+		"next_hop": nextHop,
+		"dst_id":   rt2.DstId,
 	}).Debug("forwarding ListPeersReq")
 
-	req2 := &ListPeersReq{
-		RoutingTag: &RoutingTag{
-			DstId: rt.DstId,
-			Path:  rt.Path[1:],
-		},
-	}
-	req2Data, err := proto.Marshal(req2)
+	req2Data, err := proto.Marshal(&ListPeersReq{RoutingTag: rt2})
 	if err != nil {
 		panic(err)
 	}
-
-	resData, err := r.peerSwarm.AskPeer(ctx, peerID, req2Data)
+	resData, err := r.peerSwarm.AskPeer(ctx, nextHop, req2Data)
 	if err != nil {
-		return nil, err
+		log.WithField("next_hop", nextHop).Warn(err)
+		return nil, ErrNoRouteToPeer
 	}
 	res := &ListPeersRes{}
 	if err := proto.Unmarshal(resData, res); err != nil {
@@ -412,13 +588,21 @@ func (r *Router) localAsk(ctx context.Context, req *ListPeersReq) *ListPeersRes
 		PeerId:    localID[:],
 		PeerInfos: r.GetPeerInfos(),
 	}
+	if r.box != nil {
+		res.PeerKey = r.box.peerKey
+	}
 	return res
 }
 
-func (r *Router) putPeer(id p2p.PeerID, p Path) {
+// putPeer adds a path to the cache.  Paths longer than MaxPathLen are ignored, since they can't be used.
+// Up to MaxPaths paths are kept for each peer, which don't overlap, so one peer going down can't break all of them.
+// Of two paths which overlap, the more reliable is kept, or the shorter if they are as reliable.
+// If the cache is full, the least reliable peer in the farthest bucket with room to evict is dropped.
+func (r *Router) putPeer(id p2p.PeerID, rte route) {
+	p := rte.path
 	// prevent ourselves from entering the cache
 	localID := r.peerSwarm.LocalID()
-	if id.Equals(localID) {
+	if id.Equals(localID) || len(p) == 0 || len(p) > MaxPathLen {
 		return
 	}
 	// prevent one hop peers from entering the cache
@@ -433,26 +617,319 @@ func (r *Router) putPeer(id p2p.PeerID, p Path) {
 	})
 
 	r.mu.Lock()
-	defer r.mu.Unlock()
+	var evicted *kademlia.Entry
+	var routes []route
 	v := r.cache.Get(id[:])
-	if v != nil {
-		currentPath := v.(Path)
-		if len(p) < len(currentPath) {
-			r.cache.Put(id[:], p)
+	switch {
+	case v != nil:
+		var added bool
+		if routes, added = r.addRoute(v.([]route), rte); !added {
+			r.mu.Unlock()
+			return
 		}
-		log.Info("found shorter path for peer")
-	} else {
+		log.Info("found better path for peer")
+	case r.cache.IsFull():
+		victim := r.pickVictim(id)
+		if victim.Equals(id) {
+			r.mu.Unlock()
+			return
+		}
+		evicted = r.cache.Delete(victim[:])
+		routes = []route{rte}
+		log.Info("found new peer")
+	default:
+		routes = []route{rte}
 		log.Info("found new peer")
-		r.cache.Put(id[:], p)
+	}
+	r.cache.Put(id[:], routes)
+	r.mu.Unlock()
+
+	r.pathsChanged(id)
+	if evicted != nil {
+		evictedID := p2p.PeerID{}
+		copy(evictedID[:], evicted.Key)
+		r.dropped(evictedID)
+	}
+}
+
+// addRoute returns routes with rte added, best first, and whether rte was added.
+// routes is not modified.
+// Must be called with r.mu held.
+func (r *Router) addRoute(routes []route, rte route) ([]route, bool) {
+	better := func(a, b route) bool {
+		ra, rb := r.routeReliability(a), r.routeReliability(b)
+		if ra != rb {
+			return ra > rb
+		}
+		return len(a.path) < len(b.path)
+	}
+	// rte replaces every path it overlaps, if it is better than all of them.
+	var kept []route
+	for _, rte2 := range routes {
+		if !r.overlaps(rte, rte2) {
+			kept = append(kept, rte2)
+		} else if !better(rte, rte2) {
+			return routes, false
+		}
+	}
+	if len(kept) < MaxPaths {
+		kept = append(kept, rte)
+	} else {
+		sort.SliceStable(kept, func(i, j int) bool { return better(kept[i], kept[j]) })
+		if !better(rte, kept[len(kept)-1]) {
+			return routes, false
+		}
+		kept[len(kept)-1] = rte
+	}
+	sort.SliceStable(kept, func(i, j int) bool { return better(kept[i], kept[j]) })
+	return kept, true
+}
+
+// overlaps returns true if a and b go through a peer in common, as far as we know.
+// We only know two of the peers on a path: its next hop, and the peer it was learned from.
+// Must be called with r.mu held.
+func (r *Router) overlaps(a, b route) bool {
+	zero := p2p.ZeroPeerID()
+	for _, x := range []p2p.PeerID{r.lm.Peer(int(a.path[0])), a.via} {
+		if x.Equals(zero) {
+			continue
+		}
+		if x.Equals(r.lm.Peer(int(b.path[0]))) || x.Equals(b.via) {
+			return true
+		}
+	}
+	return a.path[0] == b.path[0]
+}
+
+// pathsChanged marks the paths to id to be written by the next flush.
+func (r *Router) pathsChanged(id p2p.PeerID) {
+	r.dirtyMu.Lock()
+	defer r.dirtyMu.Unlock()
+	r.dirty[id] = struct{}{}
+}
+
+// flush writes the paths which changed since the last flush, and the link map, so they are kept across restarts.
+// Paths which fail to be written are written by the next flush.
+func (r *Router) flush() error {
+	r.dirtyMu.Lock()
+	dirty := r.dirty
+	r.dirty = make(map[p2p.PeerID]struct{})
+	r.dirtyMu.Unlock()
+
+	var retErr error
+	for id := range dirty {
+		var paths []Path
+		r.mu.RLock()
+		if v := r.cache.Get(id[:]); v != nil {
+			for _, rte := range v.([]route) {
+				paths = append(paths, rte.path)
+			}
+		}
+		r.mu.RUnlock()
+		if err := r.paths.put(id, paths); err != nil {
+			r.pathsChanged(id)
+			retErr = err
+		}
+	}
+	if err := r.lm.Flush(); err != nil {
+		retErr = err
+	}
+	if retErr != nil {
+		return fmt.Errorf("saving paths: %w", retErr)
+	}
+	return nil
+}
+
+// dropStale removes the paths learned from via under a different link epoch.
+// Peers left with no paths are deleted.
+func (r *Router) dropStale(via p2p.PeerID, epoch uint64) {
+	var deleted []p2p.PeerID
+	r.mu.Lock()
+	var updates []kademlia.Entry
+	r.cache.ForEach(func(e kademlia.Entry) bool {
+		routes := e.Value.([]route)
+		var kept []route
+		for _, rte := range routes {
+			if rte.via != via || rte.viaEpoch == epoch {
+				kept = append(kept, rte)
+			}
+		}
+		if len(kept) < len(routes) {
+			updates = append(updates, kademlia.Entry{Key: e.Key, Value: kept})
+		}
+		return true
+	})
+	for _, e := range updates {
+		id := p2p.PeerID{}
+		copy(id[:], e.Key)
+		if kept := e.Value.([]route); len(kept) > 0 {
+			r.cache.Put(e.Key, kept)
+			r.pathsChanged(id)
+		} else {
+			r.cache.Delete(e.Key)
+			deleted = append(deleted, id)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, id := range deleted {
+		log.WithFields(log.Fields{
+			"peer_id": id,
+			"via":     via,
+		}).Info("dropping stale path")
+		r.dropped(id)
 	}
 }
 
+// dropPath removes a path which failed from a peer's paths.
+// The last path to a peer is not removed; deletePeer removes the peer.
+func (r *Router) dropPath(id p2p.PeerID, p Path) {
+	r.mu.Lock()
+	v := r.cache.Get(id[:])
+	if v == nil {
+		r.mu.Unlock()
+		return
+	}
+	var routes []route
+	for _, rte := range v.([]route) {
+		if !pathsEqual(rte.path, p) {
+			routes = append(routes, rte)
+		}
+	}
+	if len(routes) == 0 || len(routes) == len(v.([]route)) {
+		r.mu.Unlock()
+		return
+	}
+	r.cache.Put(id[:], routes)
+	r.mu.Unlock()
+
+	r.pathsChanged(id)
+}
+
 func (r *Router) deletePeer(id p2p.PeerID) {
 	r.mu.Lock()
-	defer r.mu.Unlock()
 	log.WithFields(log.Fields{
 		"peer_id": id,
 	}).Debug("deleting peer")
 
-	r.cache.Delete(id[:])
+	deleted := r.cache.Delete(id[:])
+	r.mu.Unlock()
+
+	if deleted != nil {
+		r.dropped(id)
+	}
+}
+
+// dropped forgets about a peer which has been removed from the cache.
+// Its reliability is kept for a while, in case it is learned again.
+func (r *Router) dropped(id p2p.PeerID) {
+	r.mu.RLock()
+	onDrop := r.onDrop
+	r.mu.RUnlock()
+
+	r.rel.forget(id, r.clock.Now())
+	r.keys.delete(id)
+	r.pathsChanged(id)
+	if onDrop != nil {
+		onDrop(id)
+	}
+}
+
+// pickVictim returns the peer to evict to make room for id, which may be id itself.
+// Like the kademlia cache, it evicts from the bucket farthest from us which has more than one peer,
+// but from that bucket it picks the least reliable peer, rather than any of them.
+// Must be called with r.mu held.
+func (r *Router) pickVictim(id p2p.PeerID) p2p.PeerID {
+	localID := r.peerSwarm.LocalID()
+	buckets := map[int][]p2p.PeerID{}
+	add := func(peer p2p.PeerID) {
+		dist := make([]byte, len(localID))
+		kademlia.XORBytes(dist, localID[:], peer[:])
+		lz := kademlia.Leading0s(dist)
+		buckets[lz] = append(buckets[lz], peer)
+	}
+	add(id)
+	r.cache.ForEach(func(e kademlia.Entry) bool {
+		peer := p2p.PeerID{}
+		copy(peer[:], e.Key)
+		add(peer)
+		return true
+	})
+	for lz := 0; lz <= len(localID)*8; lz++ {
+		if len(buckets[lz]) <= 1 {
+			continue
+		}
+		now := r.clock.Now()
+		victim := buckets[lz][0]
+		for _, peer := range buckets[lz][1:] {
+			if r.rel.get(peer).worse(r.rel.get(victim), now) {
+				victim = peer
+			}
+		}
+		return victim
+	}
+	return id
+}
+
+// route is a path in the cache, and where it came from.
+type route struct {
+	path Path
+	// via is the peer the path was learned from, and extraHops is how far the path goes beyond it.
+	via       p2p.PeerID
+	extraHops int
+	// viaEpoch is the link epoch of via when the path was learned.
+	viaEpoch uint64
+}
+
+func (r *Router) routeReliability(rte route) float64 {
+	return pathReliability(r.rel.get(rte.via), rte.extraHops)
+}
+
+func pathsEqual(a, b Path) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Reliability returns what the router knows about how well peer responds to queries.
+func (r *Router) Reliability(peer p2p.PeerID) Reliability {
+	return r.rel.get(peer)
+}
+
+// loadPaths fills the cache with the paths stored before the last restart.
+// They are not checked here; a path which no longer works is dropped the next time the peer is queried.
+func (r *Router) loadPaths() {
+	localID := r.peerSwarm.LocalID()
+	var evicted []p2p.PeerID
+	if err := r.paths.forEach(func(id p2p.PeerID, paths []Path, dropped bool) error {
+		if dropped {
+			// rewrite what is left, or delete the peer if nothing is.
+			r.pathsChanged(id)
+		}
+		if id.Equals(localID) || len(paths) == 0 {
+			return nil
+		}
+		// all we know about a stored path is its length.
+		routes := make([]route, len(paths))
+		for i, p := range paths {
+			routes[i] = route{path: p, extraHops: len(p) - 1}
+		}
+		if e := r.cache.Put(id[:], routes); e != nil {
+			evictedID := p2p.PeerID{}
+			copy(evictedID[:], e.Key)
+			evicted = append(evicted, evictedID)
+		}
+		return nil
+	}); err != nil {
+		log.Error("loading paths: ", err)
+	}
+	for _, id := range evicted {
+		r.pathsChanged(id)
+	}
 }
@@ -2,7 +2,9 @@ package peerrouting
 
 import (
 	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/brendoncarroll/go-p2p"
 	"github.com/brendoncarroll/go-p2p/p2ptest"
@@ -12,6 +14,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/blobcache/blobcache/pkg/bcstate"
 	"github.com/blobcache/blobcache/pkg/blobnet/peers"
 )
 
@@ -66,3 +69,374 @@ func TestRouter(t *testing.T) {
 		}
 	}
 }
+
+func TestRouterPersist(t *testing.T) {
+	const N = 3
+	realm := memswarm.NewRealm()
+	swarms := make([]p2p.SecureAskSwarm, N)
+	for i := range swarms {
+		swarms[i] = realm.NewSwarmWithKey(p2ptest.NewTestKey(t, i))
+	}
+	adjList := p2ptest.Chain(p2ptest.CastSlice(swarms))
+
+	peerStores := make([]peers.MemPeerStore, N)
+	routers := make([]*Router, N)
+	db := &fullDB{full: true}
+	for i := range swarms {
+		peerStores[i] = make(peers.MemPeerStore)
+		for _, addr := range adjList[i] {
+			pubKey, err := swarms[i].LookupPublicKey(context.TODO(), addr)
+			require.NoError(t, err)
+			peerStores[i].AddAddr(p2p.NewPeerID(pubKey), addr)
+		}
+		params := RouterParams{
+			PeerSwarm: peers.NewPeerSwarm(swarms[i], peerStores[i]),
+			Clock:     clockwork.NewRealClock(),
+		}
+		if i == 0 {
+			params.DB = db
+		}
+		routers[i] = NewRouter(params)
+	}
+	// paths which can't be written are kept until they can be.
+	err := routers[0].Bootstrap(context.TODO())
+	assert.True(t, errors.Is(err, bcstate.ErrFull))
+	far := p2p.NewPeerID(swarms[2].PublicKey())
+	path := routers[0].PathTo(far)
+	require.NotNil(t, path)
+	db.full = false
+	require.NoError(t, routers[0].flush())
+
+	// a router using the same DB knows the path without asking anyone.
+	r := NewRouter(RouterParams{
+		PeerSwarm: peers.NewPeerSwarm(swarms[0], peerStores[0]),
+		Clock:     clockwork.NewRealClock(),
+		DB:        db,
+	})
+	assert.Equal(t, []p2p.PeerID{far}, r.MultiHop())
+	assert.Equal(t, path, r.PathTo(far))
+}
+
+// fullDB is a DB which has no room for writes while full is set.
+type fullDB struct {
+	bcstate.MemDB
+	full bool
+}
+
+func (db *fullDB) Bucket(name string) bcstate.KV {
+	return fullKV{KV: db.MemDB.Bucket(name), db: db}
+}
+
+type fullKV struct {
+	bcstate.KV
+	db *fullDB
+}
+
+func (kv fullKV) Put(key, value []byte) error {
+	if kv.db.full {
+		return bcstate.ErrFull
+	}
+	return kv.KV.Put(key, value)
+}
+
+func TestReliabilityEviction(t *testing.T) {
+	realm := memswarm.NewRealm()
+	swarm := realm.NewSwarmWithKey(p2ptest.NewTestKey(t, 0))
+	r := NewRouter(RouterParams{
+		PeerSwarm: peers.NewPeerSwarm(swarm, make(peers.MemPeerStore)),
+		CacheSize: 2,
+		Clock:     clockwork.NewRealClock(),
+	})
+	defer r.Close()
+	now := r.clock.Now()
+
+	// all in the bucket farthest from us
+	localID := r.peerSwarm.LocalID()
+	far := func(i byte) p2p.PeerID {
+		id := localID
+		id[0] ^= 0x80
+		id[31] ^= i
+		return id
+	}
+	flaky, steady, fresh := far(1), far(2), far(3)
+	r.rel.record(flaky, time.Millisecond, false, now)
+	r.rel.record(steady, time.Millisecond, true, now)
+	assert.True(t, r.Reliability(flaky).worse(r.Reliability(steady), now))
+	// but overlap comes first
+	assert.False(t, Reliability{Overlap: 2}.worse(r.Reliability(steady), now))
+
+	via := p2p.PeerID{1}
+	r.putPeer(flaky, route{path: Path{1, 2}, via: via, extraHops: 1})
+	r.putPeer(steady, route{path: Path{1, 3}, via: via, extraHops: 1})
+	r.putPeer(fresh, route{path: Path{1, 4}, via: via, extraHops: 1})
+	assert.ElementsMatch(t, []p2p.PeerID{steady, fresh}, r.MultiHop())
+
+	// a longer path through a more reliable peer replaces a shorter one
+	reliable := p2p.PeerID{2}
+	for i := 0; i < 10; i++ {
+		r.rel.record(reliable, time.Millisecond, true, now)
+	}
+	r.putPeer(fresh, route{path: Path{1, 5, 6}, via: reliable, extraHops: 2})
+	assert.Equal(t, Path{1, 5, 6}, r.PathTo(fresh))
+	r.putPeer(fresh, route{path: Path{1, 7}, via: via, extraHops: 1})
+	assert.Equal(t, Path{1, 5, 6}, r.PathTo(fresh))
+}
+
+func TestRouterFailover(t *testing.T) {
+	ctx := context.TODO()
+	// a diamond: 0 can reach 3 through 1 or 2
+	const N = 4
+	adj := [N][]int{{1, 2}, {0, 3}, {0, 3}, {1, 2}}
+	realm := memswarm.NewRealm()
+	swarms := make([]p2p.SecureAskSwarm, N)
+	ids := make([]p2p.PeerID, N)
+	for i := range swarms {
+		swarms[i] = realm.NewSwarmWithKey(p2ptest.NewTestKey(t, i))
+		ids[i] = p2p.NewPeerID(swarms[i].PublicKey())
+	}
+	peerStores := make([]peers.MemPeerStore, N)
+	routers := make([]*Router, N)
+	db := &bcstate.MemDB{}
+	for i := range swarms {
+		peerStores[i] = make(peers.MemPeerStore)
+		for _, j := range adj[i] {
+			peerStores[i].AddAddr(ids[j], swarms[j].LocalAddrs()[0])
+		}
+		params := RouterParams{
+			PeerSwarm: peers.NewPeerSwarm(swarms[i], peerStores[i]),
+			Clock:     clockwork.NewRealClock(),
+		}
+		if i == 0 {
+			params.DB = db
+		}
+		routers[i] = NewRouter(params)
+	}
+	for _, r := range routers {
+		r.queryPeers(ctx)
+	}
+	r := routers[0]
+	paths := r.PathsTo(ids[3])
+	require.Len(t, paths, 2)
+	primary, alternate := r.lm.Peer(int(paths[0][0])), r.lm.Peer(int(paths[1][0]))
+
+	// both paths are kept across restarts
+	r2 := NewRouter(RouterParams{
+		PeerSwarm: peers.NewPeerSwarm(swarms[0], peerStores[0]),
+		Clock:     clockwork.NewRealClock(),
+		DB:        db,
+	})
+	assert.ElementsMatch(t, paths, r2.PathsTo(ids[3]))
+
+	// the link to the primary next hop goes down, so only the alternate path works
+	delete(peerStores[0], primary)
+	require.NoError(t, r.queryPeer(ctx, ids[3]))
+	paths = r.PathsTo(ids[3])
+	require.Len(t, paths, 1)
+	assert.Equal(t, alternate, r.lm.Peer(int(paths[0][0])))
+
+	// and when that goes down too, the peer is dropped, but its failures are remembered
+	delete(peerStores[0], alternate)
+	require.Error(t, r.queryPeer(ctx, ids[3]))
+	assert.Nil(t, r.PathsTo(ids[3]))
+	rel := r.Reliability(ids[3])
+	assert.Equal(t, uint64(1), rel.Failures)
+	assert.True(t, rel.Successes > 0)
+}
+
+func TestLinkMap(t *testing.T) {
+	kv := &bcstate.MemKV{}
+	clock := clockwork.NewFakeClock()
+	lm := NewLinkMap(kv, clock)
+	a, b, c := p2p.PeerID{1}, p2p.PeerID{2}, p2p.PeerID{3}
+	assert.Equal(t, 0, lm.Int(a))
+	assert.Equal(t, 1, lm.Int(b))
+	epoch := lm.Epoch()
+
+	// indices survive a restart, once they are flushed
+	require.NoError(t, lm.Flush())
+	lm = NewLinkMap(kv, clock)
+	assert.Equal(t, 1, lm.Int(b))
+	assert.Equal(t, epoch, lm.Epoch())
+
+	// a removed peer gets its index back during quarantine, and no one else gets it
+	lm.Retain([]p2p.PeerID{a})
+	assert.Equal(t, 2, lm.Int(c))
+	assert.Equal(t, 1, lm.Int(b))
+	assert.Equal(t, epoch, lm.Epoch())
+
+	// after quarantine the index is recycled, and the epoch changes
+	lm.Retain([]p2p.PeerID{a, c})
+	clock.Advance(LinkQuarantine)
+	d := p2p.PeerID{4}
+	assert.Equal(t, 1, lm.Int(d))
+	assert.Equal(t, d, lm.Peer(1))
+	assert.NotEqual(t, epoch, lm.Epoch())
+
+	// a new link map starts a new epoch
+	assert.NotEqual(t, epoch, NewLinkMap(&bcstate.MemKV{}, clock).Epoch())
+}
+
+func TestDropStale(t *testing.T) {
+	realm := memswarm.NewRealm()
+	swarm := realm.NewSwarmWithKey(p2ptest.NewTestKey(t, 0))
+	r := NewRouter(RouterParams{
+		PeerSwarm: peers.NewPeerSwarm(swarm, make(peers.MemPeerStore)),
+		Clock:     clockwork.NewRealClock(),
+	})
+	defer r.Close()
+
+	via, other := p2p.PeerID{1}, p2p.PeerID{2}
+	stale, fine := p2p.PeerID{3}, p2p.PeerID{4}
+	r.putPeer(stale, route{path: Path{1, 2}, via: via, viaEpoch: 1, extraHops: 1})
+	r.putPeer(fine, route{path: Path{1, 3}, via: via, viaEpoch: 2, extraHops: 1})
+	r.putPeer(fine, route{path: Path{2, 3}, via: other, viaEpoch: 1, extraHops: 1})
+
+	r.dropStale(via, 2)
+	assert.Nil(t, r.PathsTo(stale))
+	assert.Len(t, r.PathsTo(fine), 2)
+
+	r.dropStale(other, 2)
+	assert.Equal(t, []Path{{1, 3}}, r.PathsTo(fine))
+}
+
+func TestLoadPathsWithoutLink(t *testing.T) {
+	realm := memswarm.NewRealm()
+	swarm := realm.NewSwarmWithKey(p2ptest.NewTestKey(t, 0))
+	localID := p2p.NewPeerID(swarm.PublicKey())
+	db := &bcstate.MemDB{}
+	params := RouterParams{
+		PeerSwarm: peers.NewPeerSwarm(swarm, make(peers.MemPeerStore)),
+		Clock:     clockwork.NewFakeClock(),
+		DB:        db,
+	}
+	r := NewRouter(params)
+	defer r.Close()
+
+	via, dst := p2p.PeerID{1}, p2p.PeerID{2}
+	r.putPeer(dst, route{path: Path{uint64(r.lm.Int(via)), 2}, via: via, extraHops: 1})
+	// the link to the next hop is removed before the restart.
+	r.lm.Retain([]p2p.PeerID{localID})
+	require.NoError(t, r.flush())
+
+	// so the path is dropped, and the link stays quarantined.
+	r2 := NewRouter(params)
+	assert.Nil(t, r2.PathsTo(dst))
+	_, ok := r2.lm.Lookup(via)
+	assert.False(t, ok)
+	require.NoError(t, r2.flush())
+	exists, err := bcstate.Exists(db.Bucket("paths"), append(dst[:], 0))
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestDisjointPaths(t *testing.T) {
+	realm := memswarm.NewRealm()
+	swarm := realm.NewSwarmWithKey(p2ptest.NewTestKey(t, 0))
+	r := NewRouter(RouterParams{
+		PeerSwarm: peers.NewPeerSwarm(swarm, make(peers.MemPeerStore)),
+		Clock:     clockwork.NewRealClock(),
+	})
+	defer r.Close()
+
+	via, other := p2p.PeerID{1}, p2p.PeerID{2}
+	dst := p2p.PeerID{3}
+	r.putPeer(dst, route{path: Path{1, 2, 3}, via: via, extraHops: 1})
+	// a path through a different next hop, but learned from the same peer, goes through that peer too.
+	r.putPeer(dst, route{path: Path{2, 4, 3}, via: via, extraHops: 1})
+	assert.Equal(t, []Path{{1, 2, 3}}, r.PathsTo(dst))
+
+	r.putPeer(dst, route{path: Path{2, 5}, via: other, extraHops: 1})
+	assert.Equal(t, []Path{{2, 5}, {1, 2, 3}}, r.PathsTo(dst))
+	// a better path replaces the one it overlaps.
+	r.putPeer(dst, route{path: Path{3, 6}, via: via, extraHops: 1})
+	assert.Equal(t, []Path{{2, 5}, {3, 6}}, r.PathsTo(dst))
+}
+
+func TestLongPaths(t *testing.T) {
+	realm := memswarm.NewRealm()
+	swarm := realm.NewSwarmWithKey(p2ptest.NewTestKey(t, 0))
+	r := NewRouter(RouterParams{
+		PeerSwarm: peers.NewPeerSwarm(swarm, make(peers.MemPeerStore)),
+		Clock:     clockwork.NewRealClock(),
+	})
+	defer r.Close()
+
+	via, dst := p2p.PeerID{1}, p2p.PeerID{2}
+	long := make(Path, MaxPathLen+1)
+	r.putPeer(dst, route{path: long, via: via, extraHops: len(long) - 1})
+	assert.Nil(t, r.PathsTo(dst))
+	r.putPeer(dst, route{path: long[:MaxPathLen], via: via, extraHops: MaxPathLen - 1})
+	assert.Len(t, r.PathsTo(dst), 1)
+
+	// reply paths which would be too long are not learned either.
+	src := p2p.PeerID{3}
+	r.learnReply(&RoutingTag{SrcId: src[:], ReversePath: long[:MaxPathLen]}, via)
+	assert.Nil(t, r.PathsTo(src))
+	r.learnReply(&RoutingTag{SrcId: src[:], ReversePath: long[:MaxPathLen-1]}, via)
+	assert.Len(t, r.PathsTo(src), 1)
+}
+
+func TestForward(t *testing.T) {
+	ctx := context.TODO()
+	const N = 3
+	realm := memswarm.NewRealm()
+	swarms := make([]p2p.SecureAskSwarm, N)
+	ids := make([]p2p.PeerID, N)
+	for i := range swarms {
+		swarms[i] = realm.NewSwarmWithKey(p2ptest.NewTestKey(t, i))
+		ids[i] = p2p.NewPeerID(swarms[i].PublicKey())
+	}
+	adjList := p2ptest.Chain(p2ptest.CastSlice(swarms))
+	routers := make([]*Router, N)
+	for i := range swarms {
+		peerStore := make(peers.MemPeerStore)
+		for _, addr := range adjList[i] {
+			pubKey, err := swarms[i].LookupPublicKey(ctx, addr)
+			require.NoError(t, err)
+			peerStore.AddAddr(p2p.NewPeerID(pubKey), addr)
+		}
+		routers[i] = NewRouter(RouterParams{
+			PeerSwarm: peers.NewPeerSwarm(swarms[i], peerStore),
+			Clock:     clockwork.NewRealClock(),
+		})
+	}
+	a, b, c := routers[0], routers[1], routers[2]
+	a.Bootstrap(ctx)
+	path := a.PathTo(ids[2])
+	require.Len(t, path, 2)
+	// c learned the way back to a when a queried it.
+	assert.Len(t, c.PathTo(ids[0]), 2)
+
+	// a -> b -> c, building the reverse path along the way
+	rt, nextHop, err := a.Forward(&RoutingTag{DstId: ids[2][:], Path: path}, p2p.ZeroPeerID())
+	require.NoError(t, err)
+	assert.Equal(t, ids[1], nextHop)
+	assert.Equal(t, ids[0][:], rt.SrcId)
+	rt, nextHop, err = b.Forward(rt, ids[0])
+	require.NoError(t, err)
+	assert.Equal(t, ids[2], nextHop)
+	assert.Len(t, rt.ReversePath, 1)
+
+	// c can reply along the reverse path
+	reply := c.ReplyTag(rt, ids[1])
+	assert.Equal(t, ids[0][:], reply.DstId)
+	reply, nextHop, err = c.Forward(reply, p2p.ZeroPeerID())
+	require.NoError(t, err)
+	assert.Equal(t, ids[1], nextHop)
+	_, nextHop, err = b.Forward(reply, ids[2])
+	require.NoError(t, err)
+	assert.Equal(t, ids[0], nextHop)
+
+	// a tag which has run out of path is sent on by our own route.
+	_, nextHop, err = b.Forward(&RoutingTag{DstId: ids[2][:]}, ids[0])
+	require.NoError(t, err)
+	assert.Equal(t, ids[2], nextHop)
+
+	// bad hops are refused, and the requester is told why
+	_, _, err = b.Forward(&RoutingTag{DstId: ids[2][:], Path: Path{99}}, ids[0])
+	assert.Equal(t, ErrNoRouteToPeer, err)
+	_, _, err = b.Forward(&RoutingTag{DstId: ids[2][:], Path: make(Path, MaxPathLen+1)}, ids[0])
+	assert.Equal(t, ErrPathTooLong, err)
+	_, err = a.askVia(ctx, ids[2], Path{path[0], 99})
+	assert.Equal(t, ErrNoRouteToPeer, err)
+}
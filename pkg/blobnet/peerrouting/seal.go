@@ -0,0 +1,171 @@
+package peerrouting
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"sync"
+
+	"github.com/blobcache/blobcache/pkg/blobnet/bcproto"
+	"github.com/brendoncarroll/go-p2p"
+	proto "github.com/golang/protobuf/proto"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/sha3"
+)
+
+type (
+	PeerKey       = bcproto.PeerKey
+	SignedMessage = bcproto.SignedMessage
+)
+
+const (
+	purposePeerKey = "blobcache/peer-key"
+	purposeSealed  = "blobcache/sealed"
+)
+
+var (
+	ErrNoPeerKey    = errors.New("no key is known for peer")
+	ErrNoPrivateKey = errors.New("router has no private key")
+	ErrBadPeerKey   = errors.New("invalid peer key")
+	ErrBadSeal      = errors.New("sealed message could not be opened")
+)
+
+// boxKeys are what messages sealed to us are opened with.
+type boxKeys struct {
+	privKey   p2p.PrivateKey
+	pub, priv [32]byte
+	peerKey   *PeerKey
+}
+
+// newBoxKeys derives a box key pair from an ed25519 private key, so it is the same across restarts.
+// Other kinds of keys get a new box key pair every time.
+func newBoxKeys(privKey p2p.PrivateKey) (*boxKeys, error) {
+	bk := &boxKeys{privKey: privKey}
+	if k, ok := privKey.(ed25519.PrivateKey); ok {
+		bk.priv = sha3.Sum256(append([]byte(purposePeerKey), k.Seed()...))
+		curve25519.ScalarBaseMult(&bk.pub, &bk.priv)
+	} else {
+		pub, priv, err := box.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		bk.pub, bk.priv = *pub, *priv
+	}
+	sig, err := p2p.Sign(privKey, purposePeerKey, bk.pub[:])
+	if err != nil {
+		return nil, err
+	}
+	bk.peerKey = &PeerKey{
+		PublicKey: p2p.MarshalPublicKey(privKey.Public()),
+		BoxKey:    bk.pub[:],
+		Sig:       sig,
+	}
+	return bk, nil
+}
+
+// VerifyPeerKey checks that key belongs to id, and was signed by it.
+func VerifyPeerKey(id p2p.PeerID, key *PeerKey) error {
+	pub, err := p2p.ParsePublicKey(key.GetPublicKey())
+	if err != nil {
+		return ErrBadPeerKey
+	}
+	if !p2p.NewPeerID(pub).Equals(id) || len(key.BoxKey) != 32 {
+		return ErrBadPeerKey
+	}
+	if err := p2p.Verify(pub, purposePeerKey, key.BoxKey, key.Sig); err != nil {
+		return ErrBadPeerKey
+	}
+	return nil
+}
+
+// KeyOf returns the key peers have given us for sealing messages to them, or nil if we don't have one.
+func (r *Router) KeyOf(id p2p.PeerID) *PeerKey {
+	return r.keys.get(id)
+}
+
+// Seal signs payload for dst, and seals it to dstKey, so only dst can open it.
+// dstKey must have been checked with VerifyPeerKey, or come from Open.
+func (r *Router) Seal(dst p2p.PeerID, dstKey *PeerKey, payload []byte) ([]byte, error) {
+	if r.box == nil {
+		return nil, ErrNoPrivateKey
+	}
+	sig, err := p2p.Sign(r.box.privKey, purposeSealed, signedData(dst, payload))
+	if err != nil {
+		return nil, err
+	}
+	data, err := proto.Marshal(&SignedMessage{
+		SrcKey:  r.box.peerKey,
+		Payload: payload,
+		Sig:     sig,
+	})
+	if err != nil {
+		panic(err)
+	}
+	var boxKey [32]byte
+	copy(boxKey[:], dstKey.GetBoxKey())
+	return box.SealAnonymous(nil, data, &boxKey, rand.Reader)
+}
+
+// Open opens a message sealed to us, and checks its signature.
+// It returns the peer which signed the message, its key, and the payload.
+func (r *Router) Open(sealed []byte) (p2p.PeerID, *PeerKey, []byte, error) {
+	zero := p2p.ZeroPeerID()
+	if r.box == nil {
+		return zero, nil, nil, ErrNoPrivateKey
+	}
+	data, ok := box.OpenAnonymous(nil, sealed, &r.box.pub, &r.box.priv)
+	if !ok {
+		return zero, nil, nil, ErrBadSeal
+	}
+	msg := &SignedMessage{}
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return zero, nil, nil, ErrBadSeal
+	}
+	pub, err := p2p.ParsePublicKey(msg.GetSrcKey().GetPublicKey())
+	if err != nil {
+		return zero, nil, nil, ErrBadSeal
+	}
+	src := p2p.NewPeerID(pub)
+	if err := VerifyPeerKey(src, msg.SrcKey); err != nil {
+		return zero, nil, nil, err
+	}
+	if err := p2p.Verify(pub, purposeSealed, signedData(r.peerSwarm.LocalID(), msg.Payload), msg.Sig); err != nil {
+		return zero, nil, nil, ErrBadSeal
+	}
+	return src, msg.SrcKey, msg.Payload, nil
+}
+
+// signedData is what the source of a sealed message signs: the destination's ID followed by the payload.
+// Signing the destination stops it from passing the message on as if it were meant for someone else.
+func signedData(dst p2p.PeerID, payload []byte) []byte {
+	return append(append([]byte{}, dst[:]...), payload...)
+}
+
+// peerKeys keeps the verified keys of peers.
+type peerKeys struct {
+	mu sync.Mutex
+	m  map[p2p.PeerID]*PeerKey
+}
+
+func newPeerKeys() *peerKeys {
+	return &peerKeys{m: make(map[p2p.PeerID]*PeerKey)}
+}
+
+func (pk *peerKeys) put(id p2p.PeerID, key *PeerKey) {
+	pk.mu.Lock()
+	defer pk.mu.Unlock()
+	pk.m[id] = key
+}
+
+func (pk *peerKeys) get(id p2p.PeerID) *PeerKey {
+	pk.mu.Lock()
+	defer pk.mu.Unlock()
+	return pk.m[id]
+}
+
+func (pk *peerKeys) delete(id p2p.PeerID) {
+	pk.mu.Lock()
+	defer pk.mu.Unlock()
+	delete(pk.m, id)
+}
@@ -0,0 +1,124 @@
+package peerrouting
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"github.com/blobcache/blobcache/pkg/bcstate"
+	"github.com/brendoncarroll/go-p2p"
+	log "github.com/sirupsen/logrus"
+)
+
+// pathStore persists the paths in the cache, so they survive restarts.
+//
+// The first index of a path is into our own LinkMap, and the link may have been removed by the time the path is loaded,
+// so the next hop is stored by PeerID instead, followed by the rest of the path.
+// Paths whose next hop is no longer a link are dropped when they are loaded.
+// Each of a peer's paths is stored under the PeerID followed by the path's rank.
+type pathStore struct {
+	kv bcstate.KV
+	lm *LinkMap
+}
+
+// put replaces the stored paths to id with paths, which may be empty.
+func (s pathStore) put(id p2p.PeerID, paths []Path) error {
+	if err := s.delete(id); err != nil {
+		return err
+	}
+	for i, p := range paths {
+		if len(p) == 0 {
+			continue
+		}
+		nextHop := s.lm.Peer(int(p[0]))
+		if nextHop.Equals(p2p.ZeroPeerID()) {
+			continue
+		}
+		v := make([]byte, len(nextHop)+8*(len(p)-1))
+		copy(v, nextHop[:])
+		for j, index := range p[1:] {
+			binary.BigEndian.PutUint64(v[len(nextHop)+8*j:], index)
+		}
+		if err := s.kv.Put(append(append([]byte{}, id[:]...), byte(i)), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s pathStore) delete(id p2p.PeerID) error {
+	var keys [][]byte
+	if err := s.kv.ForEach(id[:], bcstate.PrefixEnd(id[:]), func(k, _ []byte) error {
+		keys = append(keys, append([]byte{}, k...))
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := s.kv.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forEach calls fn with every peer's stored paths, best first, and whether any of them were dropped.
+// Entries which can't be parsed, or whose next hop is no longer a link, are dropped.
+func (s pathStore) forEach(fn func(id p2p.PeerID, paths []Path, dropped bool) error) error {
+	var (
+		id      p2p.PeerID
+		paths   []Path
+		dropped bool
+		found   bool
+	)
+	if err := s.kv.ForEach(nil, nil, func(k, v []byte) error {
+		if len(k) != len(p2p.PeerID{})+1 {
+			log.WithField("key", k).Warn("skipping invalid path")
+			return nil
+		}
+		if found && !bytes.Equal(k[:len(id)], id[:]) {
+			if err := fn(id, paths, dropped); err != nil {
+				return err
+			}
+			paths, dropped = nil, false
+		}
+		copy(id[:], k)
+		found = true
+		p, err := s.parse(v)
+		if err == errNoLink {
+			dropped = true
+			return nil
+		} else if err != nil {
+			log.WithField("key", k).Warn("skipping invalid path")
+			dropped = true
+			return nil
+		}
+		paths = append(paths, p)
+		return nil
+	}); err != nil {
+		return err
+	}
+	if found {
+		return fn(id, paths, dropped)
+	}
+	return nil
+}
+
+var errNoLink = errors.New("next hop is no longer a link")
+
+func (s pathStore) parse(v []byte) (Path, error) {
+	nextHop := p2p.PeerID{}
+	if len(v) < len(nextHop) || (len(v)-len(nextHop))%8 != 0 {
+		return nil, errors.New("invalid path")
+	}
+	copy(nextHop[:], v)
+	i, ok := s.lm.Lookup(nextHop)
+	if !ok {
+		return nil, errNoLink
+	}
+	p := Path{uint64(i)}
+	for rest := v[len(nextHop):]; len(rest) > 0; rest = rest[8:] {
+		p = append(p, binary.BigEndian.Uint64(rest))
+	}
+	return p, nil
+}
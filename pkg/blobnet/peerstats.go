@@ -0,0 +1,78 @@
+package blobnet
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/brendoncarroll/go-p2p"
+)
+
+// DefaultLatency is the latency assumed for peers which have not been asked for anything yet.
+const DefaultLatency = 100 * time.Millisecond
+
+// PeerStats are the results of fetching blobs from a peer.
+type PeerStats struct {
+	Successes uint64
+	Failures  uint64
+	// Latency is a moving average of the time taken by successful requests.
+	Latency time.Duration
+}
+
+// cost is the expected time to get a blob from the peer.
+// Each failure counts as much as a successful request.
+func (s PeerStats) cost() time.Duration {
+	latency := s.Latency
+	if s.Successes == 0 {
+		latency = DefaultLatency
+	}
+	return latency * time.Duration(s.Successes+s.Failures+1) / time.Duration(s.Successes+1)
+}
+
+// peerStats keeps PeerStats for every peer which has been asked for a blob.
+type peerStats struct {
+	mu    sync.Mutex
+	stats map[p2p.PeerID]PeerStats
+}
+
+func newPeerStats() *peerStats {
+	return &peerStats{stats: make(map[p2p.PeerID]PeerStats)}
+}
+
+func (ps *peerStats) record(peer p2p.PeerID, latency time.Duration, success bool) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	s := ps.stats[peer]
+	if !success {
+		s.Failures++
+		ps.stats[peer] = s
+		return
+	}
+	if s.Successes == 0 {
+		s.Latency = latency
+	} else {
+		s.Latency = (s.Latency*7 + latency) / 8
+	}
+	s.Successes++
+	ps.stats[peer] = s
+}
+
+func (ps *peerStats) get(peer p2p.PeerID) PeerStats {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.stats[peer]
+}
+
+// sort orders peers by expected cost, cheapest first.
+// The order of peers with the same cost is preserved.
+func (ps *peerStats) sort(peers []p2p.PeerID) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	costs := make(map[p2p.PeerID]time.Duration, len(peers))
+	for _, peer := range peers {
+		costs[peer] = ps.stats[peer].cost()
+	}
+	sort.SliceStable(peers, func(i, j int) bool {
+		return costs[peers[i]] < costs[peers[j]]
+	})
+}
@@ -0,0 +1,301 @@
+package blobnet
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/brendoncarroll/go-p2p"
+	proto "github.com/golang/protobuf/proto"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/blobcache/blobcache/pkg/bcstate"
+	"github.com/blobcache/blobcache/pkg/blobnet/bcproto"
+	"github.com/blobcache/blobcache/pkg/blobnet/peers"
+	"github.com/blobcache/blobcache/pkg/blobs"
+)
+
+type (
+	StorageReq    = bcproto.StorageReq
+	StorageRes    = bcproto.StorageRes
+	StoreReq      = bcproto.StoreReq
+	StoreRes      = bcproto.StoreRes
+	ReleaseReq    = bcproto.ReleaseReq
+	ReleaseRes    = bcproto.ReleaseRes
+	CheckReq      = bcproto.CheckReq
+	CheckRes      = bcproto.CheckRes
+	StorageStatus = bcproto.StorageStatus
+)
+
+// MaxStorageIDs is the most blob IDs which can be sent in a single Release or Check request.
+const MaxStorageIDs = 1024
+
+var (
+	ErrStorageRefused = errors.New("peer refused to store blob")
+	ErrPeerFull       = errors.New("peer does not have space for blob")
+	ErrTooManyIDs     = errors.New("too many blob ids in storage request")
+)
+
+// PeerStorage is the local storage offered to peers.
+type PeerStorage interface {
+	// StoreFor stores data on behalf of peer.
+	// If persist is false, the blob is only cached, and may be evicted at any time.
+	// It returns bcstate.ErrFull if there is no space, or the peer is over its quota.
+	StoreFor(ctx context.Context, peer p2p.PeerID, data []byte, persist bool) error
+	// ReleaseFor removes blobs persisted on behalf of peer.  IDs which are not held are ignored.
+	ReleaseFor(ctx context.Context, peer p2p.PeerID, ids []blobs.ID) error
+	// HeldFor returns whether each of ids is still held on behalf of peer.
+	HeldFor(ctx context.Context, peer p2p.PeerID, ids []blobs.ID) ([]bool, error)
+}
+
+type StorageParams struct {
+	PeerSwarm *peers.PeerSwarm
+	PeerStore peers.PeerStore
+	// Local is where blobs from peers are stored. If it is nil, all requests are refused.
+	Local PeerStorage
+	// Ledger records blobs stored for and by peers. If it is nil, nothing is recorded.
+	Ledger *bcstate.Ledger
+	// OnStored is called with the ID of each blob persisted for a peer. It can be nil.
+	OnStored func(id blobs.ID)
+}
+
+// Storage asks peers to store blobs, and stores blobs for peers.
+// Only peers with positive trust can store blobs locally, but any peer can release or check
+// the blobs it has stored.
+type Storage struct {
+	peerSwarm *peers.PeerSwarm
+	peerStore peers.PeerStore
+	local     PeerStorage
+	ledger    *bcstate.Ledger
+	onStored  func(id blobs.ID)
+}
+
+func NewStorage(params StorageParams) *Storage {
+	s := &Storage{
+		peerSwarm: params.PeerSwarm,
+		peerStore: params.PeerStore,
+		local:     params.Local,
+		ledger:    params.Ledger,
+		onStored:  params.OnStored,
+	}
+	s.peerSwarm.OnAsk(s.handleAsk)
+	return s
+}
+
+// Store asks peer to store data.
+// If persist is false, the peer only caches the blob.
+func (s *Storage) Store(ctx context.Context, peer p2p.PeerID, data []byte, persist bool) error {
+	res, err := s.ask(ctx, peer, &StorageReq{
+		Req: &bcproto.StorageReq_Store{Store: &StoreReq{Data: data, Persist: persist}},
+	})
+	if err != nil {
+		return err
+	}
+	storeRes := res.GetStore()
+	if storeRes == nil {
+		return errors.New("bad response to store request")
+	}
+	if err := statusToError(storeRes.Status); err != nil {
+		return err
+	}
+	if !blobs.IDFromBytes(storeRes.BlobId).Equals(blobs.Hash(data)) {
+		return errors.New("peer stored blob with the wrong id")
+	}
+	if persist {
+		if err := s.ledger.Received(peer, bcstate.Favors{BytesStored: uint64(len(data))}); err != nil {
+			log.Error(err)
+		}
+	}
+	return nil
+}
+
+// Release tells peer it no longer needs to hold ids.
+func (s *Storage) Release(ctx context.Context, peer p2p.PeerID, ids []blobs.ID) error {
+	if len(ids) > MaxStorageIDs {
+		return ErrTooManyIDs
+	}
+	res, err := s.ask(ctx, peer, &StorageReq{
+		Req: &bcproto.StorageReq_Release{Release: &ReleaseReq{BlobIds: idsToBytes(ids)}},
+	})
+	if err != nil {
+		return err
+	}
+	releaseRes := res.GetRelease()
+	if releaseRes == nil {
+		return errors.New("bad response to release request")
+	}
+	if releaseRes.Status != bcproto.StorageStatus_STORAGE_OK {
+		return errors.New("peer failed to release blobs")
+	}
+	return nil
+}
+
+// Check asks peer whether it still holds each of ids for us.
+func (s *Storage) Check(ctx context.Context, peer p2p.PeerID, ids []blobs.ID) ([]bool, error) {
+	if len(ids) > MaxStorageIDs {
+		return nil, ErrTooManyIDs
+	}
+	res, err := s.ask(ctx, peer, &StorageReq{
+		Req: &bcproto.StorageReq_Check{Check: &CheckReq{BlobIds: idsToBytes(ids)}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	checkRes := res.GetCheck()
+	if checkRes == nil || len(checkRes.Held) != len(ids) {
+		return nil, errors.New("bad response to check request")
+	}
+	return checkRes.Held, nil
+}
+
+func (s *Storage) ask(ctx context.Context, peer p2p.PeerID, req *StorageReq) (*StorageRes, error) {
+	reqData, err := proto.Marshal(req)
+	if err != nil {
+		panic(err)
+	}
+	resData, err := s.peerSwarm.AskPeer(ctx, peer, reqData)
+	if err != nil {
+		return nil, err
+	}
+	res := &StorageRes{}
+	if err := proto.Unmarshal(resData, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (s *Storage) handleAsk(ctx context.Context, m *p2p.Message, w io.Writer) {
+	req := &StorageReq{}
+	if err := proto.Unmarshal(m.Payload, req); err != nil {
+		log.Error(err)
+		return
+	}
+	peer := m.Src.(p2p.PeerID)
+	res := &StorageRes{}
+	switch x := req.Req.(type) {
+	case *bcproto.StorageReq_Store:
+		res.Res = &bcproto.StorageRes_Store{Store: s.handleStore(ctx, peer, x.Store)}
+	case *bcproto.StorageReq_Release:
+		res.Res = &bcproto.StorageRes_Release{Release: s.handleRelease(ctx, peer, x.Release)}
+	case *bcproto.StorageReq_Check:
+		checkRes, ok := s.handleCheck(ctx, peer, x.Check)
+		if !ok {
+			return
+		}
+		res.Res = &bcproto.StorageRes_Check{Check: checkRes}
+	default:
+		log.Warn("unrecognized storage request from ", peer)
+		return
+	}
+	data, err := proto.Marshal(res)
+	if err != nil {
+		panic(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		log.Error(err)
+	}
+}
+
+func (s *Storage) handleStore(ctx context.Context, peer p2p.PeerID, req *StoreReq) *StoreRes {
+	id := blobs.Hash(req.Data)
+	res := &StoreRes{BlobId: id[:]}
+	if s.local == nil || !s.isTrusted(peer) || s.inDebt(peer) || len(req.Data) > blobs.MaxSize {
+		res.Status = bcproto.StorageStatus_STORAGE_REFUSED
+		return res
+	}
+	switch err := s.local.StoreFor(ctx, peer, req.Data, req.Persist); {
+	case err == nil:
+		res.Status = bcproto.StorageStatus_STORAGE_OK
+		if req.Persist {
+			if err := s.ledger.Gave(peer, bcstate.Favors{BytesStored: uint64(len(req.Data))}); err != nil {
+				log.Error(err)
+			}
+			if s.onStored != nil {
+				s.onStored(id)
+			}
+		}
+	case err == bcstate.ErrFull:
+		res.Status = bcproto.StorageStatus_STORAGE_FULL
+	default:
+		log.Error(err)
+		res.Status = bcproto.StorageStatus_STORAGE_UNKNOWN
+	}
+	return res
+}
+
+func (s *Storage) handleRelease(ctx context.Context, peer p2p.PeerID, req *ReleaseReq) *ReleaseRes {
+	res := &ReleaseRes{Status: bcproto.StorageStatus_STORAGE_OK}
+	if s.local == nil {
+		return res
+	}
+	if len(req.BlobIds) > MaxStorageIDs {
+		res.Status = bcproto.StorageStatus_STORAGE_REFUSED
+		return res
+	}
+	if err := s.local.ReleaseFor(ctx, peer, idsFromBytes(req.BlobIds)); err != nil {
+		log.Error(err)
+		res.Status = bcproto.StorageStatus_STORAGE_UNKNOWN
+	}
+	return res
+}
+
+// handleCheck returns false if the request could not be answered.
+func (s *Storage) handleCheck(ctx context.Context, peer p2p.PeerID, req *CheckReq) (*CheckRes, bool) {
+	if len(req.BlobIds) > MaxStorageIDs {
+		return nil, false
+	}
+	res := &CheckRes{Held: make([]bool, len(req.BlobIds))}
+	if s.local == nil {
+		return res, true
+	}
+	held, err := s.local.HeldFor(ctx, peer, idsFromBytes(req.BlobIds))
+	if err != nil {
+		log.Error(err)
+		return nil, false
+	}
+	copy(res.Held, held)
+	return res, true
+}
+
+func (s *Storage) isTrusted(peer p2p.PeerID) bool {
+	trust, err := s.peerStore.TrustFor(peer)
+	return err == nil && trust > 0
+}
+
+func (s *Storage) inDebt(peer p2p.PeerID) bool {
+	inDebt, err := s.ledger.InDebt(peer)
+	if err != nil {
+		log.Error(err)
+		return true
+	}
+	return inDebt
+}
+
+func statusToError(x StorageStatus) error {
+	switch x {
+	case bcproto.StorageStatus_STORAGE_OK:
+		return nil
+	case bcproto.StorageStatus_STORAGE_REFUSED:
+		return ErrStorageRefused
+	case bcproto.StorageStatus_STORAGE_FULL:
+		return ErrPeerFull
+	default:
+		return errors.New("peer failed to store blob")
+	}
+}
+
+func idsToBytes(ids []blobs.ID) [][]byte {
+	out := make([][]byte, len(ids))
+	for i := range ids {
+		out[i] = ids[i][:]
+	}
+	return out
+}
+
+func idsFromBytes(xs [][]byte) []blobs.ID {
+	out := make([]blobs.ID, len(xs))
+	for i := range xs {
+		out[i] = blobs.IDFromBytes(xs[i])
+	}
+	return out
+}
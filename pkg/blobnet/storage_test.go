@@ -0,0 +1,186 @@
+package blobnet
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/brendoncarroll/go-p2p"
+	"github.com/brendoncarroll/go-p2p/p2ptest"
+	"github.com/brendoncarroll/go-p2p/s/memswarm"
+	"github.com/jonboulle/clockwork"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blobcache/blobcache/pkg/bcstate"
+	"github.com/blobcache/blobcache/pkg/blobnet/peers"
+	"github.com/blobcache/blobcache/pkg/blobs"
+)
+
+func TestStorage(t *testing.T) {
+	ctx := context.TODO()
+	realm := memswarm.NewRealm()
+	s1 := realm.NewSwarmWithKey(p2ptest.NewTestKey(t, 0))
+	s2 := realm.NewSwarmWithKey(p2ptest.NewTestKey(t, 1))
+	id1, id2 := p2p.NewPeerID(s1.PublicKey()), p2p.NewPeerID(s2.PublicKey())
+
+	ps1 := trustPeerStore{MemPeerStore: make(peers.MemPeerStore), trust: map[p2p.PeerID]int64{}}
+	ps1.AddAddr(id2, s2.LocalAddrs()[0])
+	ps2 := trustPeerStore{MemPeerStore: make(peers.MemPeerStore), trust: map[p2p.PeerID]int64{}}
+	ps2.AddAddr(id1, s1.LocalAddrs()[0])
+
+	stored := &memPeerStorage{}
+	bn1 := makeBlobnet(s1, ps1)
+	bn2 := newTestBlobnet(s2, Params{
+		PeerStore:   ps2,
+		DB:          &bcstate.MemDB{},
+		Local:       bcstate.BlobAdapter(&bcstate.MemKV{Capacity: 100}),
+		Clock:       clockwork.NewRealClock(),
+		PeerStorage: stored,
+	})
+	defer bn1.Close()
+	defer bn2.Close()
+	<-bn1.bootstrapped
+	<-bn2.bootstrapped
+
+	data := []byte("test-data")
+	// bn2 does not trust bn1 yet
+	err := bn1.StoreOn(ctx, id2, data)
+	assert.Equal(t, ErrStorageRefused, err)
+	assert.Empty(t, stored.blobs)
+
+	ps2.trust[id1] = 1
+	require.NoError(t, bn1.StoreOn(ctx, id2, data))
+	assert.Equal(t, []blobs.ID{blobs.Hash(data)}, stored.ids(id1))
+
+	// check and release work regardless of trust
+	ps2.trust[id1] = 0
+	ids := []blobs.ID{blobs.Hash(data), blobs.Hash([]byte("other-data"))}
+	held, err := bn1.CheckOn(ctx, id2, ids)
+	require.NoError(t, err)
+	assert.Equal(t, []bool{true, false}, held)
+	require.NoError(t, bn1.ReleaseOn(ctx, id2, ids))
+	held, err = bn1.CheckOn(ctx, id2, ids)
+	require.NoError(t, err)
+	assert.Equal(t, []bool{false, false}, held)
+	assert.Empty(t, stored.ids(id1))
+
+	// bn1 has no storage to offer
+	ps1.trust[id2] = 1
+	err = bn2.StoreOn(ctx, id1, data)
+	assert.Equal(t, ErrStorageRefused, err)
+}
+
+func TestStorageDebt(t *testing.T) {
+	ctx := context.TODO()
+	realm := memswarm.NewRealm()
+	s1 := realm.NewSwarmWithKey(p2ptest.NewTestKey(t, 0))
+	s2 := realm.NewSwarmWithKey(p2ptest.NewTestKey(t, 1))
+	id1, id2 := p2p.NewPeerID(s1.PublicKey()), p2p.NewPeerID(s2.PublicKey())
+
+	ps1 := trustPeerStore{MemPeerStore: make(peers.MemPeerStore), trust: map[p2p.PeerID]int64{}}
+	ps1.AddAddr(id2, s2.LocalAddrs()[0])
+	ps2 := trustPeerStore{MemPeerStore: make(peers.MemPeerStore), trust: map[p2p.PeerID]int64{id1: 10}}
+	ps2.AddAddr(id1, s1.LocalAddrs()[0])
+
+	ledger1 := bcstate.NewLedger(&bcstate.MemKV{}, ps1.TrustFor)
+	ledger2 := bcstate.NewLedger(&bcstate.MemKV{}, ps2.TrustFor)
+	bn1 := newTestBlobnet(s1, Params{
+		PeerStore: ps1,
+		DB:        &bcstate.MemDB{},
+		Local:     bcstate.BlobAdapter(&bcstate.MemKV{Capacity: 100}),
+		Clock:     clockwork.NewRealClock(),
+		Ledger:    ledger1,
+	})
+	bn2 := newTestBlobnet(s2, Params{
+		PeerStore:   ps2,
+		DB:          &bcstate.MemDB{},
+		Local:       bcstate.BlobAdapter(&bcstate.MemKV{Capacity: 100}),
+		Clock:       clockwork.NewRealClock(),
+		PeerStorage: &memPeerStorage{},
+		Ledger:      ledger2,
+	})
+	defer bn1.Close()
+	defer bn2.Close()
+	<-bn1.bootstrapped
+	<-bn2.bootstrapped
+
+	// bn1 can store up to its trust and the allowance, and one blob past it.
+	for i := 0; i < bcstate.DefaultAllowance/blobs.MaxSize; i++ {
+		data := make([]byte, blobs.MaxSize)
+		data[0] = byte(i)
+		require.NoError(t, bn1.StoreOn(ctx, id2, data))
+	}
+	require.NoError(t, bn1.StoreOn(ctx, id2, []byte("0123456789")))
+	require.NoError(t, bn1.StoreOn(ctx, id2, []byte("x")))
+	assert.Equal(t, ErrStorageRefused, bn1.StoreOn(ctx, id2, []byte("y")))
+
+	b2, err := ledger2.Balance(id1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(bcstate.DefaultAllowance+11), b2.Debt())
+	b1, err := ledger1.Balance(id2)
+	require.NoError(t, err)
+	assert.Equal(t, -int64(bcstate.DefaultAllowance+11), b1.Debt())
+}
+
+type trustPeerStore struct {
+	peers.MemPeerStore
+	trust map[p2p.PeerID]int64
+}
+
+func (ps trustPeerStore) TrustFor(id p2p.PeerID) (int64, error) {
+	return ps.trust[id], nil
+}
+
+type memPeerStorage struct {
+	mu    sync.Mutex
+	blobs map[p2p.PeerID][]blobs.ID
+}
+
+func (s *memPeerStorage) StoreFor(ctx context.Context, peer p2p.PeerID, data []byte, persist bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.blobs == nil {
+		s.blobs = make(map[p2p.PeerID][]blobs.ID)
+	}
+	s.blobs[peer] = append(s.blobs[peer], blobs.Hash(data))
+	return nil
+}
+
+func (s *memPeerStorage) ReleaseFor(ctx context.Context, peer p2p.PeerID, ids []blobs.ID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var kept []blobs.ID
+	for _, id := range s.blobs[peer] {
+		if !containsID(ids, id) {
+			kept = append(kept, id)
+		}
+	}
+	s.blobs[peer] = kept
+	return nil
+}
+
+func (s *memPeerStorage) HeldFor(ctx context.Context, peer p2p.PeerID, ids []blobs.ID) ([]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	held := make([]bool, len(ids))
+	for i := range ids {
+		held[i] = containsID(s.blobs[peer], ids[i])
+	}
+	return held, nil
+}
+
+func (s *memPeerStorage) ids(peer p2p.PeerID) []blobs.ID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.blobs[peer]
+}
+
+func containsID(ids []blobs.ID, id blobs.ID) bool {
+	for i := range ids {
+		if ids[i].Equals(id) {
+			return true
+		}
+	}
+	return false
+}
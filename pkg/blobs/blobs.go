@@ -28,6 +28,9 @@ func (id ID) String() string {
 }
 
 func (id *ID) UnmarshalB64(data []byte) error {
+	if base64.RawURLEncoding.DecodedLen(len(data)) != IDSize {
+		return errors.New("base64 string is wrong length for ID")
+	}
 	n, err := base64.RawURLEncoding.Decode(id[:], data)
 	if err != nil {
 		return err
@@ -18,7 +18,9 @@ func (c ReadChain) GetF(ctx context.Context, id ID, f func(Blob) error) error {
 				continue
 			}
 			errs = append(errs, err)
+			continue
 		}
+		return nil
 	}
 	if len(errs) > 0 {
 		return fmt.Errorf("multiple errors: %v", errs)
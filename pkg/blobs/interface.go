@@ -37,4 +37,5 @@ type Lister interface {
 var (
 	ErrTooMany  = errors.New("prefix would take up more space than buffer")
 	ErrNotFound = errors.New("blob no found")
+	ErrTooLarge = errors.New("blob is too large")
 )
@@ -0,0 +1,318 @@
+package eviction
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+
+	"github.com/jonboulle/clockwork"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/blobcache/blobcache/pkg/bcstate"
+	"github.com/blobcache/blobcache/pkg/blobs"
+)
+
+var _ blobs.Store = &Cache{}
+
+type Params struct {
+	KV       bcstate.KV
+	Capacity uint64
+	Policy   Policy
+	Clock    clockwork.Clock
+}
+
+// Cache is a blob store which evicts blobs according to a Policy
+// to stay under a capacity in bytes.
+type Cache struct {
+	kv       bcstate.KV
+	capacity uint64
+	policy   Policy
+	clock    clockwork.Clock
+
+	mu      sync.Mutex
+	entries map[blobs.ID]*Entry
+	// heap orders the entries by policy, with the next to be evicted first.
+	heap entryHeap
+	used uint64
+	// age is the largest Frequency evicted so far.
+	age      uint64
+	onChange func(id blobs.ID, added bool)
+}
+
+// New creates a cache, indexing any blobs which already exist in params.KV.
+// If they take up more than params.Capacity, blobs are evicted until they fit.
+func New(params Params) (*Cache, error) {
+	policy := params.Policy
+	if policy == nil {
+		policy = LRU{}
+	}
+	clock := params.Clock
+	if clock == nil {
+		clock = clockwork.NewRealClock()
+	}
+	c := &Cache{
+		kv:       params.KV,
+		capacity: params.Capacity,
+		policy:   policy,
+		clock:    clock,
+		entries:  make(map[blobs.ID]*Entry),
+		heap:     entryHeap{policy: policy},
+	}
+	now := clock.Now()
+	if err := c.kv.ForEach(nil, nil, func(k, v []byte) error {
+		id := blobs.IDFromBytes(k)
+		ent := &Entry{
+			ID:         id,
+			Size:       uint64(len(v)),
+			AddedAt:    now,
+			LastAccess: now,
+		}
+		c.entries[id] = ent
+		c.heap.ents = append(c.heap.ents, ent)
+		c.used += uint64(len(v))
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	for i, ent := range c.heap.ents {
+		ent.index = i
+	}
+	heap.Init(&c.heap)
+	// the capacity may have been lowered since the blobs were added.
+	for c.used > c.capacity {
+		if err := c.evictOne(); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// OnChange sets fn to be called whenever a blob is added to, or removed from the cache.
+// fn is called with the cache locked, so it must not use the cache.
+func (c *Cache) OnChange(fn func(id blobs.ID, added bool)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onChange = fn
+}
+
+// Post adds data to the cache, evicting other blobs if necessary.
+// If the policy would evict data before the blobs it would displace, ErrFull is returned.
+func (c *Cache) Post(ctx context.Context, data []byte) (blobs.ID, error) {
+	return c.post(ctx, data, true)
+}
+
+// TryPost adds data to the cache only if it fits without evicting anything.
+// It returns bcstate.ErrFull if it does not fit.
+func (c *Cache) TryPost(ctx context.Context, data []byte) (blobs.ID, error) {
+	return c.post(ctx, data, false)
+}
+
+func (c *Cache) post(ctx context.Context, data []byte, evict bool) (blobs.ID, error) {
+	id := blobs.Hash(data)
+	size := uint64(len(data))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exists := c.entries[id]; exists {
+		c.touch(id)
+		return id, nil
+	}
+	if size > c.capacity {
+		return blobs.ID{}, bcstate.ErrFull
+	}
+	now := c.clock.Now()
+	ent := &Entry{
+		ID:         id,
+		Size:       size,
+		AddedAt:    now,
+		LastAccess: now,
+		Accesses:   1,
+		Frequency:  c.age + 1,
+		index:      -1,
+	}
+	if c.used+size > c.capacity {
+		if !evict {
+			return blobs.ID{}, bcstate.ErrFull
+		}
+		if err := c.evict(ent); err != nil {
+			return blobs.ID{}, err
+		}
+	}
+	for {
+		err := c.kv.Put(id[:], data)
+		if err == nil {
+			break
+		}
+		// the KV may be sharing space with other data, so it can fill up before the cache does.
+		if err != bcstate.ErrFull || !evict || len(c.entries) == 0 {
+			return blobs.ID{}, err
+		}
+		if err := c.evictOne(); err != nil {
+			return blobs.ID{}, err
+		}
+	}
+	c.entries[id] = ent
+	heap.Push(&c.heap, ent)
+	c.used += size
+	if c.onChange != nil {
+		c.onChange(id, true)
+	}
+	return id, nil
+}
+
+func (c *Cache) GetF(ctx context.Context, id blobs.ID, fn func([]byte) error) error {
+	err := c.kv.GetF(id[:], fn)
+	if err == bcstate.ErrNotExist {
+		return blobs.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.touch(id)
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *Cache) Exists(ctx context.Context, id blobs.ID) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, exists := c.entries[id]
+	return exists, nil
+}
+
+func (c *Cache) Delete(ctx context.Context, id blobs.ID) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.delete(id)
+}
+
+func (c *Cache) List(ctx context.Context, prefix []byte, ids []blobs.ID) (int, error) {
+	return bcstate.BlobAdapter(c.kv).List(ctx, prefix, ids)
+}
+
+// SizeUsed returns the number of bytes stored in the cache
+func (c *Cache) SizeUsed() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.used
+}
+
+// SizeTotal returns the capacity of the cache in bytes
+func (c *Cache) SizeTotal() uint64 {
+	return c.capacity
+}
+
+// evict removes entries in policy order until there is room for ent.
+// If ent would be evicted before enough space was freed, nothing is evicted and ErrFull is returned.
+// ent is aged by the frequency of each victim, as it would be if it had been added after they were evicted.
+func (c *Cache) evict(ent *Entry) error {
+	need := c.used + ent.Size - c.capacity
+	var victims []*Entry
+	var freed uint64
+	age := c.age
+	for freed < need {
+		victim := heap.Pop(&c.heap).(*Entry)
+		victims = append(victims, victim)
+		if victim.Frequency > age {
+			age = victim.Frequency
+		}
+		ent.Frequency = age + ent.Accesses
+		if c.policy.Less(ent, victim) {
+			for _, victim := range victims {
+				heap.Push(&c.heap, victim)
+			}
+			ent.Frequency = c.age + ent.Accesses
+			return bcstate.ErrFull
+		}
+		freed += victim.Size
+	}
+	for _, victim := range victims {
+		if err := c.delete(victim.ID); err != nil {
+			return err
+		}
+	}
+	c.age = age
+	log.WithFields(log.Fields{
+		"count": len(victims),
+		"bytes": freed,
+	}).Debug("evicted blobs from cache")
+	return nil
+}
+
+// evictOne removes the entry which the policy would evict first
+func (c *Cache) evictOne() error {
+	if c.heap.Len() == 0 {
+		return nil
+	}
+	victim := c.heap.ents[0]
+	if victim.Frequency > c.age {
+		c.age = victim.Frequency
+	}
+	return c.delete(victim.ID)
+}
+
+func (c *Cache) delete(id blobs.ID) error {
+	ent, exists := c.entries[id]
+	if !exists {
+		return nil
+	}
+	if err := c.kv.Delete(id[:]); err != nil {
+		return err
+	}
+	if ent.index >= 0 {
+		heap.Remove(&c.heap, ent.index)
+	}
+	delete(c.entries, id)
+	c.used -= ent.Size
+	if c.onChange != nil {
+		c.onChange(id, false)
+	}
+	return nil
+}
+
+func (c *Cache) touch(id blobs.ID) {
+	ent, exists := c.entries[id]
+	if !exists {
+		return
+	}
+	ent.LastAccess = c.clock.Now()
+	ent.Accesses++
+	ent.Frequency = c.age + ent.Accesses
+	heap.Fix(&c.heap, ent.index)
+}
+
+// entryHeap is a heap of entries, ordered by policy
+type entryHeap struct {
+	policy Policy
+	ents   []*Entry
+}
+
+func (h entryHeap) Len() int {
+	return len(h.ents)
+}
+
+func (h entryHeap) Less(i, j int) bool {
+	return h.policy.Less(h.ents[i], h.ents[j])
+}
+
+func (h entryHeap) Swap(i, j int) {
+	h.ents[i], h.ents[j] = h.ents[j], h.ents[i]
+	h.ents[i].index = i
+	h.ents[j].index = j
+}
+
+func (h *entryHeap) Push(x interface{}) {
+	ent := x.(*Entry)
+	ent.index = len(h.ents)
+	h.ents = append(h.ents, ent)
+}
+
+func (h *entryHeap) Pop() interface{} {
+	n := len(h.ents)
+	ent := h.ents[n-1]
+	h.ents[n-1] = nil
+	h.ents = h.ents[:n-1]
+	ent.index = -1
+	return ent
+}
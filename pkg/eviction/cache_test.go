@@ -0,0 +1,205 @@
+package eviction
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/blobcache/blobcache/pkg/bcstate"
+	"github.com/blobcache/blobcache/pkg/blobs"
+)
+
+const blobSize = 100
+
+func TestCapacity(t *testing.T) {
+	ctx := context.TODO()
+	c := newTestCache(t, LRU{}, clockwork.NewFakeClock())
+	for i := 0; i < 100; i++ {
+		_, err := c.Post(ctx, makeBlob(i))
+		require.NoError(t, err)
+		require.True(t, c.SizeUsed() <= c.SizeTotal())
+	}
+}
+
+func TestLRU(t *testing.T) {
+	ctx := context.TODO()
+	clock := clockwork.NewFakeClock()
+	c := newTestCache(t, LRU{}, clock)
+	ids := postN(t, c, clock, 10)
+
+	// access the first blob so it is the most recently used.
+	require.NoError(t, c.GetF(ctx, ids[0], func([]byte) error { return nil }))
+	clock.Advance(time.Second)
+	_, err := c.Post(ctx, makeBlob(10))
+	require.NoError(t, err)
+
+	assertExists(t, c, ids[0], true)
+	assertExists(t, c, ids[1], false)
+}
+
+func TestLFU(t *testing.T) {
+	ctx := context.TODO()
+	clock := clockwork.NewFakeClock()
+	c := newTestCache(t, LFU{}, clock)
+	ids := postN(t, c, clock, 10)
+
+	// access everything but the last blob
+	for _, id := range ids[:9] {
+		require.NoError(t, c.GetF(ctx, id, func([]byte) error { return nil }))
+	}
+	_, err := c.Post(ctx, makeBlob(10))
+	require.NoError(t, err)
+
+	assertExists(t, c, ids[0], true)
+	assertExists(t, c, ids[9], false)
+}
+
+func TestLFUAdmitsNew(t *testing.T) {
+	ctx := context.TODO()
+	clock := clockwork.NewFakeClock()
+	c := newTestCache(t, LFU{}, clock)
+	ids := postN(t, c, clock, 10)
+	for i := 0; i < 5; i++ {
+		for _, id := range ids {
+			require.NoError(t, c.GetF(ctx, id, func([]byte) error { return nil }))
+		}
+	}
+
+	// a new blob gets in, even though everything in the cache has been accessed more,
+	// and once it is hot, it is kept over blobs which have not been accessed since.
+	hot, err := c.Post(ctx, makeBlob(10))
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		clock.Advance(time.Second)
+		require.NoError(t, c.GetF(ctx, hot, func([]byte) error { return nil }))
+	}
+	for i := 11; i < 20; i++ {
+		clock.Advance(time.Second)
+		_, err := c.Post(ctx, makeBlob(i))
+		require.NoError(t, err)
+	}
+	assertExists(t, c, hot, true)
+	for _, id := range ids {
+		assertExists(t, c, id, false)
+	}
+}
+
+func TestKademlia(t *testing.T) {
+	ctx := context.TODO()
+	clock := clockwork.NewFakeClock()
+	locus := make([]byte, blobs.IDSize)
+	c := newTestCache(t, Kademlia{Locus: locus}, clock)
+	for i := 0; i < 100; i++ {
+		_, err := c.Post(ctx, makeBlob(i))
+		if err != bcstate.ErrFull {
+			require.NoError(t, err)
+		}
+	}
+	// everything left should be closer to the locus than anything evicted
+	var farthestKept []byte
+	for id := range c.entries {
+		if farthestKept == nil || string(id[:]) > string(farthestKept) {
+			farthestKept = append([]byte{}, id[:]...)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		id := blobs.Hash(makeBlob(i))
+		if _, kept := c.entries[id]; !kept {
+			assert.True(t, string(id[:]) > string(farthestKept))
+		}
+	}
+}
+
+func TestSharedKV(t *testing.T) {
+	clock := clockwork.NewFakeClock()
+	// the KV fills up before the cache does.
+	kv := &bcstate.MemKV{Capacity: 5 * (blobs.IDSize + blobSize)}
+	c, err := New(Params{
+		KV:       kv,
+		Capacity: 10 * blobSize,
+		Clock:    clock,
+	})
+	require.NoError(t, err)
+	ids := postN(t, c, clock, 10)
+	assertExists(t, c, ids[0], false)
+	assertExists(t, c, ids[9], true)
+	require.True(t, kv.SizeUsed() <= kv.SizeTotal())
+}
+
+func TestLoweredCapacity(t *testing.T) {
+	clock := clockwork.NewFakeClock()
+	kv := &bcstate.MemKV{}
+	c, err := New(Params{KV: kv, Capacity: 10 * blobSize, Clock: clock})
+	require.NoError(t, err)
+	postN(t, c, clock, 10)
+
+	// reopen the same blobs with room for half of them.
+	c, err = New(Params{KV: kv, Capacity: 5 * blobSize, Clock: clock})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(5*blobSize), c.SizeUsed())
+	assert.Equal(t, uint64(5*(blobs.IDSize+blobSize)), kv.SizeUsed())
+}
+
+func TestTryPost(t *testing.T) {
+	ctx := context.TODO()
+	clock := clockwork.NewFakeClock()
+	c := newTestCache(t, LRU{}, clock)
+	postN(t, c, clock, 10)
+	_, err := c.TryPost(ctx, makeBlob(10))
+	require.Equal(t, bcstate.ErrFull, err)
+}
+
+func TestOnChange(t *testing.T) {
+	clock := clockwork.NewFakeClock()
+	c := newTestCache(t, LRU{}, clock)
+	present := map[blobs.ID]bool{}
+	c.OnChange(func(id blobs.ID, added bool) {
+		present[id] = added
+	})
+	ids := postN(t, c, clock, 11)
+	// the first blob was evicted to make room for the last
+	assert.Contains(t, present, ids[0])
+	assert.False(t, present[ids[0]])
+	assert.True(t, present[ids[10]])
+	require.NoError(t, c.Delete(context.TODO(), ids[10]))
+	assert.False(t, present[ids[10]])
+}
+
+func newTestCache(t *testing.T, p Policy, clock clockwork.Clock) *Cache {
+	c, err := New(Params{
+		KV:       &bcstate.MemKV{},
+		Capacity: 10 * blobSize,
+		Policy:   p,
+		Clock:    clock,
+	})
+	require.NoError(t, err)
+	return c
+}
+
+func postN(t *testing.T, c *Cache, clock clockwork.FakeClock, n int) []blobs.ID {
+	ids := make([]blobs.ID, n)
+	for i := range ids {
+		id, err := c.Post(context.TODO(), makeBlob(i))
+		require.NoError(t, err)
+		ids[i] = id
+		clock.Advance(time.Second)
+	}
+	return ids
+}
+
+func assertExists(t *testing.T, c *Cache, id blobs.ID, expected bool) {
+	exists, err := c.Exists(context.TODO(), id)
+	require.NoError(t, err)
+	assert.Equal(t, expected, exists)
+}
+
+func makeBlob(i int) []byte {
+	data := make([]byte, blobSize)
+	binary.BigEndian.PutUint64(data, uint64(i))
+	return data
+}
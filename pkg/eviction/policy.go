@@ -0,0 +1,82 @@
+package eviction
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/brendoncarroll/go-p2p/p/kademlia"
+	"github.com/pkg/errors"
+
+	"github.com/blobcache/blobcache/pkg/blobs"
+)
+
+// Entry is the metadata the cache keeps about each blob
+type Entry struct {
+	ID         blobs.ID
+	Size       uint64
+	AddedAt    time.Time
+	LastAccess time.Time
+	Accesses   uint64
+	// Frequency is Accesses plus the Frequency of the entries the cache had evicted at the last access.
+	// It lets new entries compete with entries which were accessed often a long time ago.
+	Frequency uint64
+
+	// index is the position of the entry in the cache's heap, or -1 if it is not in the heap.
+	index int
+}
+
+// Policy decides the order in which entries are evicted
+type Policy interface {
+	// Less returns true if a should be evicted before b.
+	// The order of an entry may only change when it is accessed.
+	Less(a, b *Entry) bool
+}
+
+// LRU evicts the least recently accessed entries first
+type LRU struct{}
+
+func (LRU) Less(a, b *Entry) bool {
+	return a.LastAccess.Before(b.LastAccess)
+}
+
+// LFU evicts the least frequently accessed entries first.
+// Frequencies are aged by the frequency of evicted entries, so new blobs are always admitted,
+// and blobs which were popular once do not stay forever.
+// Ties are broken by recency.
+type LFU struct{}
+
+func (LFU) Less(a, b *Entry) bool {
+	if a.Frequency != b.Frequency {
+		return a.Frequency < b.Frequency
+	}
+	return a.LastAccess.Before(b.LastAccess)
+}
+
+// Kademlia evicts the entries farthest from Locus in keyspace first.
+// Nodes using it will tend to specialize in the part of the keyspace near their ID.
+type Kademlia struct {
+	Locus []byte
+}
+
+func (p Kademlia) Less(a, b *Entry) bool {
+	da := make([]byte, len(p.Locus))
+	db := make([]byte, len(p.Locus))
+	kademlia.XORBytes(da, p.Locus, a.ID[:])
+	kademlia.XORBytes(db, p.Locus, b.ID[:])
+	return bytes.Compare(da, db) > 0
+}
+
+// ParsePolicy returns the policy with name.
+// locus is only used by the kademlia policy.
+func ParsePolicy(name string, locus []byte) (Policy, error) {
+	switch name {
+	case "", "lru":
+		return LRU{}, nil
+	case "lfu":
+		return LFU{}, nil
+	case "kademlia":
+		return Kademlia{Locus: locus}, nil
+	default:
+		return nil, errors.Errorf("unknown eviction policy %q", name)
+	}
+}
@@ -0,0 +1,121 @@
+package tries
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/blobcache/blobcache/pkg/blobs"
+)
+
+// Diff calls fn for every key which is only in one of left or right, or which has a different value in each.
+// The entries passed to fn have full keys, and left or right is nil if the key is missing from that trie.
+// Keys are visited in order.
+//
+// Subtrees with the same ID are skipped, so the cost is proportional to the size of the difference,
+// not the size of the tries.
+func Diff(ctx context.Context, s blobs.Store, left, right Ref, fn func(left, right *Entry) error) error {
+	if left.ID.Equals(right.ID) {
+		return nil
+	}
+	l, err := GetNode(ctx, s, left)
+	if err != nil {
+		return err
+	}
+	r, err := GetNode(ctx, s, right)
+	if err != nil {
+		return err
+	}
+	if !IsParent(l) || !IsParent(r) || !bytes.Equal(l.Prefix, r.Prefix) {
+		// leaves are at most a single blob, so just compare all the entries.
+		lEnts, err := collectEntries(ctx, s, l)
+		if err != nil {
+			return err
+		}
+		rEnts, err := collectEntries(ctx, s, r)
+		if err != nil {
+			return err
+		}
+		return diffEntries(lEnts, rEnts, fn)
+	}
+
+	if err := diffEntries(fullEntries(l.Prefix, l.Entries), fullEntries(r.Prefix, r.Entries), fn); err != nil {
+		return err
+	}
+	for i := range l.Children {
+		lc, rc := l.Children[i], r.Children[i]
+		switch {
+		case isNilChild(lc) && isNilChild(rc):
+			continue
+		case isNilChild(lc):
+			err = ForEach(ctx, s, fromChildProto(rc), nil, nil, func(k, v []byte) error {
+				return fn(nil, &Entry{Key: k, Value: v})
+			})
+		case isNilChild(rc):
+			err = ForEach(ctx, s, fromChildProto(lc), nil, nil, func(k, v []byte) error {
+				return fn(&Entry{Key: k, Value: v}, nil)
+			})
+		default:
+			err = Diff(ctx, s, fromChildProto(lc), fromChildProto(rc), fn)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diffEntries merges two sorted lists of entries, calling fn for each difference.
+func diffEntries(ls, rs []*Entry, fn func(left, right *Entry) error) error {
+	for len(ls) > 0 || len(rs) > 0 {
+		var cmp int
+		switch {
+		case len(ls) == 0:
+			cmp = 1
+		case len(rs) == 0:
+			cmp = -1
+		default:
+			cmp = bytes.Compare(ls[0].Key, rs[0].Key)
+		}
+		var err error
+		switch {
+		case cmp < 0:
+			err = fn(ls[0], nil)
+			ls = ls[1:]
+		case cmp > 0:
+			err = fn(nil, rs[0])
+			rs = rs[1:]
+		default:
+			if !bytes.Equal(ls[0].Value, rs[0].Value) {
+				err = fn(ls[0], rs[0])
+			}
+			ls, rs = ls[1:], rs[1:]
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectEntries returns all the entries under n, with full keys.
+func collectEntries(ctx context.Context, s blobs.Store, n *Node) ([]*Entry, error) {
+	var ents []*Entry
+	if err := forEachNode(ctx, s, n, nil, nil, func(k, v []byte) error {
+		ents = append(ents, &Entry{Key: k, Value: v})
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return ents, nil
+}
+
+func fullEntries(prefix []byte, ents []*Entry) []*Entry {
+	out := make([]*Entry, len(ents))
+	for i, ent := range ents {
+		var key []byte
+		key = append(key, prefix...)
+		key = append(key, ent.Key...)
+		out[i] = &Entry{Key: key, Value: ent.Value}
+	}
+	return out
+}
@@ -12,6 +12,10 @@ func ForEach(ctx context.Context, s blobs.Store, x Ref, first, last []byte, fn f
 	if err != nil {
 		return err
 	}
+	return forEachNode(ctx, s, n, first, last, fn)
+}
+
+func forEachNode(ctx context.Context, s blobs.Store, n *Node, first, last []byte, fn func(key, value []byte) error) error {
 	for _, ent := range n.Entries {
 		var key []byte
 		key = append(key, n.Prefix...)
@@ -21,12 +25,12 @@ func ForEach(ctx context.Context, s blobs.Store, x Ref, first, last []byte, fn f
 		} else if last != nil && bytes.Compare(last, key) <= 0 {
 			break
 		}
-		if err := fn(ent.Key, ent.Value); err != nil {
+		if err := fn(key, ent.Value); err != nil {
 			return err
 		}
 	}
 	for i, child := range n.Children {
-		if child == nil {
+		if isNilChild(child) {
 			continue
 		}
 		var prefix []byte
@@ -43,7 +47,41 @@ func ForEach(ctx context.Context, s blobs.Store, x Ref, first, last []byte, fn f
 	return nil
 }
 
+// Walk calls fn with the ref of every node in the trie, parents before their children.
+func Walk(ctx context.Context, s blobs.Store, x Ref, fn func(Ref) error) error {
+	if err := fn(x); err != nil {
+		return err
+	}
+	n, err := GetNode(ctx, s, x)
+	if err != nil {
+		return err
+	}
+	for _, child := range n.Children {
+		if isNilChild(child) {
+			continue
+		}
+		if err := Walk(ctx, s, fromChildProto(child), fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prefixOverlaps returns true if any key with prefix could be in [first, last)
 func prefixOverlaps(prefix, first, last []byte) bool {
-	return bytes.Compare(first, prefix) <= 0 &&
-		(last == nil || bytes.Compare(last, prefix) > 0)
+	end := prefixEnd(prefix)
+	return (end == nil || bytes.Compare(first, end) < 0) &&
+		(last == nil || bytes.Compare(prefix, last) < 0)
+}
+
+// prefixEnd returns the first key after all the keys with prefix, or nil if there is no such key.
+func prefixEnd(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] < 0xff {
+			end := append([]byte{}, prefix[:i+1]...)
+			end[i]++
+			return end
+		}
+	}
+	return nil
 }
@@ -8,9 +8,8 @@ import (
 )
 
 type Ref struct {
-	ID     blobs.ID
-	DEK    *bccrypto.DEK
-	Length int
+	ID  blobs.ID      `json:"id"`
+	DEK *bccrypto.DEK `json:"dek"`
 }
 
 func toChildProto(r Ref) *ChildRef {
@@ -31,21 +30,16 @@ func fromChildProto(x *ChildRef) Ref {
 }
 
 func post(ctx context.Context, s blobs.Poster, ptext []byte) (*Ref, error) {
-	l := len(ptext)
 	id, dek, err := bccrypto.Post(ctx, s, bccrypto.Convergent, ptext)
 	if err != nil {
 		return nil, err
 	}
 	return &Ref{
-		ID:     id,
-		DEK:    dek,
-		Length: l,
+		ID:  id,
+		DEK: dek,
 	}, nil
 }
 
 func getF(ctx context.Context, s blobs.Getter, ref Ref, fn func([]byte) error) error {
-	l := ref.Length
-	return bccrypto.GetF(ctx, s, *ref.DEK, ref.ID, func(data []byte) error {
-		return fn(data[:l])
-	})
+	return bccrypto.GetF(ctx, s, *ref.DEK, ref.ID, fn)
 }
@@ -65,7 +65,16 @@ func Put(ctx context.Context, s blobs.Store, ref Ref, key, value []byte) (*Ref,
 			return PostNode(ctx, s, n)
 		}
 		c := key[len(n.Prefix)]
-		childRef := fromChildProto(n.Children[c])
+		var childRef Ref
+		if isNilChild(n.Children[c]) {
+			r, err := PostNode(ctx, s, &Node{Prefix: appendPrefix(n.Prefix, c)})
+			if err != nil {
+				return nil, err
+			}
+			childRef = *r
+		} else {
+			childRef = fromChildProto(n.Children[c])
+		}
 		childRef2, err := Put(ctx, s, childRef, key, value)
 		if err != nil {
 			return nil, err
@@ -73,10 +82,17 @@ func Put(ctx context.Context, s blobs.Store, ref Ref, key, value []byte) (*Ref,
 		n.Children[c] = toChildProto(*childRef2)
 		return PostNode(ctx, s, n)
 	}
-	n.Entries = append(n.Entries, makeEntry(n.Prefix, key, value))
-	sort.Slice(n.Entries, func(i, j int) bool {
-		return bytes.Compare(n.Entries[i].Key, n.Entries[j].Key) < 0
+	ent := makeEntry(n.Prefix, key, value)
+	i := sort.Search(len(n.Entries), func(i int) bool {
+		return bytes.Compare(n.Entries[i].Key, ent.Key) >= 0
 	})
+	if i < len(n.Entries) && bytes.Equal(n.Entries[i].Key, ent.Key) {
+		n.Entries[i] = ent
+	} else {
+		n.Entries = append(n.Entries, nil)
+		copy(n.Entries[i+1:], n.Entries[i:])
+		n.Entries[i] = ent
+	}
 	return PostNode(ctx, s, n)
 }
 
@@ -96,15 +112,14 @@ func Get(ctx context.Context, s blobs.Store, ref Ref, key []byte) ([]byte, error
 			return n.Entries[0].Value, nil
 		}
 		c := key[len(n.Prefix)]
-		if n.Children[c] == nil {
+		if isNilChild(n.Children[c]) {
 			return nil, ErrNotExist
 		}
 		childRef := fromChildProto(n.Children[c])
 		return Get(ctx, s, childRef, key)
 	}
 	for _, ent := range n.Entries {
-		entKey := append(n.Prefix, ent.Key...)
-		if bytes.Equal(entKey, key) {
+		if bytes.Equal(ent.Key, key[len(n.Prefix):]) {
 			return ent.Value, nil
 		}
 	}
@@ -121,33 +136,45 @@ func Delete(ctx context.Context, s blobs.Store, ref Ref, key []byte) (*Ref, erro
 	}
 	if IsParent(n) {
 		if len(n.Prefix) == len(key) {
-			if len(n.Entries) > 0 {
-				n.Entries = nil
-				return PostNode(ctx, s, n)
+			if len(n.Entries) == 0 {
+				return &ref, nil
+			}
+			n.Entries = nil
+		} else {
+			c := key[len(n.Prefix)]
+			if isNilChild(n.Children[c]) {
+				return &ref, nil
+			}
+			childRef := fromChildProto(n.Children[c])
+			childRef2, err := Delete(ctx, s, childRef, key)
+			if err != nil {
+				return nil, err
+			}
+			if childRef2.ID.Equals(childRef.ID) {
+				return &ref, nil
+			}
+			child, err := GetNode(ctx, s, *childRef2)
+			if err != nil {
+				return nil, err
+			}
+			if !IsParent(child) && len(child.Entries) == 0 {
+				n.Children[c] = nil
+			} else {
+				n.Children[c] = toChildProto(*childRef2)
 			}
-			return &ref, nil
-		}
-		c := key[len(n.Prefix)]
-		if n.Children[c] == nil {
-			return &ref, nil
-		}
-		childRef := fromChildProto(n.Children[c])
-		childRef2, err := Delete(ctx, s, childRef, key)
-		if err != nil {
-			return nil, err
 		}
-		n.Children[c] = toChildProto(*childRef2)
+		// collapse the node back into a single leaf if it fits, so the same entries
+		// always produce the same trie, regardless of the order of operations.
 		n2, err := Collapse(ctx, s, n)
 		if err != nil && err != ErrCannotCollapse {
 			return nil, err
-		} else if err != nil {
+		} else if err == nil {
 			n = n2
 		}
 		return PostNode(ctx, s, n)
 	}
 	for i, ent := range n.Entries {
-		entKey := append(n.Prefix, ent.Key...)
-		if bytes.Equal(entKey, key) {
+		if bytes.Equal(ent.Key, key[len(n.Prefix):]) {
 			n.Entries = deleteEntry(n.Entries, i)
 			return PostNode(ctx, s, n)
 		}
@@ -155,11 +182,12 @@ func Delete(ctx context.Context, s blobs.Store, ref Ref, key []byte) (*Ref, erro
 	return &ref, nil
 }
 
+// Split turns a leaf into a parent, moving its entries into children by their next byte.
 func Split(ctx context.Context, s blobs.Store, x *Node) (*Node, error) {
 	if len(x.Entries) < 2 {
 		return nil, ErrCannotSplit
 	}
-	y := &Node{}
+	y := &Node{Prefix: x.Prefix}
 	childEntries := [256][]*Entry{}
 	for _, ent := range x.Entries {
 		if len(ent.Key) == 0 {
@@ -167,7 +195,10 @@ func Split(ctx context.Context, s blobs.Store, x *Node) (*Node, error) {
 			continue
 		}
 		c := ent.Key[0]
-		childEntries[c] = append(childEntries[c], ent)
+		childEntries[c] = append(childEntries[c], &Entry{
+			Key:   ent.Key[1:],
+			Value: ent.Value,
+		})
 	}
 
 	y.Children = make([]*ChildRef, 256)
@@ -177,7 +208,7 @@ func Split(ctx context.Context, s blobs.Store, x *Node) (*Node, error) {
 			continue
 		}
 		child := &Node{
-			Prefix:  append(x.Prefix, uint8(i)),
+			Prefix:  appendPrefix(x.Prefix, uint8(i)),
 			Entries: childEntries[i],
 		}
 		childRef, err := PostNode(ctx, s, child)
@@ -189,13 +220,17 @@ func Split(ctx context.Context, s blobs.Store, x *Node) (*Node, error) {
 	return y, nil
 }
 
+// Collapse turns a parent, whose children are all leaves, back into a leaf.
+// It returns ErrCannotCollapse if that is not possible.
 func Collapse(ctx context.Context, s blobs.Store, x *Node) (*Node, error) {
 	if !IsParent(x) {
 		return x, nil
 	}
-	y := &Node{}
+	y := &Node{Prefix: x.Prefix}
+	y.Entries = append(y.Entries, x.Entries...)
+	size := proto.Size(y)
 	for i := range x.Children {
-		if x.Children[i] == nil {
+		if isNilChild(x.Children[i]) {
 			continue
 		}
 		childRef := fromChildProto(x.Children[i])
@@ -206,7 +241,18 @@ func Collapse(ctx context.Context, s blobs.Store, x *Node) (*Node, error) {
 		if IsParent(child) {
 			return nil, ErrCannotCollapse
 		}
-		y.Entries = append(y.Entries, child.Entries...)
+		for _, ent := range child.Entries {
+			ent2 := &Entry{
+				Key:   append([]byte{uint8(i)}, ent.Key...),
+				Value: ent.Value,
+			}
+			y.Entries = append(y.Entries, ent2)
+			// stop reading children as soon as it is clear they won't fit.
+			size += proto.Size(ent2)
+			if size > blobs.MaxSize {
+				return nil, ErrCannotCollapse
+			}
+		}
 	}
 	if proto.Size(y) > blobs.MaxSize {
 		return nil, ErrCannotCollapse
@@ -224,6 +270,9 @@ func Validate(ctx context.Context, s blobs.Store, ref Ref) error {
 	}
 	if IsParent(n) {
 		for i := range n.Children {
+			if isNilChild(n.Children[i]) {
+				continue
+			}
 			childID := fromChildProto(n.Children[i])
 			if err := Validate(ctx, s, childID); err != nil {
 				return err
@@ -255,6 +304,15 @@ func IsParent(x *Node) bool {
 	return len(x.Children) == 256
 }
 
+// Child returns the ref for the child of a parent at c, or nil if there is no child there.
+func Child(x *Node, c byte) *Ref {
+	if !IsParent(x) || isNilChild(x.Children[c]) {
+		return nil
+	}
+	ref := fromChildProto(x.Children[c])
+	return &ref
+}
+
 func makeEntry(prefix, key, value []byte) *Entry {
 	if len(prefix) == len(key) {
 		return &Entry{Value: value}
@@ -271,3 +329,15 @@ func deleteEntry(ents []*Entry, i int) []*Entry {
 	}
 	return append(ents[:i], ents[i+1:]...)
 }
+
+func isNilChild(x *ChildRef) bool {
+	return x == nil || len(x.Id) == 0
+}
+
+// appendPrefix returns a new slice, so children never share memory with their parent's prefix.
+func appendPrefix(prefix []byte, c byte) []byte {
+	out := make([]byte, len(prefix)+1)
+	copy(out, prefix)
+	out[len(prefix)] = c
+	return out
+}
@@ -1,6 +1,7 @@
 package tries
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"testing"
@@ -34,3 +35,159 @@ func TestPutGet(t *testing.T) {
 		assert.Equal(t, expected, actual)
 	}
 }
+
+func TestSplitDelete(t *testing.T) {
+	ctx := context.TODO()
+	s := blobs.NewMem()
+	const N = 2000 // enough to split the root
+
+	empty, err := PostNode(ctx, s, New())
+	require.NoError(t, err)
+	ref := empty
+	keys := make([][]byte, N)
+	for i := range keys {
+		id := blobs.Hash([]byte(fmt.Sprintf("test-key-%d", i)))
+		keys[i] = id[:]
+		ref, err = Put(ctx, s, *ref, keys[i], []byte("test-value"))
+		require.NoError(t, err)
+	}
+	root, err := GetNode(ctx, s, *ref)
+	require.NoError(t, err)
+	require.True(t, IsParent(root))
+	require.NoError(t, Validate(ctx, s, *ref))
+
+	// putting an existing key does not add an entry
+	ref2, err := Put(ctx, s, *ref, keys[0], []byte("test-value"))
+	require.NoError(t, err)
+	assert.Equal(t, ref.ID, ref2.ID)
+
+	var count int
+	var last []byte
+	require.NoError(t, ForEach(ctx, s, *ref, nil, nil, func(k, v []byte) error {
+		assert.Len(t, k, blobs.IDSize)
+		if last != nil {
+			assert.True(t, bytes.Compare(last, k) < 0)
+		}
+		last = append([]byte{}, k...)
+		count++
+		return nil
+	}))
+	assert.Equal(t, N, count)
+
+	for _, key := range keys {
+		ref, err = Delete(ctx, s, *ref, key)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, empty.ID, ref.ID)
+}
+
+func TestCanonical(t *testing.T) {
+	ctx := context.TODO()
+	s := blobs.NewMem()
+	const N = 2500
+
+	keys := make([][]byte, N)
+	for i := range keys {
+		id := blobs.Hash([]byte(fmt.Sprintf("test-key-%d", i)))
+		keys[i] = id[:]
+	}
+	build := func(keys [][]byte) Ref {
+		ref, err := PostNode(ctx, s, New())
+		require.NoError(t, err)
+		for _, key := range keys {
+			ref, err = Put(ctx, s, *ref, key, nil)
+			require.NoError(t, err)
+		}
+		return *ref
+	}
+	a := build(keys)
+	// add extra keys, then remove them, in a different order
+	extra := build(append(append([][]byte{}, keys[N/2:]...), keys[:N/2]...))
+	for i := 0; i < N/2; i++ {
+		id := blobs.Hash([]byte(fmt.Sprintf("extra-key-%d", i)))
+		ref, err := Put(ctx, s, extra, id[:], nil)
+		require.NoError(t, err)
+		ref, err = Delete(ctx, s, *ref, id[:])
+		require.NoError(t, err)
+		extra = *ref
+	}
+	assert.Equal(t, a.ID, extra.ID)
+}
+
+func TestDiff(t *testing.T) {
+	ctx := context.TODO()
+	s := blobs.NewMem()
+	const N = 2000
+
+	empty, err := PostNode(ctx, s, New())
+	require.NoError(t, err)
+	left := empty
+	keys := make([][]byte, N)
+	for i := range keys {
+		id := blobs.Hash([]byte(fmt.Sprintf("test-key-%d", i)))
+		keys[i] = id[:]
+		left, err = Put(ctx, s, *left, keys[i], []byte("test-value"))
+		require.NoError(t, err)
+	}
+
+	right := left
+	var added, removed, changed [][]byte
+	for i := 0; i < 10; i++ {
+		id := blobs.Hash([]byte(fmt.Sprintf("test-key-added-%d", i)))
+		right, err = Put(ctx, s, *right, id[:], []byte("test-value"))
+		require.NoError(t, err)
+		added = append(added, id[:])
+
+		right, err = Delete(ctx, s, *right, keys[i])
+		require.NoError(t, err)
+		removed = append(removed, keys[i])
+
+		right, err = Put(ctx, s, *right, keys[N-1-i], []byte("test-value-2"))
+		require.NoError(t, err)
+		changed = append(changed, keys[N-1-i])
+	}
+
+	diff := func(left, right Ref) (added, removed, changed [][]byte) {
+		var last []byte
+		require.NoError(t, Diff(ctx, s, left, right, func(l, r *Entry) error {
+			var key []byte
+			switch {
+			case l == nil:
+				key = r.Key
+				added = append(added, key)
+			case r == nil:
+				key = l.Key
+				removed = append(removed, key)
+			default:
+				require.Equal(t, l.Key, r.Key)
+				key = l.Key
+				changed = append(changed, key)
+			}
+			require.True(t, bytes.Compare(last, key) < 0, "keys out of order")
+			last = key
+			return nil
+		}))
+		return added, removed, changed
+	}
+
+	a, r, c := diff(*left, *right)
+	assert.ElementsMatch(t, added, a)
+	assert.ElementsMatch(t, removed, r)
+	assert.ElementsMatch(t, changed, c)
+
+	a, r, c = diff(*right, *left)
+	assert.ElementsMatch(t, removed, a)
+	assert.ElementsMatch(t, added, r)
+	assert.ElementsMatch(t, changed, c)
+
+	a, r, c = diff(*left, *left)
+	assert.Empty(t, a)
+	assert.Empty(t, r)
+	assert.Empty(t, c)
+
+	// against an empty trie, which is a leaf.
+	a, r, c = diff(*empty, *left)
+	assert.ElementsMatch(t, keys, a)
+	assert.Empty(t, r)
+	assert.Empty(t, c)
+}